@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+type assignReq struct {
+	UserID int `json:"userId"`
+}
+
+// assignTaskHandler adds an assignee. The caller must be allowed to modify
+// the task, or anyone could assign themselves and gain that right.
+func assignTaskHandler(store AssignmentStore, tasks taskReader, users UserStore, events *EventBus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		taskID, err := strconv.Atoi(r.PathValue("ID"))
+		if err != nil || taskID <= 0 {
+			writeErr(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		var req assignReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeErr(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		if req.UserID <= 0 {
+			writeErr(w, http.StatusBadRequest, "invalid userId")
+			return
+		}
+		if _, err := newTaskAuth(r.Context(), users, getUserID(r)).task(r.Context(), tasks, taskID); err != nil {
+			writeTaskAuthError(w, err)
+			return
+		}
+		changed, err := store.AssignUser(r.Context(), taskID, req.UserID)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrInvalid):
+				writeErr(w, http.StatusBadRequest, "unknown or deleted user")
+			case errors.Is(err, ErrNotFound):
+				writeErr(w, http.StatusNotFound, "not found")
+			default:
+				writeErr(w, http.StatusInternalServerError, "internal error")
+			}
+			return
+		}
+		if changed {
+			events.PublishFrom(r.Context(), Event{Type: EventTaskAssigned, TaskID: taskID, UserID: req.UserID, ActorID: getUserID(r)})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// unassignTaskHandler removes an assignee. Users may always take themselves
+// off a task; removing anyone else needs the right to modify it.
+func unassignTaskHandler(store AssignmentStore, tasks taskReader, users UserStore, events *EventBus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		taskID, err := strconv.Atoi(r.PathValue("ID"))
+		if err != nil || taskID <= 0 {
+			writeErr(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		userID, err := strconv.Atoi(r.PathValue("UserID"))
+		if err != nil || userID <= 0 {
+			writeErr(w, http.StatusBadRequest, "invalid user id")
+			return
+		}
+		if me := getUserID(r); userID != me {
+			if _, err := newTaskAuth(r.Context(), users, me).task(r.Context(), tasks, taskID); err != nil {
+				writeTaskAuthError(w, err)
+				return
+			}
+		}
+		changed, err := store.UnassignUser(r.Context(), taskID, userID)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				writeErr(w, http.StatusNotFound, "not found")
+			} else {
+				writeErr(w, http.StatusInternalServerError, "internal error")
+			}
+			return
+		}
+		if changed {
+			events.PublishFrom(r.Context(), Event{Type: EventTaskUnassigned, TaskID: taskID, UserID: userID, ActorID: getUserID(r)})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// getMyTasksHandler lists the tasks assigned to the authenticated user. The
+// usual list filters still apply on top.
+func getMyTasksHandler(store TaskStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseTaskFilter(r)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		me := getUserID(r)
+		filter.Assignee = &me
+
+		tasks, err := store.GetAllTasks(r.Context(), filter)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		writeJSON(w, http.StatusOK, tasks)
+	}
+}
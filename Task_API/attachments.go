@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const maxAttachmentSize = 10 << 20
+
+// postAttachmentHandler streams the "file" part of a multipart upload to a
+// temporary file while hashing it, then stores it under its SHA-256 so the
+// same content is only kept once. The content type is sniffed from the
+// bytes rather than trusted from the client. Nothing is written before the
+// task is known to exist and the caller may modify it.
+func postAttachmentHandler(store AttachmentStore, tasks TaskStore, users UserStore, blobs BlobStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		taskID, err := strconv.Atoi(r.PathValue("ID"))
+		if err != nil || taskID <= 0 {
+			writeErr(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		userID := getUserID(r)
+		if _, err := newTaskAuth(r.Context(), users, userID).task(r.Context(), tasks, taskID); err != nil {
+			writeTaskAuthError(w, err)
+			return
+		}
+
+		// Leave headroom for the multipart framing around the file.
+		r.Body = http.MaxBytesReader(w, r.Body, maxAttachmentSize+1<<20)
+		mr, err := r.MultipartReader()
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, "expected multipart/form-data")
+			return
+		}
+
+		var part *multipart.Part
+		for {
+			p, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				writeErr(w, http.StatusBadRequest, "invalid multipart body")
+				return
+			}
+			if p.FormName() == "file" && p.FileName() != "" {
+				part = p
+				break
+			}
+		}
+		if part == nil {
+			writeErr(w, http.StatusBadRequest, "missing file")
+			return
+		}
+
+		tmp, err := os.CreateTemp("", "attachment-*")
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		h := sha256.New()
+		size, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(part, maxAttachmentSize+1))
+		if err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				writeErr(w, http.StatusRequestEntityTooLarge, "file too large")
+			} else {
+				writeErr(w, http.StatusBadRequest, "upload failed")
+			}
+			return
+		}
+		if size > maxAttachmentSize {
+			writeErr(w, http.StatusRequestEntityTooLarge, "file too large")
+			return
+		}
+		if size == 0 {
+			writeErr(w, http.StatusBadRequest, "empty file")
+			return
+		}
+
+		head := make([]byte, 512)
+		n, _ := tmp.ReadAt(head, 0)
+		contentType := http.DetectContentType(head[:n])
+
+		a := Attachment{
+			TaskID:      taskID,
+			Filename:    filepath.Base(part.FileName()),
+			ContentType: contentType,
+			Size:        size,
+			SHA256:      hex.EncodeToString(h.Sum(nil)),
+			UploadedBy:  userID,
+		}
+		if err := saveAttachment(r.Context(), store, blobs, &a, tmp); err != nil {
+			switch {
+			case errors.Is(err, ErrInvalid):
+				writeErr(w, http.StatusBadRequest, err.Error())
+			case errors.Is(err, ErrNotFound):
+				writeErr(w, http.StatusNotFound, "not found")
+			default:
+				writeErr(w, http.StatusInternalServerError, "internal error")
+			}
+			return
+		}
+		writeJSON(w, http.StatusCreated, a)
+	}
+}
+
+// saveAttachment records a, storing content as its blob unless the same
+// content is already kept.
+func saveAttachment(ctx context.Context, store AttachmentStore, blobs BlobStore, a *Attachment, content io.ReadSeeker) error {
+	blobRefs.Lock()
+	defer blobRefs.Unlock()
+	exists, err := blobs.Exists(a.SHA256)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if _, err := content.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := blobs.Put(a.SHA256, content); err != nil {
+			return err
+		}
+	}
+	return store.CreateAttachment(ctx, a)
+}
+
+func listAttachmentsHandler(store AttachmentStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		taskID, err := strconv.Atoi(r.PathValue("ID"))
+		if err != nil || taskID <= 0 {
+			writeErr(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		list, err := store.ListAttachments(r.Context(), taskID)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				writeErr(w, http.StatusNotFound, "not found")
+			} else {
+				writeErr(w, http.StatusInternalServerError, "internal error")
+			}
+			return
+		}
+		writeJSON(w, http.StatusOK, list)
+	}
+}
+
+// loadTaskAttachment fetches the attachment named in the path and checks
+// that it belongs to the task in the path. It writes the error response
+// itself.
+func loadTaskAttachment(w http.ResponseWriter, r *http.Request, store AttachmentStore) (Attachment, bool) {
+	taskID, err := strconv.Atoi(r.PathValue("ID"))
+	if err != nil || taskID <= 0 {
+		writeErr(w, http.StatusBadRequest, "invalid id")
+		return Attachment{}, false
+	}
+	attachmentID, err := strconv.Atoi(r.PathValue("AttachmentID"))
+	if err != nil || attachmentID <= 0 {
+		writeErr(w, http.StatusBadRequest, "invalid attachment id")
+		return Attachment{}, false
+	}
+
+	a, err := store.GetAttachment(r.Context(), attachmentID)
+	if err != nil || a.TaskID != taskID {
+		if err == nil || errors.Is(err, ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "not found")
+		} else {
+			writeErr(w, http.StatusInternalServerError, "internal error")
+		}
+		return Attachment{}, false
+	}
+	return a, true
+}
+
+// downloadAttachmentHandler streams the blob back. http.ServeContent takes
+// care of Range, If-Range and conditional requests.
+func downloadAttachmentHandler(store AttachmentStore, blobs BlobStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		a, ok := loadTaskAttachment(w, r, store)
+		if !ok {
+			return
+		}
+		f, err := blobs.Open(a.SHA256)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				writeErr(w, http.StatusNotFound, "not found")
+			} else {
+				writeErr(w, http.StatusInternalServerError, "internal error")
+			}
+			return
+		}
+		defer f.Close()
+
+		w.Header().Set("Content-Type", a.ContentType)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("ETag", `"`+a.SHA256+`"`)
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename}))
+		http.ServeContent(w, r, "", a.CreatedAt, f)
+	}
+}
+
+// deleteAttachmentHandler removes an attachment, and its blob once nothing
+// else refers to it. The caller must be allowed to modify the task, as for
+// uploads.
+func deleteAttachmentHandler(store AttachmentStore, tasks TaskStore, users UserStore, blobs BlobStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		a, ok := loadTaskAttachment(w, r, store)
+		if !ok {
+			return
+		}
+		if _, err := newTaskAuth(r.Context(), users, getUserID(r)).task(r.Context(), tasks, a.TaskID); err != nil {
+			writeTaskAuthError(w, err)
+			return
+		}
+		if err := removeAttachment(r.Context(), store, blobs, a); err != nil {
+			if errors.Is(err, ErrNotFound) {
+				writeErr(w, http.StatusNotFound, "not found")
+			} else {
+				writeErr(w, http.StatusInternalServerError, "internal error")
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// removeAttachment deletes a, and its blob if no other attachment refers
+// to it.
+func removeAttachment(ctx context.Context, store AttachmentStore, blobs BlobStore, a Attachment) error {
+	blobRefs.Lock()
+	defer blobRefs.Unlock()
+	orphaned, err := store.DeleteAttachment(ctx, a.ID)
+	if err != nil {
+		return err
+	}
+	if orphaned {
+		if err := blobs.Delete(a.SHA256); err != nil {
+			loggerFrom(ctx).Error("delete blob failed", "sha256", a.SHA256, "error", err)
+		}
+	}
+	return nil
+}
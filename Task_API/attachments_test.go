@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memBlobStore is an in-memory stand-in for a remote BlobStore such as S3.
+type memBlobStore struct {
+	mu    sync.Mutex
+	blobs map[string][]byte
+}
+
+func newMemBlobStore() *memBlobStore {
+	return &memBlobStore{blobs: make(map[string][]byte)}
+}
+
+func (m *memBlobStore) Put(key string, r io.Reader) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blobs[key] = b
+	return nil
+}
+
+func (m *memBlobStore) Open(key string) (io.ReadSeekCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b, ok := m.blobs[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return nopSeekCloser{bytes.NewReader(b)}, nil
+}
+
+func (m *memBlobStore) Exists(key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.blobs[key]
+	return ok, nil
+}
+
+func (m *memBlobStore) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.blobs, key)
+	return nil
+}
+
+type nopSeekCloser struct{ io.ReadSeeker }
+
+func (nopSeekCloser) Close() error { return nil }
+
+type memAttachmentStore struct {
+	list []Attachment
+}
+
+func (m *memAttachmentStore) CreateAttachment(ctx context.Context, a *Attachment) error {
+	a.ID = len(m.list) + 1
+	m.list = append(m.list, *a)
+	return nil
+}
+func (m *memAttachmentStore) GetAttachment(ctx context.Context, id int) (Attachment, error) {
+	for _, a := range m.list {
+		if a.ID == id {
+			return a, nil
+		}
+	}
+	return Attachment{}, ErrNotFound
+}
+func (m *memAttachmentStore) ListAttachments(ctx context.Context, taskID int) ([]Attachment, error) {
+	return m.list, nil
+}
+func (m *memAttachmentStore) DeleteAttachment(ctx context.Context, id int) (bool, error) {
+	return true, nil
+}
+
+// openTasks finds every task, unassigned.
+func openTasks() *MockStore {
+	return &MockStore{GetTaskByIDFunc: openTask}
+}
+
+func noAdmins() *MockUserStore {
+	return &MockUserStore{GetUserFunc: func(id int) (User, error) {
+		return User{ID: id}, nil
+	}}
+}
+
+func uploadRequest(t *testing.T, filename, content string) *http.Request {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(fw, content)
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/tasks/1/attachments", &buf)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.SetPathValue("ID", "1")
+	return req
+}
+
+func TestAttachments_UploadDedupAndRangeDownload(t *testing.T) {
+	store := &memAttachmentStore{}
+	blobs := newMemBlobStore()
+	content := "line one\nline two\n"
+
+	for _, name := range []string{"a.log", "b.log"} {
+		rec := httptest.NewRecorder()
+		postAttachmentHandler(store, openTasks(), noAdmins(), blobs).ServeHTTP(rec, uploadRequest(t, name, content))
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("expected 201 Created, got %d: %s", rec.Code, rec.Body)
+		}
+	}
+	if len(blobs.blobs) != 1 {
+		t.Fatalf("expected identical uploads to share one blob, got %d", len(blobs.blobs))
+	}
+	if ct := store.list[0].ContentType; !strings.HasPrefix(ct, "text/plain") {
+		t.Fatalf("expected sniffed text/plain, got %s", ct)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/tasks/1/attachments/2", nil)
+	req.SetPathValue("ID", "1")
+	req.SetPathValue("AttachmentID", "2")
+	req.Header.Set("Range", "bytes=5-7")
+	rec := httptest.NewRecorder()
+	downloadAttachmentHandler(store, blobs).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("expected 206 Partial Content, got %d", rec.Code)
+	}
+	if got := rec.Body.String(); got != "one" {
+		t.Fatalf("expected range body %q, got %q", "one", got)
+	}
+}
+
+func TestAttachments_TooLarge(t *testing.T) {
+	rec := httptest.NewRecorder()
+	big := strings.Repeat("x", maxAttachmentSize+1)
+	postAttachmentHandler(&memAttachmentStore{}, openTasks(), noAdmins(), newMemBlobStore()).ServeHTTP(rec, uploadRequest(t, "big.bin", big))
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413, got %d", rec.Code)
+	}
+}
+
+func TestAttachments_CheckTaskBeforeWriting(t *testing.T) {
+	missing := &MockStore{GetTaskByIDFunc: func(id int) (Task, error) {
+		return Task{}, ErrNotFound
+	}}
+	theirs := &MockStore{GetTaskByIDFunc: func(id int) (Task, error) {
+		return Task{ID: id, Title: "theirs", Assignees: []int{8}}, nil
+	}}
+	for _, tc := range []struct {
+		name  string
+		tasks TaskStore
+		want  int
+	}{
+		{"missing task", missing, http.StatusNotFound},
+		{"assigned to someone else", theirs, http.StatusForbidden},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			store, blobs := &memAttachmentStore{}, newMemBlobStore()
+			rec := httptest.NewRecorder()
+			postAttachmentHandler(store, tc.tasks, noAdmins(), blobs).ServeHTTP(rec, withUser(uploadRequest(t, "a.txt", "hello"), 7))
+			if rec.Code != tc.want {
+				t.Fatalf("expected %d, got %d: %s", tc.want, rec.Code, rec.Body)
+			}
+			if len(blobs.blobs) != 0 || len(store.list) != 0 {
+				t.Fatalf("upload stored: %d blobs, %d attachments", len(blobs.blobs), len(store.list))
+			}
+		})
+	}
+}
+
+func TestAttachments_DeleteChecksTask(t *testing.T) {
+	theirs := &MockStore{GetTaskByIDFunc: func(id int) (Task, error) {
+		return Task{ID: id, Title: "theirs", Assignees: []int{8}}, nil
+	}}
+	store, blobs := &memAttachmentStore{}, newMemBlobStore()
+	rec := httptest.NewRecorder()
+	postAttachmentHandler(store, openTasks(), noAdmins(), blobs).ServeHTTP(rec, uploadRequest(t, "a.txt", "hello"))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("upload: %d %s", rec.Code, rec.Body)
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/tasks/1/attachments/1", nil)
+	req.SetPathValue("ID", "1")
+	req.SetPathValue("AttachmentID", "1")
+	rec = httptest.NewRecorder()
+	deleteAttachmentHandler(store, theirs, noAdmins(), blobs).ServeHTTP(rec, withUser(req, 7))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d: %s", rec.Code, rec.Body)
+	}
+	if len(blobs.blobs) != 1 {
+		t.Error("blob removed by a refused delete")
+	}
+}
+
+func TestSQLiteStore_DeleteTaskRemovesUnsharedBlobs(t *testing.T) {
+	s := newTestStore(t)
+	blobs := newMemBlobStore()
+	s.Blobs = blobs
+	shared, own := strings.Repeat("a", 64), strings.Repeat("b", 64)
+
+	var tasks []Task
+	for _, title := range []string{"parent", "child", "keeper"} {
+		task := Task{Title: title}
+		if title == "child" {
+			task.ParentID = &tasks[0].ID
+		}
+		if err := s.CreateTask(t.Context(), &task); err != nil {
+			t.Fatal(err)
+		}
+		tasks = append(tasks, task)
+	}
+	for _, a := range []Attachment{
+		{TaskID: tasks[1].ID, Filename: "shared.txt", SHA256: shared},
+		{TaskID: tasks[1].ID, Filename: "own.txt", SHA256: own},
+		{TaskID: tasks[2].ID, Filename: "shared.txt", SHA256: shared},
+	} {
+		if err := s.CreateAttachment(t.Context(), &a); err != nil {
+			t.Fatal(err)
+		}
+		blobs.Put(a.SHA256, strings.NewReader(a.Filename))
+	}
+
+	if err := s.DeleteTask(t.Context(), tasks[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := blobs.Exists(own); ok {
+		t.Error("blob only the deleted subtask used was kept")
+	}
+	if ok, _ := blobs.Exists(shared); !ok {
+		t.Error("blob still attached to another task was removed")
+	}
+}
+
+// racingBlobStore runs onExists in the middle of an upload: after it has
+// found the blob but before it has recorded the attachment.
+type racingBlobStore struct {
+	*memBlobStore
+	onExists func()
+}
+
+func (r *racingBlobStore) Exists(key string) (bool, error) {
+	ok, err := r.memBlobStore.Exists(key)
+	if f := r.onExists; f != nil {
+		r.onExists = nil
+		f()
+	}
+	return ok, err
+}
+
+func TestAttachments_DeleteCannotRemoveABlobBeingReused(t *testing.T) {
+	s := newTestStore(t)
+	task := Task{Title: "task"}
+	if err := s.CreateTask(t.Context(), &task); err != nil || task.ID != 1 {
+		t.Fatalf("task %d: %v", task.ID, err)
+	}
+	blobs := &racingBlobStore{memBlobStore: newMemBlobStore()}
+	upload := func() Attachment {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		postAttachmentHandler(s, s, s, blobs).ServeHTTP(rec, withUser(uploadRequest(t, "a.txt", "same bytes"), demoUserID))
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("upload: %d %s", rec.Code, rec.Body)
+		}
+		var a Attachment
+		if err := json.Unmarshal(rec.Body.Bytes(), &a); err != nil {
+			t.Fatal(err)
+		}
+		return a
+	}
+	first := upload()
+
+	// Delete the only other reference while the second upload is between
+	// finding the blob and recording its attachment.
+	deleted := make(chan int, 1)
+	blobs.onExists = func() {
+		go func() {
+			req := httptest.NewRequest(http.MethodDelete, "/", nil)
+			req.SetPathValue("ID", "1")
+			req.SetPathValue("AttachmentID", strconv.Itoa(first.ID))
+			rec := httptest.NewRecorder()
+			deleteAttachmentHandler(s, s, s, blobs).ServeHTTP(rec, withUser(req, demoUserID))
+			deleted <- rec.Code
+		}()
+		select {
+		case code := <-deleted:
+			t.Error("delete finished while the upload was reusing the blob")
+			deleted <- code
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	second := upload()
+	if code := <-deleted; code != http.StatusNoContent {
+		t.Fatalf("delete: status %d", code)
+	}
+
+	if ok, _ := blobs.Exists(second.SHA256); !ok {
+		t.Error("blob removed while an attachment still refers to it")
+	}
+}
+
+func TestFSBlobStore_RoundTrip(t *testing.T) {
+	blobs, err := NewFSBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := strings.Repeat("ab", 32)
+
+	if err := blobs.Put(key, strings.NewReader("hello")); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if ok, _ := blobs.Exists(key); !ok {
+		t.Fatal("expected blob to exist")
+	}
+	f, err := blobs.Open(key)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	b, _ := io.ReadAll(f)
+	f.Close()
+	if string(b) != "hello" {
+		t.Fatalf("unexpected contents %q", b)
+	}
+	if err := blobs.Delete(key); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := blobs.Open(key); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound after delete, got %v", err)
+	}
+	if err := blobs.Put("../../etc/passwd", strings.NewReader("x")); err != ErrInvalidBlobKey {
+		t.Fatalf("expected ErrInvalidBlobKey, got %v", err)
+	}
+}
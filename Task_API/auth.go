@@ -5,68 +5,108 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"os"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtSecret = []byte(os.Getenv("TASK_API_JWT_SECRET"))
-
 func GenerateJWT(userID int) (string, error) {
-	if len(jwtSecret) == 0 {
+	auth := currentLive().Auth
+	if auth.JWTSecret == "" {
 		return "", errors.New("JWT secret not set")
 	}
 
 	claims := jwt.MapClaims{
 		"userId": userID,
-		"exp":    time.Now().Add(2 * time.Hour).Unix(),
+		"exp":    time.Now().Add(auth.TokenTTL).Unix(),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return token.SignedString([]byte(auth.JWTSecret))
+}
+
+// verificationKeys are the current secret followed by any previous ones,
+// so tokens issued before a key rotation stay valid until they expire.
+func verificationKeys(auth AuthConfig) jwt.VerificationKeySet {
+	var set jwt.VerificationKeySet
+	for _, secret := range append([]string{auth.JWTSecret}, auth.PreviousJWTSecrets...) {
+		if secret != "" {
+			set.Keys = append(set.Keys, []byte(secret))
+		}
+	}
+	return set
 }
 
 type contextKey int
 
-const userKey contextKey = iota
+const (
+	userKey contextKey = iota
+	loggerKey
+	requestInfoKey
+	requestIDKey
+	endSpanKey
+)
 
+// AuthMiddleware accepts a bearer token in the Authorization header.
+// Browsers cannot set headers on a WebSocket handshake, so upgrade requests
+// may instead offer the subprotocols "bearer" and the token, in that order,
+// which keeps the token out of URLs and access logs.
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		auth := r.Header.Get("Authorization")
-		if !strings.HasPrefix(auth, "Bearer ") {
+		tokenStr, ok := strings.CutPrefix(auth, "Bearer ")
+		if !ok && headerHasToken(r.Header, "Upgrade", "websocket") {
+			tokenStr, ok = webSocketToken(r.Header)
+		}
+		if !ok {
 			writeErr(w, http.StatusUnauthorized, "missing or invalid token")
 			return
 		}
 
-		tokenStr := strings.TrimPrefix(auth, "Bearer ")
-		token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method")
-			}
-			return jwtSecret, nil
-		})
-		if err != nil || !token.Valid {
-			writeErr(w, http.StatusUnauthorized, "invalid token")
+		userID, err := userIDFromToken(tokenStr)
+		if err != nil {
+			writeErr(w, http.StatusUnauthorized, err.Error())
 			return
 		}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			writeErr(w, http.StatusUnauthorized, "invalid claims")
-			return
-		}
+		ctx := context.WithValue(r.Context(), userKey, userID)
+		next.ServeHTTP(w, r.WithContext(withLoggedUser(ctx, userID)))
+	})
+}
 
-		userID, ok := claims["userId"].(float64)
-		if !ok {
-			writeErr(w, http.StatusUnauthorized, "invalid user ID")
-			return
-		}
+// webSocketToken returns the subprotocol offered after wsTokenProtocol.
+func webSocketToken(h http.Header) (string, bool) {
+	protocols := webSocketProtocols(h)
+	i := slices.Index(protocols, wsTokenProtocol)
+	if i < 0 || i+1 == len(protocols) {
+		return "", false
+	}
+	return protocols[i+1], true
+}
 
-		ctx := context.WithValue(r.Context(), userKey, int(userID))
-		next.ServeHTTP(w, r.WithContext(ctx))
+func userIDFromToken(tokenStr string) (int, error) {
+	token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method")
+		}
+		return verificationKeys(currentLive().Auth), nil
 	})
+	if err != nil || !token.Valid {
+		return 0, errors.New("invalid token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0, errors.New("invalid claims")
+	}
+
+	userID, ok := claims["userId"].(float64)
+	if !ok {
+		return 0, errors.New("invalid user ID")
+	}
+	return int(userID), nil
 }
 
 func getUserID(r *http.Request) int {
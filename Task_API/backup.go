@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	backupPrefix     = "tasks-"
+	backupSuffix     = ".db"
+	backupTimeLayout = "20060102T150405.000Z"
+)
+
+// BackupConfig says where snapshots go, how often the scheduler takes one
+// and how many are kept. A zero Interval turns the scheduler off; manual
+// backups still work.
+type BackupConfig struct {
+	Dir      string
+	Interval time.Duration
+	Keep     int
+}
+
+type BackupInfo struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Backupper takes consistent snapshots of the live database with VACUUM
+// INTO, which reads inside a single transaction and so does not block other
+// readers or need the server to stop. Attachment blobs live on disk and are
+// not part of the snapshot.
+type Backupper struct {
+	db  *DB
+	cfg BackupConfig
+	now func() time.Time
+	// mu serializes backups so a scheduled one and a manual one never
+	// rotate the directory under each other.
+	mu sync.Mutex
+}
+
+func NewBackupper(db *DB, cfg BackupConfig) *Backupper {
+	return &Backupper{db: db, cfg: cfg, now: time.Now}
+}
+
+// Backup writes a snapshot, checks it and then prunes old ones. The file
+// only gets its final name once it has passed the integrity check, so a
+// crash mid-backup never leaves something that looks restorable.
+func (b *Backupper) Backup(ctx context.Context) (BackupInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := os.MkdirAll(b.cfg.Dir, 0o750); err != nil {
+		return BackupInfo{}, err
+	}
+	created := b.now().UTC()
+	name := backupPrefix + created.Format(backupTimeLayout) + backupSuffix
+	final := filepath.Join(b.cfg.Dir, name)
+	tmp := final + ".tmp"
+	_ = os.Remove(tmp)
+
+	if err := b.vacuumInto(ctx, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return BackupInfo{}, fmt.Errorf("vacuum into %s: %w", tmp, err)
+	}
+	if err := verifyDatabase(tmp); err != nil {
+		_ = os.Remove(tmp)
+		return BackupInfo{}, err
+	}
+	if err := os.Rename(tmp, final); err != nil {
+		_ = os.Remove(tmp)
+		return BackupInfo{}, err
+	}
+	fi, err := os.Stat(final)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	if err := b.prune(); err != nil {
+		loggerFrom(ctx).Error("prune backups failed", "error", err)
+	}
+	return BackupInfo{Name: name, Size: fi.Size(), CreatedAt: created}, nil
+}
+
+// vacuumInto runs VACUUM INTO on a reader so the write connection stays
+// free. It only reads the live database, but SQLite still refuses it under
+// query_only, so that is lifted for the one statement.
+func (b *Backupper) vacuumInto(ctx context.Context, path string) error {
+	conn, err := b.db.reader.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, `PRAGMA query_only(0)`); err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, `VACUUM INTO ?`, path)
+	if _, resetErr := conn.ExecContext(context.Background(), `PRAGMA query_only(1)`); resetErr != nil {
+		// Never hand a writable connection back to the read pool.
+		_ = conn.Raw(func(any) error { return driver.ErrBadConn })
+	}
+	return err
+}
+
+// List returns the finished backups, newest first.
+func (b *Backupper) List() ([]BackupInfo, error) {
+	entries, err := os.ReadDir(b.cfg.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []BackupInfo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := []BackupInfo{}
+	for _, e := range entries {
+		name := e.Name()
+		stamp, ok := strings.CutPrefix(name, backupPrefix)
+		if !ok || e.IsDir() {
+			continue
+		}
+		stamp, ok = strings.CutSuffix(stamp, backupSuffix)
+		if !ok {
+			continue
+		}
+		created, err := time.Parse(backupTimeLayout, stamp)
+		if err != nil {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		out = append(out, BackupInfo{Name: name, Size: fi.Size(), CreatedAt: created})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out, nil
+}
+
+func (b *Backupper) prune() error {
+	list, err := b.List()
+	if err != nil || len(list) <= b.cfg.Keep {
+		return err
+	}
+	var errs []error
+	for _, old := range list[b.cfg.Keep:] {
+		if err := os.Remove(filepath.Join(b.cfg.Dir, old.Name)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run takes a backup every Interval until ctx is cancelled. It does nothing
+// when the schedule is off.
+func (b *Backupper) Run(ctx context.Context) {
+	if b.cfg.Interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(b.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if info, err := b.Backup(ctx); err != nil {
+			loggerFrom(ctx).Error("scheduled backup failed", "error", err)
+		} else {
+			loggerFrom(ctx).Info("backup written", "name", info.Name, "bytes", info.Size)
+		}
+	}
+}
+
+// verifyDatabase opens path and runs SQLite's integrity check, and makes
+// sure it is one of our databases rather than any SQLite file.
+func verifyDatabase(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(`PRAGMA integrity_check`)
+	if err != nil {
+		return fmt.Errorf("%s: integrity check: %w", path, err)
+	}
+	defer rows.Close()
+	var problems []string
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			return err
+		}
+		if msg != "ok" {
+			problems = append(problems, msg)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("%s: integrity check: %w", path, err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s: integrity check failed: %s", path, strings.Join(problems, "; "))
+	}
+
+	var tables int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN ('tasks', 'users')`).Scan(&tables); err != nil {
+		return err
+	}
+	if tables != 2 {
+		return fmt.Errorf("%s: not a task database", path)
+	}
+	return nil
+}
+
+func postBackupHandler(b *Backupper) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// A large database can take longer than the server's write timeout.
+		_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		info, err := b.Backup(r.Context())
+		if err != nil {
+			loggerFrom(r.Context()).Error("backup failed", "error", err)
+			writeErr(w, http.StatusInternalServerError, "backup failed")
+			return
+		}
+		writeJSON(w, http.StatusCreated, info)
+	}
+}
+
+func listBackupsHandler(b *Backupper) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		list, err := b.List()
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		writeJSON(w, http.StatusOK, list)
+	}
+}
+
+// dbJournalSuffixes name the files SQLite keeps next to a database.
+var dbJournalSuffixes = []string{"-journal", "-wal", "-shm"}
+
+// runRestore is the restore subcommand. It replaces the database file with
+// a backup after checking the backup's integrity, and keeps the file it
+// replaces next to it. Without -db it restores into the server's db.path,
+// read from the same config file and environment as the server. Stop the
+// server first: it holds the database open.
+//
+//	taskapi restore [-config file] [-db path] [-check] <backup file>
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	configFile := fs.String("config", "", "config file to read db.path from (env TASK_API_CONFIG)")
+	dbFile := fs.String("db", "", "database file to restore into (default db.path)")
+	checkOnly := fs.Bool("check", false, "only verify the backup")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: restore [-config file] [-db path] [-check] <backup file>")
+	}
+	src := fs.Arg(0)
+
+	if err := verifyDatabase(src); err != nil {
+		return err
+	}
+	fmt.Printf("%s: integrity ok\n", src)
+	if *checkOnly {
+		return nil
+	}
+	if *dbFile == "" {
+		var cfgArgs []string
+		if *configFile != "" {
+			cfgArgs = []string{"-config", *configFile}
+		}
+		// Only db.path matters here, so settings the server would refuse
+		// do not stop a restore.
+		cfg, err := readConfig(cfgArgs)
+		if err != nil {
+			return fmt.Errorf("invalid configuration:\n%w", err)
+		}
+		if cfg.DB.Path == "" {
+			return errors.New("db.path is not set; give -db")
+		}
+		*dbFile = cfg.DB.Path
+	}
+
+	// Copy next to the target first so the final step is an atomic rename
+	// on the same filesystem, then check the copy too.
+	tmp := *dbFile + ".restore"
+	if err := copyFile(src, tmp); err != nil {
+		return err
+	}
+	if err := verifyDatabase(tmp); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	if _, err := os.Stat(*dbFile); err == nil {
+		saved := *dbFile + ".pre-restore-" + time.Now().UTC().Format(backupTimeLayout)
+		if err := copyFile(*dbFile, saved); err != nil {
+			_ = os.Remove(tmp)
+			return fmt.Errorf("keep current database: %w", err)
+		}
+		// Recent writes may still be in the WAL rather than the main file,
+		// so the kept copy takes its journal files with it.
+		for _, suffix := range dbJournalSuffixes {
+			if _, err := os.Stat(*dbFile + suffix); err != nil {
+				continue
+			}
+			if err := copyFile(*dbFile+suffix, saved+suffix); err != nil {
+				_ = os.Remove(tmp)
+				return fmt.Errorf("keep current database: %w", err)
+			}
+		}
+		fmt.Printf("previous database kept as %s\n", saved)
+	}
+	// Journal files belong to the database being replaced and must not be
+	// applied to the restored one.
+	for _, suffix := range dbJournalSuffixes {
+		_ = os.Remove(*dbFile + suffix)
+	}
+	if err := os.Rename(tmp, *dbFile); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	fmt.Printf("restored %s from %s\n", *dbFile, src)
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
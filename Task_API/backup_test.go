@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackup_SnapshotRotatesAndVerifies(t *testing.T) {
+	s := newTestStore(t)
+	task := Task{Title: "keep me"}
+	if err := s.CreateTask(t.Context(), &task); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	b := NewBackupper(s.db, BackupConfig{Dir: dir, Keep: 2})
+	clock := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	b.now = func() time.Time { clock = clock.Add(time.Minute); return clock }
+
+	var names []string
+	for i := 0; i < 3; i++ {
+		info, err := b.Backup(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, info.Name)
+	}
+
+	list, err := b.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Name != names[2] || list[1].Name != names[1] {
+		t.Fatalf("kept %+v, want the two newest of %v", list, names)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*.tmp")); len(matches) != 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+
+	path := filepath.Join(dir, names[2])
+	if err := verifyDatabase(path); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var title string
+	if err := db.QueryRow(`SELECT title FROM tasks WHERE id = ?`, task.ID).Scan(&title); err != nil || title != "keep me" {
+		t.Errorf("snapshot task title %q, err %v", title, err)
+	}
+}
+
+func TestRestore_ReplacesDatabaseAndKeepsPrevious(t *testing.T) {
+	s := newTestStore(t)
+	task := Task{Title: "from backup"}
+	if err := s.CreateTask(t.Context(), &task); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	info, err := NewBackupper(s.db, BackupConfig{Dir: dir, Keep: 1}).Backup(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := filepath.Join(t.TempDir(), "tasks.db")
+	if err := os.WriteFile(target, []byte("the laptop ate it"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := runRestore([]string{"-db", target, filepath.Join(dir, info.Name)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := verifyDatabase(target); err != nil {
+		t.Fatalf("restored database: %v", err)
+	}
+	restored, err := sql.Open("sqlite", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	var title string
+	if err := restored.QueryRow(`SELECT title FROM tasks WHERE id = ?`, task.ID).Scan(&title); err != nil || title != "from backup" {
+		t.Errorf("restored task title %q, err %v", title, err)
+	}
+
+	saved, _ := filepath.Glob(target + ".pre-restore-*")
+	if len(saved) != 1 {
+		t.Fatalf("previous database not kept: %v", saved)
+	}
+	if data, _ := os.ReadFile(saved[0]); string(data) != "the laptop ate it" {
+		t.Errorf("kept file holds %q", data)
+	}
+}
+
+func TestRestore_KeepsWALWrites(t *testing.T) {
+	s := newTestStore(t)
+	dir := t.TempDir()
+	info, err := NewBackupper(s.db, BackupConfig{Dir: dir, Keep: 1}).Backup(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Hold the current database open so its last write is only in the WAL.
+	target := filepath.Join(t.TempDir(), "tasks.db")
+	current, err := sql.Open("sqlite", target+"?_pragma=journal_mode(WAL)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer current.Close()
+	current.SetMaxOpenConns(1)
+	for _, q := range []string{
+		`CREATE TABLE notes (body TEXT)`,
+		`INSERT INTO notes VALUES ('not checkpointed')`,
+	} {
+		if _, err := current.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(target + "-wal"); err != nil {
+		t.Fatalf("no WAL to keep: %v", err)
+	}
+
+	if err := runRestore([]string{"-db", target, filepath.Join(dir, info.Name)}); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, _ := filepath.Glob(target + ".pre-restore-*Z")
+	if len(saved) != 1 {
+		t.Fatalf("previous database not kept: %v", saved)
+	}
+	kept, err := sql.Open("sqlite", saved[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kept.Close()
+	var body string
+	if err := kept.QueryRow(`SELECT body FROM notes`).Scan(&body); err != nil || body != "not checkpointed" {
+		t.Errorf("kept note %q, err %v", body, err)
+	}
+}
+
+func TestRestore_DefaultsToConfiguredPath(t *testing.T) {
+	s := newTestStore(t)
+	dir := t.TempDir()
+	info, err := NewBackupper(s.db, BackupConfig{Dir: dir, Keep: 1}).Backup(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fromEnv := filepath.Join(t.TempDir(), "env.db")
+	t.Setenv("TASK_API_DB_PATH", fromEnv)
+	if err := runRestore([]string{filepath.Join(dir, info.Name)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyDatabase(fromEnv); err != nil {
+		t.Errorf("TASK_API_DB_PATH not restored: %v", err)
+	}
+
+	t.Setenv("TASK_API_DB_PATH", "")
+	fromFile := filepath.Join(t.TempDir(), "file.db")
+	config := filepath.Join(t.TempDir(), "taskapi.yaml")
+	if err := os.WriteFile(config, []byte("db:\n  path: "+fromFile+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := runRestore([]string{"-config", config, filepath.Join(dir, info.Name)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyDatabase(fromFile); err != nil {
+		t.Errorf("db.path from -config not restored: %v", err)
+	}
+}
+
+func TestRestore_RejectsCorruptBackup(t *testing.T) {
+	dir := t.TempDir()
+	bad := filepath.Join(dir, "bad.db")
+	if err := os.WriteFile(bad, []byte(strings.Repeat("not sqlite ", 100)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(dir, "tasks.db")
+	if err := runRestore([]string{"-db", target, bad}); err == nil {
+		t.Fatal("corrupt backup restored")
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("target touched after a failed restore: %v", err)
+	}
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+const (
+	maxBatchOperations = 100
+	// maxBatchTasks caps how many tasks filter operations may touch in one
+	// request, summed over all of them.
+	maxBatchTasks = 1000
+	maxBatchBody  = 1 << 20
+)
+
+const (
+	BatchAtomic     = "atomic"
+	BatchBestEffort = "best_effort"
+)
+
+var (
+	errBatchTooLarge = fmt.Errorf("filter matches more than %d tasks", maxBatchTasks)
+	errRolledBack    = errors.New("rolled back")
+	errNotAttempted  = errors.New("not attempted")
+)
+
+// batchFilter is TaskFilter as it appears in a request body.
+type batchFilter struct {
+	Completed  *bool `json:"completed,omitempty"`
+	Assignee   *int  `json:"assignee,omitempty"`
+	Unassigned bool  `json:"unassigned,omitempty"`
+}
+
+// batchOperation is one entry of POST /tasks:batch. create and update take
+// a task, the same body as POST /tasks and PUT /tasks/{ID}. delete, complete
+// and reopen take either an id or a filter; with a filter they apply to
+// every matching task.
+type batchOperation struct {
+	Op     string          `json:"op"`
+	ID     int             `json:"id,omitempty"`
+	Filter *batchFilter    `json:"filter,omitempty"`
+	Task   json.RawMessage `json:"task,omitempty"`
+	Force  bool            `json:"force,omitempty"`
+}
+
+// batchResult reports one operation. Status is the HTTP status the same
+// change would have got from its single-task endpoint.
+type batchResult struct {
+	Index  int    `json:"index"`
+	Op     string `json:"op"`
+	Status int    `json:"status"`
+	ID     int    `json:"id,omitempty"`
+	IDs    []int  `json:"ids,omitempty"`
+	Task   *Task  `json:"task,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+type batchResponse struct {
+	Mode      string        `json:"mode"`
+	Committed bool          `json:"committed"`
+	Results   []batchResult `json:"results"`
+}
+
+// batchChange is a write to announce once the batch has committed.
+type batchChange struct {
+	id           int
+	created      bool
+	wasCompleted bool
+	before       *Task
+}
+
+// batchTasksHandler applies a list of task operations in one transaction.
+// In atomic mode, the default, the first failure rolls everything back; in
+// best_effort mode each operation succeeds or fails on its own. Every task
+// an operation touches must be one the caller may modify: admins may modify
+// anything, everyone else only tasks that are unassigned or assigned to
+// them.
+func batchTasksHandler(batches TaskBatchStore, tasks TaskStore, users UserStore, events *EventBus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBatchBody)
+		var req struct {
+			Mode       string           `json:"mode"`
+			Operations []batchOperation `json:"operations"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			var tooBig *http.MaxBytesError
+			if errors.As(err, &tooBig) {
+				writeErr(w, http.StatusRequestEntityTooLarge, "request body too large")
+				return
+			}
+			writeErr(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		switch req.Mode {
+		case "":
+			req.Mode = BatchAtomic
+		case BatchAtomic, BatchBestEffort:
+		default:
+			writeErr(w, http.StatusBadRequest, "mode must be atomic or best_effort")
+			return
+		}
+		if len(req.Operations) == 0 {
+			writeErr(w, http.StatusBadRequest, "operations is required")
+			return
+		}
+		if len(req.Operations) > maxBatchOperations {
+			writeErr(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("at most %d operations per request", maxBatchOperations))
+			return
+		}
+
+		userID := getUserID(r)
+		b := &batchRun{auth: newTaskAuth(r.Context(), users, userID)}
+		results := make([]batchResult, len(req.Operations))
+		for i, op := range req.Operations {
+			results[i] = batchResult{Index: i, Op: op.Op}
+		}
+
+		var changes []batchChange
+		failed := -1
+		err := batches.TaskBatch(r.Context(), func(tx TaskTx) error {
+			b.tx = tx
+			for i, op := range req.Operations {
+				var opChanges []batchChange
+				apply := func() error {
+					var err error
+					opChanges, err = b.apply(r.Context(), op, &results[i])
+					return err
+				}
+				var err error
+				if req.Mode == BatchBestEffort {
+					err = tx.Savepoint(r.Context(), apply)
+				} else {
+					err = apply()
+				}
+				if err != nil {
+					setBatchError(r.Context(), &results[i], err)
+					if req.Mode == BatchAtomic {
+						failed = i
+						return errRolledBack
+					}
+					continue
+				}
+				changes = append(changes, opChanges...)
+			}
+			return nil
+		})
+
+		resp := batchResponse{Mode: req.Mode, Results: results}
+		switch {
+		case err == nil:
+			resp.Committed = true
+		case errors.Is(err, errRolledBack):
+			for i := range resp.Results {
+				switch {
+				case i < failed:
+					resp.Results[i] = batchResult{Index: i, Op: resp.Results[i].Op}
+					setBatchError(r.Context(), &resp.Results[i], errRolledBack)
+				case i > failed:
+					setBatchError(r.Context(), &resp.Results[i], errNotAttempted)
+				}
+			}
+			writeJSON(w, resp.Results[failed].Status, resp)
+			return
+		default:
+			writeErr(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		// Computed fields and event snapshots need the committed state.
+		for _, c := range coalesceBatchChanges(changes) {
+			if c.before != nil {
+				events.publishTaskDeleted(r.Context(), c.id, c.before, userID)
+				continue
+			}
+			task, err := tasks.GetTaskByID(r.Context(), c.id)
+			if err != nil {
+				continue
+			}
+			if c.created {
+				events.publishTaskCreated(r.Context(), &task, userID)
+			} else {
+				events.publishTaskUpdated(r.Context(), &task, c.wasCompleted, userID)
+			}
+			for i := range resp.Results {
+				if resp.Results[i].ID == c.id {
+					resp.Results[i].Task = &task
+				}
+			}
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// updateChanges lists the changes one update made: the task itself and the
+// tasks it created or completed along the way.
+func updateChanges(id int, res UpdateResult) []batchChange {
+	changes := []batchChange{{id: id, wasCompleted: res.WasCompleted}}
+	for _, created := range res.Created {
+		changes = append(changes, batchChange{id: created, created: true})
+	}
+	for _, completed := range res.Completed {
+		changes = append(changes, batchChange{id: completed})
+	}
+	return changes
+}
+
+// coalesceBatchChanges merges the changes to each task into one, so a task
+// touched by several operations produces the events for its net change:
+// created wins over updated, and deleted over both.
+func coalesceBatchChanges(changes []batchChange) []batchChange {
+	var out []batchChange
+	seen := make(map[int]int)
+	for _, c := range changes {
+		i, ok := seen[c.id]
+		if !ok {
+			seen[c.id] = len(out)
+			out = append(out, c)
+			continue
+		}
+		if c.before != nil {
+			out[i].before = c.before
+		}
+	}
+	return out
+}
+
+// setBatchError fills in the status and message for a failed operation.
+func setBatchError(ctx context.Context, res *batchResult, err error) {
+	res.IDs = nil
+	res.Task = nil
+	switch {
+	case errors.Is(err, errRolledBack), errors.Is(err, errNotAttempted):
+		res.Status, res.Error = http.StatusFailedDependency, err.Error()
+	case errors.Is(err, errNotAllowed):
+		res.Status, res.Error = http.StatusForbidden, err.Error()
+	case errors.Is(err, errBatchTooLarge):
+		res.Status, res.Error = http.StatusRequestEntityTooLarge, err.Error()
+	case errors.Is(err, ErrInvalid), errors.Is(err, ErrCycle), errors.Is(err, ErrTooDeep):
+		res.Status, res.Error = http.StatusBadRequest, err.Error()
+	case errors.Is(err, ErrOpenSubtasks), errors.Is(err, ErrBlocked), errors.Is(err, ErrConflict):
+		res.Status, res.Error = http.StatusConflict, err.Error()
+	case errors.Is(err, ErrNotFound):
+		res.Status, res.Error = http.StatusNotFound, "not found"
+	default:
+		res.Status, res.Error = http.StatusInternalServerError, publicErrorMessage(ctx, err)
+	}
+}
+
+// batchRun holds what one request's operations share: the transaction,
+// who is asking, and how many tasks filters have matched so far.
+type batchRun struct {
+	tx      TaskTx
+	auth    taskAuth
+	matched int
+}
+
+func (b *batchRun) apply(ctx context.Context, op batchOperation, res *batchResult) ([]batchChange, error) {
+	switch op.Op {
+	case "create":
+		var task Task
+		if op.Task == nil || json.Unmarshal(op.Task, &task) != nil {
+			return nil, fmt.Errorf("%w: task is required", ErrInvalid)
+		}
+		task.ID = 0
+		if err := b.auth.move(ctx, b.tx, nil, task.ParentID); err != nil {
+			return nil, err
+		}
+		if err := b.tx.CreateTask(ctx, &task); err != nil {
+			return nil, err
+		}
+		res.Status, res.ID = http.StatusCreated, task.ID
+		return []batchChange{{id: task.ID, created: true}}, nil
+
+	case "update":
+		if op.Task == nil {
+			return nil, fmt.Errorf("%w: task is required", ErrInvalid)
+		}
+		task, keep, err := decodeTaskUpdate(op.Task)
+		if err != nil {
+			return nil, fmt.Errorf("%w: task is required", ErrInvalid)
+		}
+		current, err := b.authorized(ctx, op.ID)
+		if err != nil {
+			return nil, err
+		}
+		task.ID = op.ID
+		parent := task.ParentID
+		if slices.Contains(keep, "parentId") {
+			parent = current.ParentID
+		}
+		if err := b.auth.move(ctx, b.tx, current.ParentID, parent); err != nil {
+			return nil, err
+		}
+		var updated UpdateResult
+		if err := b.tx.UpdateTask(ctx, &task, UpdateOptions{Force: op.Force, Keep: keep, Result: &updated}); err != nil {
+			return nil, err
+		}
+		res.Status, res.ID = http.StatusOK, task.ID
+		return updateChanges(task.ID, updated), nil
+
+	case "delete", "complete", "reopen":
+		targets, err := b.targets(ctx, op)
+		if err != nil {
+			return nil, err
+		}
+		var changes []batchChange
+		if op.Op == "delete" {
+			changes, err = b.delete(ctx, targets)
+		} else {
+			changes, err = b.setCompleted(ctx, targets, op.Op == "complete", op.Force)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if op.Filter == nil {
+			res.ID = op.ID
+		} else {
+			res.IDs = []int{}
+			for _, t := range targets {
+				res.IDs = append(res.IDs, t.ID)
+			}
+		}
+		res.Status = http.StatusOK
+		if op.Op == "delete" {
+			res.Status = http.StatusNoContent
+		}
+		return changes, nil
+	}
+	return nil, fmt.Errorf("%w: unknown op %q", ErrInvalid, op.Op)
+}
+
+// targets resolves an operation's id or filter to the tasks it touches and
+// checks the caller may modify all of them before anything is written.
+func (b *batchRun) targets(ctx context.Context, op batchOperation) ([]Task, error) {
+	if op.Filter == nil {
+		task, err := b.authorized(ctx, op.ID)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "delete" {
+			if _, err := b.auth.subtree(ctx, b.tx, task.ID); err != nil {
+				return nil, err
+			}
+		}
+		return []Task{task}, nil
+	}
+	if op.ID != 0 {
+		return nil, fmt.Errorf("%w: give either id or filter, not both", ErrInvalid)
+	}
+
+	tasks, err := b.tx.GetAllTasks(ctx, TaskFilter{
+		Completed:  op.Filter.Completed,
+		Assignee:   op.Filter.Assignee,
+		Unassigned: op.Filter.Unassigned,
+	})
+	if err != nil {
+		return nil, err
+	}
+	b.matched += len(tasks)
+	if b.matched > maxBatchTasks {
+		return nil, errBatchTooLarge
+	}
+	for _, t := range tasks {
+		if !b.auth.allows(t) {
+			return nil, fmt.Errorf("%w: task %d", errNotAllowed, t.ID)
+		}
+		if op.Op == "delete" {
+			if _, err := b.auth.subtree(ctx, b.tx, t.ID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return tasks, nil
+}
+
+func (b *batchRun) delete(ctx context.Context, targets []Task) ([]batchChange, error) {
+	var changes []batchChange
+	for j, t := range targets {
+		// Deleting a parent takes its subtasks with it, so later targets
+		// may already be gone.
+		before, err := b.tx.GetTaskByID(ctx, t.ID)
+		if errors.Is(err, ErrNotFound) && j > 0 {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		subtasks, err := b.tx.GetSubtasks(ctx, t.ID)
+		if err != nil {
+			return nil, err
+		}
+		if err := b.tx.DeleteTask(ctx, t.ID); err != nil {
+			return nil, err
+		}
+		changes = append(changes, batchChange{id: t.ID, before: &before})
+		for i := range subtasks {
+			changes = append(changes, batchChange{id: subtasks[i].ID, before: &subtasks[i]})
+		}
+	}
+	return changes, nil
+}
+
+func (b *batchRun) setCompleted(ctx context.Context, targets []Task, completed, force bool) ([]batchChange, error) {
+	var changes []batchChange
+	for _, t := range targets {
+		// Re-read: completing an earlier target may have cascaded here.
+		task, err := b.tx.GetTaskByID(ctx, t.ID)
+		if err != nil {
+			return nil, err
+		}
+		if task.Completed == completed {
+			continue
+		}
+		task.Completed = completed
+		var updated UpdateResult
+		if err := b.tx.UpdateTask(ctx, &task, UpdateOptions{Force: force, Result: &updated}); err != nil {
+			return nil, fmt.Errorf("task %d: %w", t.ID, err)
+		}
+		changes = append(changes, updateChanges(t.ID, updated)...)
+	}
+	return changes, nil
+}
+
+func (b *batchRun) authorized(ctx context.Context, id int) (Task, error) {
+	if id <= 0 {
+		return Task{}, fmt.Errorf("%w: id is required", ErrInvalid)
+	}
+	return b.auth.task(ctx, b.tx, id)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func postBatch(t *testing.T, s *SQLiteStore, events *EventBus, userID int, body string) (int, batchResponse) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/tasks:batch", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	batchTasksHandler(s, s, s, events).ServeHTTP(rr, withUser(req, userID))
+
+	var resp batchResponse
+	if rr.Header().Get("Content-Type") == "application/json" {
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode %q: %v", rr.Body, err)
+		}
+	}
+	return rr.Code, resp
+}
+
+func TestBatch_AtomicAppliesEverything(t *testing.T) {
+	s := newTestStore(t)
+	events := NewEventBus()
+	var got []string
+	events.Subscribe(func(_ context.Context, e Event) { got = append(got, e.Type) })
+
+	old := Task{Title: "old"}
+	if err := s.CreateTask(t.Context(), &old); err != nil {
+		t.Fatal(err)
+	}
+
+	code, resp := postBatch(t, s, events, 1, fmt.Sprintf(`{"operations":[
+		{"op":"create","task":{"title":"a"}},
+		{"op":"update","id":%d,"task":{"title":"renamed"}},
+		{"op":"complete","id":%d}
+	]}`, old.ID, old.ID))
+	if code != http.StatusOK || !resp.Committed {
+		t.Fatalf("status %d committed=%v: %+v", code, resp.Committed, resp)
+	}
+	want := []int{http.StatusCreated, http.StatusOK, http.StatusOK}
+	for i, res := range resp.Results {
+		if res.Status != want[i] {
+			t.Errorf("result %d: status %d, want %d (%s)", i, res.Status, want[i], res.Error)
+		}
+	}
+	if resp.Results[0].Task == nil || resp.Results[0].Task.Title != "a" {
+		t.Errorf("create result task = %+v", resp.Results[0].Task)
+	}
+
+	task, err := s.GetTaskByID(t.Context(), old.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Title != "renamed" || !task.Completed {
+		t.Errorf("task = %+v, want renamed and completed", task)
+	}
+	if strings.Join(got, ",") != "task.created,task.updated,task.completed" {
+		t.Errorf("events = %v", got)
+	}
+}
+
+func TestBatch_UpdateKeepsOmittedFields(t *testing.T) {
+	s := newTestStore(t)
+	parent := Task{Title: "parent"}
+	if err := s.CreateTask(t.Context(), &parent); err != nil {
+		t.Fatal(err)
+	}
+	due := time.Date(2030, 1, 2, 9, 0, 0, 0, time.UTC)
+	sub := Task{Title: "sub", ParentID: &parent.ID, DueAt: &due}
+	if err := s.CreateTask(t.Context(), &sub); err != nil {
+		t.Fatal(err)
+	}
+
+	code, resp := postBatch(t, s, nil, 1, fmt.Sprintf(`{"operations":[
+		{"op":"update","id":%d,"task":{"title":"renamed"}}
+	]}`, sub.ID))
+	if code != http.StatusOK || resp.Results[0].Status != http.StatusOK {
+		t.Fatalf("status %d: %+v", code, resp)
+	}
+	got, err := s.GetTaskByID(t.Context(), sub.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "renamed" || got.ParentID == nil || *got.ParentID != parent.ID || got.DueAt == nil || !got.DueAt.Equal(due) {
+		t.Errorf("task = %+v, want renamed with parent and due date kept", got)
+	}
+}
+
+func TestBatch_AtomicRollsBackOnFailure(t *testing.T) {
+	s := newTestStore(t)
+	events := NewEventBus()
+	published := 0
+	events.Subscribe(func(context.Context, Event) { published++ })
+
+	code, resp := postBatch(t, s, events, 1, `{"operations":[
+		{"op":"create","task":{"title":"a"}},
+		{"op":"delete","id":999},
+		{"op":"create","task":{"title":"b"}}
+	]}`)
+	if code != http.StatusNotFound || resp.Committed {
+		t.Fatalf("status %d committed=%v", code, resp.Committed)
+	}
+	want := []int{http.StatusFailedDependency, http.StatusNotFound, http.StatusFailedDependency}
+	for i, res := range resp.Results {
+		if res.Status != want[i] {
+			t.Errorf("result %d: status %d, want %d", i, res.Status, want[i])
+		}
+	}
+	if resp.Results[0].ID != 0 {
+		t.Errorf("rolled back create still reports id %d", resp.Results[0].ID)
+	}
+
+	tasks, err := s.GetAllTasks(t.Context(), TaskFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 0 || published != 0 {
+		t.Errorf("%d tasks and %d events after rollback", len(tasks), published)
+	}
+}
+
+func TestBatch_BestEffortKeepsSuccesses(t *testing.T) {
+	s := newTestStore(t)
+
+	code, resp := postBatch(t, s, nil, 1, `{"mode":"best_effort","operations":[
+		{"op":"create","task":{"title":"a"}},
+		{"op":"create","task":{"title":""}},
+		{"op":"create","task":{"title":"c"}}
+	]}`)
+	if code != http.StatusOK || !resp.Committed {
+		t.Fatalf("status %d committed=%v", code, resp.Committed)
+	}
+	want := []int{http.StatusCreated, http.StatusBadRequest, http.StatusCreated}
+	for i, res := range resp.Results {
+		if res.Status != want[i] {
+			t.Errorf("result %d: status %d, want %d", i, res.Status, want[i])
+		}
+	}
+
+	tasks, err := s.GetAllTasks(t.Context(), TaskFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 2 {
+		t.Errorf("got %d tasks, want 2", len(tasks))
+	}
+}
+
+func TestBatch_CompleteByFilter(t *testing.T) {
+	s := newTestStore(t)
+	for _, title := range []string{"a", "b", "c"} {
+		task := Task{Title: title}
+		if err := s.CreateTask(t.Context(), &task); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	code, resp := postBatch(t, s, nil, 1, `{"operations":[{"op":"complete","filter":{"completed":false}}]}`)
+	if code != http.StatusOK || len(resp.Results[0].IDs) != 3 {
+		t.Fatalf("status %d: %+v", code, resp.Results)
+	}
+	open := false
+	tasks, err := s.GetAllTasks(t.Context(), TaskFilter{Completed: &open})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 0 {
+		t.Errorf("%d tasks still open", len(tasks))
+	}
+}
+
+func TestBatch_AuthorizesEveryAffectedTask(t *testing.T) {
+	s := newTestStore(t)
+	alice, bob := User{Name: "Alice"}, User{Name: "Bob"}
+	for _, u := range []*User{&alice, &bob} {
+		if err := s.CreateUser(t.Context(), u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	parent := Task{Title: "parent", Assignees: []int{alice.ID}}
+	if err := s.CreateTask(t.Context(), &parent); err != nil {
+		t.Fatal(err)
+	}
+	child := Task{Title: "child", ParentID: &parent.ID, Assignees: []int{bob.ID}}
+	if err := s.CreateTask(t.Context(), &child); err != nil {
+		t.Fatal(err)
+	}
+
+	// Alice may delete her task, but not Bob's subtask that would go with it.
+	body := fmt.Sprintf(`{"operations":[{"op":"delete","id":%d}]}`, parent.ID)
+	if code, resp := postBatch(t, s, nil, alice.ID, body); code != http.StatusForbidden {
+		t.Fatalf("status %d: %+v", code, resp.Results)
+	}
+	if _, err := s.GetTaskByID(t.Context(), child.ID); err != nil {
+		t.Fatalf("child deleted: %v", err)
+	}
+
+	code, resp := postBatch(t, s, nil, bob.ID, fmt.Sprintf(`{"operations":[{"op":"complete","id":%d}]}`, child.ID))
+	if code != http.StatusOK {
+		t.Fatalf("bob completing own task: status %d: %+v", code, resp.Results)
+	}
+}
+
+func TestBatch_Limits(t *testing.T) {
+	s := newTestStore(t)
+
+	ops := make([]string, maxBatchOperations+1)
+	for i := range ops {
+		ops[i] = `{"op":"create","task":{"title":"x"}}`
+	}
+	code, _ := postBatch(t, s, nil, 1, `{"operations":[`+strings.Join(ops, ",")+`]}`)
+	if code != http.StatusRequestEntityTooLarge {
+		t.Errorf("too many operations: status %d", code)
+	}
+
+	if code, _ := postBatch(t, s, nil, 1, `{"mode":"eventually","operations":[{"op":"delete","id":1}]}`); code != http.StatusBadRequest {
+		t.Errorf("bad mode: status %d", code)
+	}
+}
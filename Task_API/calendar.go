@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	calendarProdID    = "-//taskapi//Tasks//EN"
+	calendarUIDDomain = "taskapi"
+	// icalLineLimit is the longest a content line may be, in octets,
+	// before it has to be folded (RFC 5545 section 3.1).
+	icalLineLimit = 75
+)
+
+// calendarUID is stable for the life of a task, so clients update entries
+// in place rather than duplicating them. The VTODO and the VEVENT for the
+// same task need different UIDs.
+func calendarUID(taskID int, component string) string {
+	if component == "VEVENT" {
+		return fmt.Sprintf("task-%d-due@%s", taskID, calendarUIDDomain)
+	}
+	return fmt.Sprintf("task-%d@%s", taskID, calendarUIDDomain)
+}
+
+// icalWriter writes RFC 5545 content lines: CRLF endings, long lines folded
+// and text values escaped.
+type icalWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *icalWriter) line(s string) {
+	limit := icalLineLimit
+	for len(s) > limit {
+		cut := limit
+		// Never split a UTF-8 sequence across a fold.
+		for !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		w.buf.WriteString(s[:cut])
+		w.buf.WriteString("\r\n ")
+		s = s[cut:]
+		// Continuation lines start with a space, which counts.
+		limit = icalLineLimit - 1
+	}
+	w.buf.WriteString(s)
+	w.buf.WriteString("\r\n")
+}
+
+func (w *icalWriter) text(name, value string) {
+	w.line(name + ":" + icalEscape(value))
+}
+
+func (w *icalWriter) time(name string, t time.Time) {
+	w.line(name + ":" + t.UTC().Format("20060102T150405Z"))
+}
+
+var icalEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+func icalEscape(s string) string {
+	return icalEscaper.Replace(s)
+}
+
+// writeCalendar renders each task with a due date as a VTODO, which carries
+// its completion status, and a VEVENT at the due time, for calendar apps
+// that do not show to-dos.
+func writeCalendar(tasks []Task) []byte {
+	var w icalWriter
+	w.line("BEGIN:VCALENDAR")
+	w.line("VERSION:2.0")
+	w.line("PRODID:" + calendarProdID)
+	w.line("CALSCALE:GREGORIAN")
+	w.text("X-WR-CALNAME", "Tasks")
+	for _, t := range tasks {
+		if t.DueAt == nil {
+			continue
+		}
+
+		w.line("BEGIN:VTODO")
+		w.line("UID:" + calendarUID(t.ID, "VTODO"))
+		w.time("DTSTAMP", t.UpdatedAt)
+		w.time("CREATED", t.CreatedAt)
+		w.time("LAST-MODIFIED", t.UpdatedAt)
+		w.text("SUMMARY", t.Title)
+		if t.Description != "" {
+			w.text("DESCRIPTION", t.Description)
+		}
+		w.time("DUE", *t.DueAt)
+		switch {
+		case t.Completed:
+			w.line("STATUS:COMPLETED")
+			w.line("PERCENT-COMPLETE:100")
+			// Completion time is not recorded; the last change is the
+			// closest thing to it.
+			w.time("COMPLETED", t.UpdatedAt)
+		case t.Progress > 0:
+			w.line("STATUS:IN-PROCESS")
+			w.line("PERCENT-COMPLETE:" + strconv.Itoa(t.Progress))
+		default:
+			w.line("STATUS:NEEDS-ACTION")
+		}
+		if t.ParentID != nil {
+			w.line("RELATED-TO:" + calendarUID(*t.ParentID, "VTODO"))
+		}
+		w.line("END:VTODO")
+
+		w.line("BEGIN:VEVENT")
+		w.line("UID:" + calendarUID(t.ID, "VEVENT"))
+		w.time("DTSTAMP", t.UpdatedAt)
+		w.time("LAST-MODIFIED", t.UpdatedAt)
+		w.text("SUMMARY", t.Title)
+		if t.Description != "" {
+			w.text("DESCRIPTION", t.Description)
+		}
+		w.time("DTSTART", *t.DueAt)
+		w.time("DTEND", *t.DueAt)
+		w.line("TRANSP:TRANSPARENT")
+		w.line("STATUS:CONFIRMED")
+		w.line("RELATED-TO:" + calendarUID(t.ID, "VTODO"))
+		w.line("END:VEVENT")
+	}
+	w.line("END:VCALENDAR")
+	return w.buf.Bytes()
+}
+
+// calendarFeedHandler serves GET /calendar/{token}.ics: the due tasks
+// assigned to the token's owner. The token is the only credential, so an
+// unknown one is a plain 404. Responses carry an ETag of the body so
+// polling clients get a 304 when nothing changed.
+func calendarFeedHandler(cal CalendarStore, tasks TaskStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutSuffix(r.PathValue("file"), ".ics")
+		if !ok || token == "" {
+			writeErr(w, http.StatusNotFound, "not found")
+			return
+		}
+		userID, err := cal.CalendarUser(r.Context(), token)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				writeErr(w, http.StatusNotFound, "not found")
+			} else {
+				writeErr(w, http.StatusInternalServerError, "internal error")
+			}
+			return
+		}
+		list, err := tasks.GetAllTasks(r.Context(), TaskFilter{Assignee: &userID})
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		body := writeCalendar(list)
+		sum := sha256.Sum256(body)
+		etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "private, no-cache")
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method != http.MethodHead {
+			_, _ = w.Write(body)
+		}
+	}
+}
+
+// etagMatches implements the weak comparison If-None-Match calls for.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// rotateCalendarTokenHandler issues a new feed token for the caller. Any
+// previous feed URL stops working.
+func rotateCalendarTokenHandler(cal CalendarStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, err := cal.RotateCalendarToken(r.Context(), getUserID(r))
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]string{
+			"token": token,
+			"url":   "/calendar/" + token + ".ics",
+		})
+	}
+}
+
+func revokeCalendarTokenHandler(cal CalendarStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := cal.RevokeCalendarToken(r.Context(), getUserID(r)); err != nil {
+			if errors.Is(err, ErrNotFound) {
+				writeErr(w, http.StatusNotFound, "not found")
+			} else {
+				writeErr(w, http.StatusInternalServerError, "internal error")
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
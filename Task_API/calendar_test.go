@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// icalComponent is a parsed VTODO or VEVENT: property name to unescaped
+// value, ignoring parameters.
+type icalComponent struct {
+	Kind  string
+	Props map[string]string
+}
+
+// parseICal is a small RFC 5545 reader for checking what the feed emits. It
+// fails the test on anything malformed, including unfolded lines that are
+// too long.
+func parseICal(t *testing.T, data string) []icalComponent {
+	t.Helper()
+	if !strings.HasSuffix(data, "\r\n") {
+		t.Fatal("feed does not end with CRLF")
+	}
+	var lines []string
+	for _, raw := range strings.Split(strings.TrimSuffix(data, "\r\n"), "\r\n") {
+		if len(raw) > icalLineLimit {
+			t.Fatalf("line longer than %d octets: %q", icalLineLimit, raw)
+		}
+		if strings.HasPrefix(raw, " ") {
+			if len(lines) == 0 {
+				t.Fatal("feed starts with a continuation line")
+			}
+			lines[len(lines)-1] += raw[1:]
+			continue
+		}
+		lines = append(lines, raw)
+	}
+
+	unescape := strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+	var out []icalComponent
+	var cur *icalComponent
+	depth := 0
+	for _, l := range lines {
+		name, value, ok := strings.Cut(l, ":")
+		if !ok {
+			t.Fatalf("malformed line %q", l)
+		}
+		name, _, _ = strings.Cut(name, ";")
+		switch name {
+		case "BEGIN":
+			depth++
+			if value == "VTODO" || value == "VEVENT" {
+				cur = &icalComponent{Kind: value, Props: map[string]string{}}
+			}
+		case "END":
+			depth--
+			if cur != nil && value == cur.Kind {
+				out = append(out, *cur)
+				cur = nil
+			}
+		default:
+			if cur != nil {
+				cur.Props[name] = unescape.Replace(value)
+			}
+		}
+	}
+	if depth != 0 {
+		t.Fatalf("unbalanced BEGIN/END (depth %d)", depth)
+	}
+	return out
+}
+
+func TestCalendar_FeedRoundTrips(t *testing.T) {
+	s := newTestStore(t)
+	alice := User{Name: "Alice"}
+	if err := s.CreateUser(t.Context(), &alice); err != nil {
+		t.Fatal(err)
+	}
+	due := time.Date(2026, 5, 4, 9, 30, 0, 0, time.UTC)
+	long := strings.Repeat("Résumé; notes, and more. ", 8) + "\nsecond line"
+	open := Task{Title: "Ship v2, finally", Description: long, DueAt: &due, Assignees: []int{alice.ID}}
+	done := Task{Title: "Book venue", DueAt: &due, Assignees: []int{alice.ID}}
+	undated := Task{Title: "Someday", Assignees: []int{alice.ID}}
+	other := Task{Title: "Not Alice's", DueAt: &due}
+	for _, task := range []*Task{&open, &done, &undated, &other} {
+		if err := s.CreateTask(t.Context(), task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	done.Completed = true
+	if err := s.UpdateTask(t.Context(), &done, UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := s.RotateCalendarToken(t.Context(), alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /calendar/{file}", calendarFeedHandler(s, s))
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/calendar/"+token+".ics", nil))
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/calendar") {
+		t.Fatalf("status %d, content type %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+
+	byUID := map[string]icalComponent{}
+	for _, c := range parseICal(t, rr.Body.String()) {
+		byUID[c.Props["UID"]] = c
+	}
+	if len(byUID) != 4 {
+		t.Fatalf("got %d components, want a VTODO and a VEVENT for each of 2 tasks", len(byUID))
+	}
+
+	todo := byUID[calendarUID(open.ID, "VTODO")]
+	if todo.Kind != "VTODO" || todo.Props["SUMMARY"] != open.Title || todo.Props["DESCRIPTION"] != long {
+		t.Errorf("open VTODO = %+v", todo)
+	}
+	if todo.Props["STATUS"] != "NEEDS-ACTION" || todo.Props["DUE"] != "20260504T093000Z" {
+		t.Errorf("open VTODO status/due = %q/%q", todo.Props["STATUS"], todo.Props["DUE"])
+	}
+	if c := byUID[calendarUID(done.ID, "VTODO")]; c.Props["STATUS"] != "COMPLETED" || c.Props["PERCENT-COMPLETE"] != "100" {
+		t.Errorf("done VTODO = %+v", c)
+	}
+	if c := byUID[calendarUID(open.ID, "VEVENT")]; c.Kind != "VEVENT" || c.Props["DTSTART"] != "20260504T093000Z" {
+		t.Errorf("VEVENT = %+v", c)
+	}
+
+	// Same content, same ETag: a conditional request gets a 304.
+	etag := rr.Header().Get("ETag")
+	req := httptest.NewRequest(http.MethodGet, "/calendar/"+token+".ics", nil)
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("conditional GET: status %d, want 304", rr.Code)
+	}
+
+	// A change to a task in the feed changes the ETag.
+	open.Title = "Ship v2"
+	if err := s.UpdateTask(t.Context(), &open, UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") == etag {
+		t.Errorf("after change: status %d, etag unchanged=%v", rr.Code, rr.Header().Get("ETag") == etag)
+	}
+}
+
+func TestCalendar_RotateInvalidatesOldToken(t *testing.T) {
+	s := newTestStore(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /calendar/{file}", calendarFeedHandler(s, s))
+	get := func(token string) int {
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/calendar/"+token+".ics", nil))
+		return rr.Code
+	}
+
+	req := withUser(httptest.NewRequest(http.MethodPost, "/users/me/calendar/token", nil), demoUserID)
+	rr := httptest.NewRecorder()
+	rotateCalendarTokenHandler(s).ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("rotate: status %d", rr.Code)
+	}
+	var issued struct{ Token, URL string }
+	if err := json.Unmarshal(rr.Body.Bytes(), &issued); err != nil {
+		t.Fatal(err)
+	}
+	if issued.URL != "/calendar/"+issued.Token+".ics" || get(issued.Token) != http.StatusOK {
+		t.Fatalf("issued %+v does not serve a feed", issued)
+	}
+
+	old, _ := s.RotateCalendarToken(t.Context(), demoUserID)
+	if get(old) != http.StatusOK {
+		t.Fatal("fresh token rejected")
+	}
+	if _, err := s.RotateCalendarToken(t.Context(), demoUserID); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(old); got != http.StatusNotFound {
+		t.Errorf("rotated-out token: status %d, want 404", got)
+	}
+	if got := get("nope"); got != http.StatusNotFound {
+		t.Errorf("unknown token: status %d, want 404", got)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+const (
+	defaultCommentPageSize = 20
+	maxCommentPageSize     = 100
+)
+
+var mentionRe = regexp.MustCompile(`(?:^|[^\w@])@([A-Za-z0-9_.\-]+)`)
+
+// parseMentions returns the distinct names mentioned as @name in a Markdown
+// body, in order of first appearance. Mentions inside inline code are still
+// picked up; bodies are stored raw and not rendered server-side.
+func parseMentions(body string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, m := range mentionRe.FindAllStringSubmatch(body, -1) {
+		name := m[1]
+		for len(name) > 0 && (name[len(name)-1] == '.' || name[len(name)-1] == '-') {
+			name = name[:len(name)-1]
+		}
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+type commentPage struct {
+	Comments   []Comment `json:"comments"`
+	NextCursor int       `json:"nextCursor,omitempty"`
+}
+
+type commentReq struct {
+	Body string `json:"body"`
+}
+
+func listCommentsHandler(store CommentStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		taskID, err := strconv.Atoi(r.PathValue("ID"))
+		if err != nil || taskID <= 0 {
+			writeErr(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+
+		limit := defaultCommentPageSize
+		if val := r.URL.Query().Get("limit"); val != "" {
+			limit, err = strconv.Atoi(val)
+			if err != nil || limit <= 0 || limit > maxCommentPageSize {
+				writeErr(w, http.StatusBadRequest, "invalid limit value")
+				return
+			}
+		}
+		after := 0
+		if val := r.URL.Query().Get("cursor"); val != "" {
+			after, err = strconv.Atoi(val)
+			if err != nil || after < 0 {
+				writeErr(w, http.StatusBadRequest, "invalid cursor value")
+				return
+			}
+		}
+
+		// Ask for one extra row to know whether another page exists.
+		comments, err := store.ListComments(r.Context(), taskID, after, limit+1)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				writeErr(w, http.StatusNotFound, "not found")
+			} else {
+				writeErr(w, http.StatusInternalServerError, "internal error")
+			}
+			return
+		}
+
+		page := commentPage{Comments: comments}
+		if len(comments) > limit {
+			page.Comments = comments[:limit]
+			page.NextCursor = page.Comments[limit-1].ID
+		}
+		writeJSON(w, http.StatusOK, page)
+	}
+}
+
+func postCommentHandler(store CommentStore, events *EventBus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		taskID, err := strconv.Atoi(r.PathValue("ID"))
+		if err != nil || taskID <= 0 {
+			writeErr(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		var req commentReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeErr(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+
+		c := Comment{TaskID: taskID, AuthorID: getUserID(r), Body: req.Body}
+		if err := store.CreateComment(r.Context(), &c); err != nil {
+			switch {
+			case errors.Is(err, ErrInvalid):
+				writeErr(w, http.StatusBadRequest, err.Error())
+			case errors.Is(err, ErrNotFound):
+				writeErr(w, http.StatusNotFound, "not found")
+			default:
+				writeErr(w, http.StatusInternalServerError, "internal error")
+			}
+			return
+		}
+
+		events.PublishFrom(r.Context(), Event{Type: EventCommentCreated, TaskID: taskID, CommentID: c.ID, ActorID: c.AuthorID})
+		for _, userID := range c.Mentions {
+			events.PublishFrom(r.Context(), Event{Type: EventUserMentioned, TaskID: taskID, CommentID: c.ID, UserID: userID, ActorID: c.AuthorID})
+		}
+		writeJSON(w, http.StatusCreated, c)
+	}
+}
+
+// loadTaskComment fetches the comment named in the path and checks that it
+// belongs to the task in the path. It writes the error response itself.
+func loadTaskComment(w http.ResponseWriter, r *http.Request, store CommentStore) (Comment, bool) {
+	taskID, err := strconv.Atoi(r.PathValue("ID"))
+	if err != nil || taskID <= 0 {
+		writeErr(w, http.StatusBadRequest, "invalid id")
+		return Comment{}, false
+	}
+	commentID, err := strconv.Atoi(r.PathValue("CommentID"))
+	if err != nil || commentID <= 0 {
+		writeErr(w, http.StatusBadRequest, "invalid comment id")
+		return Comment{}, false
+	}
+
+	c, err := store.GetComment(r.Context(), commentID)
+	if err != nil || c.TaskID != taskID {
+		if err == nil || errors.Is(err, ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "not found")
+		} else {
+			writeErr(w, http.StatusInternalServerError, "internal error")
+		}
+		return Comment{}, false
+	}
+	return c, true
+}
+
+// updateCommentHandler lets only the comment's author change its body.
+func updateCommentHandler(store CommentStore, events *EventBus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, ok := loadTaskComment(w, r, store)
+		if !ok {
+			return
+		}
+		if c.AuthorID != getUserID(r) {
+			writeErr(w, http.StatusForbidden, "only the author can edit a comment")
+			return
+		}
+
+		var req commentReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeErr(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		before := make(map[int]bool, len(c.Mentions))
+		for _, id := range c.Mentions {
+			before[id] = true
+		}
+
+		c.Body = req.Body
+		if err := store.UpdateComment(r.Context(), &c); err != nil {
+			switch {
+			case errors.Is(err, ErrInvalid):
+				writeErr(w, http.StatusBadRequest, err.Error())
+			case errors.Is(err, ErrNotFound):
+				writeErr(w, http.StatusNotFound, "not found")
+			default:
+				writeErr(w, http.StatusInternalServerError, "internal error")
+			}
+			return
+		}
+
+		for _, userID := range c.Mentions {
+			if !before[userID] {
+				events.PublishFrom(r.Context(), Event{Type: EventUserMentioned, TaskID: c.TaskID, CommentID: c.ID, UserID: userID, ActorID: c.AuthorID})
+			}
+		}
+		writeJSON(w, http.StatusOK, c)
+	}
+}
+
+// deleteCommentHandler allows workspace admins to remove any comment.
+// Authors may also remove their own.
+func deleteCommentHandler(store CommentStore, users UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, ok := loadTaskComment(w, r, store)
+		if !ok {
+			return
+		}
+		userID := getUserID(r)
+		if c.AuthorID != userID && !isAdmin(r.Context(), users, userID) {
+			writeErr(w, http.StatusForbidden, "only the author or an admin can delete a comment")
+			return
+		}
+		if err := store.DeleteComment(r.Context(), c.ID); err != nil {
+			if errors.Is(err, ErrNotFound) {
+				writeErr(w, http.StatusNotFound, "not found")
+			} else {
+				writeErr(w, http.StatusInternalServerError, "internal error")
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	body := "Thanks @alice and @Bob.\nCC @alice, not an email: carol@example.com"
+
+	got := parseMentions(body)
+
+	if want := []string{"alice", "Bob"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestSQLiteStore_CommentMentions(t *testing.T) {
+	s := newTestStore(t)
+	alice, bob := User{Name: "Alice", Email: "alice@example.com"}, User{Name: "bob", Email: "bob@example.com"}
+	for _, u := range []*User{&alice, &bob} {
+		if err := s.CreateUser(t.Context(), u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	task := Task{Title: "discuss"}
+	if err := s.CreateTask(t.Context(), &task); err != nil {
+		t.Fatal(err)
+	}
+
+	c := Comment{TaskID: task.ID, AuthorID: demoUserID, Body: "@BOB and @alice, not @ghost; again @Bob"}
+	if err := s.CreateComment(t.Context(), &c); err != nil {
+		t.Fatal(err)
+	}
+	want := []int{alice.ID, bob.ID}
+	if !reflect.DeepEqual(c.Mentions, want) {
+		t.Errorf("created mentions = %v, want %v", c.Mentions, want)
+	}
+	plain := Comment{TaskID: task.ID, AuthorID: demoUserID, Body: "no mentions"}
+	if err := s.CreateComment(t.Context(), &plain); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := s.ListComments(t.Context(), task.ID, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || !reflect.DeepEqual(list[0].Mentions, want) || !reflect.DeepEqual(list[1].Mentions, []int{}) {
+		t.Errorf("listed = %+v", list)
+	}
+}
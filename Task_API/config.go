@@ -0,0 +1,657 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is everything the server can be configured with. LoadConfig fills
+// it from, in increasing order of precedence: the defaults, a YAML or TOML
+// config file, environment variables and command-line flags.
+type Config struct {
+	Server    ServerConfig
+	Auth      AuthConfig
+	CORS      CORSConfig
+	RateLimit RateLimitConfig
+	LogLevel  slog.Level
+	BlobDir   string
+	DB        DBConfig
+	Backup    BackupConfig
+	SMTP      SMTPConfig
+	Subtasks  SubtaskPolicy
+	Tracing   TracingConfig
+
+	// sources records where each setting's value came from, by key.
+	sources map[string]string
+}
+
+type ServerConfig struct {
+	Addr            string
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration
+	// DrainDelay is how long to keep serving after readiness starts
+	// failing, so load balancers notice before the listener closes.
+	DrainDelay time.Duration
+}
+
+type AuthConfig struct {
+	JWTSecret string
+	// PreviousJWTSecrets still verify tokens but sign none, for rotating
+	// the secret without logging everyone out.
+	PreviousJWTSecrets []string
+	TokenTTL           time.Duration
+}
+
+type CORSConfig struct {
+	// Origins allowed to call the API from a browser. Empty or "*" allows
+	// any origin.
+	Origins []string
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Server: ServerConfig{
+			Addr:            ":8080",
+			ReadTimeout:     10 * time.Second,
+			WriteTimeout:    15 * time.Second,
+			IdleTimeout:     60 * time.Second,
+			ShutdownTimeout: 5 * time.Second,
+		},
+		Auth:      AuthConfig{TokenTTL: 2 * time.Hour},
+		RateLimit: RateLimitConfig{Burst: 20},
+		LogLevel:  slog.LevelInfo,
+		BlobDir:   "./blobs",
+		DB:        DefaultDBConfig(),
+		Backup:    BackupConfig{Dir: "./backups", Keep: 7},
+		SMTP: SMTPConfig{
+			Port:     587,
+			From:     "tasks@localhost",
+			StartTLS: StartTLSRequired,
+			Timeout:  10 * time.Second,
+		},
+		Subtasks: DefaultSubtaskPolicy(),
+		Tracing: TracingConfig{
+			OTLPEndpoint: "http://localhost:4318",
+			ServiceName:  "taskapi",
+		},
+	}
+}
+
+// setting ties a config file key, which is also the flag name, to its
+// environment variable and the field it sets.
+type setting struct {
+	key    string
+	env    string
+	usage  string
+	secret bool
+	field  func(c *Config) any
+}
+
+var settings = []setting{
+	{key: "server.addr", env: "TASK_API_ADDR", usage: "address to listen on",
+		field: func(c *Config) any { return &c.Server.Addr }},
+	{key: "server.read_timeout", env: "TASK_API_READ_TIMEOUT", usage: "maximum time to read a request",
+		field: func(c *Config) any { return &c.Server.ReadTimeout }},
+	{key: "server.write_timeout", env: "TASK_API_WRITE_TIMEOUT", usage: "maximum time to write a response",
+		field: func(c *Config) any { return &c.Server.WriteTimeout }},
+	{key: "server.idle_timeout", env: "TASK_API_IDLE_TIMEOUT", usage: "how long idle keep-alive connections stay open",
+		field: func(c *Config) any { return &c.Server.IdleTimeout }},
+	{key: "server.shutdown_timeout", env: "TASK_API_SHUTDOWN_TIMEOUT", usage: "how long shutdown waits for active requests",
+		field: func(c *Config) any { return &c.Server.ShutdownTimeout }},
+	{key: "server.drain_delay", env: "TASK_API_DRAIN_DELAY", usage: "how long to keep serving after readiness fails on shutdown",
+		field: func(c *Config) any { return &c.Server.DrainDelay }},
+
+	{key: "auth.jwt_secret", env: "TASK_API_JWT_SECRET", usage: "HMAC key for signing and verifying tokens", secret: true,
+		field: func(c *Config) any { return &c.Auth.JWTSecret }},
+	{key: "auth.previous_jwt_secrets", env: "TASK_API_JWT_PREVIOUS_SECRETS", usage: "comma-separated retired keys still accepted for verification", secret: true,
+		field: func(c *Config) any { return &c.Auth.PreviousJWTSecrets }},
+	{key: "auth.token_ttl", env: "TASK_API_TOKEN_TTL", usage: "lifetime of issued tokens",
+		field: func(c *Config) any { return &c.Auth.TokenTTL }},
+
+	{key: "cors.origins", env: "TASK_API_CORS_ORIGINS", usage: "comma-separated allowed origins; empty allows any",
+		field: func(c *Config) any { return &c.CORS.Origins }},
+	{key: "ratelimit.per_minute", env: "TASK_API_RATE_LIMIT", usage: "requests a minute allowed per client IP; 0 turns limiting off",
+		field: func(c *Config) any { return &c.RateLimit.PerMinute }},
+	{key: "ratelimit.burst", env: "TASK_API_RATE_LIMIT_BURST", usage: "requests a client may make at once before the rate applies",
+		field: func(c *Config) any { return &c.RateLimit.Burst }},
+	{key: "log.level", env: "TASK_API_LOG_LEVEL", usage: "debug, info, warn or error",
+		field: func(c *Config) any { return &c.LogLevel }},
+	{key: "blobs.dir", env: "TASK_API_BLOB_DIR", usage: "directory for attachment contents",
+		field: func(c *Config) any { return &c.BlobDir }},
+
+	{key: "db.path", env: "TASK_API_DB_PATH", usage: "SQLite database file",
+		field: func(c *Config) any { return &c.DB.Path }},
+	{key: "db.journal_mode", env: "TASK_API_DB_JOURNAL_MODE", usage: "SQLite journal mode",
+		field: func(c *Config) any { return &c.DB.JournalMode }},
+	{key: "db.synchronous", env: "TASK_API_DB_SYNCHRONOUS", usage: "SQLite synchronous level",
+		field: func(c *Config) any { return &c.DB.Synchronous }},
+	{key: "db.busy_timeout", env: "TASK_API_DB_BUSY_TIMEOUT", usage: "how long SQLite waits on a locked database",
+		field: func(c *Config) any { return &c.DB.BusyTimeout }},
+	{key: "db.foreign_keys", env: "TASK_API_DB_FOREIGN_KEYS", usage: "enforce foreign keys",
+		field: func(c *Config) any { return &c.DB.ForeignKeys }},
+	{key: "db.max_readers", env: "TASK_API_DB_MAX_READERS", usage: "size of the read connection pool",
+		field: func(c *Config) any { return &c.DB.MaxReaders }},
+	{key: "db.busy_retries", env: "TASK_API_DB_BUSY_RETRIES", usage: "retries of a write after busy_timeout",
+		field: func(c *Config) any { return &c.DB.BusyRetries }},
+
+	{key: "backup.dir", env: "TASK_API_BACKUP_DIR", usage: "directory for database snapshots",
+		field: func(c *Config) any { return &c.Backup.Dir }},
+	{key: "backup.interval", env: "TASK_API_BACKUP_INTERVAL", usage: "time between scheduled backups; 0 turns them off",
+		field: func(c *Config) any { return &c.Backup.Interval }},
+	{key: "backup.keep", env: "TASK_API_BACKUP_KEEP", usage: "number of backups kept",
+		field: func(c *Config) any { return &c.Backup.Keep }},
+
+	{key: "smtp.host", env: "TASK_API_SMTP_HOST", usage: "mail server; empty disables email",
+		field: func(c *Config) any { return &c.SMTP.Host }},
+	{key: "smtp.port", env: "TASK_API_SMTP_PORT", usage: "mail server port",
+		field: func(c *Config) any { return &c.SMTP.Port }},
+	{key: "smtp.username", env: "TASK_API_SMTP_USERNAME", usage: "mail server user",
+		field: func(c *Config) any { return &c.SMTP.Username }},
+	{key: "smtp.password", env: "TASK_API_SMTP_PASSWORD", usage: "mail server password", secret: true,
+		field: func(c *Config) any { return &c.SMTP.Password }},
+	{key: "smtp.from", env: "TASK_API_SMTP_FROM", usage: "sender address",
+		field: func(c *Config) any { return &c.SMTP.From }},
+	{key: "smtp.starttls", env: "TASK_API_SMTP_STARTTLS", usage: "none, optional or required",
+		field: func(c *Config) any { return &c.SMTP.StartTLS }},
+	{key: "smtp.insecure_skip_verify", env: "TASK_API_SMTP_INSECURE_SKIP_VERIFY", usage: "accept any TLS certificate",
+		field: func(c *Config) any { return &c.SMTP.InsecureSkipVerify }},
+	{key: "smtp.timeout", env: "TASK_API_SMTP_TIMEOUT", usage: "timeout for talking to the mail server",
+		field: func(c *Config) any { return &c.SMTP.Timeout }},
+
+	{key: "subtasks.max_depth", env: "TASK_API_SUBTASK_MAX_DEPTH", usage: "deepest allowed task tree",
+		field: func(c *Config) any { return &c.Subtasks.MaxDepth }},
+	{key: "subtasks.parent_completion", env: "TASK_API_PARENT_COMPLETION", usage: "allow, reject or cascade",
+		field: func(c *Config) any { return &c.Subtasks.ParentCompletion }},
+	{key: "subtasks.auto_complete_parents", env: "TASK_API_AUTO_COMPLETE_PARENTS", usage: "complete a parent with its last subtask",
+		field: func(c *Config) any { return &c.Subtasks.AutoCompleteParents }},
+
+	{key: "tracing.exporter", env: "TASK_API_TRACING", usage: "otlp or stdout; empty turns tracing off",
+		field: func(c *Config) any { return &c.Tracing.Exporter }},
+	{key: "tracing.otlp_endpoint", env: "OTEL_EXPORTER_OTLP_ENDPOINT", usage: "OTLP/HTTP collector URL",
+		field: func(c *Config) any { return &c.Tracing.OTLPEndpoint }},
+	{key: "tracing.service_name", env: "OTEL_SERVICE_NAME", usage: "service name on exported spans",
+		field: func(c *Config) any { return &c.Tracing.ServiceName }},
+}
+
+// set parses text into the setting's field. Lists are comma-separated
+// unless they come from a config file as a list already.
+func (s setting) set(c *Config, text string, list []string, isList bool) error {
+	p := s.field(c)
+	if l, ok := p.(*[]string); ok {
+		if !isList {
+			list = nil
+			for _, item := range strings.Split(text, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					list = append(list, item)
+				}
+			}
+		}
+		if len(list) == 0 {
+			list = nil // an empty file list is the same as unset
+		}
+		*l = list
+		return nil
+	}
+	if isList {
+		return errors.New("expected a single value, not a list")
+	}
+	text = strings.TrimSpace(text)
+	switch p := p.(type) {
+	case *string:
+		*p = text
+	case *int:
+		v, err := strconv.Atoi(text)
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number", text)
+		}
+		*p = v
+	case *bool:
+		v, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", text)
+		}
+		*p = v
+	case *time.Duration:
+		v, err := time.ParseDuration(text)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration such as 30s or 2h", text)
+		}
+		*p = v
+	case *slog.Level:
+		v, err := parseLogLevel(text)
+		if err != nil {
+			return fmt.Errorf("%q is not debug, info, warn or error", text)
+		}
+		*p = v
+	default:
+		panic("config: unsupported field type for " + s.key)
+	}
+	return nil
+}
+
+// format renders the setting's value as a TOML value, so config print
+// writes a file LoadConfig can read back.
+func (s setting) format(c *Config) string {
+	switch p := s.field(c).(type) {
+	case *string:
+		return strconv.Quote(*p)
+	case *int:
+		return strconv.Itoa(*p)
+	case *bool:
+		return strconv.FormatBool(*p)
+	case *time.Duration:
+		return strconv.Quote(p.String())
+	case *slog.Level:
+		return strconv.Quote(strings.ToLower(p.String()))
+	case *[]string:
+		quoted := make([]string, len(*p))
+		for i, v := range *p {
+			quoted[i] = strconv.Quote(v)
+		}
+		return "[" + strings.Join(quoted, ", ") + "]"
+	}
+	panic("config: unsupported field type for " + s.key)
+}
+
+// redacted is format with a secret's value hidden, but not whether it is
+// set.
+func (s setting) redacted(c *Config) string {
+	value := s.format(c)
+	switch {
+	case !s.secret || value == `""` || value == "[]":
+		return value
+	case strings.HasPrefix(value, "["):
+		return `["<redacted>"]`
+	}
+	return `"<redacted>"`
+}
+
+func (s setting) envUsage() string {
+	return fmt.Sprintf("%s (env %s)", s.usage, s.env)
+}
+
+// LoadConfig builds the configuration from the defaults, then the file
+// named by -config or TASK_API_CONFIG, then environment variables, then
+// flags in args. Every problem found on the way, and every invalid value
+// in the result, is reported together in one error.
+func LoadConfig(args []string) (Config, error) {
+	cfg, err := readConfig(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return cfg, err
+	}
+	return cfg, errors.Join(err, errors.Join(cfg.Validate()...))
+}
+
+// readConfig is LoadConfig without validating the result, for commands
+// that only need some of the settings.
+func readConfig(args []string) (Config, error) {
+	cfg := DefaultConfig()
+	cfg.sources = map[string]string{}
+	var errs []error
+
+	fs := flag.NewFlagSet("taskapi", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("TASK_API_CONFIG"), "YAML or TOML config file (env TASK_API_CONFIG)")
+	flagValues := map[string]string{}
+	for _, s := range settings {
+		fs.Func(s.key, s.envUsage(), func(v string) error {
+			flagValues[s.key] = v
+			return nil
+		})
+	}
+	// A bad flag is reported with everything else rather than on its own,
+	// so the flag package is kept quiet apart from -help.
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); errors.Is(err, flag.ErrHelp) {
+		fs.SetOutput(os.Stderr)
+		fs.PrintDefaults()
+		return cfg, err
+	} else if err != nil {
+		errs = append(errs, err)
+	} else if fs.NArg() > 0 {
+		errs = append(errs, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " ")))
+	}
+
+	if *configFile != "" {
+		values, err := readConfigFile(*configFile)
+		errs = append(errs, err)
+		for _, s := range settings {
+			if v, ok := values[s.key]; ok {
+				if err := s.set(&cfg, v.text, v.list, v.isList); err != nil {
+					errs = append(errs, fmt.Errorf("%s: %s: %w", v.where(*configFile), s.key, err))
+				}
+				cfg.sources[s.key] = "file"
+				delete(values, s.key)
+			}
+		}
+		for _, key := range sortedKeys(values) {
+			errs = append(errs, fmt.Errorf("%s: unknown setting %q", values[key].where(*configFile), key))
+		}
+	}
+
+	for _, s := range settings {
+		if v := os.Getenv(s.env); v != "" {
+			if err := s.set(&cfg, v, nil, false); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", s.env, err))
+			}
+			cfg.sources[s.key] = "env"
+		}
+	}
+	for _, s := range settings {
+		if v, ok := flagValues[s.key]; ok {
+			if err := s.set(&cfg, v, nil, false); err != nil {
+				errs = append(errs, fmt.Errorf("-%s: %w", s.key, err))
+			}
+			cfg.sources[s.key] = "flag"
+		}
+	}
+
+	cfg.normalize()
+	return cfg, errors.Join(errs...)
+}
+
+// normalize puts the enumerations in the case the rest of the code
+// compares against, so either case can be configured.
+func (c *Config) normalize() {
+	c.DB.JournalMode = strings.ToUpper(strings.TrimSpace(c.DB.JournalMode))
+	c.DB.Synchronous = strings.ToUpper(strings.TrimSpace(c.DB.Synchronous))
+	c.SMTP.StartTLS = strings.ToLower(strings.TrimSpace(c.SMTP.StartTLS))
+	c.Subtasks.ParentCompletion = strings.ToLower(strings.TrimSpace(c.Subtasks.ParentCompletion))
+	c.Tracing.Exporter = strings.ToLower(strings.TrimSpace(c.Tracing.Exporter))
+	c.Tracing.OTLPEndpoint = strings.TrimRight(c.Tracing.OTLPEndpoint, "/")
+}
+
+// Validate returns every invalid setting, each naming its key.
+func (c *Config) Validate() []error {
+	var errs []error
+	bad := func(key, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+
+	if _, port, err := net.SplitHostPort(c.Server.Addr); err != nil {
+		bad("server.addr", "%q is not host:port", c.Server.Addr)
+	} else if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		bad("server.addr", "port %q is not between 0 and 65535", port)
+	}
+	for key, d := range map[string]time.Duration{
+		"server.read_timeout":  c.Server.ReadTimeout,
+		"server.write_timeout": c.Server.WriteTimeout,
+		"server.idle_timeout":  c.Server.IdleTimeout,
+		"server.drain_delay":   c.Server.DrainDelay,
+		"db.busy_timeout":      c.DB.BusyTimeout,
+		"backup.interval":      c.Backup.Interval,
+	} {
+		if d < 0 {
+			bad(key, "must not be negative")
+		}
+	}
+	if c.Auth.JWTSecret == "" {
+		bad("auth.jwt_secret", "must be set, or no one can log in")
+	}
+	for key, d := range map[string]time.Duration{
+		"server.shutdown_timeout": c.Server.ShutdownTimeout,
+		"auth.token_ttl":          c.Auth.TokenTTL,
+		"smtp.timeout":            c.SMTP.Timeout,
+	} {
+		if d <= 0 {
+			bad(key, "must be positive")
+		}
+	}
+
+	errs = append(errs, validateOrigins(c.CORS.Origins)...)
+	if c.RateLimit.PerMinute < 0 {
+		bad("ratelimit.per_minute", "must not be negative")
+	}
+	if c.RateLimit.Burst < 1 {
+		bad("ratelimit.burst", "must be at least 1")
+	}
+	if c.BlobDir == "" {
+		bad("blobs.dir", "must be set")
+	}
+
+	if c.DB.Path == "" {
+		bad("db.path", "must be set")
+	}
+	if !slices.Contains(journalModes, c.DB.JournalMode) {
+		bad("db.journal_mode", "%q is not one of %s", c.DB.JournalMode, strings.Join(journalModes, ", "))
+	}
+	if !slices.Contains(syncLevels, c.DB.Synchronous) {
+		bad("db.synchronous", "%q is not one of %s", c.DB.Synchronous, strings.Join(syncLevels, ", "))
+	}
+	if c.DB.MaxReaders < 1 {
+		bad("db.max_readers", "must be at least 1")
+	}
+	if c.DB.BusyRetries < 0 {
+		bad("db.busy_retries", "must not be negative")
+	}
+
+	if c.Backup.Dir == "" {
+		bad("backup.dir", "must be set")
+	}
+	if c.Backup.Keep < 1 {
+		bad("backup.keep", "must be at least 1")
+	}
+
+	if c.SMTP.Port < 1 || c.SMTP.Port > 65535 {
+		bad("smtp.port", "%d is not between 1 and 65535", c.SMTP.Port)
+	}
+	if !slices.Contains([]string{StartTLSNone, StartTLSOptional, StartTLSRequired}, c.SMTP.StartTLS) {
+		bad("smtp.starttls", "%q is not none, optional or required", c.SMTP.StartTLS)
+	}
+	if c.SMTP.Enabled() && !strings.Contains(c.SMTP.From, "@") {
+		bad("smtp.from", "%q is not an email address", c.SMTP.From)
+	}
+
+	if c.Subtasks.MaxDepth < 1 {
+		bad("subtasks.max_depth", "must be at least 1")
+	}
+	if !slices.Contains([]string{ParentCompletionAllow, ParentCompletionReject, ParentCompletionCascade}, c.Subtasks.ParentCompletion) {
+		bad("subtasks.parent_completion", "%q is not allow, reject or cascade", c.Subtasks.ParentCompletion)
+	}
+
+	if !slices.Contains([]string{"", "otlp", "stdout"}, c.Tracing.Exporter) {
+		bad("tracing.exporter", "%q is not otlp or stdout", c.Tracing.Exporter)
+	}
+	if c.Tracing.Exporter == "otlp" {
+		if u, err := url.Parse(c.Tracing.OTLPEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			bad("tracing.otlp_endpoint", "%q is not an http or https URL", c.Tracing.OTLPEndpoint)
+		}
+	}
+	return errs
+}
+
+func validateOrigins(origins []string) []error {
+	var errs []error
+	for _, o := range origins {
+		if o == "*" {
+			if len(origins) > 1 {
+				errs = append(errs, errors.New(`cors.origins: "*" cannot be combined with other origins`))
+			}
+			continue
+		}
+		u, err := url.Parse(o)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			errs = append(errs, fmt.Errorf("cors.origins: %q is not an origin such as https://app.example.com", o))
+		}
+	}
+	return errs
+}
+
+// Print writes the configuration as a TOML file with each setting's
+// source as a comment. Secrets are redacted.
+func (c *Config) Print(w io.Writer) {
+	section := ""
+	for _, s := range settings {
+		sec, name, _ := strings.Cut(s.key, ".")
+		if sec != section {
+			if section != "" {
+				fmt.Fprintln(w)
+			}
+			fmt.Fprintf(w, "[%s]\n", sec)
+			section = sec
+		}
+		value := s.redacted(c)
+		source := c.sources[s.key]
+		if source == "" {
+			source = "default"
+		}
+		fmt.Fprintf(w, "%s = %s # %s\n", name, value, source)
+	}
+}
+
+// runConfig implements "config print [flags]", which shows the effective
+// configuration the server would start with.
+func runConfig(args []string) error {
+	if len(args) == 0 || args[0] != "print" {
+		return errors.New("usage: config print [-config file] [flags]")
+	}
+	cfg, err := LoadConfig(args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	cfg.Print(os.Stdout)
+	if err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return nil
+}
+
+// fileValue is a setting as read from a config file.
+type fileValue struct {
+	text   string
+	list   []string
+	isList bool
+	// line is where the value is set, or 0 where the parser does not say.
+	line int
+}
+
+// where names the file, and the line when known, for an error message.
+func (v fileValue) where(path string) string {
+	if v.line > 0 {
+		return fmt.Sprintf("%s:%d", path, v.line)
+	}
+	return path
+}
+
+// readConfigFile reads a YAML (.yaml, .yml) or TOML (.toml) file into
+// values by dotted key.
+func readConfigFile(path string) (map[string]fileValue, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]fileValue{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if len(doc.Content) == 0 {
+			return values, nil
+		}
+		err = flattenYAML(values, "", doc.Content[0])
+	case ".toml":
+		var doc map[string]any
+		if _, err := toml.Decode(string(data), &doc); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		err = flattenTOML(values, "", doc)
+	default:
+		return nil, fmt.Errorf("%s: config file must end in .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", path, err)
+	}
+	return values, nil
+}
+
+// flattenYAML adds the scalars and lists under node to values, keyed by
+// their path of mapping keys.
+func flattenYAML(values map[string]fileValue, prefix string, node *yaml.Node) error {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("%d: expected a mapping of settings", node.Line)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		k, v := node.Content[i], node.Content[i+1]
+		key := k.Value
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if _, dup := values[key]; dup {
+			return fmt.Errorf("%d: %s is set twice", k.Line, key)
+		}
+		if v.Kind == yaml.AliasNode {
+			v = v.Alias
+		}
+		switch v.Kind {
+		case yaml.MappingNode:
+			if err := flattenYAML(values, key, v); err != nil {
+				return err
+			}
+			continue
+		case yaml.SequenceNode:
+			fv := fileValue{isList: true, list: []string{}, line: k.Line}
+			for _, item := range v.Content {
+				if item.Kind != yaml.ScalarNode {
+					return fmt.Errorf("%d: %s: list items must be plain values", item.Line, key)
+				}
+				fv.list = append(fv.list, item.Value)
+			}
+			values[key] = fv
+		default:
+			values[key] = fileValue{text: v.Value, line: k.Line}
+		}
+	}
+	return nil
+}
+
+// flattenTOML adds the values under table to values, keyed by their
+// dotted path.
+func flattenTOML(values map[string]fileValue, prefix string, table map[string]any) error {
+	for name, v := range table {
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+		switch v := v.(type) {
+		case map[string]any:
+			if err := flattenTOML(values, key, v); err != nil {
+				return err
+			}
+		case []any:
+			fv := fileValue{isList: true, list: []string{}}
+			for _, item := range v {
+				switch item.(type) {
+				case map[string]any, []any:
+					return fmt.Errorf(" %s: list items must be plain values", key)
+				}
+				fv.list = append(fv.list, fmt.Sprint(item))
+			}
+			values[key] = fv
+		case []map[string]any:
+			return fmt.Errorf(" %s: arrays of tables are not supported", key)
+		default:
+			values[key] = fileValue{text: fmt.Sprint(v)}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, name, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig_DBFromEnv(t *testing.T) {
+	t.Setenv("TASK_API_DB_JOURNAL_MODE", "delete")
+	t.Setenv("TASK_API_DB_SYNCHRONOUS", "sometimes")
+	t.Setenv("TASK_API_DB_BUSY_TIMEOUT", "250ms")
+	t.Setenv("TASK_API_DB_FOREIGN_KEYS", "false")
+	t.Setenv("TASK_API_DB_MAX_READERS", "2")
+
+	cfg, err := LoadConfig(nil)
+	if err == nil || !strings.Contains(err.Error(), `db.synchronous: "SOMETIMES" is not one of`) {
+		t.Errorf("error = %v", err)
+	}
+	want := DefaultDBConfig()
+	want.JournalMode = "DELETE"
+	want.Synchronous = "SOMETIMES"
+	want.BusyTimeout = 250 * time.Millisecond
+	want.ForeignKeys = false
+	want.MaxReaders = 2
+	if cfg.DB != want {
+		t.Errorf("config = %+v, want %+v", cfg.DB, want)
+	}
+}
+
+func TestLoadConfig_Precedence(t *testing.T) {
+	path := writeConfigFile(t, "taskapi.yaml", `
+# Production settings
+server:
+  addr: ":9000"
+  read_timeout: 3s
+db:
+  path: /var/lib/taskapi/tasks.db   # on the data volume
+  max_readers: 8
+cors:
+  origins:
+    - https://app.example.com
+    - "https://admin.example.com"
+auth:
+  jwt_secret: 'from-the-file'
+`)
+	t.Setenv("TASK_API_DB_MAX_READERS", "16")
+	t.Setenv("TASK_API_TOKEN_TTL", "30m")
+
+	cfg, err := LoadConfig([]string{"-config", path, "-server.addr", ":9100", "-log.level", "debug"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks := []struct {
+		got, want any
+		source    string
+		key       string
+	}{
+		{cfg.Server.Addr, ":9100", "flag", "server.addr"},
+		{cfg.Server.ReadTimeout, 3 * time.Second, "file", "server.read_timeout"},
+		{cfg.Server.WriteTimeout, 15 * time.Second, "", "server.write_timeout"},
+		{cfg.DB.Path, "/var/lib/taskapi/tasks.db", "file", "db.path"},
+		{cfg.DB.MaxReaders, 16, "env", "db.max_readers"},
+		{cfg.Auth.JWTSecret, "from-the-file", "file", "auth.jwt_secret"},
+		{cfg.Auth.TokenTTL, 30 * time.Minute, "env", "auth.token_ttl"},
+		{cfg.CORS.Origins, []string{"https://app.example.com", "https://admin.example.com"}, "file", "cors.origins"},
+		{cfg.LogLevel.String(), "DEBUG", "flag", "log.level"},
+	}
+	for _, c := range checks {
+		if !reflect.DeepEqual(c.got, c.want) || cfg.sources[c.key] != c.source {
+			t.Errorf("%s = %v from %q, want %v from %q", c.key, c.got, cfg.sources[c.key], c.want, c.source)
+		}
+	}
+}
+
+func TestLoadConfig_ReportsEveryProblem(t *testing.T) {
+	path := writeConfigFile(t, "taskapi.toml", `
+[server]
+addr = "localhost"
+read_timeout = "soon"
+
+[db]
+journal_mode = "sideways"
+max_reader = 3
+
+[cors]
+origins = ["*", "ftp://files.example.com"]
+`)
+	t.Setenv("TASK_API_SMTP_PORT", "smtp")
+	_, err := LoadConfig([]string{"-config", path, "-auth.token_ttl", "0s", "-subtasks.parent_completion", "maybe", "-no-such-flag"})
+	if err == nil {
+		t.Fatal("no error")
+	}
+	for _, want := range []string{
+		`taskapi.toml: server.read_timeout: "soon" is not a duration`,
+		`taskapi.toml: unknown setting "db.max_reader"`,
+		`flag provided but not defined: -no-such-flag`,
+		`TASK_API_SMTP_PORT: "smtp" is not a whole number`,
+		`server.addr: "localhost" is not host:port`,
+		`db.journal_mode: "SIDEWAYS" is not one of`,
+		`cors.origins: "*" cannot be combined`,
+		`cors.origins: "ftp://files.example.com" is not an origin`,
+		`auth.token_ttl: must be positive`,
+		`auth.jwt_secret: must be set`,
+		`subtasks.parent_completion: "maybe" is not allow, reject or cascade`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error lacks %q:\n%v", want, err)
+		}
+	}
+}
+
+func TestReadConfigFile(t *testing.T) {
+	values, err := readConfigFile(writeConfigFile(t, "a.yaml", `
+a:
+  b: "x \" # not a comment"  # a comment
+  c: [1, '2,3']
+d:
+  - e
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := values["a.b"]; v.text != `x " # not a comment` || v.line != 3 {
+		t.Errorf("a.b = %+v", v)
+	}
+	if !slices.Equal(values["a.c"].list, []string{"1", "2,3"}) || !slices.Equal(values["d"].list, []string{"e"}) {
+		t.Errorf("values = %+v", values)
+	}
+
+	values, err = readConfigFile(writeConfigFile(t, "a.toml", "top = 1\n[a.b]\nc = \"\\u00e9\\\"#x\" # comment\nd.e = true\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["top"].text != "1" || values["a.b.c"].text != `é"#x` || values["a.b.d.e"].text != "true" {
+		t.Errorf("values = %+v", values)
+	}
+
+	for name, body := range map[string]string{
+		"tab.yaml":    "a:\n\tb: 1\n",
+		"dup.yaml":    "a: 1\na: 2\n",
+		"open.toml":   "[broken\nc = 2\n",
+		"dup.toml":    "a = 1\na = 2\n",
+		"nested.toml": "a = [[1]]\n",
+	} {
+		if _, err := readConfigFile(writeConfigFile(t, name, body)); err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("%s: error = %v", name, err)
+		}
+	}
+}
+
+func TestConfigPrint_RedactsAndRoundTrips(t *testing.T) {
+	t.Setenv("TASK_API_JWT_SECRET", "hunter2")
+	t.Setenv("TASK_API_CORS_ORIGINS", "https://a.example.com, https://b.example.com")
+	cfg, err := LoadConfig([]string{"-smtp.host", "mail.example.com", "-smtp.password", "p@ss"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	cfg.Print(&buf)
+	out := buf.String()
+	if strings.Contains(out, "hunter2") || strings.Contains(out, "p@ss") {
+		t.Fatalf("secret printed:\n%s", out)
+	}
+	for _, want := range []string{
+		"[auth]\njwt_secret = \"<redacted>\" # env\n",
+		"[smtp]\nhost = \"mail.example.com\" # flag\n",
+		"origins = [\"https://a.example.com\", \"https://b.example.com\"] # env\n",
+		"addr = \":8080\" # default\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+
+	// What is printed loads back to the same configuration, secrets aside.
+	os.Unsetenv("TASK_API_JWT_SECRET")
+	os.Unsetenv("TASK_API_CORS_ORIGINS")
+	again, err := LoadConfig([]string{"-config", writeConfigFile(t, "printed.toml", out)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Auth.JWTSecret, cfg.SMTP.Password = "<redacted>", "<redacted>"
+	cfg.sources, again.sources = nil, nil
+	if !reflect.DeepEqual(cfg, again) {
+		t.Errorf("reloaded %+v\nwant     %+v", again, cfg)
+	}
+}
@@ -2,68 +2,396 @@ package main
 
 import (
 	"database/sql"
-	"log"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
 
 	_ "modernc.org/sqlite"
 )
 
-func initDB() *sql.DB {
-	db, err := sql.Open("sqlite", "./tasks.db")
+// dbPath is where the server keeps its database.
+const dbPath = "./tasks.db"
+
+// schemaVersion is stored in PRAGMA user_version once migrate has run, so
+// readiness can tell a database that is behind this binary. Bump it
+// whenever migrate changes.
+const schemaVersion = 1
+
+func initDB(cfg DBConfig) *DB {
+	db, err := OpenDB(cfg)
 	if err != nil {
-		log.Fatalf("Failed to open DB: %v", err)
+		slog.Error("open database failed", "error", err)
+		os.Exit(1)
+	}
+	if err := migrate(db.writer); err != nil {
+		slog.Error("migrate database failed", "error", err)
+		os.Exit(1)
 	}
+	return db
+}
 
+// migrate creates any missing tables, columns and indexes. It is safe to run
+// against an existing database on every start.
+func migrate(db *sql.DB) error {
 	schema := `
 	CREATE TABLE IF NOT EXISTS tasks (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		title TEXT NOT NULL,
 		description TEXT,
 		completed BOOLEAN,
+		parent_id INTEGER REFERENCES tasks(id) ON DELETE CASCADE,
+		due_at TIMESTAMP,
+		rrule TEXT NOT NULL DEFAULT '',
+		time_zone TEXT NOT NULL DEFAULT '',
+		recurrence_start TIMESTAMP,
+		next_occurrence_id INTEGER,
 		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
 		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
 	);`
 	if _, err := db.Exec(schema); err != nil {
-		log.Fatalf("Failed to initialize schema: %v", err)
+		return fmt.Errorf("initialize schema: %w", err)
 	}
 
-	ensureUpdatedAtColumn(db)
+	for _, c := range [][2]string{
+		{"updated_at", `TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP`},
+		{"parent_id", `INTEGER REFERENCES tasks(id) ON DELETE CASCADE`},
+		{"due_at", `TIMESTAMP`},
+		{"rrule", `TEXT NOT NULL DEFAULT ''`},
+		{"time_zone", `TEXT NOT NULL DEFAULT ''`},
+		{"recurrence_start", `TIMESTAMP`},
+		{"next_occurrence_id", `INTEGER`},
+	} {
+		if err := ensureColumn(db, "tasks", c[0], c[1]); err != nil {
+			return err
+		}
+	}
 
-	return db
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_tasks_parent_id ON tasks(parent_id)`); err != nil {
+		return fmt.Errorf("create parent index: %w", err)
+	}
+
+	depSchema := `
+	CREATE TABLE IF NOT EXISTS task_dependencies (
+		blocker_id INTEGER NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+		blocked_id INTEGER NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (blocker_id, blocked_id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_task_dependencies_blocked ON task_dependencies(blocked_id);`
+	if _, err := db.Exec(depSchema); err != nil {
+		return fmt.Errorf("initialize dependency schema: %w", err)
+	}
+
+	userSchema := `
+	CREATE TABLE IF NOT EXISTS users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		email TEXT NOT NULL DEFAULT '',
+		is_admin BOOLEAN NOT NULL DEFAULT 0,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		deleted_at TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS task_assignees (
+		task_id INTEGER NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		assigned_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (task_id, user_id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_task_assignees_user ON task_assignees(user_id);`
+	if _, err := db.Exec(userSchema); err != nil {
+		return fmt.Errorf("initialize user schema: %w", err)
+	}
+
+	commentSchema := `
+	CREATE TABLE IF NOT EXISTS comments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		task_id INTEGER NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+		author_id INTEGER NOT NULL REFERENCES users(id),
+		body TEXT NOT NULL,
+		edited BOOLEAN NOT NULL DEFAULT 0,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_comments_task ON comments(task_id, id);
+	CREATE TABLE IF NOT EXISTS comment_mentions (
+		comment_id INTEGER NOT NULL REFERENCES comments(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		PRIMARY KEY (comment_id, user_id)
+	);`
+	if _, err := db.Exec(commentSchema); err != nil {
+		return fmt.Errorf("initialize comment schema: %w", err)
+	}
+
+	attachmentSchema := `
+	CREATE TABLE IF NOT EXISTS attachments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		task_id INTEGER NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+		filename TEXT NOT NULL,
+		content_type TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		sha256 TEXT NOT NULL,
+		uploaded_by INTEGER NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_attachments_task ON attachments(task_id);
+	CREATE INDEX IF NOT EXISTS idx_attachments_sha256 ON attachments(sha256);`
+	if _, err := db.Exec(attachmentSchema); err != nil {
+		return fmt.Errorf("initialize attachment schema: %w", err)
+	}
+
+	// loginHandler still issues tokens for a fixed demo user, so make sure
+	// that user exists and can administer the workspace.
+	if _, err := db.Exec(`INSERT OR IGNORE INTO users (id, name, is_admin) VALUES (?, 'demo', 1)`, demoUserID); err != nil {
+		return fmt.Errorf("seed demo user: %w", err)
+	}
+
+	reminderSchema := `
+	CREATE TABLE IF NOT EXISTS reminders (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		task_id INTEGER NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		before_seconds INTEGER NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		claimed_unix INTEGER,
+		sent_at TIMESTAMP,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_reminders_status ON reminders(status);
+	CREATE INDEX IF NOT EXISTS idx_reminders_task ON reminders(task_id);`
+	if _, err := db.Exec(reminderSchema); err != nil {
+		return fmt.Errorf("initialize reminder schema: %w", err)
+	}
+	if err := ensureColumn(db, "reminders", "next_attempt_unix", `INTEGER NOT NULL DEFAULT 0`); err != nil {
+		return err
+	}
+
+	emailSchema := `
+	CREATE TABLE IF NOT EXISTS overdue_notices (
+		task_id INTEGER PRIMARY KEY REFERENCES tasks(id) ON DELETE CASCADE,
+		due_unix INTEGER NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS notification_prefs (
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		kind TEXT NOT NULL,
+		enabled INTEGER NOT NULL,
+		PRIMARY KEY (user_id, kind)
+	);
+	CREATE TABLE IF NOT EXISTS email_outbox (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		to_addr TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		text_body TEXT NOT NULL,
+		html_body TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_unix INTEGER NOT NULL,
+		last_error TEXT NOT NULL DEFAULT '',
+		sent_at TIMESTAMP,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_email_outbox_due ON email_outbox(status, next_attempt_unix);`
+	if _, err := db.Exec(emailSchema); err != nil {
+		return fmt.Errorf("initialize email schema: %w", err)
+	}
+	if err := ensureColumn(db, "email_outbox", "request_id", `TEXT NOT NULL DEFAULT ''`); err != nil {
+		return err
+	}
+
+	webhookSchema := `
+	CREATE TABLE IF NOT EXISTS webhooks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		url TEXT NOT NULL,
+		secret TEXT NOT NULL,
+		events TEXT NOT NULL,
+		created_by INTEGER NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		webhook_id INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+		event_type TEXT NOT NULL,
+		payload TEXT NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		response_status INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		next_attempt_unix INTEGER NOT NULL,
+		delivered_at TIMESTAMP,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_unix);
+	CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_hook ON webhook_deliveries(webhook_id, id);`
+	if _, err := db.Exec(webhookSchema); err != nil {
+		return fmt.Errorf("initialize webhook schema: %w", err)
+	}
+	if err := ensureColumn(db, "webhook_deliveries", "traceparent", `TEXT NOT NULL DEFAULT ''`); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS task_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		type TEXT NOT NULL,
+		task_id INTEGER NOT NULL,
+		payload TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);`); err != nil {
+		return fmt.Errorf("initialize task event schema: %w", err)
+	}
+
+	if err := migrateSync(db); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS calendar_feeds (
+		user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+		token_hash TEXT NOT NULL UNIQUE,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);`); err != nil {
+		return fmt.Errorf("initialize calendar schema: %w", err)
+	}
+
+	if _, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		user_id INTEGER NOT NULL,
+		key TEXT NOT NULL,
+		fingerprint TEXT NOT NULL,
+		done INTEGER NOT NULL DEFAULT 0,
+		response_status INTEGER NOT NULL DEFAULT 0,
+		content_type TEXT NOT NULL DEFAULT '',
+		response_body BLOB,
+		created_unix INTEGER NOT NULL,
+		PRIMARY KEY (user_id, key)
+	);
+	CREATE INDEX IF NOT EXISTS idx_idempotency_keys_created ON idempotency_keys(created_unix);`); err != nil {
+		return fmt.Errorf("initialize idempotency schema: %w", err)
+	}
+	if err := ensureColumn(db, "idempotency_keys", "locked_until_unix", `INTEGER NOT NULL DEFAULT 0`); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "idempotency_keys", "response_headers", `TEXT NOT NULL DEFAULT '{}'`); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, schemaVersion)); err != nil {
+		return fmt.Errorf("record schema version: %w", err)
+	}
+	return nil
 }
 
-func ensureUpdatedAtColumn(db *sql.DB) {
-	rows, err := db.Query(`PRAGMA table_info(tasks)`)
+// migrateSync sets up change tracking for delta sync. Triggers do the
+// bookkeeping so every write path, including cascades and recurrence,
+// bumps the change sequence without each store method having to.
+func migrateSync(db *sql.DB) error {
+	if err := ensureColumn(db, "tasks", "change_seq", `INTEGER NOT NULL DEFAULT 0`); err != nil {
+		return err
+	}
+
+	schema := `
+	CREATE TABLE IF NOT EXISTS sync_seq (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		value INTEGER NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS task_tombstones (
+		task_id INTEGER PRIMARY KEY,
+		change_seq INTEGER NOT NULL,
+		deleted_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_task_tombstones_seq ON task_tombstones(change_seq);
+	CREATE TABLE IF NOT EXISTS task_field_clocks (
+		task_id INTEGER NOT NULL,
+		field TEXT NOT NULL,
+		changed_ms INTEGER NOT NULL,
+		PRIMARY KEY (task_id, field)
+	);
+	UPDATE tasks SET change_seq = id WHERE change_seq = 0;
+	INSERT OR IGNORE INTO sync_seq (id, value) SELECT 1, COALESCE(MAX(change_seq), 0) FROM tasks;
+	CREATE INDEX IF NOT EXISTS idx_tasks_change_seq ON tasks(change_seq);`
+	if _, err := db.Exec(schema); err != nil {
+		return fmt.Errorf("initialize sync schema: %w", err)
+	}
+
+	const bump = `UPDATE sync_seq SET value = value + 1 WHERE id = 1;`
+	const seq = `(SELECT value FROM sync_seq WHERE id = 1)`
+	const nowMS = `CAST((julianday('now') - 2440587.5) * 86400000 AS INTEGER)`
+
+	cols := make([]string, 0, len(syncFields))
+	for _, f := range syncFields {
+		cols = append(cols, f.column)
+	}
+	triggers := []string{
+		`CREATE TRIGGER IF NOT EXISTS tasks_sync_insert AFTER INSERT ON tasks BEGIN ` + bump +
+			` UPDATE tasks SET change_seq = ` + seq + ` WHERE id = NEW.id;` +
+			` DELETE FROM task_tombstones WHERE task_id = NEW.id; END`,
+		`CREATE TRIGGER IF NOT EXISTS tasks_sync_update AFTER UPDATE OF ` + strings.Join(cols, ", ") + ` ON tasks BEGIN ` + bump +
+			` UPDATE tasks SET change_seq = ` + seq + ` WHERE id = NEW.id; END`,
+		`CREATE TRIGGER IF NOT EXISTS tasks_sync_delete AFTER DELETE ON tasks BEGIN ` + bump +
+			` INSERT OR REPLACE INTO task_tombstones (task_id, change_seq, deleted_at) VALUES (OLD.id, ` + seq + `, CURRENT_TIMESTAMP);` +
+			` DELETE FROM task_field_clocks WHERE task_id = OLD.id; END`,
+		// Assignees and dependency edges are part of the task payload.
+		`CREATE TRIGGER IF NOT EXISTS task_assignees_sync_insert AFTER INSERT ON task_assignees BEGIN ` + bump +
+			` UPDATE tasks SET change_seq = ` + seq + ` WHERE id = NEW.task_id; END`,
+		`CREATE TRIGGER IF NOT EXISTS task_assignees_sync_delete AFTER DELETE ON task_assignees BEGIN ` + bump +
+			` UPDATE tasks SET change_seq = ` + seq + ` WHERE id = OLD.task_id; END`,
+		`CREATE TRIGGER IF NOT EXISTS task_dependencies_sync_insert AFTER INSERT ON task_dependencies BEGIN ` + bump +
+			` UPDATE tasks SET change_seq = ` + seq + ` WHERE id IN (NEW.blocker_id, NEW.blocked_id); END`,
+		`CREATE TRIGGER IF NOT EXISTS task_dependencies_sync_delete AFTER DELETE ON task_dependencies BEGIN ` + bump +
+			` UPDATE tasks SET change_seq = ` + seq + ` WHERE id IN (OLD.blocker_id, OLD.blocked_id); END`,
+	}
+	for _, f := range syncFields {
+		triggers = append(triggers, fmt.Sprintf(
+			`CREATE TRIGGER IF NOT EXISTS tasks_clock_%[1]s AFTER UPDATE OF %[1]s ON tasks WHEN OLD.%[1]s IS NOT NEW.%[1]s BEGIN
+			INSERT INTO task_field_clocks (task_id, field, changed_ms) VALUES (NEW.id, '%[2]s', %[3]s)
+			ON CONFLICT(task_id, field) DO UPDATE SET changed_ms = excluded.changed_ms; END`,
+			f.column, f.name, nowMS))
+	}
+	for _, t := range triggers {
+		if _, err := db.Exec(t); err != nil {
+			return fmt.Errorf("create sync trigger: %w", err)
+		}
+	}
+	return nil
+}
+
+// ensureColumn adds a column to an existing table when an older database
+// file was created before the column existed.
+func ensureColumn(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query(`PRAGMA table_info(` + table + `)`)
 	if err != nil {
-		log.Printf("schema check failed: %v", err)
-		return
+		return fmt.Errorf("check %s schema: %w", table, err)
 	}
 	defer rows.Close()
 
-	hasUpdatedAt := false
+	hasColumn := false
 	for rows.Next() {
 		var cid int
 		var name, colType string
 		var dfltVal sql.NullString
 		var notNull, pk int
 		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltVal, &pk); err != nil {
-			log.Printf("schema scan failed: %v", err)
-			return
+			return fmt.Errorf("check %s schema: %w", table, err)
 		}
-		if name == "updated_at" {
-			hasUpdatedAt = true
+		if name == column {
+			hasColumn = true
 			break
 		}
 	}
 	if err := rows.Err(); err != nil {
-		log.Printf("schema rows err: %v", err)
-		return
+		return fmt.Errorf("check %s schema: %w", table, err)
 	}
+	rows.Close()
 
-	if !hasUpdatedAt {
-		if _, err := db.Exec(`ALTER TABLE tasks ADD COLUMN updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP`); err != nil {
-			log.Printf("add updated_at failed (maybe already exists?): %v", err)
-		} else {
-			log.Printf("migrated: added tasks.updated_at")
-		}
+	if hasColumn {
+		return nil
+	}
+	if _, err := db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + definition); err != nil {
+		return fmt.Errorf("add column %s.%s: %w", table, column, err)
 	}
+	slog.Info("added column", "table", table, "column", column)
+	return nil
 }
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+)
+
+type DBPoolStats struct {
+	MaxOpen           int   `json:"maxOpen"`
+	Open              int   `json:"open"`
+	InUse             int   `json:"inUse"`
+	Idle              int   `json:"idle"`
+	WaitCount         int64 `json:"waitCount"`
+	WaitDurationMs    int64 `json:"waitDurationMs"`
+	MaxIdleClosed     int64 `json:"maxIdleClosed"`
+	MaxLifetimeClosed int64 `json:"maxLifetimeClosed"`
+}
+
+func poolStats(s sql.DBStats) DBPoolStats {
+	return DBPoolStats{
+		MaxOpen:           s.MaxOpenConnections,
+		Open:              s.OpenConnections,
+		InUse:             s.InUse,
+		Idle:              s.Idle,
+		WaitCount:         s.WaitCount,
+		WaitDurationMs:    s.WaitDuration.Milliseconds(),
+		MaxIdleClosed:     s.MaxIdleClosed,
+		MaxLifetimeClosed: s.MaxLifetimeClosed,
+	}
+}
+
+// DBStats is what GET /admin/db/stats reports. The pragma values are read
+// back from a live connection rather than copied from the config, so they
+// show what SQLite is actually doing.
+type DBStats struct {
+	Writer        DBPoolStats `json:"writer"`
+	Reader        DBPoolStats `json:"reader"`
+	BusyRetries   int64       `json:"busyRetries"`
+	BusyErrors    int64       `json:"busyErrors"`
+	JournalMode   string      `json:"journalMode"`
+	Synchronous   string      `json:"synchronous"`
+	BusyTimeoutMs int64       `json:"busyTimeoutMs"`
+	ForeignKeys   bool        `json:"foreignKeys"`
+	PageSize      int64       `json:"pageSize"`
+	PageCount     int64       `json:"pageCount"`
+	FreelistCount int64       `json:"freelistCount"`
+}
+
+// synchronousNames maps PRAGMA synchronous's numeric answer to its name.
+var synchronousNames = map[int]string{0: "OFF", 1: "NORMAL", 2: "FULL", 3: "EXTRA"}
+
+func (d *DB) Stats(ctx context.Context) (DBStats, error) {
+	st := DBStats{
+		Writer:      poolStats(d.writer.Stats()),
+		Reader:      poolStats(d.reader.Stats()),
+		BusyRetries: d.retries.Load(),
+		BusyErrors:  d.busy.Load(),
+	}
+	// Per-connection settings are only meaningful read from one
+	// connection, so hold a single reader for all of them.
+	conn, err := d.reader.Conn(ctx)
+	if err != nil {
+		return DBStats{}, err
+	}
+	defer conn.Close()
+
+	var syncLevel int
+	for _, p := range []struct {
+		pragma string
+		dest   any
+	}{
+		{"journal_mode", &st.JournalMode},
+		{"synchronous", &syncLevel},
+		{"busy_timeout", &st.BusyTimeoutMs},
+		{"foreign_keys", &st.ForeignKeys},
+		{"page_size", &st.PageSize},
+		{"page_count", &st.PageCount},
+		{"freelist_count", &st.FreelistCount},
+	} {
+		if err := conn.QueryRowContext(ctx, `PRAGMA `+p.pragma).Scan(p.dest); err != nil {
+			return DBStats{}, err
+		}
+	}
+	st.Synchronous = synchronousNames[syncLevel]
+	return st, nil
+}
+
+func dbStatsHandler(db *DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		st, err := db.Stats(ctx)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		writeJSON(w, http.StatusOK, st)
+	}
+}
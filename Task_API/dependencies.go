@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// longestOpenChain returns the longest chain of open blockers that ends at
+// id, ordered from the first task to work on through id itself.
+func longestOpenChain(blockers map[int][]int, open map[int]bool, id int) []int {
+	memo := make(map[int][]int)
+	visiting := make(map[int]bool)
+
+	var chain func(n int) []int
+	chain = func(n int) []int {
+		if c, ok := memo[n]; ok {
+			return c
+		}
+		if visiting[n] {
+			return nil
+		}
+		visiting[n] = true
+		defer delete(visiting, n)
+
+		var best []int
+		for _, b := range blockers[n] {
+			if !open[b] {
+				continue
+			}
+			if c := chain(b); len(c) > len(best) {
+				best = c
+			}
+		}
+		out := make([]int, len(best), len(best)+1)
+		copy(out, best)
+		out = append(out, n)
+		memo[n] = out
+		return out
+	}
+	return chain(id)
+}
+
+type dependencyReq struct {
+	BlockedBy int `json:"blockedBy"`
+}
+
+func addDependencyHandler(store DependencyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idStr := r.PathValue("ID")
+		id, err := strconv.Atoi(idStr)
+		if err != nil || id <= 0 {
+			writeErr(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		var req dependencyReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeErr(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		if req.BlockedBy <= 0 {
+			writeErr(w, http.StatusBadRequest, "invalid blockedBy")
+			return
+		}
+		if err := store.AddDependency(r.Context(), req.BlockedBy, id); err != nil {
+			switch {
+			case errors.Is(err, ErrInvalid), errors.Is(err, ErrDependencyCycle):
+				writeErr(w, http.StatusBadRequest, err.Error())
+			case errors.Is(err, ErrNotFound):
+				writeErr(w, http.StatusNotFound, "not found")
+			default:
+				writeErr(w, http.StatusInternalServerError, "internal error")
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func removeDependencyHandler(store DependencyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("ID"))
+		if err != nil || id <= 0 {
+			writeErr(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		blockerID, err := strconv.Atoi(r.PathValue("BlockerID"))
+		if err != nil || blockerID <= 0 {
+			writeErr(w, http.StatusBadRequest, "invalid blocker id")
+			return
+		}
+		if err := store.RemoveDependency(r.Context(), blockerID, id); err != nil {
+			if errors.Is(err, ErrNotFound) {
+				writeErr(w, http.StatusNotFound, "not found")
+			} else {
+				writeErr(w, http.StatusInternalServerError, "internal error")
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func getCriticalPathHandler(store DependencyStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idStr := r.PathValue("ID")
+		id, err := strconv.Atoi(idStr)
+		if err != nil || id <= 0 {
+			writeErr(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		path, err := store.GetCriticalPath(r.Context(), id)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				writeErr(w, http.StatusNotFound, "not found")
+			} else {
+				writeErr(w, http.StatusInternalServerError, "internal error")
+			}
+			return
+		}
+		writeJSON(w, http.StatusOK, path)
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLongestOpenChain(t *testing.T) {
+	// 1 -> 2 -> 4 and 3 -> 4, with 5 (completed) -> 1.
+	blockers := map[int][]int{
+		1: {5},
+		2: {1},
+		4: {2, 3},
+	}
+	open := map[int]bool{1: true, 2: true, 3: true, 4: true}
+
+	got := longestOpenChain(blockers, open, 4)
+
+	if want := []int{1, 2, 4}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	htmltemplate "html/template"
+
+	"net/http"
+	texttemplate "text/template"
+	"time"
+)
+
+// NotificationPrefs says which kinds of notification a user wants by email.
+// Everything is on until the user turns it off.
+type NotificationPrefs struct {
+	Reminder  bool `json:"reminder"`
+	Assigned  bool `json:"assigned"`
+	Commented bool `json:"commented"`
+	Mentioned bool `json:"mentioned"`
+	Overdue   bool `json:"overdue"`
+}
+
+func DefaultNotificationPrefs() NotificationPrefs {
+	return NotificationPrefs{Reminder: true, Assigned: true, Commented: true, Mentioned: true, Overdue: true}
+}
+
+func (p NotificationPrefs) Allows(kind string) bool {
+	switch kind {
+	case NotificationReminder:
+		return p.Reminder
+	case NotificationAssigned:
+		return p.Assigned
+	case NotificationCommented:
+		return p.Commented
+	case NotificationMentioned:
+		return p.Mentioned
+	case NotificationOverdue:
+		return p.Overdue
+	}
+	return true
+}
+
+var emailSubjects = map[string]string{
+	NotificationReminder:  `Reminder: {{.Task.Title}}`,
+	NotificationAssigned:  `You were assigned: {{.Task.Title}}`,
+	NotificationCommented: `New comment on {{.Task.Title}}`,
+	NotificationMentioned: `You were mentioned on {{.Task.Title}}`,
+	NotificationOverdue:   `Overdue: {{.Task.Title}}`,
+}
+
+const emailTextBody = `Hi {{.UserName}},
+
+{{.Message}}
+
+Task #{{.Task.ID}}: {{.Task.Title}}
+{{- if .Task.DueAt}}
+Due: {{.Task.DueAt.Format "Mon, 02 Jan 2006 15:04 MST"}}
+{{- end}}
+`
+
+const emailHTMLBody = `<p>Hi {{.UserName}},</p>
+<p>{{.Message}}</p>
+<p><strong>Task #{{.Task.ID}}:</strong> {{.Task.Title}}
+{{- if .Task.DueAt}}<br>Due: {{.Task.DueAt.Format "Mon, 02 Jan 2006 15:04 MST"}}{{end}}</p>
+`
+
+var (
+	emailTextTmpl = texttemplate.Must(texttemplate.New("text").Parse(emailTextBody))
+	emailHTMLTmpl = htmltemplate.Must(htmltemplate.New("html").Parse(emailHTMLBody))
+)
+
+type emailData struct {
+	UserName string
+	Message  string
+	Task     Task
+}
+
+func renderEmail(to string, user User, n Notification) (EmailMessage, error) {
+	data := emailData{UserName: user.Name, Message: n.Message, Task: n.Task}
+
+	subjectSrc, ok := emailSubjects[n.Kind]
+	if !ok {
+		subjectSrc = `{{.Task.Title}}`
+	}
+	subjectTmpl, err := texttemplate.New("subject").Parse(subjectSrc)
+	if err != nil {
+		return EmailMessage{}, err
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := subjectTmpl.Execute(&subject, data); err != nil {
+		return EmailMessage{}, err
+	}
+	if err := emailTextTmpl.Execute(&text, data); err != nil {
+		return EmailMessage{}, err
+	}
+	if err := emailHTMLTmpl.Execute(&html, data); err != nil {
+		return EmailMessage{}, err
+	}
+	return EmailMessage{To: to, Subject: subject.String(), Text: text.String(), HTML: html.String(), RequestID: n.RequestID}, nil
+}
+
+// EmailNotifier turns notifications into queued emails. It never talks to
+// the SMTP server itself; EmailDispatcher drains the outbox.
+type EmailNotifier struct {
+	users  UserStore
+	prefs  NotificationPrefStore
+	outbox EmailOutbox
+}
+
+func NewEmailNotifier(users UserStore, prefs NotificationPrefStore, outbox EmailOutbox) *EmailNotifier {
+	return &EmailNotifier{users: users, prefs: prefs, outbox: outbox}
+}
+
+// Notify skips users who are deleted, have no email address or have opted
+// out of this kind of notification.
+func (e *EmailNotifier) Notify(ctx context.Context, n Notification) error {
+	user, err := e.users.GetUser(ctx, n.UserID)
+	if err != nil {
+		return err
+	}
+	if user.DeletedAt != nil || user.Email == "" {
+		return nil
+	}
+	prefs, err := e.prefs.GetNotificationPrefs(ctx, n.UserID)
+	if err != nil {
+		return err
+	}
+	if !prefs.Allows(n.Kind) {
+		return nil
+	}
+	msg, err := renderEmail(user.Email, user, n)
+	if err != nil {
+		return err
+	}
+	return e.outbox.EnqueueEmail(ctx, n.UserID, msg)
+}
+
+// EmailDispatcher sends queued emails, retrying failures with exponential
+// backoff until maxAttempts, after which the email is parked as dead.
+type EmailDispatcher struct {
+	outbox      EmailOutbox
+	mailer      Mailer
+	interval    time.Duration
+	lease       time.Duration
+	baseBackoff time.Duration
+	maxBackoff  time.Duration
+	maxAttempts int
+	now         func() time.Time
+}
+
+func NewEmailDispatcher(outbox EmailOutbox, mailer Mailer) *EmailDispatcher {
+	return &EmailDispatcher{
+		outbox:      outbox,
+		mailer:      mailer,
+		interval:    10 * time.Second,
+		lease:       2 * time.Minute,
+		baseBackoff: 30 * time.Second,
+		maxBackoff:  time.Hour,
+		maxAttempts: 8,
+		now:         time.Now,
+	}
+}
+
+func (d *EmailDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		d.tick(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *EmailDispatcher) tick(ctx context.Context) {
+	now := d.now()
+	due, err := d.outbox.DueEmails(ctx, now, 50)
+	if err != nil {
+		loggerFrom(ctx).Error("load email outbox failed", "error", err)
+		return
+	}
+	for _, e := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		ok, err := d.outbox.ClaimEmail(ctx, e.ID, now, d.lease)
+		if err != nil || !ok {
+			continue
+		}
+		attempts := e.Attempts + 1
+
+		if err := d.mailer.Send(ctx, e.Message); err != nil {
+			dead := attempts >= d.maxAttempts
+			retryAt := now.Add(backoffDelay(d.baseBackoff, d.maxBackoff, attempts))
+			loggerFrom(ctx).Warn("send email failed", "email_id", e.ID, "attempt", attempts, "error", err)
+			if err := d.outbox.MarkEmailFailed(ctx, e.ID, err.Error(), retryAt, dead); err != nil {
+				loggerFrom(ctx).Error("mark email failed", "email_id", e.ID, "error", err)
+			}
+			continue
+		}
+		if err := d.outbox.MarkEmailSent(ctx, e.ID, d.now()); err != nil {
+			loggerFrom(ctx).Error("mark email sent failed", "email_id", e.ID, "error", err)
+		}
+	}
+}
+
+// backoffDelay doubles base for every attempt after the first, capped at max.
+func backoffDelay(base, max time.Duration, attempts int) time.Duration {
+	b := base
+	for i := 1; i < attempts && b < max; i++ {
+		b *= 2
+	}
+	if b > max {
+		b = max
+	}
+	return b
+}
+
+// SubscribeNotifications turns task events into notifications: assignees
+// hear about new assignments and comments, and mentioned users about
+// mentions. Nobody is notified about their own actions.
+func SubscribeNotifications(events *EventBus, tasks TaskStore, comments CommentStore, notifier Notifier) {
+	events.Subscribe(func(ctx context.Context, e Event) {
+		var kind, message string
+		switch e.Type {
+		case EventTaskAssigned:
+			kind, message = NotificationAssigned, "You have been assigned a task."
+		case EventCommentCreated:
+			kind, message = NotificationCommented, "A task you are assigned to has a new comment."
+		case EventUserMentioned:
+			kind, message = NotificationMentioned, "You were mentioned in a comment."
+		default:
+			return
+		}
+
+		task, err := tasks.GetTaskByID(ctx, e.TaskID)
+		if err != nil {
+			return
+		}
+
+		recipients := []int{e.UserID}
+		if e.Type == EventCommentCreated {
+			// Mentioned assignees get the mention instead of both emails.
+			mentioned := make(map[int]bool)
+			if c, err := comments.GetComment(ctx, e.CommentID); err == nil {
+				for _, id := range c.Mentions {
+					mentioned[id] = true
+				}
+			}
+			recipients = nil
+			for _, id := range task.Assignees {
+				if !mentioned[id] {
+					recipients = append(recipients, id)
+				}
+			}
+		}
+
+		for _, userID := range recipients {
+			if userID <= 0 || userID == e.ActorID {
+				continue
+			}
+			n := Notification{Kind: kind, UserID: userID, Task: task, Message: message, RequestID: e.RequestID}
+			if err := notifier.Notify(ctx, n); err != nil {
+				loggerFrom(ctx).Error("notify failed", "user_id", userID, "event", e.Type, "error", err)
+			}
+		}
+	})
+}
+
+func getNotificationPrefsHandler(prefs NotificationPrefStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := prefs.GetNotificationPrefs(r.Context(), getUserID(r))
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		writeJSON(w, http.StatusOK, p)
+	}
+}
+
+// putNotificationPrefsHandler replaces the caller's preferences. Kinds left
+// out of the body keep their current value.
+func putNotificationPrefsHandler(prefs NotificationPrefStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := getUserID(r)
+		p, err := prefs.GetNotificationPrefs(r.Context(), userID)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			writeErr(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		if err := prefs.SetNotificationPrefs(r.Context(), userID, p); err != nil {
+			writeErr(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		writeJSON(w, http.StatusOK, p)
+	}
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSMTP is just enough of an SMTP server to exercise SMTPMailer: EHLO,
+// optional STARTTLS, AUTH PLAIN, MAIL, RCPT, DATA and QUIT.
+type fakeSMTP struct {
+	ln       net.Listener
+	tlsCfg   *tls.Config
+	mu       sync.Mutex
+	auth     string
+	from     string
+	to       []string
+	data     []string
+	usedTLS  bool
+	received chan struct{}
+}
+
+func newFakeSMTP(t *testing.T, withTLS bool) *fakeSMTP {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeSMTP{ln: ln, received: make(chan struct{}, 16)}
+	if withTLS {
+		f.tlsCfg = &tls.Config{Certificates: []tls.Certificate{selfSignedCert(t)}}
+	}
+	t.Cleanup(func() { ln.Close() })
+	go f.serve()
+	return f
+}
+
+func (f *fakeSMTP) config() SMTPConfig {
+	host, port, _ := net.SplitHostPort(f.ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return SMTPConfig{Host: host, Port: p, From: "tasks@example.com", StartTLS: StartTLSOptional, Timeout: 5 * time.Second}
+}
+
+func (f *fakeSMTP) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeSMTP) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+	reply("220 fake ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+		switch verb {
+		case "EHLO", "HELO":
+			f.mu.Lock()
+			secure := f.usedTLS
+			f.mu.Unlock()
+			if f.tlsCfg != nil && !secure {
+				reply("250-fake")
+				reply("250-STARTTLS")
+				reply("250 AUTH PLAIN")
+			} else {
+				reply("250-fake")
+				reply("250 AUTH PLAIN")
+			}
+		case "STARTTLS":
+			reply("220 go ahead")
+			tlsConn := tls.Server(conn, f.tlsCfg)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			r = bufio.NewReader(conn)
+			f.mu.Lock()
+			f.usedTLS = true
+			f.mu.Unlock()
+		case "AUTH":
+			parts := strings.Fields(line)
+			if len(parts) == 3 {
+				raw, _ := base64.StdEncoding.DecodeString(parts[2])
+				f.mu.Lock()
+				f.auth = string(raw)
+				f.mu.Unlock()
+			}
+			reply("235 ok")
+		case "MAIL":
+			f.mu.Lock()
+			f.from = line
+			f.mu.Unlock()
+			reply("250 ok")
+		case "RCPT":
+			f.mu.Lock()
+			f.to = append(f.to, line)
+			f.mu.Unlock()
+			reply("250 ok")
+		case "DATA":
+			reply("354 end with .")
+			var body strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				body.WriteString(l)
+			}
+			f.mu.Lock()
+			f.data = append(f.data, body.String())
+			f.mu.Unlock()
+			reply("250 queued")
+			f.received <- struct{}{}
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func selfSignedCert(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestSMTPMailer_SendsMultipartWithAuth(t *testing.T) {
+	srv := newFakeSMTP(t, false)
+	cfg := srv.config()
+	cfg.Username, cfg.Password = "bot", "s3cret"
+
+	msg := EmailMessage{To: "ada@example.com", Subject: "Hello", Text: "plain body", HTML: "<p>html body</p>"}
+	if err := NewSMTPMailer(cfg).Send(context.Background(), msg); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if srv.auth != "\x00bot\x00s3cret" {
+		t.Fatalf("unexpected AUTH PLAIN payload %q", srv.auth)
+	}
+	if !strings.Contains(srv.from, "tasks@example.com") || len(srv.to) != 1 || !strings.Contains(srv.to[0], "ada@example.com") {
+		t.Fatalf("unexpected envelope from=%q to=%v", srv.from, srv.to)
+	}
+	data := srv.data[0]
+	for _, want := range []string{"multipart/alternative", "text/plain", "plain body", "text/html", "<p>html body</p>"} {
+		if !strings.Contains(data, want) {
+			t.Fatalf("message missing %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestSMTPMailer_RejectsHeaderInjection(t *testing.T) {
+	srv := newFakeSMTP(t, false)
+	for _, to := range []string{"ada@example.com\r\nBcc: eve@example.com", "not-an-address"} {
+		if err := NewSMTPMailer(srv.config()).Send(context.Background(), EmailMessage{To: to, Subject: "Hi", Text: "x"}); err == nil {
+			t.Errorf("sent to %q", to)
+		}
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(srv.data) != 0 {
+		t.Fatalf("messages reached the server: %v", srv.data)
+	}
+}
+
+func TestSQLiteStore_CreateUserValidatesEmail(t *testing.T) {
+	s := newTestStore(t)
+	for _, email := range []string{"ada@example.com\r\nBcc: eve@example.com", "not-an-address"} {
+		if err := s.CreateUser(t.Context(), &User{Name: "Ada", Email: email}); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%q: expected ErrInvalid, got %v", email, err)
+		}
+	}
+	u := User{Name: "Ada", Email: "Ada Lovelace <ada@example.com>"}
+	if err := s.CreateUser(t.Context(), &u); err != nil {
+		t.Fatal(err)
+	}
+	if u.Email != "ada@example.com" {
+		t.Errorf("email stored as %q", u.Email)
+	}
+}
+
+func TestSMTPMailer_StartTLS(t *testing.T) {
+	srv := newFakeSMTP(t, true)
+	cfg := srv.config()
+	cfg.StartTLS = StartTLSRequired
+	cfg.InsecureSkipVerify = true
+
+	if err := NewSMTPMailer(cfg).Send(context.Background(), EmailMessage{To: "ada@example.com", Subject: "s", Text: "t"}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if !srv.usedTLS {
+		t.Fatal("expected the session to be upgraded with STARTTLS")
+	}
+}
+
+func TestSMTPMailer_RequiredStartTLSUnavailable(t *testing.T) {
+	srv := newFakeSMTP(t, false)
+	cfg := srv.config()
+	cfg.StartTLS = StartTLSRequired
+
+	if err := NewSMTPMailer(cfg).Send(context.Background(), EmailMessage{To: "ada@example.com"}); err == nil {
+		t.Fatal("expected an error when STARTTLS is required but not offered")
+	}
+}
+
+type flakyMailer struct {
+	failures int
+	sent     []EmailMessage
+}
+
+func (m *flakyMailer) Send(_ context.Context, msg EmailMessage) error {
+	if m.failures > 0 {
+		m.failures--
+		return errors.New("connection refused")
+	}
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func TestEmailDispatcher_RetriesWithBackoff(t *testing.T) {
+	s := newTestStore(t)
+	if err := s.EnqueueEmail(t.Context(), demoUserID, EmailMessage{To: "demo@example.com", Subject: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+
+	mailer := &flakyMailer{failures: 2}
+	d := NewEmailDispatcher(s, mailer)
+	now := time.Now()
+	d.now = func() time.Time { return now }
+
+	d.tick(context.Background()) // attempt 1 fails, retry in 30s
+	now = now.Add(10 * time.Second)
+	d.tick(context.Background()) // still backing off
+	if len(mailer.sent) != 0 || mailer.failures != 1 {
+		t.Fatalf("expected one attempt so far, failures left %d", mailer.failures)
+	}
+
+	now = now.Add(25 * time.Second)
+	d.tick(context.Background()) // attempt 2 fails, retry in 60s
+	now = now.Add(45 * time.Second)
+	d.tick(context.Background())
+	if len(mailer.sent) != 0 {
+		t.Fatal("expected the second backoff to be longer than the first")
+	}
+
+	now = now.Add(20 * time.Second)
+	d.tick(context.Background())
+	if len(mailer.sent) != 1 {
+		t.Fatalf("expected delivery on the third attempt, got %d", len(mailer.sent))
+	}
+	d.tick(context.Background())
+	if len(mailer.sent) != 1 {
+		t.Fatal("a sent email must not be delivered again")
+	}
+}
+
+func TestEmailDispatcher_DeadAfterMaxAttempts(t *testing.T) {
+	s := newTestStore(t)
+	if err := s.EnqueueEmail(t.Context(), demoUserID, EmailMessage{To: "demo@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewEmailDispatcher(s, &flakyMailer{failures: 100})
+	d.maxAttempts = 2
+	now := time.Now()
+	d.now = func() time.Time { return now }
+	for i := 0; i < 5; i++ {
+		d.tick(context.Background())
+		now = now.Add(2 * time.Hour)
+	}
+
+	var status string
+	var attempts int
+	if err := s.db.QueryRow(`SELECT status, attempts FROM email_outbox`).Scan(&status, &attempts); err != nil {
+		t.Fatal(err)
+	}
+	if status != EmailDead || attempts != 2 {
+		t.Fatalf("expected dead after 2 attempts, got %s after %d", status, attempts)
+	}
+}
+
+func TestEmailNotifications_EndToEnd(t *testing.T) {
+	s := newTestStore(t)
+	srv := newFakeSMTP(t, false)
+
+	ada := User{Name: "ada", Email: "ada@example.com"}
+	quiet := User{Name: "quiet", Email: "quiet@example.com"}
+	for _, u := range []*User{&ada, &quiet} {
+		if err := s.CreateUser(t.Context(), u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	prefs := DefaultNotificationPrefs()
+	prefs.Assigned = false
+	if err := s.SetNotificationPrefs(t.Context(), quiet.ID, prefs); err != nil {
+		t.Fatal(err)
+	}
+
+	events := NewEventBus()
+	SubscribeNotifications(events, s, s, NewEmailNotifier(s, s, s))
+
+	task := Task{Title: "Renew certs"}
+	if err := s.CreateTask(t.Context(), &task); err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []User{ada, quiet} {
+		if _, err := s.AssignUser(t.Context(), task.ID, u.ID); err != nil {
+			t.Fatal(err)
+		}
+		events.Publish(Event{Type: EventTaskAssigned, TaskID: task.ID, UserID: u.ID, ActorID: demoUserID})
+	}
+	// Assigning yourself is not news.
+	events.Publish(Event{Type: EventTaskAssigned, TaskID: task.ID, UserID: demoUserID, ActorID: demoUserID})
+
+	d := NewEmailDispatcher(s, NewSMTPMailer(srv.config()))
+	d.tick(context.Background())
+
+	select {
+	case <-srv.received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email reached the fake server")
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(srv.data) != 1 || !strings.Contains(srv.to[0], "ada@example.com") {
+		t.Fatalf("expected exactly one email to ada, got to=%v", srv.to)
+	}
+	if !strings.Contains(srv.data[0], "Renew certs") {
+		t.Fatalf("email does not mention the task:\n%s", srv.data[0])
+	}
+}
+
+func TestReminderScheduler_OverdueOncePerDueDate(t *testing.T) {
+	s := newTestStore(t)
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	due := now.Add(-time.Hour)
+	task := Task{Title: "File taxes", DueAt: &due, Assignees: []int{demoUserID}}
+	if err := s.CreateTask(t.Context(), &task); err != nil {
+		t.Fatal(err)
+	}
+
+	notifier := &recordingNotifier{}
+	sched := NewReminderScheduler(s, notifier)
+	sched.now = func() time.Time { return now }
+	sched.tick(context.Background())
+	sched.tick(context.Background())
+
+	if len(notifier.sent) != 1 || notifier.sent[0].Kind != NotificationOverdue {
+		t.Fatalf("expected one overdue notice, got %+v", notifier.sent)
+	}
+
+	later := now.Add(-30 * time.Minute)
+	task.DueAt = &later
+	if err := s.UpdateTask(t.Context(), &task, UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	sched.tick(context.Background())
+	if len(notifier.sent) != 2 {
+		t.Fatalf("expected a new notice after the due date moved, got %d", len(notifier.sent))
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	EventTaskCreated    = "task.created"
+	EventTaskUpdated    = "task.updated"
+	EventTaskCompleted  = "task.completed"
+	EventTaskDeleted    = "task.deleted"
+	EventTaskAssigned   = "task.assigned"
+	EventTaskUnassigned = "task.unassigned"
+	EventCommentCreated = "comment.created"
+	EventUserMentioned  = "comment.mentioned"
+)
+
+// Event describes something that happened to a task. UserID is the user the
+// event is about (for example the assignee); ActorID is who caused it.
+type Event struct {
+	Type      string    `json:"type"`
+	TaskID    int       `json:"taskId"`
+	CommentID int       `json:"commentId,omitempty"`
+	UserID    int       `json:"userId,omitempty"`
+	ActorID   int       `json:"actorId,omitempty"`
+	Task      *Task     `json:"task,omitempty"`
+	At        time.Time `json:"at"`
+	// RequestID is the API request that caused the event, if any.
+	RequestID string `json:"requestId,omitempty"`
+	// TraceParent continues that request's trace, for outgoing webhooks.
+	TraceParent string `json:"-"`
+}
+
+// EventHandler is called with the context of the request that published
+// the event, or a background context for events from workers.
+type EventHandler func(context.Context, Event)
+
+// EventBus fans events out to subscribers in-process. Handlers run
+// synchronously on the publishing goroutine, so they must not block.
+type EventBus struct {
+	mu   sync.RWMutex
+	subs []EventHandler
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+func (b *EventBus) Subscribe(h EventHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs = append(b.subs, h)
+}
+
+// Publish is a no-op on a nil bus so handlers can be wired without one.
+func (b *EventBus) Publish(e Event) {
+	b.publish(context.Background(), e)
+}
+
+func (b *EventBus) publish(ctx context.Context, e Event) {
+	if b == nil {
+		return
+	}
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+	b.mu.RLock()
+	subs := b.subs
+	b.mu.RUnlock()
+	for _, h := range subs {
+		h(ctx, e)
+	}
+}
+
+// PublishFrom publishes e on behalf of the request in ctx, which ties
+// webhooks and notifications back to the request and its trace.
+func (b *EventBus) PublishFrom(ctx context.Context, e Event) {
+	e.RequestID = requestIDFrom(ctx)
+	e.TraceParent = traceparentFrom(ctx)
+	b.publish(ctx, e)
+}
+
+// The helpers below publish the lifecycle events for one store call, so
+// every entry point (REST, live, sync) reports changes the same way.
+
+func (b *EventBus) publishTaskCreated(ctx context.Context, task *Task, actorID int) {
+	b.PublishFrom(ctx, Event{Type: EventTaskCreated, TaskID: task.ID, ActorID: actorID, Task: task})
+	for _, userID := range task.Assignees {
+		b.PublishFrom(ctx, Event{Type: EventTaskAssigned, TaskID: task.ID, UserID: userID, ActorID: actorID})
+	}
+}
+
+func (b *EventBus) publishTaskUpdated(ctx context.Context, task *Task, wasCompleted bool, actorID int) {
+	b.PublishFrom(ctx, Event{Type: EventTaskUpdated, TaskID: task.ID, ActorID: actorID, Task: task})
+	if task.Completed && !wasCompleted {
+		b.PublishFrom(ctx, Event{Type: EventTaskCompleted, TaskID: task.ID, ActorID: actorID, Task: task})
+	}
+}
+
+// publishTaskUpdateResult publishes the events for an update that res
+// describes: the task itself, then the tasks the update created or
+// completed along the way, as tasks now has them.
+func (b *EventBus) publishTaskUpdateResult(ctx context.Context, tasks TaskStore, task *Task, res UpdateResult, actorID int) {
+	b.publishTaskUpdated(ctx, task, res.WasCompleted, actorID)
+	if b == nil {
+		return
+	}
+	for _, id := range res.Created {
+		if t, err := tasks.GetTaskByID(ctx, id); err == nil {
+			b.publishTaskCreated(ctx, &t, actorID)
+		}
+	}
+	for _, id := range res.Completed {
+		if t, err := tasks.GetTaskByID(ctx, id); err == nil {
+			b.publishTaskUpdated(ctx, &t, false, actorID)
+		}
+	}
+}
+
+// before is the task as it was just before deletion, or nil if unknown.
+func (b *EventBus) publishTaskDeleted(ctx context.Context, id int, before *Task, actorID int) {
+	b.PublishFrom(ctx, Event{Type: EventTaskDeleted, TaskID: id, ActorID: actorID, Task: before})
+}
+
+// publishSubtreeDeleted announces the delete of a task and of every subtask
+// that went with it.
+func (b *EventBus) publishSubtreeDeleted(ctx context.Context, before Task, subtasks []Task, actorID int) {
+	b.publishTaskDeleted(ctx, before.ID, &before, actorID)
+	for i := range subtasks {
+		b.publishTaskDeleted(ctx, subtasks[i].ID, &subtasks[i], actorID)
+	}
+}
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
+	"slices"
 	"strconv"
 )
 
 type errResp struct {
-	Error string `json:"error"`
+	Error     string `json:"error"`
+	RequestID string `json:"requestId,omitempty"`
 }
 
 func writeJSON(w http.ResponseWriter, status int, v any) {
@@ -18,49 +22,112 @@ func writeJSON(w http.ResponseWriter, status int, v any) {
 }
 
 func writeErr(w http.ResponseWriter, status int, msg string) {
-	writeJSON(w, status, errResp{Error: msg})
+	// RequestID has already put the ID on the response.
+	writeJSON(w, status, errResp{Error: msg, RequestID: w.Header().Get(requestIDHeader)})
 }
+
+// publicErrorMessage is what a client may see for a store error outside a
+// plain REST response, such as a live ack or a sync result: validation and
+// conflict errors are shown, anything unexpected is logged and hidden.
+func publicErrorMessage(ctx context.Context, err error) string {
+	switch {
+	case errors.Is(err, ErrInvalid), errors.Is(err, ErrCycle), errors.Is(err, ErrTooDeep),
+		errors.Is(err, ErrOpenSubtasks), errors.Is(err, ErrBlocked), errors.Is(err, errNotAllowed):
+		return err.Error()
+	case errors.Is(err, ErrNotFound):
+		return "not found"
+	}
+	loggerFrom(ctx).Error("store error", "error", err)
+	return "internal error"
+}
+
+// parseTaskFilter reads the list filters shared by every endpoint that
+// returns a set of tasks.
+func parseTaskFilter(r *http.Request) (TaskFilter, error) {
+	var filter TaskFilter
+	q := r.URL.Query()
+
+	if val := q.Get("completed"); val != "" {
+		if val == "true" {
+			t := true
+			filter.Completed = &t
+		} else if val == "false" {
+			f := false
+			filter.Completed = &f
+		} else {
+			return filter, errors.New("invalid completed value")
+		}
+	}
+
+	if val := q.Get("assignee"); val != "" {
+		var id int
+		if val == "me" {
+			id = getUserID(r)
+		} else {
+			id, _ = strconv.Atoi(val)
+		}
+		if id <= 0 {
+			return filter, errors.New("invalid assignee value")
+		}
+		filter.Assignee = &id
+	}
+
+	if val := q.Get("unassigned"); val != "" {
+		u, err := strconv.ParseBool(val)
+		if err != nil {
+			return filter, errors.New("invalid unassigned value")
+		}
+		filter.Unassigned = u
+	}
+	return filter, nil
+}
+
 func getTaskHandler(store TaskStore) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var filter *bool
-		if val := r.URL.Query().Get("completed"); val != "" {
-			if val == "true" {
-				t := true
-				filter = &t
-			} else if val == "false" {
-				f := false
-				filter = &f
-			} else {
-				writeErr(w, http.StatusBadRequest, "invalid completed value")
-				return
-			}
+		filter, err := parseTaskFilter(r)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		tree, err := parseTreeParam(r)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, "invalid tree value")
+			return
 		}
 
-		tasks, err := store.GetAllTasks(filter)
+		tasks, err := store.GetAllTasks(r.Context(), filter)
 		if err != nil {
 			writeErr(w, http.StatusInternalServerError, "internal error")
 			return
 		}
+		if tree {
+			tasks = buildTaskTree(tasks)
+		}
 		writeJSON(w, http.StatusOK, tasks)
 	}
 }
 
-func postTaskHandler(store TaskStore) http.HandlerFunc {
+func postTaskHandler(store TaskStore, users UserStore, events *EventBus) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var newTask Task
 		if err := json.NewDecoder(r.Body).Decode(&newTask); err != nil {
 			writeErr(w, http.StatusBadRequest, "invalid json")
 			return
 		}
-		if err := store.CreateTask(&newTask); err != nil {
+		if err := newTaskAuth(r.Context(), users, getUserID(r)).move(r.Context(), store, nil, newTask.ParentID); err != nil {
+			writeTaskAuthError(w, err)
+			return
+		}
+		if err := store.CreateTask(r.Context(), &newTask); err != nil {
 			switch {
-			case errors.Is(err, ErrInvalid):
+			case errors.Is(err, ErrInvalid), errors.Is(err, ErrTooDeep):
 				writeErr(w, http.StatusBadRequest, err.Error())
 			default:
 				writeErr(w, http.StatusInternalServerError, "internal error")
 			}
 			return
 		}
+		events.publishTaskCreated(r.Context(), &newTask, getUserID(r))
 		writeJSON(w, http.StatusCreated, newTask)
 	}
 }
@@ -73,7 +140,7 @@ func getTaskByIDHandler(store TaskStore) http.HandlerFunc {
 			writeErr(w, http.StatusBadRequest, "invalid id")
 			return
 		}
-		task, err := store.GetTaskByID(id)
+		task, err := store.GetTaskByID(r.Context(), id)
 		if err != nil {
 			if errors.Is(err, ErrNotFound) {
 				writeErr(w, http.StatusNotFound, "not found")
@@ -86,7 +153,7 @@ func getTaskByIDHandler(store TaskStore) http.HandlerFunc {
 	}
 }
 
-func deleteTaskByIDHandler(store TaskStore) http.HandlerFunc {
+func deleteTaskByIDHandler(store TaskStore, users UserStore, events *EventBus) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		idStr := r.PathValue("ID")
 		id, err := strconv.Atoi(idStr)
@@ -94,7 +161,18 @@ func deleteTaskByIDHandler(store TaskStore) http.HandlerFunc {
 			writeErr(w, http.StatusBadRequest, "invalid id")
 			return
 		}
-		if err := store.DeleteTask(id); err != nil {
+		// Subscribers get the task as it was, as loaded for the check.
+		auth := newTaskAuth(r.Context(), users, getUserID(r))
+		before, err := auth.task(r.Context(), store, id)
+		var subtasks []Task
+		if err == nil {
+			subtasks, err = auth.subtree(r.Context(), store, id)
+		}
+		if err != nil {
+			writeTaskAuthError(w, err)
+			return
+		}
+		if err := store.DeleteTask(r.Context(), id); err != nil {
 			if errors.Is(err, ErrNotFound) {
 				writeErr(w, http.StatusNotFound, "not found")
 			} else {
@@ -102,15 +180,18 @@ func deleteTaskByIDHandler(store TaskStore) http.HandlerFunc {
 			}
 			return
 		}
+		events.publishSubtreeDeleted(r.Context(), before, subtasks, getUserID(r))
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
+const demoUserID = 123
+
 func loginHandler(w http.ResponseWriter, r *http.Request) {
 
-	userID := 123
+	userID := demoUserID
 
 	token, err := GenerateJWT(userID)
 	if err != nil {
@@ -123,7 +204,27 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func updateTaskByIDHandler(store TaskStore) http.HandlerFunc {
+// decodeTaskUpdate decodes the body of a task update, over REST or live.
+// The parent and schedule stay as they are unless the body mentions them,
+// so clearing one takes an explicit null; keep lists the ones it leaves
+// out, for UpdateOptions.Keep.
+func decodeTaskUpdate(data []byte) (task Task, keep []string, err error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &task); err != nil {
+		return Task{}, nil, err
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return Task{}, nil, err
+	}
+	for _, name := range []string{"parentId", "dueAt", "rrule", "timeZone"} {
+		if _, ok := fields[name]; !ok {
+			keep = append(keep, name)
+		}
+	}
+	return task, keep, nil
+}
+
+func updateTaskByIDHandler(store TaskStore, users UserStore, events *EventBus) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		idStr := r.PathValue("ID")
 		id, err := strconv.Atoi(idStr)
@@ -131,16 +232,48 @@ func updateTaskByIDHandler(store TaskStore) http.HandlerFunc {
 			writeErr(w, http.StatusBadRequest, "invalid id")
 			return
 		}
-		var updated Task
-		if err := json.NewDecoder(r.Body).Decode(&updated); err != nil {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		updated, keep, err := decodeTaskUpdate(body)
+		if err != nil {
 			writeErr(w, http.StatusBadRequest, "invalid json")
 			return
 		}
 		updated.ID = id
-		if err := store.UpdateTask(&updated); err != nil {
+		auth := newTaskAuth(r.Context(), users, getUserID(r))
+		current, err := auth.task(r.Context(), store, id)
+		if err != nil {
+			writeTaskAuthError(w, err)
+			return
+		}
+		parent := updated.ParentID
+		if slices.Contains(keep, "parentId") {
+			parent = current.ParentID
+		}
+		if err := auth.move(r.Context(), store, current.ParentID, parent); err != nil {
+			writeTaskAuthError(w, err)
+			return
+		}
+		opts := UpdateOptions{Keep: keep}
+		if val := r.URL.Query().Get("force"); val != "" {
+			force, err := strconv.ParseBool(val)
+			if err != nil {
+				writeErr(w, http.StatusBadRequest, "invalid force value")
+				return
+			}
+			opts.Force = force
+		}
+		var res UpdateResult
+		opts.Result = &res
+		if err := store.UpdateTask(r.Context(), &updated, opts); err != nil {
 			switch {
-			case errors.Is(err, ErrInvalid):
+			case errors.Is(err, ErrInvalid), errors.Is(err, ErrCycle), errors.Is(err, ErrTooDeep):
 				writeErr(w, http.StatusBadRequest, err.Error())
+			case errors.Is(err, ErrOpenSubtasks), errors.Is(err, ErrBlocked):
+				writeErr(w, http.StatusConflict, err.Error())
 			case errors.Is(err, ErrNotFound):
 				writeErr(w, http.StatusNotFound, "not found")
 			default:
@@ -148,6 +281,7 @@ func updateTaskByIDHandler(store TaskStore) http.HandlerFunc {
 			}
 			return
 		}
+		events.publishTaskUpdateResult(r.Context(), store, &updated, res, getUserID(r))
 		writeJSON(w, http.StatusOK, updated)
 	}
 }
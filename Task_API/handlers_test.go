@@ -2,39 +2,58 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 type MockStore struct {
-	GetAllTasksFunc func(filterCompleted *bool) ([]Task, error)
+	GetAllTasksFunc func(filter TaskFilter) ([]Task, error)
 	GetTaskByIDFunc func(id int) (Task, error)
+	GetSubtasksFunc func(parentID int) ([]Task, error)
 	CreateTaskFunc  func(task *Task) error
-	UpdateTaskFunc  func(task *Task) error
+	UpdateTaskFunc  func(task *Task, opts UpdateOptions) error
 	DeleteTaskFunc  func(id int) error
 }
 
-func (m *MockStore) GetAllTasks(filterCompleted *bool) ([]Task, error) {
-	return m.GetAllTasksFunc(filterCompleted)
+func (m *MockStore) GetAllTasks(ctx context.Context, filter TaskFilter) ([]Task, error) {
+	return m.GetAllTasksFunc(filter)
 }
-func (m *MockStore) GetTaskByID(id int) (Task, error) {
+func (m *MockStore) GetTaskByID(ctx context.Context, id int) (Task, error) {
 	return m.GetTaskByIDFunc(id)
 }
-func (m *MockStore) CreateTask(task *Task) error {
+func (m *MockStore) GetSubtasks(ctx context.Context, parentID int) ([]Task, error) {
+	return m.GetSubtasksFunc(parentID)
+}
+func (m *MockStore) CreateTask(ctx context.Context, task *Task) error {
 	return m.CreateTaskFunc(task)
 }
-func (m *MockStore) UpdateTask(task *Task) error {
-	return m.UpdateTaskFunc(task)
+func (m *MockStore) UpdateTask(ctx context.Context, task *Task, opts UpdateOptions) error {
+	return m.UpdateTaskFunc(task, opts)
 }
-func (m *MockStore) DeleteTask(id int) error {
+func (m *MockStore) DeleteTask(ctx context.Context, id int) error {
 	return m.DeleteTaskFunc(id)
 }
 
+// openTask finds every task, unassigned.
+func openTask(id int) (Task, error) {
+	return Task{ID: id, Title: "task"}, nil
+}
+
+func noSubtasks(parentID int) ([]Task, error) {
+	return nil, nil
+}
+
 func TestGetTaskHandler_ReturnsTasks(t *testing.T) {
 	mockStore := &MockStore{
-		GetAllTasksFunc: func(_ *bool) ([]Task, error) {
+		GetAllTasksFunc: func(_ TaskFilter) ([]Task, error) {
 			return []Task{
 				{ID: 1, Title: "Test Task", Description: "test desc", Completed: false},
 			}, nil
@@ -66,8 +85,8 @@ func TestGetTaskHandler_ReturnsTasks(t *testing.T) {
 func TestGetTaskHandler_FilterCompleted(t *testing.T) {
 	trueVal := true
 	mockStore := &MockStore{
-		GetAllTasksFunc: func(filter *bool) ([]Task, error) {
-			if filter == nil || *filter != trueVal {
+		GetAllTasksFunc: func(filter TaskFilter) ([]Task, error) {
+			if filter.Completed == nil || *filter.Completed != trueVal {
 				t.Fatalf("expected filter=true, got %+v", filter)
 			}
 			return []Task{{ID: 1, Title: "Completed Task", Completed: true}}, nil
@@ -87,8 +106,8 @@ func TestGetTaskHandler_FilterCompleted(t *testing.T) {
 func TestGetTaskHandler_FilterIncomplete(t *testing.T) {
 	falseVal := false
 	mockStore := &MockStore{
-		GetAllTasksFunc: func(filter *bool) ([]Task, error) {
-			if filter == nil || *filter != falseVal {
+		GetAllTasksFunc: func(filter TaskFilter) ([]Task, error) {
+			if filter.Completed == nil || *filter.Completed != falseVal {
 				t.Fatalf("expected filter=false, got %+v", filter)
 			}
 			return []Task{{ID: 2, Title: "Incomplete Task", Completed: false}}, nil
@@ -113,6 +132,7 @@ func TestGetTaskByIDHandler_Found(t *testing.T) {
 	}
 
 	req := httptest.NewRequest(http.MethodGet, "/tasks/1", nil)
+	req.SetPathValue("ID", "1")
 	rec := httptest.NewRecorder()
 
 	getTaskByIDHandler(mockStore).ServeHTTP(rec, req)
@@ -130,6 +150,7 @@ func TestGetTaskByIDHandler_NotFound(t *testing.T) {
 	}
 
 	req := httptest.NewRequest(http.MethodGet, "/tasks/1", nil)
+	req.SetPathValue("ID", "1")
 	rec := httptest.NewRecorder()
 
 	getTaskByIDHandler(mockStore).ServeHTTP(rec, req)
@@ -151,7 +172,7 @@ func TestPostTaskHandler_CreatesTask(t *testing.T) {
 	req := httptest.NewRequest(http.MethodPost, "/tasks", body)
 	rec := httptest.NewRecorder()
 
-	postTaskHandler(mockStore).ServeHTTP(rec, req)
+	postTaskHandler(mockStore, noAdmins(), nil).ServeHTTP(rec, req)
 
 	if rec.Code != http.StatusCreated {
 		t.Fatalf("expected 201 Created, got %d", rec.Code)
@@ -164,7 +185,7 @@ func TestPostTaskHandler_InvalidJSON(t *testing.T) {
 	req := httptest.NewRequest(http.MethodPost, "/tasks", body)
 	rec := httptest.NewRecorder()
 
-	postTaskHandler(mockStore).ServeHTTP(rec, req)
+	postTaskHandler(mockStore, noAdmins(), nil).ServeHTTP(rec, req)
 
 	if rec.Code != http.StatusBadRequest {
 		t.Fatalf("expected 400 Bad Request, got %d", rec.Code)
@@ -181,7 +202,7 @@ func TestPostTaskHandler_MissingTitle(t *testing.T) {
 	req := httptest.NewRequest(http.MethodPost, "/tasks", body)
 	rec := httptest.NewRecorder()
 
-	postTaskHandler(mockStore).ServeHTTP(rec, req)
+	postTaskHandler(mockStore, noAdmins(), nil).ServeHTTP(rec, req)
 
 	if rec.Code != http.StatusBadRequest {
 		t.Fatalf("expected 400 Bad Request, got %d", rec.Code)
@@ -190,29 +211,121 @@ func TestPostTaskHandler_MissingTitle(t *testing.T) {
 
 func TestUpdateTaskHandler_UpdatesTask(t *testing.T) {
 	mockStore := &MockStore{
-		UpdateTaskFunc: func(task *Task) error {
+		GetTaskByIDFunc: openTask,
+		UpdateTaskFunc: func(task *Task, opts UpdateOptions) error {
 			return nil
 		},
 	}
 
 	body := bytes.NewBufferString(`{"title":"Updated Task","completed":true}`)
 	req := httptest.NewRequest(http.MethodPut, "/tasks/1", body)
+	req.SetPathValue("ID", "1")
 	rec := httptest.NewRecorder()
 
-	updateTaskByIDHandler(mockStore).ServeHTTP(rec, req)
+	updateTaskByIDHandler(mockStore, noAdmins(), nil).ServeHTTP(rec, req)
 
 	if rec.Code != http.StatusOK {
 		t.Fatalf("expected 200 OK, got %d", rec.Code)
 	}
 }
 
+func TestUpdateTaskHandler_KeepsOmittedParentAndSchedule(t *testing.T) {
+	s := newTestStore(t)
+	parent := Task{Title: "parent"}
+	if err := s.CreateTask(t.Context(), &parent); err != nil {
+		t.Fatal(err)
+	}
+	due := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	child := Task{Title: "child", ParentID: &parent.ID, DueAt: &due, RRule: "FREQ=WEEKLY"}
+	if err := s.CreateTask(t.Context(), &child); err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(body string) Task {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPut, "/tasks/"+strconv.Itoa(child.ID), bytes.NewBufferString(body))
+		req.SetPathValue("ID", strconv.Itoa(child.ID))
+		rec := httptest.NewRecorder()
+		updateTaskByIDHandler(s, s, nil).ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		got, err := s.GetTaskByID(t.Context(), child.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	got := put(`{"title":"renamed"}`)
+	if got.ParentID == nil || *got.ParentID != parent.ID {
+		t.Errorf("parent after PUT without parentId = %v, want %d", got.ParentID, parent.ID)
+	}
+	if got.DueAt == nil || !got.DueAt.Equal(due) || got.RRule != "FREQ=WEEKLY" {
+		t.Errorf("schedule after PUT without it = %v %q", got.DueAt, got.RRule)
+	}
+	got = put(`{"title":"renamed","parentId":null,"dueAt":null,"rrule":""}`)
+	if got.ParentID != nil || got.DueAt != nil || got.RRule != "" {
+		t.Errorf("after clearing: parent %v, due %v, rrule %q", got.ParentID, got.DueAt, got.RRule)
+	}
+}
+
+func TestTaskHandlers_AuthorizePerTask(t *testing.T) {
+	s := newTestStore(t)
+	alice, bob := User{Name: "Alice"}, User{Name: "Bob"}
+	for _, u := range []*User{&alice, &bob} {
+		if err := s.CreateUser(t.Context(), u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mine := Task{Title: "mine", Assignees: []int{alice.ID}}
+	theirs := Task{Title: "theirs", Assignees: []int{bob.ID}}
+	for _, task := range []*Task{&mine, &theirs} {
+		if err := s.CreateTask(t.Context(), task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sub := Task{Title: "sub", ParentID: &mine.ID, Assignees: []int{bob.ID}}
+	if err := s.CreateTask(t.Context(), &sub); err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(h http.HandlerFunc, method string, id, userID int, body string) int {
+		t.Helper()
+		req := withUser(httptest.NewRequest(method, "/tasks", strings.NewReader(body)), userID)
+		req.SetPathValue("ID", strconv.Itoa(id))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	put, del, post := updateTaskByIDHandler(s, s, nil), deleteTaskByIDHandler(s, s, nil), postTaskHandler(s, s, nil)
+	for name, got := range map[string]int{
+		"update someone else's task":    serve(put, http.MethodPut, theirs.ID, alice.ID, `{"title":"taken"}`),
+		"move own task under theirs":    serve(put, http.MethodPut, mine.ID, alice.ID, fmt.Sprintf(`{"title":"mine","parentId":%d}`, theirs.ID)),
+		"delete with their subtask":     serve(del, http.MethodDelete, mine.ID, alice.ID, ""),
+		"create under someone else's":   serve(post, http.MethodPost, 0, alice.ID, fmt.Sprintf(`{"title":"x","parentId":%d}`, theirs.ID)),
+		"delete someone else's subtask": serve(del, http.MethodDelete, sub.ID, alice.ID, ""),
+	} {
+		if got != http.StatusForbidden {
+			t.Errorf("%s: status %d, want 403", name, got)
+		}
+	}
+	if got := serve(put, http.MethodPut, mine.ID, alice.ID, `{"title":"renamed"}`); got != http.StatusOK {
+		t.Errorf("update own task: status %d", got)
+	}
+	if got := serve(put, http.MethodPut, theirs.ID, demoUserID, `{"title":"by an admin"}`); got != http.StatusOK {
+		t.Errorf("admin update: status %d", got)
+	}
+}
+
 func TestUpdateTaskHandler_InvalidJSON(t *testing.T) {
 	mockStore := &MockStore{}
 	body := bytes.NewBufferString(`{bad json}`)
 	req := httptest.NewRequest(http.MethodPut, "/tasks/1", body)
+	req.SetPathValue("ID", "1")
 	rec := httptest.NewRecorder()
 
-	updateTaskByIDHandler(mockStore).ServeHTTP(rec, req)
+	updateTaskByIDHandler(mockStore, noAdmins(), nil).ServeHTTP(rec, req)
 
 	if rec.Code != http.StatusBadRequest {
 		t.Fatalf("expected 400 Bad Request, got %d", rec.Code)
@@ -221,15 +334,17 @@ func TestUpdateTaskHandler_InvalidJSON(t *testing.T) {
 
 func TestUpdateTaskHandler_MissingTitle(t *testing.T) {
 	mockStore := &MockStore{
-		UpdateTaskFunc: func(task *Task) error {
+		GetTaskByIDFunc: openTask,
+		UpdateTaskFunc: func(task *Task, opts UpdateOptions) error {
 			return ErrInvalid
 		},
 	}
 	body := bytes.NewBufferString(`{"description":"no title"}`)
 	req := httptest.NewRequest(http.MethodPut, "/tasks/1", body)
+	req.SetPathValue("ID", "1")
 	rec := httptest.NewRecorder()
 
-	updateTaskByIDHandler(mockStore).ServeHTTP(rec, req)
+	updateTaskByIDHandler(mockStore, noAdmins(), nil).ServeHTTP(rec, req)
 
 	if rec.Code != http.StatusBadRequest {
 		t.Fatalf("expected 400 Bad Request, got %d", rec.Code)
@@ -238,15 +353,18 @@ func TestUpdateTaskHandler_MissingTitle(t *testing.T) {
 
 func TestDeleteTaskHandler_DeletesTask(t *testing.T) {
 	mockStore := &MockStore{
+		GetTaskByIDFunc: openTask,
+		GetSubtasksFunc: noSubtasks,
 		DeleteTaskFunc: func(id int) error {
 			return nil
 		},
 	}
 
 	req := httptest.NewRequest(http.MethodDelete, "/tasks/1", nil)
+	req.SetPathValue("ID", "1")
 	rec := httptest.NewRecorder()
 
-	deleteTaskByIDHandler(mockStore).ServeHTTP(rec, req)
+	deleteTaskByIDHandler(mockStore, noAdmins(), nil).ServeHTTP(rec, req)
 
 	if rec.Code != http.StatusNoContent {
 		t.Fatalf("expected 204 No Content, got %d", rec.Code)
@@ -255,17 +373,471 @@ func TestDeleteTaskHandler_DeletesTask(t *testing.T) {
 
 func TestDeleteTaskHandler_NotFound(t *testing.T) {
 	mockStore := &MockStore{
-		DeleteTaskFunc: func(id int) error {
-			return ErrNotFound
+		GetTaskByIDFunc: func(id int) (Task, error) {
+			return Task{}, ErrNotFound
 		},
 	}
 
 	req := httptest.NewRequest(http.MethodDelete, "/tasks/1", nil)
+	req.SetPathValue("ID", "1")
 	rec := httptest.NewRecorder()
 
-	deleteTaskByIDHandler(mockStore).ServeHTTP(rec, req)
+	deleteTaskByIDHandler(mockStore, noAdmins(), nil).ServeHTTP(rec, req)
 
 	if rec.Code != http.StatusNotFound {
 		t.Fatalf("expected 404 Not Found, got %d", rec.Code)
 	}
 }
+
+func TestGetSubtasksHandler_DirectChildren(t *testing.T) {
+	parent, child := 1, 2
+	mockStore := &MockStore{
+		GetSubtasksFunc: func(parentID int) ([]Task, error) {
+			return []Task{
+				{ID: 2, Title: "Child", ParentID: &parent},
+				{ID: 3, Title: "Grandchild", ParentID: &child},
+			}, nil
+		},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/tasks/1/subtasks", nil)
+	req.SetPathValue("ID", "1")
+	rec := httptest.NewRecorder()
+
+	getSubtasksHandler(mockStore).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 OK, got %d", rec.Code)
+	}
+	var tasks []Task
+	if err := json.Unmarshal(rec.Body.Bytes(), &tasks); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(tasks) != 1 || tasks[0].ID != 2 {
+		t.Fatalf("expected only the direct child, got %+v", tasks)
+	}
+}
+
+func TestGetSubtasksHandler_Tree(t *testing.T) {
+	parent, child := 1, 2
+	mockStore := &MockStore{
+		GetSubtasksFunc: func(parentID int) ([]Task, error) {
+			return []Task{
+				{ID: 2, Title: "Child", ParentID: &parent},
+				{ID: 3, Title: "Grandchild", ParentID: &child},
+			}, nil
+		},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/tasks/1/subtasks?tree=true", nil)
+	req.SetPathValue("ID", "1")
+	rec := httptest.NewRecorder()
+
+	getSubtasksHandler(mockStore).ServeHTTP(rec, req)
+
+	var tasks []Task
+	if err := json.Unmarshal(rec.Body.Bytes(), &tasks); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(tasks) != 1 || len(tasks[0].Subtasks) != 1 || tasks[0].Subtasks[0].ID != 3 {
+		t.Fatalf("expected nested tree, got %+v", tasks)
+	}
+}
+
+func TestUpdateTaskHandler_OpenSubtasks(t *testing.T) {
+	mockStore := &MockStore{
+		GetTaskByIDFunc: openTask,
+		UpdateTaskFunc: func(task *Task, opts UpdateOptions) error {
+			return ErrOpenSubtasks
+		},
+	}
+	body := bytes.NewBufferString(`{"title":"Parent","completed":true}`)
+	req := httptest.NewRequest(http.MethodPut, "/tasks/1", body)
+	req.SetPathValue("ID", "1")
+	rec := httptest.NewRecorder()
+
+	updateTaskByIDHandler(mockStore, noAdmins(), nil).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409 Conflict, got %d", rec.Code)
+	}
+}
+
+func TestUpdateTaskHandler_BlockedUnlessForced(t *testing.T) {
+	mockStore := &MockStore{
+		GetTaskByIDFunc: openTask,
+		UpdateTaskFunc: func(task *Task, opts UpdateOptions) error {
+			if !opts.Force {
+				return ErrBlocked
+			}
+			return nil
+		},
+	}
+
+	body := bytes.NewBufferString(`{"title":"Blocked","completed":true}`)
+	req := httptest.NewRequest(http.MethodPut, "/tasks/1", body)
+	req.SetPathValue("ID", "1")
+	rec := httptest.NewRecorder()
+	updateTaskByIDHandler(mockStore, noAdmins(), nil).ServeHTTP(rec, req)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409 Conflict, got %d", rec.Code)
+	}
+
+	body = bytes.NewBufferString(`{"title":"Blocked","completed":true}`)
+	req = httptest.NewRequest(http.MethodPut, "/tasks/1?force=true", body)
+	req.SetPathValue("ID", "1")
+	rec = httptest.NewRecorder()
+	updateTaskByIDHandler(mockStore, noAdmins(), nil).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 OK with force, got %d", rec.Code)
+	}
+}
+
+type MockAssignmentStore struct {
+	AssignUserFunc   func(taskID, userID int) (bool, error)
+	UnassignUserFunc func(taskID, userID int) (bool, error)
+}
+
+func (m *MockAssignmentStore) AssignUser(ctx context.Context, taskID, userID int) (bool, error) {
+	return m.AssignUserFunc(taskID, userID)
+}
+func (m *MockAssignmentStore) UnassignUser(ctx context.Context, taskID, userID int) (bool, error) {
+	return m.UnassignUserFunc(taskID, userID)
+}
+
+func TestAssignTaskHandler_PublishesEvent(t *testing.T) {
+	mockStore := &MockAssignmentStore{
+		AssignUserFunc: func(taskID, userID int) (bool, error) {
+			return true, nil
+		},
+	}
+	events := NewEventBus()
+	var got []Event
+	events.Subscribe(func(_ context.Context, e Event) { got = append(got, e) })
+
+	body := bytes.NewBufferString(`{"userId":7}`)
+	req := httptest.NewRequest(http.MethodPost, "/tasks/1/assignees", body)
+	req.SetPathValue("ID", "1")
+	rec := httptest.NewRecorder()
+
+	assignTaskHandler(mockStore, &MockStore{GetTaskByIDFunc: openTask}, noAdmins(), events).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 No Content, got %d", rec.Code)
+	}
+	if len(got) != 1 || got[0].Type != EventTaskAssigned || got[0].TaskID != 1 || got[0].UserID != 7 {
+		t.Fatalf("unexpected events: %+v", got)
+	}
+}
+
+func TestAssignTaskHandler_RepeatsPublishOnce(t *testing.T) {
+	s := newTestStore(t)
+	task := Task{Title: "shared"}
+	if err := s.CreateTask(t.Context(), &task); err != nil {
+		t.Fatal(err)
+	}
+	events := NewEventBus()
+	var got []string
+	events.Subscribe(func(_ context.Context, e Event) { got = append(got, e.Type) })
+	id := strconv.Itoa(task.ID)
+
+	for range 2 {
+		req := httptest.NewRequest(http.MethodPost, "/tasks/"+id+"/assignees", bytes.NewBufferString(`{"userId":`+strconv.Itoa(demoUserID)+`}`))
+		req.SetPathValue("ID", id)
+		rec := httptest.NewRecorder()
+		assignTaskHandler(s, s, s, events).ServeHTTP(rec, withUser(req, demoUserID))
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("assign: status %d: %s", rec.Code, rec.Body)
+		}
+	}
+	for range 2 {
+		req := httptest.NewRequest(http.MethodDelete, "/tasks/"+id+"/assignees/"+strconv.Itoa(demoUserID), nil)
+		req.SetPathValue("ID", id)
+		req.SetPathValue("UserID", strconv.Itoa(demoUserID))
+		rec := httptest.NewRecorder()
+		unassignTaskHandler(s, s, s, events).ServeHTTP(rec, withUser(req, demoUserID))
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("unassign: status %d: %s", rec.Code, rec.Body)
+		}
+	}
+
+	if want := []string{EventTaskAssigned, EventTaskUnassigned}; !slices.Equal(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+}
+
+func TestAssignTaskHandler_Authorizes(t *testing.T) {
+	s := newTestStore(t)
+	alice, bob := User{Name: "Alice"}, User{Name: "Bob"}
+	for _, u := range []*User{&alice, &bob} {
+		if err := s.CreateUser(t.Context(), u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	task := Task{Title: "theirs", Assignees: []int{bob.ID}}
+	if err := s.CreateTask(t.Context(), &task); err != nil {
+		t.Fatal(err)
+	}
+	id := strconv.Itoa(task.ID)
+
+	assign := func(userID, assignee int) int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/tasks/"+id+"/assignees", strings.NewReader(`{"userId":`+strconv.Itoa(assignee)+`}`))
+		req.SetPathValue("ID", id)
+		rec := httptest.NewRecorder()
+		assignTaskHandler(s, s, s, nil).ServeHTTP(rec, withUser(req, userID))
+		return rec.Code
+	}
+	unassign := func(userID, assignee int) int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodDelete, "/tasks/"+id+"/assignees/"+strconv.Itoa(assignee), nil)
+		req.SetPathValue("ID", id)
+		req.SetPathValue("UserID", strconv.Itoa(assignee))
+		rec := httptest.NewRecorder()
+		unassignTaskHandler(s, s, s, nil).ServeHTTP(rec, withUser(req, userID))
+		return rec.Code
+	}
+
+	if got := assign(alice.ID, alice.ID); got != http.StatusForbidden {
+		t.Errorf("assign self to someone else's task: status %d, want 403", got)
+	}
+	if got := unassign(alice.ID, bob.ID); got != http.StatusForbidden {
+		t.Errorf("unassign the assignee: status %d, want 403", got)
+	}
+	if got := assign(bob.ID, alice.ID); got != http.StatusNoContent {
+		t.Errorf("assignee adds a colleague: status %d", got)
+	}
+	if got := unassign(alice.ID, alice.ID); got != http.StatusNoContent {
+		t.Errorf("self-unassign: status %d", got)
+	}
+	got, err := s.GetTaskByID(t.Context(), task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got.Assignees, []int{bob.ID}) {
+		t.Errorf("assignees = %v, want [%d]", got.Assignees, bob.ID)
+	}
+}
+
+func TestAssignTaskHandler_DeletedUser(t *testing.T) {
+	mockStore := &MockAssignmentStore{
+		AssignUserFunc: func(taskID, userID int) (bool, error) {
+			return false, ErrInvalid
+		},
+	}
+
+	body := bytes.NewBufferString(`{"userId":7}`)
+	req := httptest.NewRequest(http.MethodPost, "/tasks/1/assignees", body)
+	req.SetPathValue("ID", "1")
+	rec := httptest.NewRecorder()
+
+	assignTaskHandler(mockStore, &MockStore{GetTaskByIDFunc: openTask}, noAdmins(), nil).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 Bad Request, got %d", rec.Code)
+	}
+}
+
+func TestGetTaskHandler_AssigneeFilter(t *testing.T) {
+	mockStore := &MockStore{
+		GetAllTasksFunc: func(filter TaskFilter) ([]Task, error) {
+			if filter.Assignee == nil || *filter.Assignee != 7 {
+				t.Fatalf("expected assignee=7, got %+v", filter.Assignee)
+			}
+			return []Task{}, nil
+		},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/tasks?assignee=7", nil)
+	rec := httptest.NewRecorder()
+
+	getTaskHandler(mockStore).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 OK, got %d", rec.Code)
+	}
+}
+
+type MockCommentStore struct {
+	CreateCommentFunc func(c *Comment) error
+	GetCommentFunc    func(id int) (Comment, error)
+	UpdateCommentFunc func(c *Comment) error
+	DeleteCommentFunc func(id int) error
+	ListCommentsFunc  func(taskID, afterID, limit int) ([]Comment, error)
+}
+
+func (m *MockCommentStore) CreateComment(ctx context.Context, c *Comment) error {
+	return m.CreateCommentFunc(c)
+}
+func (m *MockCommentStore) GetComment(ctx context.Context, id int) (Comment, error) {
+	return m.GetCommentFunc(id)
+}
+func (m *MockCommentStore) UpdateComment(ctx context.Context, c *Comment) error {
+	return m.UpdateCommentFunc(c)
+}
+func (m *MockCommentStore) DeleteComment(ctx context.Context, id int) error {
+	return m.DeleteCommentFunc(id)
+}
+func (m *MockCommentStore) ListComments(ctx context.Context, taskID, afterID, limit int) ([]Comment, error) {
+	return m.ListCommentsFunc(taskID, afterID, limit)
+}
+
+type MockUserStore struct {
+	GetUserFunc func(id int) (User, error)
+}
+
+func (m *MockUserStore) CreateUser(ctx context.Context, user *User) error      { return nil }
+func (m *MockUserStore) GetUser(ctx context.Context, id int) (User, error)     { return m.GetUserFunc(id) }
+func (m *MockUserStore) ListUsers(ctx context.Context) ([]User, error)         { return nil, nil }
+func (m *MockUserStore) DeleteUser(ctx context.Context, id int) ([]int, error) { return nil, nil }
+
+func withUser(r *http.Request, userID int) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userKey, userID))
+}
+
+func TestUpdateCommentHandler_OnlyAuthor(t *testing.T) {
+	mockStore := &MockCommentStore{
+		GetCommentFunc: func(id int) (Comment, error) {
+			return Comment{ID: id, TaskID: 1, AuthorID: 5, Body: "hi"}, nil
+		},
+		UpdateCommentFunc: func(c *Comment) error {
+			t.Fatalf("non-author edit reached the store")
+			return nil
+		},
+	}
+
+	body := bytes.NewBufferString(`{"body":"changed"}`)
+	req := withUser(httptest.NewRequest(http.MethodPatch, "/tasks/1/comments/2", body), 6)
+	req.SetPathValue("ID", "1")
+	req.SetPathValue("CommentID", "2")
+	rec := httptest.NewRecorder()
+
+	updateCommentHandler(mockStore, nil).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 Forbidden, got %d", rec.Code)
+	}
+}
+
+func TestDeleteCommentHandler_AdminCanDelete(t *testing.T) {
+	deleted := false
+	mockStore := &MockCommentStore{
+		GetCommentFunc: func(id int) (Comment, error) {
+			return Comment{ID: id, TaskID: 1, AuthorID: 5}, nil
+		},
+		DeleteCommentFunc: func(id int) error {
+			deleted = true
+			return nil
+		},
+	}
+	users := &MockUserStore{
+		GetUserFunc: func(id int) (User, error) {
+			return User{ID: id, IsAdmin: id == 9}, nil
+		},
+	}
+
+	req := withUser(httptest.NewRequest(http.MethodDelete, "/tasks/1/comments/2", nil), 8)
+	req.SetPathValue("ID", "1")
+	req.SetPathValue("CommentID", "2")
+	rec := httptest.NewRecorder()
+	deleteCommentHandler(mockStore, users).ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden || deleted {
+		t.Fatalf("expected 403 for non-admin, got %d", rec.Code)
+	}
+
+	req = withUser(httptest.NewRequest(http.MethodDelete, "/tasks/1/comments/2", nil), 9)
+	req.SetPathValue("ID", "1")
+	req.SetPathValue("CommentID", "2")
+	rec = httptest.NewRecorder()
+	deleteCommentHandler(mockStore, users).ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent || !deleted {
+		t.Fatalf("expected 204 for admin, got %d", rec.Code)
+	}
+}
+
+func TestDeleteCommentHandler_AuthorOrAdmin(t *testing.T) {
+	deleted := false
+	mockStore := &MockCommentStore{
+		GetCommentFunc: func(id int) (Comment, error) {
+			return Comment{ID: id, TaskID: 1, AuthorID: 5}, nil
+		},
+		DeleteCommentFunc: func(id int) error {
+			deleted = true
+			return nil
+		},
+	}
+	users := &MockUserStore{
+		GetUserFunc: func(id int) (User, error) {
+			return User{ID: id}, nil
+		},
+	}
+	del := func(userID int) *httptest.ResponseRecorder {
+		req := withUser(httptest.NewRequest(http.MethodDelete, "/tasks/1/comments/2", nil), userID)
+		req.SetPathValue("ID", "1")
+		req.SetPathValue("CommentID", "2")
+		rec := httptest.NewRecorder()
+		deleteCommentHandler(mockStore, users).ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := del(6)
+	var resp errResp
+	_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusForbidden || deleted || resp.Error != "only the author or an admin can delete a comment" {
+		t.Fatalf("non-author, non-admin: %d %q", rec.Code, resp.Error)
+	}
+	if rec := del(5); rec.Code != http.StatusNoContent || !deleted {
+		t.Fatalf("expected 204 for the author, got %d", rec.Code)
+	}
+}
+
+func TestListCommentsHandler_Paginates(t *testing.T) {
+	mockStore := &MockCommentStore{
+		ListCommentsFunc: func(taskID, afterID, limit int) ([]Comment, error) {
+			if afterID != 10 || limit != 3 {
+				t.Fatalf("expected after=10 limit=3, got %d %d", afterID, limit)
+			}
+			return []Comment{{ID: 11}, {ID: 12}, {ID: 13}}, nil
+		},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/tasks/1/comments?limit=2&cursor=10", nil)
+	req.SetPathValue("ID", "1")
+	rec := httptest.NewRecorder()
+
+	listCommentsHandler(mockStore).ServeHTTP(rec, req)
+
+	var page commentPage
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(page.Comments) != 2 || page.NextCursor != 12 {
+		t.Fatalf("unexpected page: %+v", page)
+	}
+}
+
+func TestGetOccurrencesHandler_PreviewsSchedule(t *testing.T) {
+	due := time.Date(2026, 1, 5, 9, 0, 0, 0, time.UTC)
+	mockStore := &MockStore{
+		GetTaskByIDFunc: func(id int) (Task, error) {
+			return Task{ID: id, Title: "Renew certs", DueAt: &due, RRule: "FREQ=WEEKLY"}, nil
+		},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/tasks/1/occurrences?from=2026-01-01T00:00:00Z&to=2026-01-20T00:00:00Z", nil)
+	req.SetPathValue("ID", "1")
+	rec := httptest.NewRecorder()
+
+	getOccurrencesHandler(mockStore).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 OK, got %d", rec.Code)
+	}
+	var got []time.Time
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(got) != 3 || !got[2].Equal(due.AddDate(0, 0, 14)) {
+		t.Fatalf("unexpected occurrences: %v", got)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Workers runs the background loops and keeps track of which are still
+// running, for readiness.
+type Workers struct {
+	wg      sync.WaitGroup
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+func NewWorkers() *Workers {
+	return &Workers{running: map[string]bool{}}
+}
+
+// Go runs fn on its own goroutine under name until it returns, which it
+// should do once ctx is cancelled. The logger in fn's context names the
+// worker.
+func (w *Workers) Go(ctx context.Context, name string, fn func(ctx context.Context)) {
+	w.mu.Lock()
+	w.running[name] = true
+	w.mu.Unlock()
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		defer func() {
+			w.mu.Lock()
+			w.running[name] = false
+			w.mu.Unlock()
+		}()
+		fn(withLogger(ctx, loggerFrom(ctx).With("worker", name)))
+	}()
+}
+
+// Wait blocks until every worker has returned.
+func (w *Workers) Wait() {
+	w.wg.Wait()
+}
+
+// Status reports, by name, whether each worker started is still running.
+func (w *Workers) Status() map[string]bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	out := make(map[string]bool, len(w.running))
+	for name, running := range w.running {
+		out[name] = running
+	}
+	return out
+}
+
+// Health answers the orchestrator's probes. Liveness only says the process
+// is serving; readiness also checks the database and the workers, and
+// fails as soon as shutdown begins so traffic is routed away before the
+// listener closes.
+type Health struct {
+	db       *DB
+	workers  *Workers
+	timeout  time.Duration
+	draining atomic.Bool
+}
+
+func NewHealth(db *DB, workers *Workers) *Health {
+	return &Health{db: db, workers: workers, timeout: 2 * time.Second}
+}
+
+// Drain marks the server as shutting down. Readiness fails from then on.
+func (h *Health) Drain() {
+	h.draining.Store(true)
+}
+
+type readiness struct {
+	Ready    bool              `json:"ready"`
+	Draining bool              `json:"draining"`
+	Checks   map[string]string `json:"checks"`
+}
+
+// check runs every readiness check, each reported as "ok" or the reason it
+// failed, so one probe shows everything that is wrong.
+func (h *Health) check(ctx context.Context) readiness {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	rd := readiness{Draining: h.draining.Load(), Checks: map[string]string{}}
+	rd.Checks["database"] = "ok"
+	if err := h.db.writer.PingContext(ctx); err != nil {
+		rd.Checks["database"] = "writer: " + err.Error()
+	} else if err := h.db.reader.PingContext(ctx); err != nil {
+		rd.Checks["database"] = "reader: " + err.Error()
+	}
+
+	var version int
+	if err := h.db.reader.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version); err != nil {
+		rd.Checks["migrations"] = err.Error()
+	} else if version != schemaVersion {
+		rd.Checks["migrations"] = fmt.Sprintf("schema version %d, want %d", version, schemaVersion)
+	} else {
+		rd.Checks["migrations"] = "ok"
+	}
+
+	for name, running := range h.workers.Status() {
+		rd.Checks["worker:"+name] = "ok"
+		if !running {
+			rd.Checks["worker:"+name] = "stopped"
+		}
+	}
+
+	rd.Ready = !rd.Draining
+	for _, result := range rd.Checks {
+		if result != "ok" {
+			rd.Ready = false
+		}
+	}
+	return rd
+}
+
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func readyzHandler(h *Health) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rd := h.check(r.Context())
+		status := http.StatusOK
+		if !rd.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(w, status, rd)
+	}
+}
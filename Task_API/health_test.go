@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func probe(t *testing.T, h *Health) (int, readiness) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	readyzHandler(h).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var rd readiness
+	if err := json.Unmarshal(rr.Body.Bytes(), &rd); err != nil {
+		t.Fatal(err)
+	}
+	return rr.Code, rd
+}
+
+func TestHealthz(t *testing.T) {
+	rr := httptest.NewRecorder()
+	healthzHandler(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status %d", rr.Code)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	s := newTestStore(t)
+	workers := NewWorkers()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	workers.Go(ctx, "webhooks", func(ctx context.Context) { <-ctx.Done() })
+	stopped := make(chan struct{})
+	workers.Go(ctx, "reminders", func(ctx context.Context) {
+		select {
+		case <-ctx.Done():
+		case <-stopped:
+		}
+	})
+	h := NewHealth(s.db, workers)
+
+	code, rd := probe(t, h)
+	if code != http.StatusOK || !rd.Ready {
+		t.Fatalf("status %d, %+v", code, rd)
+	}
+	for _, check := range []string{"database", "migrations", "worker:webhooks", "worker:reminders"} {
+		if rd.Checks[check] != "ok" {
+			t.Errorf("%s = %q", check, rd.Checks[check])
+		}
+	}
+
+	close(stopped)
+	for workers.Status()["reminders"] {
+		time.Sleep(time.Millisecond)
+	}
+	code, rd = probe(t, h)
+	if code != http.StatusServiceUnavailable || rd.Checks["worker:reminders"] != "stopped" || rd.Checks["worker:webhooks"] != "ok" {
+		t.Errorf("after a worker stopped: status %d, %+v", code, rd)
+	}
+}
+
+func TestReadyz_StaleSchemaAndDraining(t *testing.T) {
+	s := newTestStore(t)
+	h := NewHealth(s.db, NewWorkers())
+
+	if _, err := s.db.Exec(`PRAGMA user_version = 0`); err != nil {
+		t.Fatal(err)
+	}
+	code, rd := probe(t, h)
+	if code != http.StatusServiceUnavailable || rd.Checks["migrations"] == "ok" || rd.Checks["database"] != "ok" {
+		t.Errorf("stale schema: status %d, %+v", code, rd)
+	}
+	if err := migrate(s.db.writer); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := probe(t, h); code != http.StatusOK {
+		t.Fatalf("after migrating: status %d", code)
+	}
+
+	h.Drain()
+	code, rd = probe(t, h)
+	if code != http.StatusServiceUnavailable || !rd.Draining {
+		t.Errorf("draining: status %d, %+v", code, rd)
+	}
+}
+
+func TestMigrate_FailedColumnLeavesSchemaVersion(t *testing.T) {
+	cfg := DefaultDBConfig()
+	cfg.Path = filepath.Join(t.TempDir(), "test.db")
+	db, err := OpenDB(cfg)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	// The column exists under another case, which table_info does not
+	// match but ALTER TABLE rejects as a duplicate.
+	if _, err := db.writer.Exec(`CREATE TABLE idempotency_keys (
+		user_id INTEGER NOT NULL,
+		key TEXT NOT NULL,
+		fingerprint TEXT NOT NULL,
+		done INTEGER NOT NULL DEFAULT 0,
+		response_status INTEGER NOT NULL DEFAULT 0,
+		content_type TEXT NOT NULL DEFAULT '',
+		response_body BLOB,
+		created_unix INTEGER NOT NULL,
+		locked_until_unix INTEGER NOT NULL DEFAULT 0,
+		Response_Headers TEXT NOT NULL DEFAULT '{}',
+		PRIMARY KEY (user_id, key)
+	)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrate(db.writer); err == nil {
+		t.Fatal("migrate succeeded although a column could not be added")
+	}
+	var version int
+	if err := db.writer.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != 0 {
+		t.Errorf("user_version = %d after a failed migration, want 0", version)
+	}
+}
+
+func TestReadyz_DefaultConfig(t *testing.T) {
+	s := newTestStore(t)
+	cfg := DefaultConfig()
+	workers := NewWorkers()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer workers.Wait()
+	defer cancel()
+	startWorkers(ctx, workers, cfg, s, NewEventBus(), NewBackupper(s.db, cfg.Backup), noopTracer())
+
+	// Give a worker that has nothing to do the chance to return.
+	time.Sleep(10 * time.Millisecond)
+	if code, rd := probe(t, NewHealth(s.db, workers)); code != http.StatusOK {
+		t.Errorf("status %d, %+v", code, rd)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+	idempotencyTTL    = 24 * time.Hour
+	// idempotencyLease is how long a request holds its key before a retry
+	// may take over, in case the process died mid-request. It is well past
+	// the server's write timeout.
+	idempotencyLease   = 2 * time.Minute
+	maxIdempotencyKey  = 255
+	maxIdempotencyBody = 1 << 20
+)
+
+// replayedHeaders are the response headers stored with an idempotent
+// response and sent again on replay.
+var replayedHeaders = []string{"Content-Type", "Location"}
+
+// IdempotencyRecord is a stored request: its fingerprint and, once the
+// handler has finished, the response to replay.
+type IdempotencyRecord struct {
+	Fingerprint string
+	Done        bool
+	Status      int
+	Header      http.Header
+	Body        []byte
+}
+
+// Idempotent makes POST and PATCH handlers safe to retry. A request with an
+// Idempotency-Key header runs once per user and key; an identical retry
+// within idempotencyTTL gets the stored response back with
+// Idempotent-Replayed: true, and reusing the key with a different request
+// is a 422. Server errors and panics are not stored and free the key, so
+// they can be retried. It must run after AuthMiddleware, because keys are
+// scoped to the user.
+//
+// Bodies are buffered to fingerprint them, so this is for JSON endpoints,
+// not uploads.
+func Idempotent(store IdempotencyStore) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(idempotencyHeader)
+			if key == "" || (r.Method != http.MethodPost && r.Method != http.MethodPatch) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKey {
+				writeErr(w, http.StatusBadRequest, "idempotency key too long")
+				return
+			}
+
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotencyBody+1))
+			if err != nil {
+				writeErr(w, http.StatusBadRequest, "could not read body")
+				return
+			}
+			if len(body) > maxIdempotencyBody {
+				writeErr(w, http.StatusRequestEntityTooLarge, "body too large for an idempotent request")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			userID := getUserID(r)
+			fp := requestFingerprint(r, body)
+			rec, started, err := store.BeginIdempotent(r.Context(), userID, key, fp, time.Now())
+			if err != nil {
+				writeErr(w, http.StatusInternalServerError, "internal error")
+				return
+			}
+			if !started {
+				switch {
+				case rec.Fingerprint != fp:
+					writeErr(w, http.StatusUnprocessableEntity, "idempotency key was used for a different request")
+				case !rec.Done:
+					writeErr(w, http.StatusConflict, "a request with this idempotency key is still in progress")
+				default:
+					for _, name := range replayedHeaders {
+						if v := rec.Header.Get(name); v != "" {
+							w.Header().Set(name, v)
+						}
+					}
+					w.Header().Set("Idempotent-Replayed", "true")
+					w.WriteHeader(rec.Status)
+					_, _ = w.Write(rec.Body)
+				}
+				return
+			}
+
+			// The outcome is recorded even if the client has gone away.
+			ctx := context.WithoutCancel(r.Context())
+			cw := &captureWriter{ResponseWriter: w, status: http.StatusOK}
+			finished := false
+			defer func() {
+				// Free the key if the handler panicked or failed on our
+				// side, so the client can retry.
+				if !finished || cw.status >= 500 {
+					if err := store.ReleaseIdempotent(ctx, userID, key); err != nil {
+						loggerFrom(ctx).Error("release idempotency key failed", "error", err)
+					}
+				}
+			}()
+			next.ServeHTTP(cw, r)
+			finished = true
+
+			if cw.status < 500 {
+				header := http.Header{}
+				for _, name := range replayedHeaders {
+					if v := cw.Header().Get(name); v != "" {
+						header.Set(name, v)
+					}
+				}
+				// Without the stored response, a retry runs the request
+				// again once the lease is up.
+				if err := store.CompleteIdempotent(ctx, userID, key, cw.status, header, cw.buf.Bytes()); err != nil {
+					loggerFrom(ctx).Error("store idempotent response failed", "error", err)
+				}
+			}
+		})
+	}
+}
+
+// requestFingerprint covers the method, the path with its query and the
+// body, so the same key on a different endpoint also counts as misuse.
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// captureWriter passes the response through while keeping a copy.
+type captureWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	buf         bytes.Buffer
+}
+
+func (cw *captureWriter) WriteHeader(code int) {
+	if !cw.wroteHeader {
+		cw.status = code
+		cw.wroteHeader = true
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *captureWriter) Write(p []byte) (int, error) {
+	cw.wroteHeader = true
+	cw.buf.Write(p)
+	return cw.ResponseWriter.Write(p)
+}
+
+func (cw *captureWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
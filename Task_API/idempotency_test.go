@@ -0,0 +1,169 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func idempotentRequest(t *testing.T, h http.Handler, userID int, key, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/tasks", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(idempotencyHeader, key)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, withUser(req, userID))
+	return rr
+}
+
+func TestIdempotentReplaysRetry(t *testing.T) {
+	store := newTestStore(t)
+	h := Idempotent(store)(postTaskHandler(store, store, nil))
+
+	first := idempotentRequest(t, h, 1, "abc", `{"title":"once"}`)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("first: status %d: %s", first.Code, first.Body)
+	}
+	retry := idempotentRequest(t, h, 1, "abc", `{"title":"once"}`)
+	if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() {
+		t.Fatalf("retry: status %d body %q, want %q", retry.Code, retry.Body, first.Body)
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("retry not marked as replayed")
+	}
+
+	tasks, err := store.GetAllTasks(t.Context(), TaskFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 {
+		t.Fatalf("got %d tasks, want 1", len(tasks))
+	}
+}
+
+func TestIdempotentKeyReuseWithDifferentBody(t *testing.T) {
+	store := newTestStore(t)
+	h := Idempotent(store)(postTaskHandler(store, store, nil))
+
+	idempotentRequest(t, h, 1, "abc", `{"title":"a"}`)
+	rr := idempotentRequest(t, h, 1, "abc", `{"title":"b"}`)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status %d, want 422", rr.Code)
+	}
+}
+
+func TestIdempotentKeysArePerUser(t *testing.T) {
+	store := newTestStore(t)
+	h := Idempotent(store)(postTaskHandler(store, store, nil))
+
+	idempotentRequest(t, h, 1, "abc", `{"title":"a"}`)
+	rr := idempotentRequest(t, h, 2, "abc", `{"title":"a"}`)
+	if rr.Code != http.StatusCreated || rr.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("second user: status %d replayed=%q", rr.Code, rr.Header().Get("Idempotent-Replayed"))
+	}
+}
+
+func TestIdempotentServerErrorIsNotStored(t *testing.T) {
+	store := newTestStore(t)
+	calls := 0
+	h := Idempotent(store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			writeErr(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	idempotentRequest(t, h, 1, "abc", `{}`)
+	rr := idempotentRequest(t, h, 1, "abc", `{}`)
+	if rr.Code != http.StatusCreated || calls != 2 {
+		t.Fatalf("status %d after %d calls, want 201 after 2", rr.Code, calls)
+	}
+}
+
+func TestBeginIdempotentExpiresOldKeys(t *testing.T) {
+	store := newTestStore(t)
+	now := time.Now()
+
+	if _, started, err := store.BeginIdempotent(t.Context(), 1, "k", "fp1", now); err != nil || !started {
+		t.Fatalf("begin: started=%v err=%v", started, err)
+	}
+	if err := store.CompleteIdempotent(t.Context(), 1, "k", http.StatusCreated, http.Header{"Content-Type": {"application/json"}}, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	rec, started, err := store.BeginIdempotent(t.Context(), 1, "k", "fp2", now.Add(time.Hour))
+	if err != nil || started || rec.Fingerprint != "fp1" || !rec.Done {
+		t.Fatalf("within TTL: rec=%+v started=%v err=%v", rec, started, err)
+	}
+	if _, started, err := store.BeginIdempotent(t.Context(), 1, "k", "fp2", now.Add(idempotencyTTL+time.Minute)); err != nil || !started {
+		t.Fatalf("after TTL: started=%v err=%v", started, err)
+	}
+}
+
+func TestBeginIdempotentTakesOverExpiredLease(t *testing.T) {
+	store := newTestStore(t)
+	now := time.Now()
+
+	if _, started, err := store.BeginIdempotent(t.Context(), 1, "k", "fp", now); err != nil || !started {
+		t.Fatalf("begin: started=%v err=%v", started, err)
+	}
+	// The first request is still running.
+	if rec, started, err := store.BeginIdempotent(t.Context(), 1, "k", "fp", now.Add(time.Second)); err != nil || started || rec.Done {
+		t.Fatalf("within lease: rec=%+v started=%v err=%v", rec, started, err)
+	}
+	// A different request never takes the key over.
+	if _, started, err := store.BeginIdempotent(t.Context(), 1, "k", "other", now.Add(idempotencyLease+time.Second)); err != nil || started {
+		t.Fatalf("different request: started=%v err=%v", started, err)
+	}
+	// Its process died, so a retry runs it.
+	if _, started, err := store.BeginIdempotent(t.Context(), 1, "k", "fp", now.Add(idempotencyLease+time.Second)); err != nil || !started {
+		t.Fatalf("after lease: started=%v err=%v", started, err)
+	}
+}
+
+func TestIdempotentPanicFreesKey(t *testing.T) {
+	store := newTestStore(t)
+	calls := 0
+	h := Idempotent(store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			panic("boom")
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	func() {
+		defer func() { _ = recover() }()
+		idempotentRequest(t, h, 1, "abc", `{}`)
+	}()
+	rr := idempotentRequest(t, h, 1, "abc", `{}`)
+	if rr.Code != http.StatusCreated || calls != 2 {
+		t.Fatalf("status %d after %d calls, want 201 after 2", rr.Code, calls)
+	}
+}
+
+func TestIdempotentReplaysHeaders(t *testing.T) {
+	store := newTestStore(t)
+	h := Idempotent(store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "/tasks/7")
+		w.Header().Set("X-Other", "not replayed")
+		writeJSON(w, http.StatusCreated, map[string]int{"id": 7})
+	}))
+
+	idempotentRequest(t, h, 1, "abc", `{}`)
+	rr := idempotentRequest(t, h, 1, "abc", `{}`)
+	if rr.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatal("not replayed")
+	}
+	if rr.Header().Get("Location") != "/tasks/7" || !strings.HasPrefix(rr.Header().Get("Content-Type"), "application/json") {
+		t.Errorf("replayed headers = %v", rr.Header())
+	}
+	if rr.Header().Get("X-Other") != "" {
+		t.Errorf("unlisted header replayed: %v", rr.Header())
+	}
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// The live endpoint speaks JSON text messages. Clients send requests with
+// an optional "id" that is echoed in the matching ack:
+//
+//	{"type":"subscribe","id":"1","projects":[4]}
+//	{"type":"unsubscribe","projects":[4]}
+//	{"type":"presence","taskId":42}            (0 stops viewing)
+//	{"type":"create","task":{...}}
+//	{"type":"update","task":{"id":42,...},"force":true}
+//	{"type":"delete","taskId":42}
+//
+// The server answers each request with {"type":"ack","id":...,"ok":true}
+// or {"type":"ack","id":...,"error":"..."}, and pushes "event" and
+// "presence" messages for the projects the client subscribed to. There is
+// no project entity: a project is a root task and its subtree.
+
+type Presence struct {
+	UserID  int    `json:"userId"`
+	Name    string `json:"name"`
+	TaskID  int    `json:"taskId"`
+	Project int    `json:"project"`
+}
+
+type liveRequest struct {
+	Type     string `json:"type"`
+	ID       string `json:"id,omitempty"`
+	Projects []int  `json:"projects,omitempty"`
+	TaskID   int    `json:"taskId,omitempty"`
+	// Task is decoded as REST decodes a task body, so an update leaves
+	// out the same fields.
+	Task  json.RawMessage `json:"task,omitempty"`
+	Force bool            `json:"force,omitempty"`
+}
+
+type liveMessage struct {
+	Type     string     `json:"type"`
+	ID       string     `json:"id,omitempty"`
+	OK       bool       `json:"ok,omitempty"`
+	Error    string     `json:"error,omitempty"`
+	Task     *Task      `json:"task,omitempty"`
+	Event    *Event     `json:"event,omitempty"`
+	Presence []Presence `json:"presence,omitempty"`
+}
+
+// LiveHub tracks open WebSocket clients, their subscriptions and presence.
+// Messages to a client go through a bounded queue; a client that lets it
+// fill up is disconnected rather than allowed to hold up everyone else.
+type LiveHub struct {
+	tasks    TaskStore
+	projects ProjectResolver
+	users    UserStore
+	events   *EventBus
+
+	pingInterval time.Duration
+	pongWait     time.Duration
+	sendBuffer   int
+
+	mu      sync.Mutex
+	clients map[*liveClient]struct{}
+	closed  bool
+}
+
+// NewLiveHub subscribes the hub to events, so changes made over REST reach
+// live clients too. Mutations from live clients are published on the same
+// bus.
+func NewLiveHub(tasks TaskStore, projects ProjectResolver, users UserStore, events *EventBus) *LiveHub {
+	h := &LiveHub{
+		tasks:        tasks,
+		projects:     projects,
+		users:        users,
+		events:       events,
+		pingInterval: 30 * time.Second,
+		pongWait:     60 * time.Second,
+		sendBuffer:   64,
+		clients:      make(map[*liveClient]struct{}),
+	}
+	events.Subscribe(h.broadcastEvent)
+	return h
+}
+
+type liveClient struct {
+	hub    *LiveHub
+	ws     *wsConn
+	userID int
+	name   string
+	send   chan []byte
+	done   chan struct{}
+	once   sync.Once
+
+	// origin is the upgrade request's context, which stands for every
+	// message on the connection when events are published.
+	origin context.Context
+
+	// Guarded by hub.mu.
+	projects map[int]bool
+	viewing  Presence
+}
+
+// liveHandler upgrades an authenticated request and serves the client
+// until it disconnects. Handshakes from pages on an origin CORS would
+// refuse are refused too.
+func liveHandler(hub *LiveHub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" && !originAllowed(origin) {
+			writeErr(w, http.StatusForbidden, "origin not allowed")
+			return
+		}
+		userID := getUserID(r)
+		name := fmt.Sprintf("user %d", userID)
+		if u, err := hub.users.GetUser(r.Context(), userID); err == nil {
+			name = u.Name
+		}
+
+		ws, err := upgradeWebSocket(w, r)
+		if err != nil {
+			return
+		}
+		c := &liveClient{
+			hub:      hub,
+			ws:       ws,
+			userID:   userID,
+			name:     name,
+			origin:   context.WithoutCancel(r.Context()),
+			send:     make(chan []byte, hub.sendBuffer),
+			done:     make(chan struct{}),
+			projects: make(map[int]bool),
+		}
+		if !hub.register(c) {
+			ws.Close(wsCloseGoingAway, "server shutting down")
+			return
+		}
+		// As with event streams, the connection outlives the request's
+		// span.
+		endRequestSpan(r.Context())
+		go c.writePump()
+		c.readPump(r.Context())
+	}
+}
+
+func (h *LiveHub) register(c *liveClient) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return false
+	}
+	h.clients[c] = struct{}{}
+	return true
+}
+
+func (h *LiveHub) unregister(c *liveClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.clients[c]; !ok {
+		return
+	}
+	delete(h.clients, c)
+	if c.viewing.TaskID != 0 {
+		left := c.viewing
+		left.TaskID = 0
+		h.broadcastLocked(left.Project, liveMessage{Type: "presence", Presence: []Presence{left}})
+	}
+}
+
+// Close disconnects every client. It is registered with
+// srv.RegisterOnShutdown because hijacked connections are not tracked by
+// srv.Shutdown.
+func (h *LiveHub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for c := range h.clients {
+		delete(h.clients, c)
+		go c.shutdown(wsCloseGoingAway, "server shutting down")
+	}
+}
+
+func (h *LiveHub) broadcastEvent(ctx context.Context, e Event) {
+	if !slices.Contains(streamEvents, e.Type) {
+		return
+	}
+	h.mu.Lock()
+	idle := len(h.clients) == 0
+	h.mu.Unlock()
+	if idle {
+		return
+	}
+
+	project, err := h.projectOf(ctx, e)
+	if err != nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.broadcastLocked(project, liveMessage{Type: "event", Event: &e})
+}
+
+// projectOf finds the root of the changed task. A deleted task can no
+// longer be looked up, so its snapshot's parent is used instead.
+func (h *LiveHub) projectOf(ctx context.Context, e Event) (int, error) {
+	if e.Task != nil && e.Task.ParentID == nil {
+		return e.TaskID, nil
+	}
+	if e.Type == EventTaskDeleted {
+		if e.Task == nil {
+			return 0, ErrNotFound
+		}
+		return h.projects.RootTaskID(ctx, *e.Task.ParentID)
+	}
+	return h.projects.RootTaskID(ctx, e.TaskID)
+}
+
+func (h *LiveHub) broadcastLocked(project int, msg liveMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	for c := range h.clients {
+		if c.projects[project] {
+			c.enqueue(data)
+		}
+	}
+}
+
+// enqueue never blocks. If the client's queue is full it is disconnected.
+func (c *liveClient) enqueue(data []byte) {
+	select {
+	case c.send <- data:
+	case <-c.done:
+	default:
+		go c.shutdown(wsCloseTryAgain, "client too slow")
+	}
+}
+
+func (c *liveClient) reply(msg liveMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	c.enqueue(data)
+}
+
+func (c *liveClient) shutdown(code int, reason string) {
+	c.once.Do(func() {
+		close(c.done)
+		c.ws.Close(code, reason)
+	})
+}
+
+func (c *liveClient) writePump() {
+	ping := time.NewTicker(c.hub.pingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case data := <-c.send:
+			if err := c.ws.WriteMessage(wsOpText, data); err != nil {
+				c.shutdown(wsCloseGoingAway, "")
+				return
+			}
+		case <-ping.C:
+			if err := c.ws.WriteMessage(wsOpPing, nil); err != nil {
+				c.shutdown(wsCloseGoingAway, "")
+				return
+			}
+		}
+	}
+}
+
+// readPump handles requests until the client goes away or stops answering
+// pings within pongWait.
+func (c *liveClient) readPump(ctx context.Context) {
+	defer func() {
+		c.hub.unregister(c)
+		c.shutdown(wsCloseNormal, "")
+	}()
+
+	extend := func() { _ = c.ws.conn.SetReadDeadline(time.Now().Add(c.hub.pongWait)) }
+	c.ws.onPong = extend
+	extend()
+
+	for {
+		op, data, err := c.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		extend()
+
+		var req liveRequest
+		if op != wsOpText || json.Unmarshal(data, &req) != nil {
+			c.reply(liveMessage{Type: "ack", Error: "invalid message"})
+			continue
+		}
+		c.reply(c.handle(ctx, req))
+	}
+}
+
+// auth is looked up per message, so a user who stops being an admin loses
+// the rights on connections that are already open.
+func (c *liveClient) auth(ctx context.Context) taskAuth {
+	return newTaskAuth(ctx, c.hub.users, c.userID)
+}
+
+func (c *liveClient) handle(ctx context.Context, req liveRequest) liveMessage {
+	ack := liveMessage{Type: "ack", ID: req.ID, OK: true}
+	fail := func(err error) liveMessage {
+		return liveMessage{Type: "ack", ID: req.ID, Error: publicErrorMessage(ctx, err)}
+	}
+	h := c.hub
+
+	switch req.Type {
+	case "subscribe":
+		for _, p := range req.Projects {
+			root, err := h.projects.RootTaskID(ctx, p)
+			if err != nil {
+				return fail(err)
+			}
+			if root != p {
+				return fail(fmt.Errorf("%w: task %d is not a project root", ErrInvalid, p))
+			}
+		}
+		h.mu.Lock()
+		for _, p := range req.Projects {
+			c.projects[p] = true
+		}
+		for other := range h.clients {
+			if other.viewing.TaskID != 0 && slices.Contains(req.Projects, other.viewing.Project) {
+				ack.Presence = append(ack.Presence, other.viewing)
+			}
+		}
+		h.mu.Unlock()
+		return ack
+
+	case "unsubscribe":
+		h.mu.Lock()
+		for _, p := range req.Projects {
+			delete(c.projects, p)
+		}
+		h.mu.Unlock()
+		return ack
+
+	case "presence":
+		next := Presence{UserID: c.userID, Name: c.name}
+		if req.TaskID != 0 {
+			project, err := h.projects.RootTaskID(ctx, req.TaskID)
+			if err != nil {
+				return fail(err)
+			}
+			next.TaskID, next.Project = req.TaskID, project
+		}
+		h.mu.Lock()
+		prev := c.viewing
+		c.viewing = next
+		if prev.TaskID != 0 && prev.Project != next.Project {
+			left := prev
+			left.TaskID = 0
+			h.broadcastLocked(prev.Project, liveMessage{Type: "presence", Presence: []Presence{left}})
+		}
+		if next.TaskID != 0 {
+			h.broadcastLocked(next.Project, liveMessage{Type: "presence", Presence: []Presence{next}})
+		}
+		h.mu.Unlock()
+		return ack
+
+	case "create":
+		var task Task
+		if req.Task == nil || json.Unmarshal(req.Task, &task) != nil {
+			return fail(fmt.Errorf("%w: task is required", ErrInvalid))
+		}
+		task.ID = 0
+		if err := c.auth(ctx).move(ctx, h.tasks, nil, task.ParentID); err != nil {
+			return fail(err)
+		}
+		if err := h.tasks.CreateTask(ctx, &task); err != nil {
+			return fail(err)
+		}
+		h.events.publishTaskCreated(c.origin, &task, c.userID)
+		ack.Task = &task
+		return ack
+
+	case "update":
+		if req.Task == nil {
+			return fail(fmt.Errorf("%w: task with id is required", ErrInvalid))
+		}
+		task, keep, err := decodeTaskUpdate(req.Task)
+		if err != nil || task.ID <= 0 {
+			return fail(fmt.Errorf("%w: task with id is required", ErrInvalid))
+		}
+		auth := c.auth(ctx)
+		current, err := auth.task(ctx, h.tasks, task.ID)
+		if err != nil {
+			return fail(err)
+		}
+		parent := task.ParentID
+		if slices.Contains(keep, "parentId") {
+			parent = current.ParentID
+		}
+		if err := auth.move(ctx, h.tasks, current.ParentID, parent); err != nil {
+			return fail(err)
+		}
+		var res UpdateResult
+		if err := h.tasks.UpdateTask(ctx, &task, UpdateOptions{Force: req.Force, Keep: keep, Result: &res}); err != nil {
+			return fail(err)
+		}
+		h.events.publishTaskUpdateResult(c.origin, h.tasks, &task, res, c.userID)
+		ack.Task = &task
+		return ack
+
+	case "delete":
+		auth := c.auth(ctx)
+		before, err := auth.task(ctx, h.tasks, req.TaskID)
+		if err != nil {
+			return fail(err)
+		}
+		subtasks, err := auth.subtree(ctx, h.tasks, req.TaskID)
+		if err != nil {
+			return fail(err)
+		}
+		if err := h.tasks.DeleteTask(ctx, req.TaskID); err != nil {
+			return fail(err)
+		}
+		h.events.publishSubtreeDeleted(c.origin, before, subtasks, c.userID)
+		return ack
+	}
+	return fail(fmt.Errorf("%w: unknown message type %q", ErrInvalid, req.Type))
+}
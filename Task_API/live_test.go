@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func withJWTSecret(t *testing.T) {
+	t.Helper()
+	old := live.Load()
+	live.Store(&LiveConfig{Auth: AuthConfig{JWTSecret: "test-secret", TokenTTL: time.Hour}})
+	t.Cleanup(func() { live.Store(old) })
+}
+
+func newLiveServer(t *testing.T) (*SQLiteStore, *LiveHub, *httptest.Server) {
+	t.Helper()
+	withJWTSecret(t)
+	s := newTestStore(t)
+	hub := NewLiveHub(s, s, s, NewEventBus())
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /ws", AuthMiddleware(liveHandler(hub)))
+	srv := httptest.NewServer(Chain(mux, Recover, Logging))
+	t.Cleanup(func() {
+		hub.Close()
+		srv.Close()
+	})
+	return s, hub, srv
+}
+
+// dialLive performs the client side of the handshake by hand, passing the
+// token as a subprotocol as browsers do.
+func dialLive(t *testing.T, srv *httptest.Server, token string) (*wsConn, *http.Response) {
+	t.Helper()
+	return dialLiveWith(t, srv, "/ws", "Sec-WebSocket-Protocol: "+wsTokenProtocol+", "+token+"\r\n")
+}
+
+// dialLiveWith sends extra, complete header lines with the handshake.
+func dialLiveWith(t *testing.T, srv *httptest.Server, path, extra string) (*wsConn, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	req := "GET " + path + " HTTP/1.1\r\n" +
+		"Host: " + srv.Listener.Addr().String() + "\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n" + extra +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: " + key + "\r\n\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, resp
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != websocketAccept(key) {
+		t.Fatalf("bad accept header %q", resp.Header.Get("Sec-WebSocket-Accept"))
+	}
+	return &wsConn{conn: conn, br: br, isClient: true}, resp
+}
+
+func liveSend(t *testing.T, c *wsConn, req liveRequest) {
+	t.Helper()
+	data, _ := json.Marshal(req)
+	if err := c.WriteMessage(wsOpText, data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func liveRecv(t *testing.T, c *wsConn) liveMessage {
+	t.Helper()
+	_ = c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := c.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	var msg liveMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestLive_RejectsUpgradeWithoutToken(t *testing.T) {
+	_, _, srv := newLiveServer(t)
+	if _, resp := dialLive(t, srv, "not-a-jwt"); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", resp.StatusCode)
+	}
+}
+
+func TestLive_HandshakeTokenAndOrigin(t *testing.T) {
+	_, _, srv := newLiveServer(t)
+	live.Store(&LiveConfig{Auth: currentLive().Auth, CORSOrigins: []string{"https://app.example.com"}})
+	token, _ := GenerateJWT(demoUserID)
+
+	if _, resp := dialLive(t, srv, token); resp.Header.Get("Sec-WebSocket-Protocol") != wsTokenProtocol {
+		t.Errorf("selected subprotocol %q, want %q", resp.Header.Get("Sec-WebSocket-Protocol"), wsTokenProtocol)
+	}
+	if _, resp := dialLiveWith(t, srv, "/ws?access_token="+token, ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("token in the URL: expected 401, got %d", resp.StatusCode)
+	}
+	protocol := "Sec-WebSocket-Protocol: " + wsTokenProtocol + ", " + token + "\r\n"
+	if c, _ := dialLiveWith(t, srv, "/ws", protocol+"Origin: https://app.example.com\r\n"); c == nil {
+		t.Error("allowed origin refused")
+	}
+	if _, resp := dialLiveWith(t, srv, "/ws", protocol+"Origin: https://evil.example.com\r\n"); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("foreign origin: expected 403, got %d", resp.StatusCode)
+	}
+}
+
+func TestLive_UpdateKeepsOmittedFields(t *testing.T) {
+	s, _, srv := newLiveServer(t)
+	parent := Task{Title: "parent"}
+	if err := s.CreateTask(t.Context(), &parent); err != nil {
+		t.Fatal(err)
+	}
+	due := time.Date(2026, 6, 1, 9, 0, 0, 0, time.UTC)
+	child := Task{Title: "child", ParentID: &parent.ID, DueAt: &due}
+	if err := s.CreateTask(t.Context(), &child); err != nil {
+		t.Fatal(err)
+	}
+	token, _ := GenerateJWT(demoUserID)
+	c, _ := dialLive(t, srv, token)
+
+	liveSend(t, c, liveRequest{Type: "update", ID: "u1", Task: json.RawMessage(fmt.Sprintf(`{"id":%d,"title":"renamed"}`, child.ID))})
+	if ack := liveRecv(t, c); !ack.OK {
+		t.Fatalf("update rejected: %+v", ack)
+	}
+	got, err := s.GetTaskByID(t.Context(), child.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "renamed" || got.ParentID == nil || *got.ParentID != parent.ID || got.DueAt == nil || !got.DueAt.Equal(due) {
+		t.Errorf("task = %+v, want only the title changed", got)
+	}
+}
+
+func TestLive_AuthorizesLikeREST(t *testing.T) {
+	s, _, srv := newLiveServer(t)
+	alice, bob := User{Name: "Alice"}, User{Name: "Bob"}
+	for _, u := range []*User{&alice, &bob} {
+		if err := s.CreateUser(t.Context(), u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	theirs := Task{Title: "theirs", Assignees: []int{bob.ID}}
+	if err := s.CreateTask(t.Context(), &theirs); err != nil {
+		t.Fatal(err)
+	}
+	token, _ := GenerateJWT(alice.ID)
+	c, _ := dialLive(t, srv, token)
+
+	for _, req := range []liveRequest{
+		{Type: "update", Task: json.RawMessage(fmt.Sprintf(`{"id":%d,"title":"taken"}`, theirs.ID))},
+		{Type: "delete", TaskID: theirs.ID},
+		{Type: "create", Task: json.RawMessage(fmt.Sprintf(`{"title":"x","parentId":%d}`, theirs.ID))},
+	} {
+		liveSend(t, c, req)
+		if ack := liveRecv(t, c); ack.OK || !strings.HasPrefix(ack.Error, errNotAllowed.Error()) {
+			t.Errorf("%s: ack %+v, want not allowed", req.Type, ack)
+		}
+	}
+	if got, err := s.GetTaskByID(t.Context(), theirs.ID); err != nil || got.Title != "theirs" {
+		t.Errorf("task changed: %+v (%v)", got, err)
+	}
+}
+
+func TestLive_MutationsAcksEventsAndPresence(t *testing.T) {
+	s, _, srv := newLiveServer(t)
+	project := Task{Title: "Launch"}
+	if err := s.CreateTask(t.Context(), &project); err != nil {
+		t.Fatal(err)
+	}
+	alice := User{Name: "Alice"}
+	if err := s.CreateUser(t.Context(), &alice); err != nil {
+		t.Fatal(err)
+	}
+
+	demoToken, _ := GenerateJWT(demoUserID)
+	aliceToken, _ := GenerateJWT(alice.ID)
+	watcher, _ := dialLive(t, srv, demoToken)
+	editor, _ := dialLive(t, srv, aliceToken)
+
+	liveSend(t, watcher, liveRequest{Type: "subscribe", ID: "s1", Projects: []int{project.ID}})
+	if ack := liveRecv(t, watcher); !ack.OK || ack.ID != "s1" {
+		t.Fatalf("expected subscribe ack, got %+v", ack)
+	}
+
+	liveSend(t, editor, liveRequest{Type: "presence", ID: "p1", TaskID: project.ID})
+	if ack := liveRecv(t, editor); !ack.OK {
+		t.Fatalf("presence rejected: %+v", ack)
+	}
+	if msg := liveRecv(t, watcher); msg.Type != "presence" || msg.Presence[0].Name != "Alice" || msg.Presence[0].TaskID != project.ID {
+		t.Fatalf("expected Alice viewing the project, got %+v", msg)
+	}
+
+	liveSend(t, editor, liveRequest{Type: "create", ID: "c1", Task: json.RawMessage(fmt.Sprintf(`{"title":"Write launch post","parentId":%d}`, project.ID))})
+	ack := liveRecv(t, editor)
+	if !ack.OK || ack.ID != "c1" || ack.Task == nil || ack.Task.ID == 0 {
+		t.Fatalf("expected create ack with the new task, got %+v", ack)
+	}
+	if msg := liveRecv(t, watcher); msg.Type != "event" || msg.Event.Type != EventTaskCreated || msg.Event.TaskID != ack.Task.ID {
+		t.Fatalf("expected the subtask creation to reach the project subscriber, got %+v", msg)
+	}
+	if _, err := s.GetTaskByID(t.Context(), ack.Task.ID); err != nil {
+		t.Fatalf("task not stored: %v", err)
+	}
+
+	liveSend(t, editor, liveRequest{Type: "update", ID: "u1", Task: json.RawMessage(`{"id":999,"title":"nope"}`)})
+	if ack := liveRecv(t, editor); ack.OK || ack.Error != "not found" {
+		t.Fatalf("expected a not found ack, got %+v", ack)
+	}
+
+	// Leaving announces presence gone.
+	editor.Close(wsCloseNormal, "")
+	if msg := liveRecv(t, watcher); msg.Type != "presence" || msg.Presence[0].TaskID != 0 {
+		t.Fatalf("expected Alice to leave, got %+v", msg)
+	}
+}
+
+func TestLive_DropsClientsThatStopAnsweringPings(t *testing.T) {
+	_, hub, srv := newLiveServer(t)
+	hub.pingInterval = 50 * time.Millisecond
+	hub.pongWait = 300 * time.Millisecond
+
+	token, _ := GenerateJWT(demoUserID)
+	responsive, _ := dialLive(t, srv, token)
+	go func() {
+		for {
+			if _, _, err := responsive.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	silent, _ := dialLive(t, srv, token)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		hub.mu.Lock()
+		n := len(hub.clients)
+		hub.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the silent client to be dropped, %d clients remain", n)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	_ = silent.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := silent.ReadMessage(); err != nil {
+			break
+		}
+	}
+}
+
+func TestLive_SlowClientIsDisconnected(t *testing.T) {
+	server, peer := net.Pipe()
+	defer peer.Close()
+	go io.Copy(io.Discard, peer)
+
+	c := &liveClient{
+		ws:   &wsConn{conn: server, br: bufio.NewReader(server)},
+		send: make(chan []byte, 1),
+		done: make(chan struct{}),
+	}
+	c.enqueue([]byte(`{}`))
+	c.enqueue([]byte(`{}`))
+
+	select {
+	case <-c.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a client with a full queue to be disconnected")
+	}
+}
+
+func TestWebSocket_FragmentedMessage(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	srvConn := &wsConn{conn: server, br: bufio.NewReader(server)}
+
+	go func() {
+		// A masked text frame split in two, with a ping in between.
+		frames := [][]byte{
+			{0x01, 0x83, 0, 0, 0, 0, 'a', 'b', 'c'},
+			{0x89, 0x80, 0, 0, 0, 0},
+			{0x80, 0x82, 0, 0, 0, 0, 'd', 'e'},
+		}
+		for _, f := range frames {
+			client.Write(f)
+		}
+	}()
+	go io.Copy(io.Discard, client)
+
+	op, msg, err := srvConn.ReadMessage()
+	if err != nil || op != wsOpText || string(msg) != "abcde" {
+		t.Fatalf("got op=%d msg=%q err=%v", op, msg, err)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// logLevel is shared by every logger the server creates, so changing it
+// takes effect at once without rebuilding handlers.
+var logLevel = new(slog.LevelVar)
+
+// NewLogger returns a JSON logger filtered by logLevel.
+func NewLogger(w io.Writer) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: logLevel}))
+}
+
+// SetupLogging makes a JSON logger on stderr at level the default, which
+// also routes the standard log package through it.
+func SetupLogging(level slog.Level) {
+	logLevel.Set(level)
+	slog.SetDefault(NewLogger(os.Stderr))
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var lvl slog.Level
+	err := lvl.UnmarshalText([]byte(strings.TrimSpace(s)))
+	return lvl, err
+}
+
+// requestInfo is filled in as a request passes through the middleware, for
+// the access log line written once the handler returns.
+type requestInfo struct {
+	ID     string
+	UserID int
+	// Route is the matched pattern, for middleware that sits above one
+	// that replaced the request.
+	Route   string
+	TraceID string
+}
+
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey).(*requestInfo)
+	return info
+}
+
+// recordRoute saves the pattern the mux set on r, which only the
+// middleware that passed r down can see.
+func recordRoute(r *http.Request) {
+	if info := requestInfoFrom(r.Context()); info != nil && r.Pattern != "" {
+		info.Route = r.Pattern
+	}
+}
+
+// loggerFrom returns the request-scoped logger, which carries the request
+// ID and, once authenticated, the user ID. Outside a request it is the
+// default logger.
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+func withLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, l)
+}
+
+// withLoggedUser records the authenticated user for the access log and
+// adds it to the request logger.
+func withLoggedUser(ctx context.Context, userID int) context.Context {
+	if info := requestInfoFrom(ctx); info != nil {
+		info.UserID = userID
+	}
+	return withLogger(ctx, loggerFrom(ctx).With("user_id", userID))
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Logging writes one access log line per request and gives the handlers
+// below it a request-scoped logger. It has to sit outside Recover so a
+// panicking request is still logged, with the 500 Recover sends.
+func Logging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := requestIDFrom(r.Context())
+		if id == "" {
+			// Only when RequestID is not in the chain.
+			id = uuid.NewString()
+		}
+		info := &requestInfo{ID: id}
+		logger := slog.Default().With("request_id", info.ID)
+		ctx := context.WithValue(r.Context(), requestInfoKey, info)
+		r = r.WithContext(withLogger(ctx, logger))
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		route := routeLabel(r)
+		level := slog.LevelInfo
+		if rec.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("route", route),
+			slog.Int("status", rec.status),
+			slog.Int64("bytes", rec.bytes),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int("user_id", info.UserID),
+			slog.String("remote_ip", remoteIP(r)),
+		}
+		if info.TraceID != "" {
+			attrs = append(attrs, slog.String("trace_id", info.TraceID))
+		}
+		logger.LogAttrs(r.Context(), level, "request", attrs...)
+	})
+}
+
+func getLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"level": logLevel.Level().String()})
+}
+
+// putLogLevelHandler changes the level of every logger at once, without a
+// restart.
+func putLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Level string `json:"level"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeErr(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	lvl, err := parseLogLevel(body.Level)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "level must be debug, info, warn or error")
+		return
+	}
+	old := logLevel.Level()
+	logLevel.Set(lvl)
+	loggerFrom(r.Context()).Info("log level changed", "from", old.String(), "to", lvl.String())
+	writeJSON(w, http.StatusOK, map[string]string{"level": lvl.String()})
+}
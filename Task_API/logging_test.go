@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// captureLogs points the default logger at a buffer and returns a function
+// that parses what was written so far, one map per line.
+func captureLogs(t *testing.T) func() []map[string]any {
+	t.Helper()
+	var buf bytes.Buffer
+	old := slog.Default()
+	slog.SetDefault(NewLogger(&buf))
+	t.Cleanup(func() { slog.SetDefault(old) })
+	return func() []map[string]any {
+		var lines []map[string]any
+		for _, l := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var m map[string]any
+			if err := json.Unmarshal([]byte(l), &m); err != nil {
+				t.Fatalf("log line %q is not JSON: %v", l, err)
+			}
+			lines = append(lines, m)
+		}
+		return lines
+	}
+}
+
+func findLog(lines []map[string]any, msg string) map[string]any {
+	for _, l := range lines {
+		if l["msg"] == msg {
+			return l
+		}
+	}
+	return nil
+}
+
+func TestLogging_AccessLineAndRequestLogger(t *testing.T) {
+	withJWTSecret(t)
+	logs := captureLogs(t)
+	mux := http.NewServeMux()
+	mux.Handle("GET /tasks/{ID}", AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		loggerFrom(r.Context()).Info("inside")
+		_, _ = w.Write([]byte("hello"))
+	})))
+	token, _ := GenerateJWT(demoUserID)
+	req := httptest.NewRequest(http.MethodGet, "/tasks/42", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.RemoteAddr = "203.0.113.9:41000"
+	Chain(mux, Logging, Recover).ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := logs()
+	access, inside := findLog(lines, "request"), findLog(lines, "inside")
+	if access == nil || inside == nil {
+		t.Fatalf("log lines = %v", lines)
+	}
+	want := map[string]any{
+		"method":    "GET",
+		"route":     "GET /tasks/{ID}",
+		"status":    float64(200),
+		"bytes":     float64(5),
+		"user_id":   float64(demoUserID),
+		"remote_ip": "203.0.113.9",
+	}
+	for k, v := range want {
+		if access[k] != v {
+			t.Errorf("access log %s = %v, want %v", k, access[k], v)
+		}
+	}
+	if _, ok := access["latency_ms"]; !ok {
+		t.Error("access log has no latency")
+	}
+	if id := access["request_id"]; id == nil || id == "" || inside["request_id"] != id {
+		t.Errorf("request ids: access %v, handler %v", id, inside["request_id"])
+	}
+	if inside["user_id"] != float64(demoUserID) {
+		t.Errorf("handler log user_id = %v", inside["user_id"])
+	}
+}
+
+func TestPublicErrorMessage_LogsThroughRequestLogger(t *testing.T) {
+	logs := captureLogs(t)
+	ctx := withLogger(t.Context(), slog.Default().With("request_id", "req-1"))
+	if msg := publicErrorMessage(ctx, errors.New("disk on fire")); msg != "internal error" {
+		t.Errorf("message = %q", msg)
+	}
+	line := findLog(logs(), "store error")
+	if line == nil || line["request_id"] != "req-1" || line["error"] != "disk on fire" {
+		t.Errorf("log line = %v", line)
+	}
+}
+
+func TestRecover_LogsStackAndAccessLine(t *testing.T) {
+	logs := captureLogs(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /boom", func(w http.ResponseWriter, r *http.Request) {
+		panic("kaboom")
+	})
+	rr := httptest.NewRecorder()
+	Chain(mux, Logging, Recover).ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/boom", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d", rr.Code)
+	}
+
+	lines := logs()
+	p := findLog(lines, "panic")
+	if p == nil || p["error"] != "kaboom" || !strings.Contains(p["stack"].(string), "logging_test.go") {
+		t.Errorf("panic line = %v", p)
+	}
+	if a := findLog(lines, "request"); a == nil || a["status"] != float64(500) || a["level"] != "ERROR" {
+		t.Errorf("access line = %v", a)
+	}
+}
+
+func TestPutLogLevel(t *testing.T) {
+	old := logLevel.Level()
+	t.Cleanup(func() { logLevel.Set(old) })
+	logs := captureLogs(t)
+
+	rr := httptest.NewRecorder()
+	putLogLevelHandler(rr, httptest.NewRequest(http.MethodPut, "/admin/log-level", strings.NewReader(`{"level":"debug"}`)))
+	if rr.Code != http.StatusOK || logLevel.Level() != slog.LevelDebug {
+		t.Fatalf("status %d, level %v", rr.Code, logLevel.Level())
+	}
+	slog.Debug("now visible")
+	if findLog(logs(), "now visible") == nil {
+		t.Error("debug line filtered after lowering the level")
+	}
+
+	rr = httptest.NewRecorder()
+	putLogLevelHandler(rr, httptest.NewRequest(http.MethodPut, "/admin/log-level", strings.NewReader(`{"level":"loud"}`)))
+	if rr.Code != http.StatusBadRequest || logLevel.Level() != slog.LevelDebug {
+		t.Errorf("bad level: status %d, level %v", rr.Code, logLevel.Level())
+	}
+}
@@ -2,53 +2,227 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
+// commands are run instead of the server when named as the first argument.
+var commands = map[string]func(args []string) error{
+	"restore": runRestore,
+	"config":  runConfig,
+}
+
 func main() {
-	db := initDB()
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		cmd, ok := commands[os.Args[1]]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "unknown command %q\n", os.Args[1])
+			os.Exit(2)
+		}
+		if err := cmd(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	cfg, err := LoadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
+
+	SetupLogging(cfg.LogLevel)
+	live.Store(liveConfigOf(cfg))
+	tracerProvider, stopTracing, err := NewTracerProvider(context.Background(), cfg.Tracing)
+	if err != nil {
+		slog.Error("set up tracing failed", "error", err)
+		os.Exit(1)
+	}
+	tracer := tracerProvider.Tracer("taskapi")
+	db := initDB(cfg.DB)
+	db.Tracer = tracer
+	blobs, err := NewFSBlobStore(cfg.BlobDir)
+	if err != nil {
+		slog.Error("open blob store failed", "error", err)
+		os.Exit(1)
+	}
 	store := NewSQLiteStore(db)
+	store.Subtasks = cfg.Subtasks
+	store.Blobs = blobs
+	metrics := NewMetrics(db, store)
+	store.Metrics = metrics
+	events := NewEventBus()
+	broker := NewStreamBroker()
+	SubscribeTaskStream(events, store, broker)
+	hub := NewLiveHub(store, store, store, events)
+	idem := Idempotent(store)
+
+	workers := NewWorkers()
+	health := NewHealth(db, workers)
 
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("GET /tasks", getTaskHandler(store))
+	mux.HandleFunc("GET /tasks/stream", taskStreamHandler(store, broker))
 	mux.HandleFunc("GET /tasks/{ID}", getTaskByIDHandler(store))
+	mux.HandleFunc("GET /tasks/{ID}/subtasks", getSubtasksHandler(store))
+	mux.HandleFunc("GET /tasks/{ID}/critical-path", getCriticalPathHandler(store))
+	mux.HandleFunc("GET /tasks/{ID}/occurrences", getOccurrencesHandler(store))
+	mux.HandleFunc("GET /tasks/{ID}/comments", listCommentsHandler(store))
+	mux.HandleFunc("GET /tasks/{ID}/attachments", listAttachmentsHandler(store))
+	mux.HandleFunc("GET /tasks/{ID}/attachments/{AttachmentID}", downloadAttachmentHandler(store, blobs))
 	mux.HandleFunc("POST /login", loginHandler)
+	mux.HandleFunc("GET /metrics", metricsHandler(metrics))
+	mux.HandleFunc("GET /healthz", healthzHandler)
+	mux.HandleFunc("GET /readyz", readyzHandler(health))
+
+	mux.Handle("POST /tasks", AuthMiddleware(idem(postTaskHandler(store, store, events))))
+	mux.Handle("POST /tasks:batch", AuthMiddleware(idem(batchTasksHandler(store, store, store, events))))
+	mux.Handle("PUT /tasks/{ID}", AuthMiddleware(updateTaskByIDHandler(store, store, events)))
+	mux.Handle("DELETE /tasks/{ID}", AuthMiddleware(deleteTaskByIDHandler(store, store, events)))
+	mux.Handle("POST /tasks/{ID}/dependencies", AuthMiddleware(idem(addDependencyHandler(store))))
+	mux.Handle("DELETE /tasks/{ID}/dependencies/{BlockerID}", AuthMiddleware(removeDependencyHandler(store)))
+	mux.Handle("POST /tasks/{ID}/assignees", AuthMiddleware(idem(assignTaskHandler(store, store, store, events))))
+	mux.Handle("DELETE /tasks/{ID}/assignees/{UserID}", AuthMiddleware(unassignTaskHandler(store, store, store, events)))
+	mux.Handle("POST /tasks/{ID}/comments", AuthMiddleware(idem(postCommentHandler(store, events))))
+	mux.Handle("PATCH /tasks/{ID}/comments/{CommentID}", AuthMiddleware(idem(updateCommentHandler(store, events))))
+	mux.Handle("DELETE /tasks/{ID}/comments/{CommentID}", AuthMiddleware(deleteCommentHandler(store, store)))
+	mux.Handle("GET /tasks/{ID}/reminders", AuthMiddleware(listRemindersHandler(store)))
+	mux.Handle("POST /tasks/{ID}/reminders", AuthMiddleware(idem(postReminderHandler(store))))
+	mux.Handle("DELETE /tasks/{ID}/reminders/{ReminderID}", AuthMiddleware(deleteReminderHandler(store)))
+	mux.Handle("POST /tasks/{ID}/attachments", AuthMiddleware(postAttachmentHandler(store, store, store, blobs)))
+	mux.Handle("DELETE /tasks/{ID}/attachments/{AttachmentID}", AuthMiddleware(deleteAttachmentHandler(store, store, store, blobs)))
+
+	mux.HandleFunc("GET /calendar/{file}", calendarFeedHandler(store, store))
+	mux.Handle("POST /users/me/calendar/token", AuthMiddleware(rotateCalendarTokenHandler(store)))
+	mux.Handle("DELETE /users/me/calendar/token", AuthMiddleware(revokeCalendarTokenHandler(store)))
+	mux.Handle("GET /export", AuthMiddleware(exportHandler(store)))
+	mux.Handle("POST /import", AuthMiddleware(importHandler(store, events)))
+	mux.Handle("GET /ws", AuthMiddleware(liveHandler(hub)))
+	mux.Handle("GET /sync", AuthMiddleware(getSyncHandler(store)))
+	mux.Handle("POST /sync", AuthMiddleware(idem(postSyncHandler(store, store, store, events))))
+
+	mux.Handle("GET /users", AuthMiddleware(listUsersHandler(store)))
+	mux.Handle("POST /users", AuthMiddleware(idem(AdminOnly(store)(postUserHandler(store)))))
+	mux.Handle("GET /users/me/notifications", AuthMiddleware(getNotificationPrefsHandler(store)))
+	mux.Handle("PUT /users/me/notifications", AuthMiddleware(putNotificationPrefsHandler(store)))
+	mux.Handle("DELETE /users/{ID}", AuthMiddleware(AdminOnly(store)(deleteUserHandler(store, events))))
+	mux.Handle("GET /users/me/tasks", AuthMiddleware(getMyTasksHandler(store)))
 
-	mux.Handle("POST /tasks", AuthMiddleware(postTaskHandler(store)))
-	mux.Handle("PUT /tasks/{ID}", AuthMiddleware(updateTaskByIDHandler(store)))
-	mux.Handle("DELETE /tasks/{ID}", AuthMiddleware(deleteTaskByIDHandler(store)))
+	backups := NewBackupper(db, cfg.Backup)
+	mux.Handle("GET /admin/backups", AuthMiddleware(AdminOnly(store)(listBackupsHandler(backups))))
+	mux.Handle("POST /admin/backup", AuthMiddleware(AdminOnly(store)(postBackupHandler(backups))))
+	mux.Handle("GET /admin/log-level", AuthMiddleware(AdminOnly(store)(http.HandlerFunc(getLogLevelHandler))))
+	mux.Handle("PUT /admin/log-level", AuthMiddleware(AdminOnly(store)(http.HandlerFunc(putLogLevelHandler))))
+	mux.Handle("GET /admin/db/stats", AuthMiddleware(AdminOnly(store)(dbStatsHandler(db))))
+
+	mux.Handle("GET /webhooks", AuthMiddleware(AdminOnly(store)(listWebhooksHandler(store))))
+	mux.Handle("POST /webhooks", AuthMiddleware(idem(AdminOnly(store)(postWebhookHandler(store)))))
+	mux.Handle("GET /webhooks/{ID}", AuthMiddleware(AdminOnly(store)(getWebhookHandler(store))))
+	mux.Handle("DELETE /webhooks/{ID}", AuthMiddleware(AdminOnly(store)(deleteWebhookHandler(store))))
+	mux.Handle("GET /webhooks/{ID}/deliveries", AuthMiddleware(AdminOnly(store)(listDeliveriesHandler(store))))
+	mux.Handle("POST /webhooks/{ID}/deliveries/{DeliveryID}/redeliver", AuthMiddleware(idem(AdminOnly(store)(redeliverHandler(store)))))
 
 	handler := Chain(mux,
-		Recover,
-		CORSFromEnv(),
+		RequestID,
 		Logging,
+		metrics.Middleware,
+		Tracing(tracer),
+		Recover,
+		CORS,
+		NewRateLimiter().Middleware,
 	)
 
 	srv := &http.Server{
-		Addr:         ":8080",
+		Addr:         cfg.Server.Addr,
 		Handler:      handler,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
 	}
+	// Shutdown does not interrupt active requests, so end open streams
+	// instead of letting them hold it up until the timeout.
+	srv.RegisterOnShutdown(broker.Close)
+	srv.RegisterOnShutdown(hub.Close)
+
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	startWorkers(bgCtx, workers, cfg, store, events, backups, tracer)
 
 	go func() {
-		fmt.Println("Server running on http://localhost:8080")
+		slog.Info("server listening", "addr", cfg.Server.Addr)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			fmt.Printf("Server crashed: %v\n", err)
+			slog.Error("server failed", "error", err)
+			os.Exit(1)
 		}
 	}()
 
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt)
-	<-stop
+	// SIGHUP reloads the configuration; anything else shuts down.
+	reloader := NewReloader(os.Args[1:], cfg)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range signals {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		_ = reloader.Reload()
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// Fail readiness first and give load balancers time to notice before
+	// the listener closes.
+	health.Drain()
+	time.Sleep(cfg.Server.DrainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
 	defer cancel()
 	_ = srv.Shutdown(ctx)
+
+	stopBackground()
+	workers.Wait()
+	// The tracer outlives the background workers so their last spans are
+	// exported too.
+	exportCtx, cancelExport := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelExport()
+	if err := stopTracing(exportCtx); err != nil {
+		slog.Error("export spans failed", "error", err)
+	}
+	if err := db.Close(); err != nil {
+		slog.Error("close database failed", "error", err)
+	}
+}
+
+// startWorkers subscribes the notification and webhook fan-out to events
+// and runs the background loops that cfg turns on. Readiness expects every
+// worker it starts to keep running, so a loop with nothing to do is not
+// started at all.
+func startWorkers(ctx context.Context, workers *Workers, cfg Config, store *SQLiteStore, events *EventBus, backups *Backupper, tracer trace.Tracer) {
+	var notifier Notifier = LogNotifier{}
+	if cfg.SMTP.Enabled() {
+		notifier = NewEmailNotifier(store, store, store)
+		dispatcher := NewEmailDispatcher(store, NewSMTPMailer(cfg.SMTP))
+		workers.Go(ctx, "email", dispatcher.Run)
+	}
+	SubscribeNotifications(events, store, store, notifier)
+	SubscribeWebhooks(events, store)
+
+	dispatcher := NewWebhookDispatcher(store)
+	dispatcher.Tracer = tracer
+	workers.Go(ctx, "webhooks", dispatcher.Run)
+	if cfg.Backup.Interval > 0 {
+		workers.Go(ctx, "backups", backups.Run)
+	}
+	workers.Go(ctx, "reminders", NewReminderScheduler(store, notifier).Run)
 }
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are Prometheus's default histogram buckets, in seconds.
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// counterVec is a counter split by label values. Series are keyed by their
+// label values joined with a separator no label value can contain.
+type counterVec struct {
+	name, help string
+	labels     []string
+	mu         sync.Mutex
+	values     map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+}
+
+func (c *counterVec) inc(values ...string) {
+	c.mu.Lock()
+	c.values[strings.Join(values, "\xff")]++
+	c.mu.Unlock()
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeSeries(w, "counter", c.name, c.help, c.labels, c.values)
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative; the last is +Inf
+	sum    float64
+	count  uint64
+}
+
+// histogramVec is a histogram split by label values.
+type histogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+	mu         sync.Mutex
+	values     map[string]*histogram
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, values: map[string]*histogram{}}
+}
+
+func (h *histogramVec) observe(v float64, values ...string) {
+	key := strings.Join(values, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.values[key]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets)+1)}
+		h.values[key] = s
+	}
+	s.counts[sort.SearchFloat64s(h.buckets, v)]++
+	s.sum += v
+	s.count++
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.values) {
+		s, values := h.values[key], splitKey(key)
+		var cumulative uint64
+		for i, n := range s.counts {
+			cumulative += n
+			le := "+Inf"
+			if i < len(h.buckets) {
+				le = formatValue(h.buckets[i])
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, values, "le", le), cumulative)
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, values, "", ""), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, values, "", ""), s.count)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func splitKey(key string) []string {
+	if key == "" {
+		return nil
+	}
+	return strings.Split(key, "\xff")
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels renders {a="x",b="y"}, with an extra pair appended when
+// extraName is set (the le of a histogram bucket).
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, name, labelEscaper.Replace(values[i]))
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, extraName, extraValue)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// writeSeries writes a metric whose values are already at hand, keyed like
+// counterVec's.
+func writeSeries(w io.Writer, typ, name, help string, labels []string, series map[string]float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	for _, key := range sortedKeys(series) {
+		fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(labels, splitKey(key), "", ""), formatValue(series[key]))
+	}
+}
+
+// TaskCounter reports the business gauges: how many tasks are open and how
+// many are completed.
+type TaskCounter interface {
+	CountTasks(ctx context.Context) (open, completed int, err error)
+}
+
+// Metrics collects what GET /metrics exposes in the Prometheus text
+// format. Request and store metrics are recorded as they happen; pool
+// stats and task counts are read at scrape time. A nil *Metrics records
+// nothing, so the store can be used without one.
+type Metrics struct {
+	requests        *counterVec
+	requestDuration *histogramVec
+	inFlight        atomic.Int64
+	storeDuration   *histogramVec
+
+	db    *DB
+	tasks TaskCounter
+}
+
+func NewMetrics(db *DB, tasks TaskCounter) *Metrics {
+	return &Metrics{
+		requests: newCounterVec("taskapi_http_requests_total",
+			"HTTP requests handled, by route pattern and status.", "route", "status"),
+		requestDuration: newHistogramVec("taskapi_http_request_duration_seconds",
+			"HTTP request latency, by route pattern and status.", latencyBuckets, "route", "status"),
+		storeDuration: newHistogramVec("taskapi_store_duration_seconds",
+			"Time spent in TaskStore methods.", latencyBuckets, "method"),
+		db:    db,
+		tasks: tasks,
+	}
+}
+
+// Middleware counts and times requests. It does not replace the request,
+// so the route is still readable after it, and it has to sit outside
+// Recover so panics are counted as the 500 they become.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		m.inFlight.Add(1)
+		defer m.inFlight.Add(-1)
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		route, status := routeLabel(r), strconv.Itoa(rec.status)
+		m.requests.inc(route, status)
+		m.requestDuration.observe(time.Since(start).Seconds(), route, status)
+	})
+}
+
+// observeStore records how long a store method took. Call it deferred at
+// the top of the method.
+func (m *Metrics) observeStore(method string, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.storeDuration.observe(time.Since(start).Seconds(), method)
+}
+
+// WriteTo writes every metric in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	var b bytes.Buffer
+	m.requests.write(&b)
+	m.requestDuration.write(&b)
+	writeSeries(&b, "gauge", "taskapi_http_requests_in_flight", "HTTP requests currently being served.",
+		nil, map[string]float64{"": float64(m.inFlight.Load())})
+	m.storeDuration.write(&b)
+
+	if m.db != nil {
+		m.writeDBStats(&b)
+	}
+	if m.tasks != nil {
+		if open, completed, err := m.tasks.CountTasks(context.Background()); err != nil {
+			slog.Error("metrics: count tasks", "error", err)
+		} else {
+			writeSeries(&b, "gauge", "taskapi_tasks", "Tasks by state.", []string{"state"},
+				map[string]float64{"open": float64(open), "completed": float64(completed)})
+		}
+	}
+	return b.WriteTo(w)
+}
+
+func (m *Metrics) writeDBStats(w io.Writer) {
+	conns, maxOpen, waits, waitSeconds := map[string]float64{}, map[string]float64{}, map[string]float64{}, map[string]float64{}
+	for pool, st := range map[string]sql.DBStats{"writer": m.db.writer.Stats(), "reader": m.db.reader.Stats()} {
+		conns[pool+"\xffin_use"] = float64(st.InUse)
+		conns[pool+"\xffidle"] = float64(st.Idle)
+		maxOpen[pool] = float64(st.MaxOpenConnections)
+		waits[pool] = float64(st.WaitCount)
+		waitSeconds[pool] = st.WaitDuration.Seconds()
+	}
+	writeSeries(w, "gauge", "taskapi_db_connections", "Open database connections, by pool and state.", []string{"pool", "state"}, conns)
+	writeSeries(w, "gauge", "taskapi_db_max_open_connections", "Connection limit of each pool.", []string{"pool"}, maxOpen)
+	writeSeries(w, "counter", "taskapi_db_wait_total", "Connections waited for because the pool was exhausted.", []string{"pool"}, waits)
+	writeSeries(w, "counter", "taskapi_db_wait_seconds_total", "Time spent waiting for a connection.", []string{"pool"}, waitSeconds)
+	writeSeries(w, "counter", "taskapi_db_busy_retries_total", "Writes retried because the database was locked.", nil,
+		map[string]float64{"": float64(m.db.retries.Load())})
+	writeSeries(w, "counter", "taskapi_db_busy_errors_total", "Writes that failed because the database stayed locked.", nil,
+		map[string]float64{"": float64(m.db.busy.Load())})
+}
+
+// routeLabel is the pattern the mux matched, which keeps label cardinality
+// bounded where the raw path would not. The mux sets it on the request it
+// was given; middleware further out finds it through recordRoute.
+func routeLabel(r *http.Request) string {
+	if r.Pattern != "" {
+		return r.Pattern
+	}
+	if info := requestInfoFrom(r.Context()); info != nil && info.Route != "" {
+		return info.Route
+	}
+	return "unmatched"
+}
+
+func metricsHandler(m *Metrics) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = m.WriteTo(w)
+	}
+}
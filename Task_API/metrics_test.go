@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// parseMetrics reads the text exposition format into series name (with
+// labels, exactly as written) to value, checking every series is preceded
+// by its TYPE line.
+func parseMetrics(t *testing.T, body string) map[string]float64 {
+	t.Helper()
+	out := map[string]float64{}
+	typed := map[string]bool{}
+	sc := bufio.NewScanner(strings.NewReader(body))
+	for sc.Scan() {
+		line := sc.Text()
+		if rest, ok := strings.CutPrefix(line, "# TYPE "); ok {
+			typed[strings.Fields(rest)[0]] = true
+			continue
+		}
+		if strings.HasPrefix(line, "#") || line == "" {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		series, raw := line[:i], line[i+1:]
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			t.Fatalf("bad value in %q", line)
+		}
+		name, _, _ := strings.Cut(series, "{")
+		base := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(name, "_bucket"), "_sum"), "_count")
+		if !typed[name] && !typed[base] {
+			t.Fatalf("series %q has no TYPE line", series)
+		}
+		out[series] = v
+	}
+	return out
+}
+
+func scrape(t *testing.T, m *Metrics) map[string]float64 {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	metricsHandler(m).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("content type %q", rr.Header().Get("Content-Type"))
+	}
+	return parseMetrics(t, rr.Body.String())
+}
+
+func TestMetrics_RequestsStoreAndGauges(t *testing.T) {
+	s := newTestStore(t)
+	m := NewMetrics(s.db, s)
+	s.Metrics = m
+	task := Task{Title: "measured"}
+	if err := s.CreateTask(t.Context(), &task); err != nil {
+		t.Fatal(err)
+	}
+
+	var inFlight map[string]float64
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /tasks/{ID}", getTaskByIDHandler(s))
+	mux.HandleFunc("GET /busy", func(w http.ResponseWriter, r *http.Request) {
+		inFlight = scrape(t, m)
+	})
+	mux.HandleFunc("GET /boom", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	h := Chain(mux, m.Middleware, Recover)
+	for _, path := range []string{"/tasks/" + strconv.Itoa(task.ID), "/tasks/" + strconv.Itoa(task.ID), "/tasks/999", "/nope", "/busy", "/boom"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	got := scrape(t, m)
+	for series, want := range map[string]float64{
+		`taskapi_http_requests_total{route="GET /tasks/{ID}",status="200"}`:                            2,
+		`taskapi_http_requests_total{route="GET /tasks/{ID}",status="404"}`:                            1,
+		`taskapi_http_requests_total{route="unmatched",status="404"}`:                                  1,
+		`taskapi_http_requests_total{route="GET /boom",status="500"}`:                                  1,
+		`taskapi_http_request_duration_seconds_bucket{route="GET /tasks/{ID}",status="200",le="+Inf"}`: 2,
+		`taskapi_http_request_duration_seconds_count{route="GET /tasks/{ID}",status="200"}`:            2,
+		`taskapi_http_requests_in_flight`:                                                              0,
+		`taskapi_store_duration_seconds_count{method="CreateTask"}`:                                    1,
+		`taskapi_tasks{state="open"}`:                                                                  1,
+		`taskapi_tasks{state="completed"}`:                                                             0,
+		`taskapi_db_max_open_connections{pool="writer"}`:                                               1,
+	} {
+		if v, ok := got[series]; !ok || v != want {
+			t.Errorf("%s = %v (present %v), want %v", series, v, ok, want)
+		}
+	}
+	if got[`taskapi_store_duration_seconds_count{method="GetTaskByID"}`] < 3 {
+		t.Errorf("GetTaskByID timed %v times, want at least 3", got[`taskapi_store_duration_seconds_count{method="GetTaskByID"}`])
+	}
+	if inFlight[`taskapi_http_requests_in_flight`] != 1 {
+		t.Errorf("in flight during a request = %v, want 1", inFlight[`taskapi_http_requests_in_flight`])
+	}
+}
+
+func TestHistogramVec_CumulativeBuckets(t *testing.T) {
+	h := newHistogramVec("x_seconds", "test", []float64{0.1, 1}, "path")
+	for _, v := range []float64{0.05, 0.1, 0.5, 3} {
+		h.observe(v, `a"b`)
+	}
+	var buf bytes.Buffer
+	h.write(&buf)
+	got := parseMetrics(t, buf.String())
+	for series, want := range map[string]float64{
+		`x_seconds_bucket{path="a\"b",le="0.1"}`:  2,
+		`x_seconds_bucket{path="a\"b",le="1"}`:    3,
+		`x_seconds_bucket{path="a\"b",le="+Inf"}`: 4,
+		`x_seconds_sum{path="a\"b"}`:              3.65,
+		`x_seconds_count{path="a\"b"}`:            4,
+	} {
+		if got[series] != want {
+			t.Errorf("%s = %v, want %v", series, got[series], want)
+		}
+	}
+}
@@ -1,16 +1,14 @@
 package main
 
 import (
-	"log"
 	"net/http"
-	"os"
-	"strings"
-	"time"
+	"runtime/debug"
 )
 
 type statusRecorder struct {
 	http.ResponseWriter
 	status int
+	bytes  int64
 }
 
 func (sr *statusRecorder) WriteHeader(code int) {
@@ -18,6 +16,18 @@ func (sr *statusRecorder) WriteHeader(code int) {
 	sr.ResponseWriter.WriteHeader(code)
 }
 
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	n, err := sr.ResponseWriter.Write(b)
+	sr.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, which
+// streaming handlers need for Flush and SetWriteDeadline.
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
 type Middleware func(http.Handler) http.Handler
 
 func Chain(h http.Handler, mws ...Middleware) http.Handler {
@@ -27,21 +37,17 @@ func Chain(h http.Handler, mws ...Middleware) http.Handler {
 	return h
 }
 
-func Logging(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
-		next.ServeHTTP(rec, r)
-		dur := time.Since(start)
-		log.Printf("%s %s -> %d (%s)", r.Method, r.URL.Path, rec.status, dur)
-	})
-}
-
 func Recover(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if rec := recover(); rec != nil {
-				log.Printf("panic: %v", rec)
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+				loggerFrom(r.Context()).Error("panic",
+					"error", rec,
+					"stack", string(debug.Stack()),
+				)
 				writeErr(w, http.StatusInternalServerError, "internal error")
 			}
 		}()
@@ -49,54 +55,37 @@ func Recover(next http.Handler) http.Handler {
 	})
 }
 
-func CORS(origins string) Middleware {
-	allowedOrigins := parseOrigins(origins)
-	allowAll := len(allowedOrigins) == 1 && allowedOrigins[0] == "*"
-
-	allowedMethods := "GET,POST,PUT,DELETE,OPTIONS"
-	allowedHeaders := "Content-Type,Authorization"
-	exposeHeaders := "Content-Type"
-
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-
-			origin := r.Header.Get("Origin")
-			if origin != "" && (allowAll || containsOrigin(allowedOrigins, origin)) {
-				w.Header().Set("Access-Control-Allow-Origin", allowAllIfWildcard(origin, allowAll))
-				w.Header().Set("Vary", "Origin")
-				w.Header().Set("Access-Control-Allow-Credentials", "true")
-				w.Header().Set("Access-Control-Allow-Methods", allowedMethods)
-				w.Header().Set("Access-Control-Allow-Headers", allowedHeaders)
-				w.Header().Set("Access-Control-Expose-Headers", exposeHeaders)
-			}
-
-			if r.Method == http.MethodOptions {
-				w.WriteHeader(http.StatusNoContent)
-				return
-			}
+// CORS allows browsers on the configured origins to call the API. No
+// origins, or "*", allows any. The origins are looked up per request so a
+// reload takes effect without rebuilding the chain.
+func CORS(next http.Handler) http.Handler {
+	const (
+		allowedMethods = "GET,POST,PUT,PATCH,DELETE,OPTIONS"
+		allowedHeaders = "Content-Type,Authorization," + requestIDHeader + "," + idempotencyHeader + "," + traceparentHeader
+		exposeHeaders  = "Content-Type,X-Request-ID"
+	)
 
-			next.ServeHTTP(w, r)
-		})
-	}
-}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowedOrigins := currentLive().CORSOrigins
+		allowAll := len(allowedOrigins) == 0 || containsOrigin(allowedOrigins, "*")
+
+		origin := r.Header.Get("Origin")
+		if origin != "" && (allowAll || containsOrigin(allowedOrigins, origin)) {
+			w.Header().Set("Access-Control-Allow-Origin", allowAllIfWildcard(origin, allowAll))
+			w.Header().Set("Vary", "Origin")
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			w.Header().Set("Access-Control-Allow-Methods", allowedMethods)
+			w.Header().Set("Access-Control-Allow-Headers", allowedHeaders)
+			w.Header().Set("Access-Control-Expose-Headers", exposeHeaders)
+		}
 
-func parseOrigins(csv string) []string {
-	csv = strings.TrimSpace(csv)
-	if csv == "" {
-		return []string{"*"}
-	}
-	parts := strings.Split(csv, ",")
-	out := make([]string, 0, len(parts))
-	for _, p := range parts {
-		p = strings.TrimSpace(p)
-		if p != "" {
-			out = append(out, p)
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
 		}
-	}
-	if len(out) == 0 {
-		return []string{"*"}
-	}
-	return out
+
+		next.ServeHTTP(w, r)
+	})
 }
 
 func containsOrigin(list []string, origin string) bool {
@@ -108,13 +97,17 @@ func containsOrigin(list []string, origin string) bool {
 	return false
 }
 
+// originAllowed reports whether the CORS settings let pages from origin use
+// the API. Browsers do not apply CORS to WebSocket handshakes, so the live
+// endpoint checks this itself.
+func originAllowed(origin string) bool {
+	allowed := currentLive().CORSOrigins
+	return len(allowed) == 0 || containsOrigin(allowed, "*") || containsOrigin(allowed, origin)
+}
+
 func allowAllIfWildcard(origin string, allowAll bool) string {
 	if allowAll {
 		return "*"
 	}
 	return origin
 }
-
-func CORSFromEnv() Middleware {
-	return CORS(os.Getenv("TASK_API_CORS_ORIGINS"))
-}
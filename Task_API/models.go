@@ -1,12 +1,98 @@
 package main
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type Task struct {
+	ID               int        `json:"id"`
+	Title            string     `json:"title"`
+	Description      string     `json:"description"`
+	Completed        bool       `json:"completed"`
+	ParentID         *int       `json:"parentId,omitempty"`
+	Progress         int        `json:"progress"`
+	Subtasks         []Task     `json:"subtasks,omitempty"`
+	BlockedBy        []int      `json:"blockedBy"`
+	Blocks           []int      `json:"blocks"`
+	Blocked          bool       `json:"blocked"`
+	Assignees        []int      `json:"assignees"`
+	CommentCount     int        `json:"commentCount"`
+	DueAt            *time.Time `json:"dueAt,omitempty"`
+	RRule            string     `json:"rrule,omitempty"`
+	TimeZone         string     `json:"timeZone,omitempty"`
+	RecurrenceStart  *time.Time `json:"recurrenceStart,omitempty"`
+	NextOccurrenceID *int       `json:"nextOccurrenceId,omitempty"`
+	CreatedAt        time.Time  `json:"createdAt"`
+	UpdatedAt        time.Time  `json:"updatedAt,omitempty"`
+}
+
+type User struct {
+	ID        int        `json:"id"`
+	Name      string     `json:"name"`
+	Email     string     `json:"email"`
+	IsAdmin   bool       `json:"isAdmin"`
+	CreatedAt time.Time  `json:"createdAt"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+type Comment struct {
+	ID        int       `json:"id"`
+	TaskID    int       `json:"taskId"`
+	AuthorID  int       `json:"authorId"`
+	Body      string    `json:"body"`
+	Mentions  []int     `json:"mentions"`
+	Edited    bool      `json:"edited"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type Attachment struct {
 	ID          int       `json:"id"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	Completed   bool      `json:"completed"`
+	TaskID      int       `json:"taskId"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"contentType"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	UploadedBy  int       `json:"uploadedBy"`
 	CreatedAt   time.Time `json:"createdAt"`
-	UpdatedAt   time.Time `json:"updatedAt,omitempty"`
+}
+
+type Reminder struct {
+	ID        int        `json:"id"`
+	TaskID    int        `json:"taskId"`
+	UserID    int        `json:"userId"`
+	Before    string     `json:"before"`
+	Status    string     `json:"status"`
+	SentAt    *time.Time `json:"sentAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+type Webhook struct {
+	ID        int       `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	CreatedBy int       `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type WebhookDelivery struct {
+	ID             int             `json:"id"`
+	WebhookID      int             `json:"webhookId"`
+	EventType      string          `json:"eventType"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus int             `json:"responseStatus,omitempty"`
+	LastError      string          `json:"lastError,omitempty"`
+	NextAttemptAt  *time.Time      `json:"nextAttemptAt,omitempty"`
+	DeliveredAt    *time.Time      `json:"deliveredAt,omitempty"`
+	CreatedAt      time.Time       `json:"createdAt"`
+	TraceParent    string          `json:"-"`
+}
+
+type SyncTombstone struct {
+	ID        int       `json:"id"`
+	DeletedAt time.Time `json:"deletedAt"`
 }
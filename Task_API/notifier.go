@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+)
+
+const (
+	NotificationReminder  = "reminder"
+	NotificationAssigned  = "assigned"
+	NotificationCommented = "commented"
+	NotificationMentioned = "mentioned"
+	NotificationOverdue   = "overdue"
+)
+
+// Notification is a message for one user about one task.
+type Notification struct {
+	Kind    string
+	UserID  int
+	Task    Task
+	Message string
+	// RequestID is the API request behind the notification; scheduled
+	// ones have none.
+	RequestID string
+}
+
+// Notifier delivers notifications. Implementations should honour ctx so a
+// shutdown does not wait on a slow delivery.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// LogNotifier writes notifications to the server log. It is the default
+// until a real delivery channel is configured.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(_ context.Context, n Notification) error {
+	slog.Info("notify", "user_id", n.UserID, "kind", n.Kind, "task_id", n.Task.ID, "message", n.Message, "request_id", n.RequestID)
+	return nil
+}
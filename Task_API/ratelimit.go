@@ -0,0 +1,95 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitConfig caps how fast one client may call the API. Each client
+// has a bucket of Burst requests that refills at PerMinute a minute.
+type RateLimitConfig struct {
+	// PerMinute is the sustained rate; 0 turns limiting off.
+	PerMinute int
+	Burst     int
+}
+
+// rateLimitExempt are never limited, so probes and scrapes keep working
+// while a client is being throttled.
+var rateLimitExempt = map[string]bool{"/healthz": true, "/readyz": true, "/metrics": true}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter keeps a token bucket per client IP. The limits are read from
+// the live configuration on every request, so a reload applies to existing
+// buckets straight away.
+type RateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{buckets: map[string]*bucket{}, now: time.Now}
+}
+
+// Middleware answers 429 with Retry-After once a client's bucket is empty.
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := currentLive().RateLimit
+		if cfg.PerMinute <= 0 || rateLimitExempt[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok, wait := l.allow(remoteIP(r), cfg); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeErr(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allow takes a token from key's bucket. When there is none it reports how
+// long until there will be.
+func (l *RateLimiter) allow(key string, cfg RateLimitConfig) (bool, time.Duration) {
+	now := l.now()
+	perSecond := float64(cfg.PerMinute) / 60
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now, perSecond, cfg.Burst)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(cfg.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(float64(cfg.Burst), b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+}
+
+// sweep forgets buckets that have filled up again, which behave the same as
+// new ones, so clients that went away do not pile up.
+func (l *RateLimiter) sweep(now time.Time, perSecond float64, burst int) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*perSecond >= float64(burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
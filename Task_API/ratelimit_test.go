@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func withRateLimit(t *testing.T, cfg RateLimitConfig) {
+	t.Helper()
+	old := live.Load()
+	live.Store(&LiveConfig{RateLimit: cfg})
+	t.Cleanup(func() { live.Store(old) })
+}
+
+func TestRateLimiter_PerClientBuckets(t *testing.T) {
+	withRateLimit(t, RateLimitConfig{PerMinute: 60, Burst: 2})
+	l := NewRateLimiter()
+	now := time.Unix(1700000000, 0)
+	l.now = func() time.Time { return now }
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	call := func(ip, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = ip + ":1234"
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i := 0; i < 2; i++ {
+		if rr := call("203.0.113.1", "/tasks"); rr.Code != http.StatusOK {
+			t.Fatalf("request %d within the burst: %d", i, rr.Code)
+		}
+	}
+	rr := call("203.0.113.1", "/tasks")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "1" {
+		t.Fatalf("over the burst: %d, Retry-After %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	if rr := call("203.0.113.2", "/tasks"); rr.Code != http.StatusOK {
+		t.Errorf("another client was limited: %d", rr.Code)
+	}
+	if rr := call("203.0.113.1", "/readyz"); rr.Code != http.StatusOK {
+		t.Errorf("probe was limited: %d", rr.Code)
+	}
+
+	now = now.Add(time.Second)
+	if rr := call("203.0.113.1", "/tasks"); rr.Code != http.StatusOK {
+		t.Errorf("after refilling a token: %d", rr.Code)
+	}
+
+	// A reload applies to the buckets already there.
+	withRateLimit(t, RateLimitConfig{})
+	for i := 0; i < 5; i++ {
+		if rr := call("203.0.113.1", "/tasks"); rr.Code != http.StatusOK {
+			t.Fatalf("limiting turned off but got %d", rr.Code)
+		}
+	}
+}
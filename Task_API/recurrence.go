@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultOccurrenceWindow = 90 * 24 * time.Hour
+	maxOccurrences          = 500
+)
+
+// taskRule parses the task's RRULE and resolves its time zone. An empty
+// TimeZone means UTC.
+func taskRule(t Task) (RRule, *time.Location, error) {
+	rule, err := ParseRRule(t.RRule)
+	if err != nil {
+		return RRule{}, nil, fmt.Errorf("%w: rrule: %v", ErrInvalid, err)
+	}
+	loc := time.UTC
+	if t.TimeZone != "" {
+		loc, err = time.LoadLocation(t.TimeZone)
+		if err != nil {
+			return RRule{}, nil, fmt.Errorf("%w: unknown time zone %q", ErrInvalid, t.TimeZone)
+		}
+	}
+	return rule, loc, nil
+}
+
+// validateRecurrence checks the recurrence fields before they are stored.
+// A recurring task needs a due date to anchor the series.
+func validateRecurrence(t Task) error {
+	if t.RRule == "" {
+		if t.TimeZone != "" {
+			if _, err := time.LoadLocation(t.TimeZone); err != nil {
+				return fmt.Errorf("%w: unknown time zone %q", ErrInvalid, t.TimeZone)
+			}
+		}
+		return nil
+	}
+	if t.DueAt == nil {
+		return fmt.Errorf("%w: a recurring task needs dueAt", ErrInvalid)
+	}
+	_, _, err := taskRule(t)
+	return err
+}
+
+// nextDue returns the due date of the occurrence after t, or false when the
+// series has ended.
+func nextDue(t Task) (time.Time, bool, error) {
+	if t.RRule == "" || t.DueAt == nil {
+		return time.Time{}, false, nil
+	}
+	rule, loc, err := taskRule(t)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	start := *t.DueAt
+	if t.RecurrenceStart != nil {
+		start = *t.RecurrenceStart
+	}
+	next, ok := rule.After(start.In(loc), *t.DueAt)
+	return next, ok, nil
+}
+
+func parseOccurrenceWindow(r *http.Request) (time.Time, time.Time, error) {
+	from := time.Now()
+	if val := r.URL.Query().Get("from"); val != "" {
+		t, err := time.Parse(time.RFC3339, val)
+		if err != nil {
+			return from, from, errors.New("invalid from value")
+		}
+		from = t
+	}
+	to := from.Add(defaultOccurrenceWindow)
+	if val := r.URL.Query().Get("to"); val != "" {
+		t, err := time.Parse(time.RFC3339, val)
+		if err != nil {
+			return from, to, errors.New("invalid to value")
+		}
+		to = t
+	}
+	if to.Before(from) {
+		return from, to, errors.New("to must not be before from")
+	}
+	return from, to, nil
+}
+
+// getOccurrencesHandler previews when a recurring task falls due between
+// from and to (RFC 3339, defaulting to the next 90 days).
+func getOccurrencesHandler(store TaskStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("ID"))
+		if err != nil || id <= 0 {
+			writeErr(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		from, to, err := parseOccurrenceWindow(r)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		task, err := store.GetTaskByID(r.Context(), id)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				writeErr(w, http.StatusNotFound, "not found")
+			} else {
+				writeErr(w, http.StatusInternalServerError, "internal error")
+			}
+			return
+		}
+		if task.RRule == "" || task.DueAt == nil {
+			writeErr(w, http.StatusBadRequest, "task is not recurring")
+			return
+		}
+
+		rule, loc, err := taskRule(task)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		start := *task.DueAt
+		if task.RecurrenceStart != nil {
+			start = *task.RecurrenceStart
+		}
+		occurrences := rule.Between(start.In(loc), from, to, maxOccurrences)
+		if occurrences == nil {
+			occurrences = []time.Time{}
+		}
+		writeJSON(w, http.StatusOK, occurrences)
+	}
+}
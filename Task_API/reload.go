@@ -0,0 +1,102 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LiveConfig is the part of the configuration that can change while the
+// server runs. Readers take a snapshot with currentLive; a reload swaps the
+// whole value at once, so a request never sees half of an update.
+type LiveConfig struct {
+	CORSOrigins []string
+	Auth        AuthConfig
+	RateLimit   RateLimitConfig
+}
+
+var live atomic.Pointer[LiveConfig]
+
+// currentLive returns the configuration in effect. Before any is set it
+// allows any origin and cannot sign tokens.
+func currentLive() *LiveConfig {
+	if lc := live.Load(); lc != nil {
+		return lc
+	}
+	return &LiveConfig{Auth: AuthConfig{TokenTTL: 2 * time.Hour}}
+}
+
+func liveConfigOf(cfg Config) *LiveConfig {
+	return &LiveConfig{CORSOrigins: cfg.CORS.Origins, Auth: cfg.Auth, RateLimit: cfg.RateLimit}
+}
+
+// reloadable are the settings a reload applies. Changes to any other
+// setting are reported but wait for a restart.
+var reloadable = []string{
+	"cors.origins",
+	"log.level",
+	"auth.jwt_secret",
+	"auth.previous_jwt_secrets",
+	"auth.token_ttl",
+	"ratelimit.per_minute",
+	"ratelimit.burst",
+}
+
+// Reloader re-reads the configuration from the same file, environment and
+// flags the server started with.
+type Reloader struct {
+	args []string
+
+	mu      sync.Mutex
+	current Config
+}
+
+func NewReloader(args []string, cfg Config) *Reloader {
+	return &Reloader{args: args, current: cfg}
+}
+
+// Reload loads the configuration again and applies what changed. An
+// invalid configuration is rejected as a whole and the old one stays in
+// effect.
+func (rl *Reloader) Reload() error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	next, err := LoadConfig(rl.args)
+	if err != nil {
+		slog.Error("config reload rejected", "err", err)
+		return err
+	}
+
+	var changed int
+	for _, s := range settings {
+		from, to := s.redacted(&rl.current), s.redacted(&next)
+		if s.format(&rl.current) == s.format(&next) {
+			continue
+		}
+		if !slices.Contains(reloadable, s.key) {
+			slog.Warn("config change needs a restart", "key", s.key, "from", from, "to", to)
+			continue
+		}
+		slog.Info("config changed", "key", s.key, "from", from, "to", to)
+		changed++
+	}
+
+	// Only the reloadable fields move forward, so a setting that still
+	// needs a restart is reported again on the next reload.
+	if next.LogLevel != rl.current.LogLevel {
+		// Left alone otherwise, so a level set through the admin endpoint
+		// survives reloads that do not touch it.
+		logLevel.Set(next.LogLevel)
+	}
+	rl.current.CORS = next.CORS
+	rl.current.LogLevel = next.LogLevel
+	rl.current.Auth = next.Auth
+	rl.current.RateLimit = next.RateLimit
+	live.Store(liveConfigOf(rl.current))
+
+	slog.Info("config reloaded", "changed", changed)
+	return nil
+}
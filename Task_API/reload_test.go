@@ -0,0 +1,192 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// withReloader starts a reloader on the config file at path, as main does,
+// and restores the live config and log level afterwards.
+func withReloader(t *testing.T, path string) *Reloader {
+	t.Helper()
+	oldLive, oldLevel := live.Load(), logLevel.Level()
+	t.Cleanup(func() {
+		live.Store(oldLive)
+		logLevel.Set(oldLevel)
+	})
+	args := []string{"-config", path}
+	cfg, err := LoadConfig(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logLevel.Set(cfg.LogLevel)
+	live.Store(liveConfigOf(cfg))
+	return NewReloader(args, cfg)
+}
+
+func corsAllows(origin string) bool {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Origin", origin)
+	rr := httptest.NewRecorder()
+	CORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, req)
+	return rr.Header().Get("Access-Control-Allow-Origin") == origin
+}
+
+func TestReload_AppliesChangesAndLogsDiff(t *testing.T) {
+	path := writeConfigFile(t, "taskapi.toml", `
+[auth]
+jwt_secret = "first"
+[cors]
+origins = ["https://a.example.com"]
+`)
+	rl := withReloader(t, path)
+	oldToken, err := GenerateJWT(demoUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !corsAllows("https://a.example.com") || corsAllows("https://b.example.com") {
+		t.Fatal("initial origins not applied")
+	}
+
+	if err := os.WriteFile(path, []byte(`
+log.level = "debug"
+[auth]
+jwt_secret = "second"
+previous_jwt_secrets = ["first"]
+token_ttl = "10m"
+[cors]
+origins = ["https://b.example.com"]
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	logs := captureLogs(t)
+	if err := rl.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if corsAllows("https://a.example.com") || !corsAllows("https://b.example.com") {
+		t.Error("origins not swapped")
+	}
+	if logLevel.Level() != slog.LevelDebug {
+		t.Errorf("log level = %v", logLevel.Level())
+	}
+	if auth := currentLive().Auth; auth.JWTSecret != "second" || auth.TokenTTL != 10*time.Minute {
+		t.Errorf("auth = %+v", auth)
+	}
+	newToken, _ := GenerateJWT(demoUserID)
+	for name, token := range map[string]string{"old": oldToken, "new": newToken} {
+		if id, err := userIDFromToken(token); err != nil || id != demoUserID {
+			t.Errorf("%s token: %d, %v", name, id, err)
+		}
+	}
+
+	changes := map[string][2]any{}
+	for _, l := range logs() {
+		if l["msg"] == "config changed" {
+			changes[l["key"].(string)] = [2]any{l["from"], l["to"]}
+		}
+	}
+	for key, want := range map[string][2]any{
+		"cors.origins":              {`["https://a.example.com"]`, `["https://b.example.com"]`},
+		"log.level":                 {`"info"`, `"debug"`},
+		"auth.jwt_secret":           {`"<redacted>"`, `"<redacted>"`},
+		"auth.previous_jwt_secrets": {`[]`, `["<redacted>"]`},
+		"auth.token_ttl":            {`"2h0m0s"`, `"10m0s"`},
+	} {
+		if changes[key] != want {
+			t.Errorf("%s logged as %v, want %v", key, changes[key], want)
+		}
+	}
+	if len(changes) != 5 {
+		t.Errorf("changes = %v", changes)
+	}
+}
+
+func TestReload_RejectsInvalidConfig(t *testing.T) {
+	path := writeConfigFile(t, "taskapi.toml", `
+[auth]
+jwt_secret = "kept"
+[cors]
+origins = ["https://a.example.com"]
+`)
+	rl := withReloader(t, path)
+	token, _ := GenerateJWT(demoUserID)
+
+	if err := os.WriteFile(path, []byte(`
+[auth]
+jwt_secret = "replaced"
+token_ttl = "forever"
+[cors]
+origins = ["https://b.example.com"]
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	logs := captureLogs(t)
+	if err := rl.Reload(); err == nil {
+		t.Fatal("invalid config accepted")
+	}
+
+	if line := findLog(logs(), "config reload rejected"); line == nil {
+		t.Error("rejection not logged")
+	}
+	if findLog(logs(), "config changed") != nil {
+		t.Error("changes logged for a rejected reload")
+	}
+	if !corsAllows("https://a.example.com") || corsAllows("https://b.example.com") {
+		t.Error("origins changed")
+	}
+	if _, err := userIDFromToken(token); err != nil {
+		t.Errorf("token signed before the rejected reload: %v", err)
+	}
+}
+
+func TestReload_RestartOnlySettings(t *testing.T) {
+	path := writeConfigFile(t, "taskapi.toml", "[auth]\njwt_secret = \"s\"\n[server]\naddr = \":8080\"\n")
+	rl := withReloader(t, path)
+
+	if err := os.WriteFile(path, []byte("[auth]\njwt_secret = \"s\"\n[server]\naddr = \":9090\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	logs := captureLogs(t)
+	for range 2 {
+		if err := rl.Reload(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var warnings int
+	for _, l := range logs() {
+		if l["msg"] == "config change needs a restart" && l["key"] == "server.addr" && l["to"] == `":9090"` {
+			warnings++
+		}
+	}
+	if warnings != 2 {
+		t.Errorf("got %d restart warnings, want one per reload", warnings)
+	}
+	if findLog(logs(), "config changed") != nil {
+		t.Error("restart-only setting reported as applied")
+	}
+}
+
+func TestReload_RejectsBlankSecret(t *testing.T) {
+	path := writeConfigFile(t, "taskapi.toml", "[auth]\njwt_secret = \"kept\"\n[ratelimit]\nper_minute = 60\n")
+	rl := withReloader(t, path)
+	token, _ := GenerateJWT(demoUserID)
+
+	if err := os.WriteFile(path, []byte("[ratelimit]\nper_minute = 600\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := rl.Reload(); err == nil {
+		t.Fatal("reload without a JWT secret accepted")
+	}
+	if _, err := userIDFromToken(token); err != nil {
+		t.Errorf("session lost to a rejected reload: %v", err)
+	}
+	if got := currentLive().RateLimit.PerMinute; got != 60 {
+		t.Errorf("rate limit = %d, want the old 60", got)
+	}
+}
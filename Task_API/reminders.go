@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ReminderScheduler polls the store for reminders whose fire time has
+// passed and hands them to a Notifier. State lives in SQLite, so a restart
+// picks up where the previous process stopped. Delivery is at most once: a
+// reminder is claimed before sending, and one whose sender stopped before
+// marking it sent or failed is given up after the lease rather than risk
+// sending it twice. A notifier error puts it back in the queue with
+// exponential backoff.
+type ReminderScheduler struct {
+	store       ReminderStore
+	notifier    Notifier
+	interval    time.Duration
+	lease       time.Duration
+	baseBackoff time.Duration
+	maxBackoff  time.Duration
+	now         func() time.Time
+}
+
+func NewReminderScheduler(store ReminderStore, notifier Notifier) *ReminderScheduler {
+	return &ReminderScheduler{
+		store:       store,
+		notifier:    notifier,
+		interval:    30 * time.Second,
+		lease:       5 * time.Minute,
+		baseBackoff: time.Minute,
+		maxBackoff:  time.Hour,
+		now:         time.Now,
+	}
+}
+
+// Run polls until ctx is cancelled.
+func (s *ReminderScheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.tick(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *ReminderScheduler) tick(ctx context.Context) {
+	now := s.now()
+	if err := s.store.AbandonReminders(ctx, now.Add(-s.lease)); err != nil {
+		loggerFrom(ctx).Error("abandon stale reminders failed", "error", err)
+	}
+	due, err := s.store.DueReminders(ctx, now)
+	if err != nil {
+		loggerFrom(ctx).Error("load due reminders failed", "error", err)
+		return
+	}
+
+	for _, d := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		ok, err := s.store.ClaimReminder(ctx, d.Reminder.ID, now)
+		if err != nil {
+			loggerFrom(ctx).Error("claim reminder failed", "reminder_id", d.Reminder.ID, "error", err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		n := Notification{
+			Kind:    NotificationReminder,
+			UserID:  d.Reminder.UserID,
+			Task:    d.Task,
+			Message: reminderMessage(d.Task, now),
+		}
+		if err := s.notifier.Notify(ctx, n); err != nil {
+			attempts := d.Attempts + 1
+			retryAt := now.Add(backoffDelay(s.baseBackoff, s.maxBackoff, attempts))
+			loggerFrom(ctx).Warn("deliver reminder failed", "reminder_id", d.Reminder.ID, "attempt", attempts, "error", err)
+			if err := s.store.MarkReminderFailed(ctx, d.Reminder.ID, err.Error(), retryAt); err != nil {
+				loggerFrom(ctx).Error("mark reminder failed", "reminder_id", d.Reminder.ID, "error", err)
+			}
+			continue
+		}
+		if err := s.store.MarkReminderSent(ctx, d.Reminder.ID, s.now()); err != nil {
+			loggerFrom(ctx).Error("mark reminder sent failed", "reminder_id", d.Reminder.ID, "error", err)
+		}
+	}
+
+	s.notifyOverdue(ctx, now)
+}
+
+// notifyOverdue tells each assignee once per due date that a task is late.
+// A task is only marked notified when every assignee was reached, so a
+// failed delivery is retried on the next tick.
+func (s *ReminderScheduler) notifyOverdue(ctx context.Context, now time.Time) {
+	overdue, err := s.store.OverdueTasks(ctx, now)
+	if err != nil {
+		loggerFrom(ctx).Error("load overdue tasks failed", "error", err)
+		return
+	}
+
+	for _, task := range overdue {
+		if ctx.Err() != nil {
+			return
+		}
+		delivered := true
+		for _, userID := range task.Assignees {
+			n := Notification{
+				Kind:    NotificationOverdue,
+				UserID:  userID,
+				Task:    task,
+				Message: fmt.Sprintf("%q was due %s ago", task.Title, now.Sub(*task.DueAt).Round(time.Minute)),
+			}
+			if err := s.notifier.Notify(ctx, n); err != nil {
+				loggerFrom(ctx).Warn("overdue notice failed", "task_id", task.ID, "user_id", userID, "error", err)
+				delivered = false
+			}
+		}
+		if !delivered {
+			continue
+		}
+		if err := s.store.MarkOverdueNotified(ctx, task.ID, *task.DueAt); err != nil {
+			loggerFrom(ctx).Error("mark overdue notified failed", "task_id", task.ID, "error", err)
+		}
+	}
+}
+
+func reminderMessage(t Task, now time.Time) string {
+	if t.DueAt == nil {
+		return fmt.Sprintf("Reminder: %q", t.Title)
+	}
+	left := t.DueAt.Sub(now).Round(time.Minute)
+	if left <= 0 {
+		return fmt.Sprintf("%q is due now", t.Title)
+	}
+	return fmt.Sprintf("%q is due in %s", t.Title, left)
+}
+
+type reminderReq struct {
+	Before string `json:"before"`
+	UserID int    `json:"userId"`
+}
+
+// postReminderHandler adds a reminder such as {"before":"1h"}. It defaults
+// to reminding the caller.
+func postReminderHandler(store ReminderStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		taskID, err := strconv.Atoi(r.PathValue("ID"))
+		if err != nil || taskID <= 0 {
+			writeErr(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		var req reminderReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeErr(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		if req.UserID == 0 {
+			req.UserID = getUserID(r)
+		}
+
+		rem := Reminder{TaskID: taskID, UserID: req.UserID, Before: req.Before}
+		if err := store.CreateReminder(r.Context(), &rem); err != nil {
+			switch {
+			case errors.Is(err, ErrInvalid):
+				writeErr(w, http.StatusBadRequest, err.Error())
+			case errors.Is(err, ErrNotFound):
+				writeErr(w, http.StatusNotFound, "not found")
+			default:
+				writeErr(w, http.StatusInternalServerError, "internal error")
+			}
+			return
+		}
+		writeJSON(w, http.StatusCreated, rem)
+	}
+}
+
+func listRemindersHandler(store ReminderStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		taskID, err := strconv.Atoi(r.PathValue("ID"))
+		if err != nil || taskID <= 0 {
+			writeErr(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		list, err := store.ListReminders(r.Context(), taskID)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				writeErr(w, http.StatusNotFound, "not found")
+			} else {
+				writeErr(w, http.StatusInternalServerError, "internal error")
+			}
+			return
+		}
+		writeJSON(w, http.StatusOK, list)
+	}
+}
+
+func deleteReminderHandler(store ReminderStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		taskID, err := strconv.Atoi(r.PathValue("ID"))
+		if err != nil || taskID <= 0 {
+			writeErr(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		reminderID, err := strconv.Atoi(r.PathValue("ReminderID"))
+		if err != nil || reminderID <= 0 {
+			writeErr(w, http.StatusBadRequest, "invalid reminder id")
+			return
+		}
+
+		rem, err := store.GetReminder(r.Context(), reminderID)
+		if err == nil && rem.TaskID != taskID {
+			err = ErrNotFound
+		}
+		if err == nil {
+			err = store.DeleteReminder(r.Context(), reminderID)
+		}
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				writeErr(w, http.StatusNotFound, "not found")
+			} else {
+				writeErr(w, http.StatusInternalServerError, "internal error")
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
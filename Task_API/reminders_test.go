@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type recordingNotifier struct {
+	sent []Notification
+}
+
+func (n *recordingNotifier) Notify(_ context.Context, note Notification) error {
+	n.sent = append(n.sent, note)
+	return nil
+}
+
+// failingNotifier fails the first fails notifications it is given.
+type failingNotifier struct {
+	recordingNotifier
+	fails int
+}
+
+func (n *failingNotifier) Notify(ctx context.Context, note Notification) error {
+	if n.fails > 0 {
+		n.fails--
+		return errors.New("smtp down")
+	}
+	return n.recordingNotifier.Notify(ctx, note)
+}
+
+func TestReminderScheduler_DeliversOnceAcrossRestarts(t *testing.T) {
+	s := newTestStore(t)
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	due := now.Add(30 * time.Minute)
+	task := Task{Title: "Rotate on-call", DueAt: &due}
+	if err := s.CreateTask(t.Context(), &task); err != nil {
+		t.Fatal(err)
+	}
+	early := Reminder{TaskID: task.ID, UserID: demoUserID, Before: "1h"}
+	later := Reminder{TaskID: task.ID, UserID: demoUserID, Before: "10m"}
+	for _, rem := range []*Reminder{&early, &later} {
+		if err := s.CreateReminder(t.Context(), rem); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	notifier := &recordingNotifier{}
+	sched := NewReminderScheduler(s, notifier)
+	sched.now = func() time.Time { return now }
+	sched.tick(context.Background())
+	sched.tick(context.Background())
+
+	if len(notifier.sent) != 1 {
+		t.Fatalf("expected only the 1h reminder to fire once, got %d", len(notifier.sent))
+	}
+
+	// A fresh scheduler stands in for a restarted process.
+	restarted := NewReminderScheduler(s, notifier)
+	restarted.now = func() time.Time { return now.Add(25 * time.Minute) }
+	restarted.tick(context.Background())
+
+	if len(notifier.sent) != 2 {
+		t.Fatalf("expected the 10m reminder after restart, got %d deliveries", len(notifier.sent))
+	}
+	got, err := s.GetReminder(t.Context(), early.ID)
+	if err != nil || got.Status != ReminderSent {
+		t.Fatalf("expected early reminder marked sent, got %+v (%v)", got, err)
+	}
+}
+
+func TestReminderScheduler_StopsOnCancel(t *testing.T) {
+	sched := NewReminderScheduler(newTestStore(t), &recordingNotifier{})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		sched.Run(ctx)
+		close(done)
+	}()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("scheduler did not stop after cancel")
+	}
+}
+
+func TestReminderScheduler_InterruptedSendIsNotRepeated(t *testing.T) {
+	s := newTestStore(t)
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	due := now.Add(30 * time.Minute)
+	task := Task{Title: "Ship release", DueAt: &due}
+	if err := s.CreateTask(t.Context(), &task); err != nil {
+		t.Fatal(err)
+	}
+	rem := Reminder{TaskID: task.ID, UserID: demoUserID, Before: "1h"}
+	if err := s.CreateReminder(t.Context(), &rem); err != nil {
+		t.Fatal(err)
+	}
+	// A process claimed it and died before recording the outcome.
+	if ok, err := s.ClaimReminder(t.Context(), rem.ID, now); err != nil || !ok {
+		t.Fatalf("claim: %v %v", ok, err)
+	}
+
+	notifier := &recordingNotifier{}
+	sched := NewReminderScheduler(s, notifier)
+	sched.now = func() time.Time { return now.Add(time.Minute) }
+	sched.tick(context.Background())
+	sched.now = func() time.Time { return now.Add(sched.lease + time.Minute) }
+	sched.tick(context.Background())
+
+	if len(notifier.sent) != 0 {
+		t.Fatalf("interrupted reminder sent again: %+v", notifier.sent)
+	}
+	got, err := s.GetReminder(t.Context(), rem.ID)
+	if err != nil || got.Status != ReminderFailed {
+		t.Fatalf("expected the interrupted reminder to be failed, got %+v (%v)", got, err)
+	}
+}
+
+func TestReminderScheduler_RetriesWithBackoff(t *testing.T) {
+	s := newTestStore(t)
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	due := now.Add(30 * time.Minute)
+	task := Task{Title: "Renew domain", DueAt: &due}
+	if err := s.CreateTask(t.Context(), &task); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateReminder(t.Context(), &Reminder{TaskID: task.ID, UserID: demoUserID, Before: "1h"}); err != nil {
+		t.Fatal(err)
+	}
+
+	notifier := &failingNotifier{fails: 2}
+	sched := NewReminderScheduler(s, notifier)
+	at := func(d time.Duration) {
+		sched.now = func() time.Time { return now.Add(d) }
+		sched.tick(context.Background())
+	}
+	at(0)                // attempt 1 fails, retry in 1m
+	at(30 * time.Second) // still backing off
+	at(time.Minute)      // attempt 2 fails, retry in 2m
+	at(2 * time.Minute)  // still backing off
+	if notifier.fails != 0 || len(notifier.sent) != 0 {
+		t.Fatalf("retried too early: %d failures left, sent %d", notifier.fails, len(notifier.sent))
+	}
+	at(3 * time.Minute)
+	if len(notifier.sent) != 1 {
+		t.Fatalf("expected delivery after backing off, got %d", len(notifier.sent))
+	}
+}
+
+func TestSQLiteStore_DueRemindersLoadsTasks(t *testing.T) {
+	s := newTestStore(t)
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	due := now.Add(time.Hour)
+	task := Task{Title: "Renew cert", DueAt: &due, Assignees: []int{demoUserID}}
+	if err := s.CreateTask(t.Context(), &task); err != nil {
+		t.Fatal(err)
+	}
+	for _, before := range []string{"2h", "30m"} {
+		if err := s.CreateReminder(t.Context(), &Reminder{TaskID: task.ID, UserID: demoUserID, Before: before}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := s.DueReminders(t.Context(), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Reminder.Before != "2h0m0s" || got[0].Reminder.TaskID != task.ID {
+		t.Fatalf("due = %+v", got)
+	}
+	if got[0].Task.Title != "Renew cert" || len(got[0].Task.Assignees) != 1 {
+		t.Errorf("task = %+v", got[0].Task)
+	}
+}
+
+func TestSQLiteStore_CreateReminderRejectsUnknownUser(t *testing.T) {
+	s := newTestStore(t)
+	task := Task{Title: "t"}
+	if err := s.CreateTask(t.Context(), &task); err != nil {
+		t.Fatal(err)
+	}
+	err := s.CreateReminder(t.Context(), &Reminder{TaskID: task.ID, UserID: 9999, Before: "1h"})
+	if !errors.Is(err, ErrInvalid) {
+		t.Fatalf("expected ErrInvalid, got %v", err)
+	}
+}
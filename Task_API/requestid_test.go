@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestRequestID_KeptGeneratedAndInErrors(t *testing.T) {
+	s := newTestStore(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /tasks/{ID}", getTaskByIDHandler(s))
+	h := Chain(mux, RequestID, Logging, Recover)
+
+	get := func(id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/tasks/999", nil)
+		if id != "" {
+			req.Header.Set(requestIDHeader, id)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if got := get("client-abc.123").Header().Get(requestIDHeader); got != "client-abc.123" {
+		t.Errorf("client ID replaced with %q", got)
+	}
+	for _, sent := range []string{"", "has space", strings.Repeat("x", maxRequestIDLen+1)} {
+		got := get(sent).Header().Get(requestIDHeader)
+		if _, err := uuid.Parse(got); err != nil {
+			t.Errorf("sent %q: got ID %q, want a generated UUID", sent, got)
+		}
+	}
+
+	rr := get("trace-me")
+	var body errResp
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if rr.Code != http.StatusNotFound || body.RequestID != "trace-me" {
+		t.Errorf("status %d, error body %+v", rr.Code, body)
+	}
+}
+
+func TestRequestID_ReachesWebhooksAndNotifications(t *testing.T) {
+	s := newTestStore(t)
+	rcv := &webhookReceiver{}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+	if err := s.CreateWebhook(t.Context(), &Webhook{URL: srv.URL, Secret: "shh", Events: []string{EventTaskCreated}}); err != nil {
+		t.Fatal(err)
+	}
+	alice := User{Name: "Alice"}
+	if err := s.CreateUser(t.Context(), &alice); err != nil {
+		t.Fatal(err)
+	}
+
+	events := NewEventBus()
+	SubscribeWebhooks(events, s)
+	notifier := &recordingNotifier{}
+	SubscribeNotifications(events, s, s, notifier)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /tasks", postTaskHandler(s, s, events))
+	body := `{"title":"Ship it","assignees":[` + strconv.Itoa(alice.ID) + `]}`
+	req := withUser(httptest.NewRequest(http.MethodPost, "/tasks", strings.NewReader(body)), demoUserID)
+	req.Header.Set(requestIDHeader, "req-42")
+	rr := httptest.NewRecorder()
+	Chain(mux, RequestID, Logging).ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create: status %d: %s", rr.Code, rr.Body)
+	}
+
+	d := NewWebhookDispatcher(s)
+	d.now = func() time.Time { return time.Now().Add(time.Second) }
+	d.tick(context.Background())
+	if rcv.count() != 1 {
+		t.Fatalf("got %d deliveries", rcv.count())
+	}
+	if got := rcv.headers[0].Get(requestIDHeader); got != "req-42" {
+		t.Errorf("webhook %s = %q", requestIDHeader, got)
+	}
+	var e Event
+	if err := json.Unmarshal(rcv.bodies[0], &e); err != nil || e.RequestID != "req-42" {
+		t.Errorf("webhook payload requestId = %q (%v)", e.RequestID, err)
+	}
+
+	if len(notifier.sent) != 1 || notifier.sent[0].RequestID != "req-42" {
+		t.Fatalf("notifications = %+v", notifier.sent)
+	}
+	msg, err := buildMIME(&mail.Address{Address: "tasks@example.com"}, &mail.Address{Address: "a@example.com"}, EmailMessage{RequestID: "req-42"})
+	if mime := string(msg); err != nil || !strings.Contains(mime, "\r\nX-Request-ID: req-42\r\n") {
+		t.Errorf("email headers lack the request ID (%v):\n%s", err, mime)
+	}
+}
+
+func TestCORS_PreflightAllowsRequestHeaders(t *testing.T) {
+	req := httptest.NewRequest(http.MethodOptions, "/tasks", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Headers", "x-request-id,idempotency-key,traceparent")
+	rr := httptest.NewRecorder()
+	CORS(http.NotFoundHandler()).ServeHTTP(rr, req)
+
+	allowed := strings.Split(strings.ToLower(rr.Header().Get("Access-Control-Allow-Headers")), ",")
+	for _, h := range []string{"x-request-id", "idempotency-key", "traceparent"} {
+		if !slices.Contains(allowed, h) {
+			t.Errorf("preflight does not allow %s: %v", h, allowed)
+		}
+	}
+}
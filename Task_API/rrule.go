@@ -0,0 +1,423 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RRule is the subset of an RFC 5545 recurrence rule the API supports:
+// FREQ, INTERVAL, COUNT, UNTIL, BYDAY, BYMONTHDAY, BYMONTH and WKST.
+// Occurrences keep the wall-clock time of DTSTART in its location, so a
+// 09:00 task stays at 09:00 across DST changes.
+type RRule struct {
+	Freq     string
+	Interval int
+	Count    int
+	Until    time.Time
+	// untilFloating marks an UNTIL without a trailing Z, which RFC 5545
+	// reads as wall-clock time in DTSTART's location. A date-only UNTIL
+	// includes the whole day.
+	untilFloating bool
+	untilDate     bool
+	ByDay         []rruleDay
+	ByMonthDay    []int
+	ByMonth       []time.Month
+	WeekStart     time.Weekday
+}
+
+type rruleDay struct {
+	N   int // 0 means every such weekday in the period
+	Day time.Weekday
+}
+
+var rruleWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// maxRRuleIterations bounds how many periods expansion walks through, so a
+// rule that never matches (for example BYMONTHDAY=31;BYMONTH=2) terminates.
+const maxRRuleIterations = 100000
+
+func ParseRRule(s string) (RRule, error) {
+	r := RRule{Interval: 1, WeekStart: time.Monday}
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	if s == "" {
+		return r, fmt.Errorf("empty rule")
+	}
+
+	for _, part := range strings.Split(s, ";") {
+		key, val, ok := strings.Cut(part, "=")
+		if !ok || val == "" {
+			return r, fmt.Errorf("malformed part %q", part)
+		}
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			switch v := strings.ToUpper(val); v {
+			case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+				r.Freq = v
+			default:
+				return r, fmt.Errorf("unsupported FREQ %q", val)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return r, fmt.Errorf("invalid INTERVAL %q", val)
+			}
+			r.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return r, fmt.Errorf("invalid COUNT %q", val)
+			}
+			r.Count = n
+		case "UNTIL":
+			t, err := parseRRuleTime(val)
+			if err != nil {
+				return r, fmt.Errorf("invalid UNTIL %q", val)
+			}
+			r.Until = t
+			r.untilFloating = !strings.HasSuffix(val, "Z")
+			r.untilDate = len(val) == 8
+		case "BYDAY":
+			for _, d := range strings.Split(val, ",") {
+				d = strings.ToUpper(d)
+				if len(d) < 2 {
+					return r, fmt.Errorf("invalid BYDAY %q", d)
+				}
+				wd, ok := rruleWeekdays[d[len(d)-2:]]
+				if !ok {
+					return r, fmt.Errorf("invalid BYDAY %q", d)
+				}
+				n := 0
+				if prefix := d[:len(d)-2]; prefix != "" {
+					var err error
+					n, err = strconv.Atoi(prefix)
+					if err != nil || n == 0 || n < -53 || n > 53 {
+						return r, fmt.Errorf("invalid BYDAY %q", d)
+					}
+				}
+				r.ByDay = append(r.ByDay, rruleDay{N: n, Day: wd})
+			}
+		case "BYMONTHDAY":
+			for _, d := range strings.Split(val, ",") {
+				n, err := strconv.Atoi(d)
+				if err != nil || n == 0 || n < -31 || n > 31 {
+					return r, fmt.Errorf("invalid BYMONTHDAY %q", d)
+				}
+				r.ByMonthDay = append(r.ByMonthDay, n)
+			}
+		case "BYMONTH":
+			for _, m := range strings.Split(val, ",") {
+				n, err := strconv.Atoi(m)
+				if err != nil || n < 1 || n > 12 {
+					return r, fmt.Errorf("invalid BYMONTH %q", m)
+				}
+				r.ByMonth = append(r.ByMonth, time.Month(n))
+			}
+		case "WKST":
+			wd, ok := rruleWeekdays[strings.ToUpper(val)]
+			if !ok {
+				return r, fmt.Errorf("invalid WKST %q", val)
+			}
+			r.WeekStart = wd
+		default:
+			return r, fmt.Errorf("unsupported rule part %q", key)
+		}
+	}
+
+	if r.Freq == "" {
+		return r, fmt.Errorf("FREQ is required")
+	}
+	if r.Count > 0 && !r.Until.IsZero() {
+		return r, fmt.Errorf("COUNT and UNTIL are mutually exclusive")
+	}
+	for _, d := range r.ByDay {
+		if d.N != 0 && r.Freq != "MONTHLY" && r.Freq != "YEARLY" {
+			return r, fmt.Errorf("numbered BYDAY needs FREQ=MONTHLY or YEARLY")
+		}
+	}
+	return r, nil
+}
+
+func parseRRuleTime(v string) (time.Time, error) {
+	for _, layout := range []string{"20060102T150405Z", "20060102T150405", "20060102"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("bad time")
+}
+
+// Between returns the occurrences of the rule starting at dtstart that fall
+// in [from, to], up to limit results. dtstart's location decides the wall
+// clock used for every occurrence.
+func (r RRule) Between(dtstart, from, to time.Time, limit int) []time.Time {
+	var out []time.Time
+	r.each(dtstart, func(t time.Time) bool {
+		if t.After(to) || len(out) >= limit {
+			return false
+		}
+		if !t.Before(from) {
+			out = append(out, t)
+		}
+		return true
+	})
+	return out
+}
+
+// After returns the first occurrence strictly after t.
+func (r RRule) After(dtstart, t time.Time) (time.Time, bool) {
+	var next time.Time
+	found := false
+	r.each(dtstart, func(occ time.Time) bool {
+		if occ.After(t) {
+			next, found = occ, true
+			return false
+		}
+		return true
+	})
+	return next, found
+}
+
+// each walks occurrences in order, starting with dtstart itself, until fn
+// returns false or the rule is exhausted.
+func (r RRule) each(dtstart time.Time, fn func(time.Time) bool) {
+	loc := dtstart.Location()
+	hh, mm, ss := dtstart.Clock()
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, hh, mm, ss, 0, loc)
+	}
+
+	until := r.Until
+	if r.untilFloating {
+		y, m, d := until.Date()
+		uh, um, us := until.Clock()
+		if r.untilDate {
+			uh, um, us = 23, 59, 59
+		}
+		until = time.Date(y, m, d, uh, um, us, 0, loc)
+	}
+
+	emitted := 0
+	for i := 0; i < maxRRuleIterations; i++ {
+		var days []time.Time
+		switch r.Freq {
+		case "DAILY":
+			d := dtstart.AddDate(0, 0, i*r.Interval)
+			if r.matchesFilters(d) {
+				days = append(days, at(d.Date()))
+			}
+		case "WEEKLY":
+			offset := (int(dtstart.Weekday()) - int(r.WeekStart) + 7) % 7
+			weekStart := dtstart.AddDate(0, 0, -offset+7*i*r.Interval)
+			for k := 0; k < 7; k++ {
+				d := weekStart.AddDate(0, 0, k)
+				if r.matchesWeekly(d, dtstart) && r.inMonths(d.Month()) {
+					days = append(days, at(d.Date()))
+				}
+			}
+		case "MONTHLY":
+			y, m, _ := dtstart.Date()
+			first := time.Date(y, m+time.Month(i*r.Interval), 1, 0, 0, 0, 0, loc)
+			if r.inMonths(first.Month()) {
+				days = r.monthDays(first, dtstart, at)
+			}
+		case "YEARLY":
+			year := dtstart.Year() + i*r.Interval
+			if len(r.ByMonth) == 0 && (len(r.ByDay) > 0 || len(r.ByMonthDay) > 0) {
+				days = r.yearDays(year, at)
+				break
+			}
+			months := r.ByMonth
+			if len(months) == 0 {
+				months = []time.Month{dtstart.Month()}
+			}
+			for _, m := range months {
+				days = append(days, r.monthDays(time.Date(year, m, 1, 0, 0, 0, 0, loc), dtstart, at)...)
+			}
+		}
+		// Overlapping parts, such as BYDAY=MO,1MO, select a day only once.
+		sort.Slice(days, func(a, b int) bool { return days[a].Before(days[b]) })
+		days = slices.CompactFunc(days, time.Time.Equal)
+
+		for _, d := range days {
+			if d.Before(dtstart) {
+				continue
+			}
+			if !until.IsZero() && d.After(until) {
+				return
+			}
+			if r.Count > 0 && emitted >= r.Count {
+				return
+			}
+			emitted++
+			if !fn(d) {
+				return
+			}
+		}
+	}
+}
+
+func (r RRule) inMonths(m time.Month) bool {
+	if len(r.ByMonth) == 0 {
+		return true
+	}
+	for _, bm := range r.ByMonth {
+		if bm == m {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesFilters applies BYMONTH, BYMONTHDAY and BYDAY as filters, which is
+// how they behave for FREQ=DAILY.
+func (r RRule) matchesFilters(d time.Time) bool {
+	if !r.inMonths(d.Month()) {
+		return false
+	}
+	if len(r.ByMonthDay) > 0 && !matchesMonthDay(r.ByMonthDay, d) {
+		return false
+	}
+	if len(r.ByDay) > 0 {
+		for _, bd := range r.ByDay {
+			if bd.Day == d.Weekday() {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func (r RRule) matchesWeekly(d, dtstart time.Time) bool {
+	if len(r.ByDay) == 0 {
+		return d.Weekday() == dtstart.Weekday()
+	}
+	for _, bd := range r.ByDay {
+		if bd.Day == d.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+// monthDays expands one month for MONTHLY and YEARLY rules. Without BYDAY
+// or BYMONTHDAY it uses DTSTART's day of month and skips months that are
+// too short, as RFC 5545 requires.
+func (r RRule) monthDays(first, dtstart time.Time, at func(int, time.Month, int) time.Time) []time.Time {
+	y, m := first.Year(), first.Month()
+	last := daysIn(y, m)
+
+	var out []time.Time
+	switch {
+	case len(r.ByDay) > 0:
+		for _, bd := range r.ByDay {
+			for _, d := range weekdaysIn(bd, time.Date(y, m, 1, 0, 0, 0, 0, time.UTC), last) {
+				out = append(out, at(d.Date()))
+			}
+		}
+		if len(r.ByMonthDay) > 0 {
+			filtered := out[:0]
+			for _, t := range out {
+				if matchesMonthDay(r.ByMonthDay, t) {
+					filtered = append(filtered, t)
+				}
+			}
+			out = filtered
+		}
+	case len(r.ByMonthDay) > 0:
+		for _, md := range r.ByMonthDay {
+			if d, ok := monthDay(md, last); ok {
+				out = append(out, at(y, m, d))
+			}
+		}
+	default:
+		if d := dtstart.Day(); d <= last {
+			out = append(out, at(y, m, d))
+		}
+	}
+	return out
+}
+
+// yearDays expands a YEARLY rule without BYMONTH across the whole year:
+// BYMONTHDAY applies to every month and a numbered BYDAY counts from the
+// start or end of the year. Given both, BYDAY limits the BYMONTHDAY days.
+func (r RRule) yearDays(year int, at func(int, time.Month, int) time.Time) []time.Time {
+	jan1 := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	length := time.Date(year, time.December, 31, 0, 0, 0, 0, time.UTC).YearDay()
+	var byDay []time.Time
+	for _, bd := range r.ByDay {
+		byDay = append(byDay, weekdaysIn(bd, jan1, length)...)
+	}
+
+	var out []time.Time
+	if len(r.ByMonthDay) == 0 {
+		for _, d := range byDay {
+			out = append(out, at(d.Date()))
+		}
+		return out
+	}
+	for m := time.January; m <= time.December; m++ {
+		for _, md := range r.ByMonthDay {
+			d, ok := monthDay(md, daysIn(year, m))
+			if !ok {
+				continue
+			}
+			if len(r.ByDay) > 0 && !slices.ContainsFunc(byDay, time.Date(year, m, d, 0, 0, 0, 0, time.UTC).Equal) {
+				continue
+			}
+			out = append(out, at(year, m, d))
+		}
+	}
+	return out
+}
+
+// weekdaysIn returns the days among the length days from first that bd
+// selects: every such weekday, or only the Nth from the start or end.
+func weekdaysIn(bd rruleDay, first time.Time, length int) []time.Time {
+	var matches []time.Time
+	for i := 0; i < length; i++ {
+		if d := first.AddDate(0, 0, i); d.Weekday() == bd.Day {
+			matches = append(matches, d)
+		}
+	}
+	switch {
+	case bd.N == 0:
+		return matches
+	case bd.N > 0 && bd.N <= len(matches):
+		return matches[bd.N-1 : bd.N]
+	case bd.N < 0 && -bd.N <= len(matches):
+		return matches[len(matches)+bd.N : len(matches)+bd.N+1]
+	}
+	return nil
+}
+
+// monthDay resolves a BYMONTHDAY value, which counts back from the end of
+// the month when negative, in a month of last days.
+func monthDay(md, last int) (int, bool) {
+	d := md
+	if md < 0 {
+		d = last + md + 1
+	}
+	return d, d >= 1 && d <= last
+}
+
+func matchesMonthDay(list []int, t time.Time) bool {
+	last := daysIn(t.Year(), t.Month())
+	for _, md := range list {
+		if md == t.Day() || (md < 0 && last+md+1 == t.Day()) {
+			return true
+		}
+	}
+	return false
+}
+
+func daysIn(y int, m time.Month) int {
+	return time.Date(y, m+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
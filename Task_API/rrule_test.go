@@ -0,0 +1,127 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func mustRule(t *testing.T, s string) RRule {
+	t.Helper()
+	r, err := ParseRRule(s)
+	if err != nil {
+		t.Fatalf("parse %q: %v", s, err)
+	}
+	return r
+}
+
+func formatAll(ts []time.Time) []string {
+	out := make([]string, len(ts))
+	for i, t := range ts {
+		out[i] = t.Format(time.RFC3339)
+	}
+	return out
+}
+
+func TestRRule_Expansion(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("tzdata unavailable: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		rule  string
+		start time.Time
+		want  []string
+	}{
+		{
+			name:  "weekly keeps wall clock across DST",
+			rule:  "FREQ=WEEKLY;COUNT=3",
+			start: time.Date(2026, 3, 2, 9, 0, 0, 0, ny),
+			want:  []string{"2026-03-02T09:00:00-05:00", "2026-03-09T09:00:00-04:00", "2026-03-16T09:00:00-04:00"},
+		},
+		{
+			name:  "last friday of the month",
+			rule:  "FREQ=MONTHLY;BYDAY=-1FR;COUNT=3",
+			start: time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC),
+			want:  []string{"2026-01-30T10:00:00Z", "2026-02-27T10:00:00Z", "2026-03-27T10:00:00Z"},
+		},
+		{
+			name:  "monthly on the 31st skips short months",
+			rule:  "FREQ=MONTHLY;COUNT=3",
+			start: time.Date(2026, 1, 31, 8, 0, 0, 0, time.UTC),
+			want:  []string{"2026-01-31T08:00:00Z", "2026-03-31T08:00:00Z", "2026-05-31T08:00:00Z"},
+		},
+		{
+			name:  "every other week on tuesday and thursday until a date",
+			rule:  "FREQ=WEEKLY;INTERVAL=2;BYDAY=TU,TH;UNTIL=20260115",
+			start: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC),
+			want:  []string{"2026-01-01T12:00:00Z", "2026-01-13T12:00:00Z", "2026-01-15T12:00:00Z"},
+		},
+		{
+			name:  "yearly in march and september on the first",
+			rule:  "FREQ=YEARLY;BYMONTH=3,9;BYMONTHDAY=1;COUNT=3",
+			start: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			want:  []string{"2026-03-01T00:00:00Z", "2026-09-01T00:00:00Z", "2027-03-01T00:00:00Z"},
+		},
+		{
+			name:  "yearly on the first monday of the year",
+			rule:  "FREQ=YEARLY;BYDAY=1MO;COUNT=3",
+			start: time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC),
+			want:  []string{"2026-01-05T09:00:00Z", "2027-01-04T09:00:00Z", "2028-01-03T09:00:00Z"},
+		},
+		{
+			name:  "yearly on the 15th spans every month",
+			rule:  "FREQ=YEARLY;BYMONTHDAY=15;COUNT=3",
+			start: time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC),
+			want:  []string{"2026-01-15T09:00:00Z", "2026-02-15T09:00:00Z", "2026-03-15T09:00:00Z"},
+		},
+		{
+			name:  "overlapping month days count once",
+			rule:  "FREQ=MONTHLY;BYMONTHDAY=1,-31;COUNT=3",
+			start: time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC),
+			want:  []string{"2026-01-01T09:00:00Z", "2026-02-01T09:00:00Z", "2026-03-01T09:00:00Z"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := mustRule(t, tt.rule)
+			got := formatAll(r.Between(tt.start, tt.start, tt.start.AddDate(5, 0, 0), 10))
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("expected %v, got %v", tt.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRRule_After(t *testing.T) {
+	r := mustRule(t, "FREQ=DAILY;INTERVAL=3")
+	start := time.Date(2026, 5, 1, 7, 30, 0, 0, time.UTC)
+
+	next, ok := r.After(start, start.AddDate(0, 0, 4))
+
+	if !ok || !next.Equal(time.Date(2026, 5, 7, 7, 30, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected next occurrence %v (%v)", next, ok)
+	}
+}
+
+func TestParseRRule_Rejects(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"INTERVAL=2",
+		"FREQ=HOURLY",
+		"FREQ=DAILY;BYHOUR=9",
+		"FREQ=WEEKLY;BYDAY=2MO",
+		"FREQ=DAILY;COUNT=2;UNTIL=20260101",
+	} {
+		if _, err := ParseRRule(s); err == nil {
+			t.Errorf("expected %q to be rejected", s)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	StartTLSNone     = "none"
+	StartTLSOptional = "optional"
+	StartTLSRequired = "required"
+)
+
+// SMTPConfig describes the outgoing mail server. An empty Host disables
+// email delivery.
+type SMTPConfig struct {
+	Host               string
+	Port               int
+	Username           string
+	Password           string
+	From               string
+	StartTLS           string
+	InsecureSkipVerify bool
+	Timeout            time.Duration
+}
+
+func (c SMTPConfig) Enabled() bool {
+	return c.Host != ""
+}
+
+// EmailMessage is a rendered email with both a plain-text and an HTML part.
+type EmailMessage struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	// RequestID goes out as an X-Request-ID header when set.
+	RequestID string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg EmailMessage) error
+}
+
+type SMTPMailer struct {
+	cfg SMTPConfig
+}
+
+func NewSMTPMailer(cfg SMTPConfig) *SMTPMailer {
+	return &SMTPMailer{cfg: cfg}
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg EmailMessage) error {
+	from, err := parseMailbox(m.cfg.From)
+	if err != nil {
+		return fmt.Errorf("smtp: sender: %w", err)
+	}
+	to, err := parseMailbox(msg.To)
+	if err != nil {
+		return fmt.Errorf("smtp: recipient: %w", err)
+	}
+	body, err := buildMIME(from, to, msg)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(m.cfg.Host, strconv.Itoa(m.cfg.Port))
+	dialer := net.Dialer{Timeout: m.cfg.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else if m.cfg.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(m.cfg.Timeout))
+	}
+
+	c, err := smtp.NewClient(conn, m.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if err := c.Hello("localhost"); err != nil {
+		return err
+	}
+	if m.cfg.StartTLS != StartTLSNone {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			tlsCfg := &tls.Config{ServerName: m.cfg.Host, InsecureSkipVerify: m.cfg.InsecureSkipVerify}
+			if err := c.StartTLS(tlsCfg); err != nil {
+				return err
+			}
+		} else if m.cfg.StartTLS == StartTLSRequired {
+			return errors.New("smtp: server does not support STARTTLS")
+		}
+	}
+	if m.cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to.Address); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// parseMailbox parses a single RFC 5322 address. Line breaks are rejected
+// outright so a value can never add header lines.
+func parseMailbox(s string) (*mail.Address, error) {
+	if strings.ContainsAny(s, "\r\n") {
+		return nil, errors.New("address contains a line break")
+	}
+	return mail.ParseAddress(s)
+}
+
+// buildMIME renders a multipart/alternative message so clients can pick the
+// text or HTML part.
+func buildMIME(from, to *mail.Address, msg EmailMessage) ([]byte, error) {
+	if strings.ContainsAny(msg.RequestID, "\r\n") {
+		return nil, errors.New("smtp: request id contains a line break")
+	}
+	var b bytes.Buffer
+	boundary := randomBoundary()
+
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	if msg.RequestID != "" {
+		fmt.Fprintf(&b, "%s: %s\r\n", requestIDHeader, msg.RequestID)
+	}
+	b.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", boundary)
+
+	for _, part := range []struct{ ctype, body string }{
+		{"text/plain", msg.Text},
+		{"text/html", msg.HTML},
+	} {
+		fmt.Fprintf(&b, "--%s\r\n", boundary)
+		fmt.Fprintf(&b, "Content-Type: %s; charset=utf-8\r\n", part.ctype)
+		b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		qp := quotedprintable.NewWriter(&b)
+		qp.Write([]byte(part.body))
+		qp.Close()
+		b.WriteString("\r\n")
+	}
+	fmt.Fprintf(&b, "--%s--\r\n", boundary)
+	return b.Bytes(), nil
+}
+
+func randomBoundary() string {
+	var buf [12]byte
+	rand.Read(buf[:])
+	return "taskapi-" + hex.EncodeToString(buf[:])
+}
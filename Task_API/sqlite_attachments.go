@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const attachmentColumns = `id, task_id, filename, content_type, size, sha256, uploaded_by, created_at`
+
+func (s *SQLiteStore) CreateAttachment(ctx context.Context, a *Attachment) error {
+	if a.Filename == "" || !validBlobKey(a.SHA256) {
+		return ErrInvalid
+	}
+	if err := taskExists(ctx, s.db, a.TaskID); err != nil {
+		return err
+	}
+	now := time.Now()
+	res, err := s.db.ExecContext(ctx,
+		`INSERT INTO attachments (task_id, filename, content_type, size, sha256, uploaded_by, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		a.TaskID, a.Filename, a.ContentType, a.Size, a.SHA256, a.UploadedBy, now,
+	)
+	if err != nil {
+		return err
+	}
+	id, _ := res.LastInsertId()
+	a.ID = int(id)
+	a.CreatedAt = now
+	return nil
+}
+
+func (s *SQLiteStore) GetAttachment(ctx context.Context, id int) (Attachment, error) {
+	a, err := scanAttachment(s.db.QueryRowContext(ctx, `SELECT `+attachmentColumns+` FROM attachments WHERE id = ?`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Attachment{}, ErrNotFound
+		}
+		return Attachment{}, err
+	}
+	return a, nil
+}
+
+func (s *SQLiteStore) ListAttachments(ctx context.Context, taskID int) ([]Attachment, error) {
+	if err := taskExists(ctx, s.db, taskID); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT `+attachmentColumns+` FROM attachments WHERE task_id = ? ORDER BY id`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Attachment{}
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+func (s *SQLiteStore) DeleteAttachment(ctx context.Context, id int) (bool, error) {
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var sum string
+	if err := tx.QueryRowContext(ctx, `SELECT sha256 FROM attachments WHERE id = ?`, id).Scan(&sum); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, ErrNotFound
+		}
+		return false, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM attachments WHERE id = ?`, id); err != nil {
+		return false, err
+	}
+	orphaned, err := unreferencedBlobs(ctx, tx, []string{sum})
+	if err != nil {
+		return false, err
+	}
+	return len(orphaned) > 0, tx.Commit()
+}
+
+// unreferencedBlobs returns the sums among sums that no attachment refers
+// to any more.
+func unreferencedBlobs(ctx context.Context, q queryer, sums []string) ([]string, error) {
+	if len(sums) == 0 {
+		return nil, nil
+	}
+	arg, err := json.Marshal(sums)
+	if err != nil {
+		return nil, err
+	}
+	return querySums(ctx, q, `SELECT value FROM json_each(?) WHERE value NOT IN (SELECT sha256 FROM attachments)`, string(arg))
+}
+
+func querySums(ctx context.Context, q queryer, query string, args ...any) ([]string, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sums []string
+	for rows.Next() {
+		var sum string
+		if err := rows.Scan(&sum); err != nil {
+			return nil, err
+		}
+		sums = append(sums, sum)
+	}
+	return sums, rows.Err()
+}
+
+// removeBlobs deletes the contents of sums from s.Blobs after the
+// transaction that orphaned them has committed. It checks the references
+// again first: the same content may have been uploaded since, or the
+// delete rolled back to a savepoint.
+func (s *SQLiteStore) removeBlobs(ctx context.Context, sums []string) {
+	if s.Blobs == nil || len(sums) == 0 {
+		return
+	}
+	blobRefs.Lock()
+	defer blobRefs.Unlock()
+	sums, err := unreferencedBlobs(ctx, s.db, sums)
+	if err != nil {
+		loggerFrom(ctx).Error("check blob references failed", "error", err)
+		return
+	}
+	for _, sum := range sums {
+		if err := s.Blobs.Delete(sum); err != nil {
+			loggerFrom(ctx).Error("delete blob failed", "sha256", sum, "error", err)
+		}
+	}
+}
+
+func scanAttachment(sc rowScanner) (Attachment, error) {
+	var a Attachment
+	err := sc.Scan(&a.ID, &a.TaskID, &a.Filename, &a.ContentType, &a.Size, &a.SHA256, &a.UploadedBy, &a.CreatedAt)
+	return a, err
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+func (s *SQLiteStore) TaskBatch(ctx context.Context, fn func(tx TaskTx) error) error {
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	b := &sqliteTaskTx{s: s, tx: tx, now: time.Now()}
+	if err := fn(b); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.removeBlobs(ctx, b.orphaned)
+	return nil
+}
+
+type sqliteTaskTx struct {
+	s          *SQLiteStore
+	tx         *Tx
+	now        time.Time
+	savepoints int
+	// orphaned are the blobs deletes in the batch left unreferenced.
+	orphaned []string
+}
+
+func (b *sqliteTaskTx) GetAllTasks(ctx context.Context, filter TaskFilter) ([]Task, error) {
+	query, args := taskFilterQuery(filter)
+	tasks, err := b.s.queryTasks(ctx, b.tx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return tasks, applyAssignees(ctx, b.tx, tasks)
+}
+
+func (b *sqliteTaskTx) GetTaskByID(ctx context.Context, id int) (Task, error) {
+	t, err := scanTask(b.tx.QueryRowContext(ctx, `SELECT `+taskColumns+` FROM tasks WHERE id = ?`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Task{}, ErrNotFound
+		}
+		return Task{}, err
+	}
+	tasks := []Task{t}
+	if err := applyAssignees(ctx, b.tx, tasks); err != nil {
+		return Task{}, err
+	}
+	return tasks[0], nil
+}
+
+func (b *sqliteTaskTx) GetSubtasks(ctx context.Context, parentID int) ([]Task, error) {
+	tasks, err := b.s.queryTasks(ctx, b.tx,
+		descendantsCTE+`SELECT `+taskColumns+` FROM tasks WHERE id IN (SELECT id FROM sub) ORDER BY id`,
+		parentID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return tasks, applyAssignees(ctx, b.tx, tasks)
+}
+
+func (b *sqliteTaskTx) CreateTask(ctx context.Context, task *Task) error {
+	return b.s.createTask(ctx, b.tx, task, b.now)
+}
+
+func (b *sqliteTaskTx) UpdateTask(ctx context.Context, task *Task, opts UpdateOptions) error {
+	return b.s.updateTask(ctx, b.tx, task, opts, b.now)
+}
+
+func (b *sqliteTaskTx) DeleteTask(ctx context.Context, id int) error {
+	orphaned, err := deleteTask(ctx, b.tx, id)
+	b.orphaned = append(b.orphaned, orphaned...)
+	return err
+}
+
+func (b *sqliteTaskTx) Savepoint(ctx context.Context, fn func() error) error {
+	b.savepoints++
+	name := fmt.Sprintf("batch_%d", b.savepoints)
+	if _, err := b.tx.ExecContext(ctx, `SAVEPOINT `+name); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		if _, rbErr := b.tx.ExecContext(ctx, `ROLLBACK TO `+name); rbErr != nil {
+			return errors.Join(err, rbErr)
+		}
+		_, _ = b.tx.ExecContext(ctx, `RELEASE `+name)
+		return err
+	}
+	_, err := b.tx.ExecContext(ctx, `RELEASE `+name)
+	return err
+}
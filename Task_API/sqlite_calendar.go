@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+func hashCalendarToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *SQLiteStore) RotateCalendarToken(ctx context.Context, userID int) (string, error) {
+	var buf [24]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf[:])
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO calendar_feeds (user_id, token_hash, created_at) VALUES (?, ?, ?)
+		 ON CONFLICT (user_id) DO UPDATE SET token_hash = excluded.token_hash, created_at = excluded.created_at`,
+		userID, hashCalendarToken(token), time.Now(),
+	)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (s *SQLiteStore) RevokeCalendarToken(ctx context.Context, userID int) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM calendar_feeds WHERE user_id = ?`, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) CalendarUser(ctx context.Context, token string) (int, error) {
+	var userID int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT f.user_id FROM calendar_feeds f JOIN users u ON u.id = f.user_id
+		WHERE f.token_hash = ? AND u.deleted_at IS NULL`, hashCalendarToken(token)).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	return userID, err
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+const maxCommentLen = 10000
+
+const commentColumns = `id, task_id, author_id, body, edited, created_at, updated_at`
+
+func (s *SQLiteStore) CreateComment(ctx context.Context, c *Comment) error {
+	c.Body = strings.TrimSpace(c.Body)
+	if c.Body == "" || len(c.Body) > maxCommentLen || c.AuthorID <= 0 {
+		return ErrInvalid
+	}
+
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := taskExists(ctx, tx, c.TaskID); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO comments (task_id, author_id, body, edited, created_at, updated_at) VALUES (?, ?, ?, 0, ?, ?)`,
+		c.TaskID, c.AuthorID, c.Body, now, now,
+	)
+	if err != nil {
+		return err
+	}
+	id, _ := res.LastInsertId()
+
+	mentions, err := saveMentions(ctx, tx, int(id), c.Body)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	c.ID = int(id)
+	c.Mentions = mentions
+	c.Edited = false
+	c.CreatedAt = now
+	c.UpdatedAt = now
+	return nil
+}
+
+func (s *SQLiteStore) GetComment(ctx context.Context, id int) (Comment, error) {
+	c, err := scanComment(s.db.QueryRowContext(ctx, `SELECT `+commentColumns+` FROM comments WHERE id = ?`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Comment{}, ErrNotFound
+		}
+		return Comment{}, err
+	}
+	comments := []Comment{c}
+	if err := s.applyMentions(ctx, comments); err != nil {
+		return Comment{}, err
+	}
+	return comments[0], nil
+}
+
+// UpdateComment replaces the body and marks the comment as edited. Only the
+// body can change; the task and author stay as they were.
+func (s *SQLiteStore) UpdateComment(ctx context.Context, c *Comment) error {
+	c.Body = strings.TrimSpace(c.Body)
+	if c.ID <= 0 || c.Body == "" || len(c.Body) > maxCommentLen {
+		return ErrInvalid
+	}
+
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	res, err := tx.ExecContext(ctx, `UPDATE comments SET body = ?, edited = 1, updated_at = ? WHERE id = ?`, c.Body, now, c.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM comment_mentions WHERE comment_id = ?`, c.ID); err != nil {
+		return err
+	}
+	if _, err := saveMentions(ctx, tx, c.ID, c.Body); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	updated, err := s.GetComment(ctx, c.ID)
+	if err != nil {
+		return err
+	}
+	*c = updated
+	return nil
+}
+
+func (s *SQLiteStore) DeleteComment(ctx context.Context, id int) error {
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM comments WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM comment_mentions WHERE comment_id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) ListComments(ctx context.Context, taskID, afterID, limit int) ([]Comment, error) {
+	if err := taskExists(ctx, s.db, taskID); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+commentColumns+` FROM comments WHERE task_id = ? AND id > ? ORDER BY id LIMIT ?`,
+		taskID, afterID, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []Comment{}
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	return comments, s.applyMentions(ctx, comments)
+}
+
+// saveMentions resolves @name tokens in body to active users, matching
+// names case-insensitively, and records them against the comment.
+func saveMentions(ctx context.Context, q queryer, commentID int, body string) ([]int, error) {
+	names := parseMentions(body)
+	if len(names) == 0 {
+		return []int{}, nil
+	}
+	namesArg, err := json.Marshal(names)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := q.QueryContext(ctx, `
+		SELECT MIN(id) FROM users
+		WHERE lower(name) IN (SELECT lower(value) FROM json_each(?)) AND deleted_at IS NULL
+		GROUP BY lower(name)
+		ORDER BY 1`, string(namesArg))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	mentions := []int{}
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		mentions = append(mentions, userID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if _, err := q.ExecContext(ctx,
+		`INSERT OR IGNORE INTO comment_mentions (comment_id, user_id) SELECT ?, value FROM json_each(?)`,
+		commentID, idList(mentions),
+	); err != nil {
+		return nil, err
+	}
+	return mentions, nil
+}
+
+// applyMentions fills in Mentions for each comment.
+func (s *SQLiteStore) applyMentions(ctx context.Context, comments []Comment) error {
+	if len(comments) == 0 {
+		return nil
+	}
+	ids := make([]int, len(comments))
+	for i, c := range comments {
+		ids[i] = c.ID
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT comment_id, user_id FROM comment_mentions
+		WHERE comment_id IN (SELECT value FROM json_each(?))
+		ORDER BY comment_id, user_id`, idList(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	mentions := make(map[int][]int)
+	for rows.Next() {
+		var commentID, userID int
+		if err := rows.Scan(&commentID, &userID); err != nil {
+			return err
+		}
+		mentions[commentID] = append(mentions[commentID], userID)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range comments {
+		comments[i].Mentions = append([]int{}, mentions[comments[i].ID]...)
+	}
+	return nil
+}
+
+// applyCommentCounts fills in CommentCount for each task.
+func (s *SQLiteStore) applyCommentCounts(ctx context.Context, tasks []Task) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT task_id, COUNT(*) FROM comments
+		WHERE task_id IN (SELECT value FROM json_each(?))
+		GROUP BY task_id`, idList(taskIDs(tasks)))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	counts := make(map[int]int)
+	for rows.Next() {
+		var taskID, n int
+		if err := rows.Scan(&taskID, &n); err != nil {
+			return err
+		}
+		counts[taskID] = n
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range tasks {
+		tasks[i].CommentCount = counts[tasks[i].ID]
+	}
+	return nil
+}
+
+func scanComment(sc rowScanner) (Comment, error) {
+	var c Comment
+	err := sc.Scan(&c.ID, &c.TaskID, &c.AuthorID, &c.Body, &c.Edited, &c.CreatedAt, &c.UpdatedAt)
+	return c, err
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// AddDependency records that blockerID blocks blockedID. Edges that would
+// close a loop in the dependency graph are rejected with
+// ErrDependencyCycle.
+func (s *SQLiteStore) AddDependency(ctx context.Context, blockerID, blockedID int) error {
+	if blockerID == blockedID {
+		return ErrDependencyCycle
+	}
+
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := taskExists(ctx, tx, blockedID); err != nil {
+		return err
+	}
+	if err := taskExists(ctx, tx, blockerID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return ErrInvalid
+		}
+		return err
+	}
+
+	// blockedID reaching blockerID through existing "blocks" edges means the
+	// new edge would close a cycle.
+	var reachable int
+	if err := tx.QueryRowContext(ctx, `
+		WITH RECURSIVE reach(id) AS (
+			SELECT blocked_id FROM task_dependencies WHERE blocker_id = ?
+			UNION
+			SELECT d.blocked_id FROM task_dependencies d JOIN reach ON d.blocker_id = reach.id
+		)
+		SELECT COUNT(*) FROM reach WHERE id = ?`, blockedID, blockerID).Scan(&reachable); err != nil {
+		return err
+	}
+	if reachable > 0 {
+		return ErrDependencyCycle
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT OR IGNORE INTO task_dependencies (blocker_id, blocked_id) VALUES (?, ?)`,
+		blockerID, blockedID,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) RemoveDependency(ctx context.Context, blockerID, blockedID int) error {
+	res, err := s.db.ExecContext(ctx,
+		`DELETE FROM task_dependencies WHERE blocker_id = ? AND blocked_id = ?`,
+		blockerID, blockedID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// GetCriticalPath returns the longest chain of open blockers leading up to
+// the task, ending with the task itself.
+func (s *SQLiteStore) GetCriticalPath(ctx context.Context, id int) ([]Task, error) {
+	if err := taskExists(ctx, s.db, id); err != nil {
+		return nil, err
+	}
+
+	blockers, open, err := s.loadOpenBlockers(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	ids := longestOpenChain(blockers, open, id)
+
+	tasks, err := s.queryTasks(ctx, s.db, `SELECT `+taskColumns+` FROM tasks WHERE id IN (SELECT value FROM json_each(?))`, idList(ids))
+	if err != nil {
+		return nil, err
+	}
+	if err := s.decorate(ctx, tasks); err != nil {
+		return nil, err
+	}
+	byID := make(map[int]Task, len(tasks))
+	for _, t := range tasks {
+		byID[t.ID] = t
+	}
+	path := make([]Task, 0, len(ids))
+	for _, tid := range ids {
+		path = append(path, byID[tid])
+	}
+	return path, nil
+}
+
+// loadOpenBlockers maps each task that id waits on, directly or through
+// other open blockers, to the open tasks blocking it, and returns the set
+// of those blockers. Only edges upstream of id are read.
+func (s *SQLiteStore) loadOpenBlockers(ctx context.Context, id int) (map[int][]int, map[int]bool, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		WITH RECURSIVE open_edges(blocker_id, blocked_id) AS (
+			SELECT d.blocker_id, d.blocked_id
+			FROM task_dependencies d JOIN tasks t ON t.id = d.blocker_id
+			WHERE t.completed = 0
+		),
+		upstream(id) AS (
+			SELECT ?
+			UNION
+			SELECT e.blocker_id FROM open_edges e JOIN upstream u ON e.blocked_id = u.id
+		)
+		SELECT blocker_id, blocked_id FROM open_edges
+		WHERE blocked_id IN (SELECT id FROM upstream)
+		ORDER BY blocker_id`, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	blockers := make(map[int][]int)
+	open := make(map[int]bool)
+	for rows.Next() {
+		var blocker, blocked int
+		if err := rows.Scan(&blocker, &blocked); err != nil {
+			return nil, nil, err
+		}
+		blockers[blocked] = append(blockers[blocked], blocker)
+		open[blocker] = true
+	}
+	return blockers, open, rows.Err()
+}
+
+// applyDependencies fills in BlockedBy, Blocks and Blocked for each task.
+func (s *SQLiteStore) applyDependencies(ctx context.Context, tasks []Task) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT d.blocker_id, d.blocked_id, t.completed
+		FROM task_dependencies d JOIN tasks t ON t.id = d.blocker_id
+		WHERE d.blocker_id IN (SELECT value FROM json_each(?1)) OR d.blocked_id IN (SELECT value FROM json_each(?1))
+		ORDER BY d.blocker_id, d.blocked_id`, idList(taskIDs(tasks)))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	blockedBy := make(map[int][]int)
+	blocks := make(map[int][]int)
+	openBlockers := make(map[int]bool)
+	for rows.Next() {
+		var blocker, blocked int
+		var completed bool
+		if err := rows.Scan(&blocker, &blocked, &completed); err != nil {
+			return err
+		}
+		blockedBy[blocked] = append(blockedBy[blocked], blocker)
+		blocks[blocker] = append(blocks[blocker], blocked)
+		if !completed {
+			openBlockers[blocked] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range tasks {
+		id := tasks[i].ID
+		tasks[i].BlockedBy = append([]int{}, blockedBy[id]...)
+		tasks[i].Blocks = append([]int{}, blocks[id]...)
+		tasks[i].Blocked = openBlockers[id]
+	}
+	return nil
+}
+
+func taskExists(ctx context.Context, q queryer, id int) error {
+	var found int
+	if err := q.QueryRowContext(ctx, `SELECT id FROM tasks WHERE id = ?`, id).Scan(&found); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
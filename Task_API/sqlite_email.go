@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	EmailPending = "pending"
+	EmailSent    = "sent"
+	EmailDead    = "dead"
+)
+
+func (s *SQLiteStore) GetNotificationPrefs(ctx context.Context, userID int) (NotificationPrefs, error) {
+	prefs := DefaultNotificationPrefs()
+	rows, err := s.db.QueryContext(ctx, `SELECT kind, enabled FROM notification_prefs WHERE user_id = ?`, userID)
+	if err != nil {
+		return prefs, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var kind string
+		var enabled bool
+		if err := rows.Scan(&kind, &enabled); err != nil {
+			return prefs, err
+		}
+		switch kind {
+		case NotificationReminder:
+			prefs.Reminder = enabled
+		case NotificationAssigned:
+			prefs.Assigned = enabled
+		case NotificationCommented:
+			prefs.Commented = enabled
+		case NotificationMentioned:
+			prefs.Mentioned = enabled
+		case NotificationOverdue:
+			prefs.Overdue = enabled
+		}
+	}
+	return prefs, rows.Err()
+}
+
+func (s *SQLiteStore) SetNotificationPrefs(ctx context.Context, userID int, prefs NotificationPrefs) error {
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	kinds := map[string]bool{
+		NotificationReminder:  prefs.Reminder,
+		NotificationAssigned:  prefs.Assigned,
+		NotificationCommented: prefs.Commented,
+		NotificationMentioned: prefs.Mentioned,
+		NotificationOverdue:   prefs.Overdue,
+	}
+	for kind, enabled := range kinds {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO notification_prefs (user_id, kind, enabled) VALUES (?, ?, ?)
+			ON CONFLICT(user_id, kind) DO UPDATE SET enabled = excluded.enabled`,
+			userID, kind, enabled,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) EnqueueEmail(ctx context.Context, userID int, msg EmailMessage) error {
+	now := time.Now()
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO email_outbox (user_id, to_addr, subject, text_body, html_body, request_id, status, next_attempt_unix, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, msg.To, msg.Subject, msg.Text, msg.HTML, msg.RequestID, EmailPending, now.Unix(), now,
+	)
+	return err
+}
+
+func (s *SQLiteStore) DueEmails(ctx context.Context, now time.Time, limit int) ([]OutboxEmail, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, user_id, to_addr, subject, text_body, html_body, request_id, attempts
+		FROM email_outbox
+		WHERE status = ? AND next_attempt_unix <= ?
+		ORDER BY next_attempt_unix, id
+		LIMIT ?`, EmailPending, now.Unix(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []OutboxEmail
+	for rows.Next() {
+		var e OutboxEmail
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Message.To, &e.Message.Subject, &e.Message.Text, &e.Message.HTML, &e.Message.RequestID, &e.Attempts); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// ClaimEmail pushes next_attempt_unix past the lease, so a dispatcher that
+// dies mid-send leaves the email to be retried once the lease runs out.
+func (s *SQLiteStore) ClaimEmail(ctx context.Context, id int, now time.Time, lease time.Duration) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE email_outbox SET next_attempt_unix = ?, attempts = attempts + 1
+		WHERE id = ? AND status = ? AND next_attempt_unix <= ?`,
+		now.Add(lease).Unix(), id, EmailPending, now.Unix(),
+	)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+func (s *SQLiteStore) MarkEmailSent(ctx context.Context, id int, now time.Time) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE email_outbox SET status = ?, sent_at = ?, last_error = '' WHERE id = ?`, EmailSent, now, id)
+	return err
+}
+
+func (s *SQLiteStore) MarkEmailFailed(ctx context.Context, id int, reason string, retryAt time.Time, dead bool) error {
+	status := EmailPending
+	if dead {
+		status = EmailDead
+	}
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE email_outbox SET status = ?, next_attempt_unix = ?, last_error = ? WHERE id = ?`,
+		status, retryAt.Unix(), reason, id,
+	)
+	return err
+}
+
+// OverdueTasks filters due dates in Go for the same reason DueReminders
+// does. A task whose due date moves gets a fresh notice for the new date.
+func (s *SQLiteStore) OverdueTasks(ctx context.Context, now time.Time) ([]Task, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT t.id, t.due_at, n.due_unix
+		FROM tasks t LEFT JOIN overdue_notices n ON n.task_id = t.id
+		WHERE t.completed = 0 AND t.due_at IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		var dueAt time.Time
+		var notified *int64
+		if err := rows.Scan(&id, &dueAt, &notified); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if !dueAt.Before(now) || (notified != nil && *notified == dueAt.Unix()) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var out []Task
+	for _, id := range ids {
+		task, err := s.GetTaskByID(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, task)
+	}
+	return out, nil
+}
+
+func (s *SQLiteStore) MarkOverdueNotified(ctx context.Context, taskID int, due time.Time) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO overdue_notices (task_id, due_unix) VALUES (?, ?)
+		ON CONFLICT(task_id) DO UPDATE SET due_unix = excluded.due_unix`,
+		taskID, due.Unix(),
+	)
+	return err
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+func (s *SQLiteStore) BeginIdempotent(ctx context.Context, userID int, key, fingerprint string, now time.Time) (IdempotencyRecord, bool, error) {
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE created_unix < ?`, now.Add(-idempotencyTTL).Unix()); err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	lockedUntil := now.Add(idempotencyLease).Unix()
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO idempotency_keys (user_id, key, fingerprint, locked_until_unix, created_unix) VALUES (?, ?, ?, ?, ?)
+		 ON CONFLICT (user_id, key) DO NOTHING`,
+		userID, key, fingerprint, lockedUntil, now.Unix(),
+	)
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		return IdempotencyRecord{Fingerprint: fingerprint}, true, tx.Commit()
+	}
+	res, err = tx.ExecContext(ctx,
+		`UPDATE idempotency_keys SET locked_until_unix = ?
+		 WHERE user_id = ? AND key = ? AND fingerprint = ? AND done = 0 AND locked_until_unix <= ?`,
+		lockedUntil, userID, key, fingerprint, now.Unix(),
+	)
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		return IdempotencyRecord{Fingerprint: fingerprint}, true, tx.Commit()
+	}
+
+	var rec IdempotencyRecord
+	var header string
+	var body []byte
+	err = tx.QueryRowContext(ctx,
+		`SELECT fingerprint, done, response_status, response_headers, response_body
+		 FROM idempotency_keys WHERE user_id = ? AND key = ?`,
+		userID, key,
+	).Scan(&rec.Fingerprint, &rec.Done, &rec.Status, &header, &body)
+	if errors.Is(err, sql.ErrNoRows) {
+		return IdempotencyRecord{}, false, ErrNotFound
+	}
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	if err := json.Unmarshal([]byte(header), &rec.Header); err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	rec.Body = body
+	return rec, false, tx.Commit()
+}
+
+func (s *SQLiteStore) CompleteIdempotent(ctx context.Context, userID int, key string, status int, header http.Header, body []byte) error {
+	encoded, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx,
+		`UPDATE idempotency_keys SET done = 1, response_status = ?, response_headers = ?, response_body = ?
+		 WHERE user_id = ? AND key = ?`,
+		status, string(encoded), body, userID, key,
+	)
+	return err
+}
+
+func (s *SQLiteStore) ReleaseIdempotent(ctx context.Context, userID int, key string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE user_id = ? AND key = ? AND done = 0`, userID, key)
+	return err
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func (s *SQLiteStore) ExportTasks(ctx context.Context, filter TaskFilter, afterID, limit int) ([]Task, error) {
+	where, args := taskFilterWhere(filter)
+	return s.exportPage(ctx, where, args, afterID, limit)
+}
+
+func (s *SQLiteStore) ExportChildren(ctx context.Context, filter TaskFilter, parentID *int, afterID, limit int) ([]Task, error) {
+	where, args := taskFilterWhere(filter)
+	switch {
+	case parentID != nil:
+		where = append(where, `parent_id = ?`)
+		args = append(args, *parentID)
+	case len(where) == 0:
+		where = append(where, `parent_id IS NULL`)
+	default:
+		// A task whose parent is filtered out starts a tree of its own.
+		where = append(where, `(parent_id IS NULL OR parent_id NOT IN (SELECT id FROM tasks WHERE `+strings.Join(where, ` AND `)+`))`)
+		args = append(args, args...)
+	}
+	return s.exportPage(ctx, where, args, afterID, limit)
+}
+
+// exportPage returns up to limit tasks matching where with IDs above
+// afterID, with their assignees.
+func (s *SQLiteStore) exportPage(ctx context.Context, where []string, args []any, afterID, limit int) ([]Task, error) {
+	where = append(where, `id > ?`)
+	args = append(args, afterID, limit)
+	tasks, err := s.queryTasks(ctx, s.db,
+		`SELECT `+taskColumns+` FROM tasks WHERE `+strings.Join(where, ` AND `)+` ORDER BY id LIMIT ?`,
+		args...,
+	)
+	if err != nil || len(tasks) == 0 {
+		return tasks, err
+	}
+
+	// Only this page's assignees, so memory stays flat however many tasks
+	// there are.
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT task_id, user_id FROM task_assignees WHERE task_id IN (SELECT value FROM json_each(?)) ORDER BY task_id, user_id`,
+		idList(taskIDs(tasks)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	assignees := make(map[int][]int)
+	for rows.Next() {
+		var taskID, userID int
+		if err := rows.Scan(&taskID, &userID); err != nil {
+			return nil, err
+		}
+		assignees[taskID] = append(assignees[taskID], userID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range tasks {
+		tasks[i].Assignees = append([]int{}, assignees[tasks[i].ID]...)
+	}
+	return tasks, nil
+}
+
+func (s *SQLiteStore) ImportTasks(ctx context.Context, rows []ImportRow, dryRun bool) ([]ImportOutcome, error) {
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	refs := make(map[string]int)
+	out := make([]ImportOutcome, len(rows))
+	for i, row := range rows {
+		task := row.Task
+		if row.ParentRef != "" {
+			parentID, ok := refs[row.ParentRef]
+			if !ok {
+				out[i].Err = fmt.Errorf("%w: parent %q is not an earlier row", ErrInvalid, row.ParentRef)
+				continue
+			}
+			task.ParentID = &parentID
+		}
+
+		if !task.CreatedAt.IsZero() {
+			id, err := findImportDuplicate(ctx, tx, task.Title, task.CreatedAt)
+			if err != nil {
+				return nil, err
+			}
+			if id != 0 {
+				out[i] = ImportOutcome{ID: id, Duplicate: true}
+				if row.Ref != "" {
+					refs[row.Ref] = id
+				}
+				continue
+			}
+		}
+
+		if _, err := tx.ExecContext(ctx, `SAVEPOINT import_row`); err != nil {
+			return nil, err
+		}
+		err := s.importTask(ctx, tx, &task, now)
+		if err != nil {
+			if _, rbErr := tx.ExecContext(ctx, `ROLLBACK TO import_row`); rbErr != nil {
+				return nil, rbErr
+			}
+			out[i].Err = err
+		}
+		if _, err := tx.ExecContext(ctx, `RELEASE import_row`); err != nil {
+			return nil, err
+		}
+		if err != nil {
+			continue
+		}
+		out[i].ID, out[i].Task = task.ID, &task
+		if row.Ref != "" {
+			refs[row.Ref] = task.ID
+		}
+	}
+
+	if dryRun {
+		return out, nil
+	}
+	return out, tx.Commit()
+}
+
+// importTask creates the task and then applies the state the source file
+// carries that a new task would not have: completion and creation time.
+func (s *SQLiteStore) importTask(ctx context.Context, tx *Tx, task *Task, now time.Time) error {
+	completed, createdAt := task.Completed, task.CreatedAt
+	if err := s.createTask(ctx, tx, task, now); err != nil {
+		return err
+	}
+	if createdAt.IsZero() {
+		createdAt = now
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE tasks SET completed = ?, created_at = ? WHERE id = ?`, completed, createdAt, task.ID); err != nil {
+		return err
+	}
+	task.Completed, task.CreatedAt = completed, createdAt
+	return nil
+}
+
+// findImportDuplicate returns the ID of a task with the given title created
+// in the same second, or 0. Creation times are compared in Go because they
+// are stored as driver-formatted strings.
+func findImportDuplicate(ctx context.Context, q queryer, title string, createdAt time.Time) (int, error) {
+	rows, err := q.QueryContext(ctx, `SELECT id, created_at FROM tasks WHERE title = ?`, title)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	want := createdAt.Truncate(time.Second)
+	for rows.Next() {
+		var id int
+		var created time.Time
+		if err := rows.Scan(&id, &created); err != nil {
+			return 0, err
+		}
+		if created.Truncate(time.Second).Equal(want) {
+			return id, nil
+		}
+	}
+	return 0, rows.Err()
+}
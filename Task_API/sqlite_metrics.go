@@ -0,0 +1,10 @@
+package main
+
+import "context"
+
+func (s *SQLiteStore) CountTasks(ctx context.Context) (open, completed int, err error) {
+	err = s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FILTER (WHERE NOT COALESCE(completed, 0)), COUNT(*) FILTER (WHERE completed)
+		FROM tasks`).Scan(&open, &completed)
+	return open, completed, err
+}
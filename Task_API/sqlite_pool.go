@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// DBConfig holds the connection settings applied to every SQLite
+// connection the server opens.
+type DBConfig struct {
+	Path        string
+	JournalMode string
+	Synchronous string
+	BusyTimeout time.Duration
+	ForeignKeys bool
+	// MaxReaders caps the read pool. Writes always go through a single
+	// connection: SQLite allows one writer at a time anyway, and queueing
+	// in the pool is cheaper than failing with SQLITE_BUSY.
+	MaxReaders int
+	// BusyRetries is how many more times a write is attempted when the
+	// database is still locked after BusyTimeout.
+	BusyRetries int
+}
+
+func DefaultDBConfig() DBConfig {
+	return DBConfig{
+		Path:        dbPath,
+		JournalMode: "WAL",
+		Synchronous: "NORMAL",
+		BusyTimeout: 5 * time.Second,
+		ForeignKeys: true,
+		MaxReaders:  max(4, runtime.NumCPU()),
+		BusyRetries: 3,
+	}
+}
+
+var (
+	journalModes = []string{"DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF"}
+	syncLevels   = []string{"OFF", "NORMAL", "FULL", "EXTRA"}
+)
+
+// dsn builds a modernc.org/sqlite data source name. The driver runs each
+// _pragma on every new connection, which matters because busy_timeout,
+// synchronous and foreign_keys are per-connection settings.
+func (c DBConfig) dsn(reader bool) string {
+	q := url.Values{}
+	q.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", c.BusyTimeout.Milliseconds()))
+	q.Add("_pragma", "synchronous("+c.Synchronous+")")
+	if c.ForeignKeys {
+		q.Add("_pragma", "foreign_keys(1)")
+	} else {
+		q.Add("_pragma", "foreign_keys(0)")
+	}
+	if reader {
+		q.Add("_pragma", "query_only(1)")
+	} else {
+		q.Add("_pragma", "journal_mode("+c.JournalMode+")")
+		// Take the write lock when the transaction starts rather than at
+		// its first write, so busy_timeout applies instead of an immediate
+		// SQLITE_BUSY on the lock upgrade.
+		q.Set("_txlock", "immediate")
+	}
+	return c.Path + "?" + q.Encode()
+}
+
+// DB splits database traffic between a single-connection write pool and a
+// read pool. Exec and Begin go to the writer and are retried while the
+// database is busy; Query and QueryRow go to the readers, which in WAL mode
+// never wait for the writer.
+//
+// Statements are traced as children of the span in the context they run
+// under. Writes do not carry over its cancellation: a write should not be
+// abandoned halfway because the client went away.
+type DB struct {
+	// Tracer traces the statements run as part of a traced request.
+	Tracer trace.Tracer
+
+	writer  *sql.DB
+	reader  *sql.DB
+	cfg     DBConfig
+	retries atomic.Int64
+	busy    atomic.Int64
+}
+
+// OpenDB opens both pools and checks the writer can reach the file. The
+// writer connects first so the journal mode is in place before any reader
+// opens the database.
+func OpenDB(cfg DBConfig) (*DB, error) {
+	writer, err := sql.Open("sqlite", cfg.dsn(false))
+	if err != nil {
+		return nil, err
+	}
+	writer.SetMaxOpenConns(1)
+	writer.SetMaxIdleConns(1)
+	if err := writer.Ping(); err != nil {
+		writer.Close()
+		return nil, fmt.Errorf("open %s: %w", cfg.Path, err)
+	}
+	reader, err := sql.Open("sqlite", cfg.dsn(true))
+	if err != nil {
+		writer.Close()
+		return nil, err
+	}
+	reader.SetMaxOpenConns(cfg.MaxReaders)
+	reader.SetMaxIdleConns(cfg.MaxReaders)
+	return &DB{Tracer: noopTracer(), writer: writer, reader: reader, cfg: cfg}, nil
+}
+
+func (d *DB) Close() error {
+	return errors.Join(d.reader.Close(), d.writer.Close())
+}
+
+// Exec, Query and QueryRow run outside any request, for migrations and
+// other startup work.
+func (d *DB) Exec(query string, args ...any) (sql.Result, error) {
+	return d.ExecContext(context.Background(), query, args...)
+}
+
+func (d *DB) Query(query string, args ...any) (*sql.Rows, error) {
+	return d.QueryContext(context.Background(), query, args...)
+}
+
+func (d *DB) QueryRow(query string, args ...any) *sql.Row {
+	return d.QueryRowContext(context.Background(), query, args...)
+}
+
+func (d *DB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, span := startSQLSpan(context.WithoutCancel(ctx), d.Tracer, query)
+	defer span.End()
+	var res sql.Result
+	err := d.retryBusy(func() error {
+		var err error
+		res, err = d.writer.ExecContext(ctx, query, args...)
+		return err
+	})
+	setSpanError(span, err)
+	return res, err
+}
+
+// BeginTx starts a write transaction. With _txlock=immediate the lock is
+// taken here, so this is the one place a transaction can be retried
+// without replaying any of its statements.
+func (d *DB) BeginTx(ctx context.Context) (*Tx, error) {
+	ctx = context.WithoutCancel(ctx)
+	var tx *sql.Tx
+	err := d.retryBusy(func() error {
+		var err error
+		tx, err = d.writer.BeginTx(ctx, nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx, tracer: d.Tracer}, nil
+}
+
+func (d *DB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	ctx, span := startSQLSpan(ctx, d.Tracer, query)
+	defer span.End()
+	rows, err := d.reader.QueryContext(ctx, query, args...)
+	setSpanError(span, err)
+	return rows, err
+}
+
+func (d *DB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	ctx, span := startSQLSpan(ctx, d.Tracer, query)
+	defer span.End()
+	return d.reader.QueryRowContext(ctx, query, args...)
+}
+
+// Tx is a write transaction whose statements are traced like DB's. Like
+// writes through DB, they ignore cancellation.
+type Tx struct {
+	*sql.Tx
+	tracer trace.Tracer
+}
+
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, span := startSQLSpan(context.WithoutCancel(ctx), tx.tracer, query)
+	defer span.End()
+	res, err := tx.Tx.ExecContext(ctx, query, args...)
+	setSpanError(span, err)
+	return res, err
+}
+
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	ctx, span := startSQLSpan(context.WithoutCancel(ctx), tx.tracer, query)
+	defer span.End()
+	rows, err := tx.Tx.QueryContext(ctx, query, args...)
+	setSpanError(span, err)
+	return rows, err
+}
+
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	ctx, span := startSQLSpan(context.WithoutCancel(ctx), tx.tracer, query)
+	defer span.End()
+	return tx.Tx.QueryRowContext(ctx, query, args...)
+}
+
+// startSQLSpan traces one statement, named after its first keyword, when
+// it runs as part of a traced request. Rows are read after the span ends,
+// so it times executing the statement rather than scanning its results.
+func startSQLSpan(ctx context.Context, tracer trace.Tracer, query string) (context.Context, trace.Span) {
+	if !trace.SpanFromContext(ctx).IsRecording() {
+		return ctx, noop.Span{}
+	}
+	query = strings.Join(strings.Fields(query), " ")
+	op, _, _ := strings.Cut(query, " ")
+	return tracer.Start(ctx, strings.ToUpper(op),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "sqlite"), attribute.String("db.statement", query)),
+	)
+}
+
+// retryBusy runs fn again with a growing pause while it fails because the
+// database is locked, which by then has already waited out busy_timeout.
+func (d *DB) retryBusy(fn func() error) error {
+	err := fn()
+	for attempt := 1; attempt <= d.cfg.BusyRetries && isBusy(err); attempt++ {
+		d.retries.Add(1)
+		time.Sleep(backoffDelay(50*time.Millisecond, time.Second, attempt))
+		err = fn()
+	}
+	if isBusy(err) {
+		d.busy.Add(1)
+	}
+	return err
+}
+
+// isBusy reports whether err is SQLITE_BUSY or SQLITE_LOCKED, including
+// their extended codes.
+func isBusy(err error) bool {
+	var se *sqlite.Error
+	if !errors.As(err, &se) {
+		return false
+	}
+	switch se.Code() & 0xff {
+	case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+		return true
+	}
+	return false
+}
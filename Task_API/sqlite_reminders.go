@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	ReminderPending = "pending"
+	ReminderSending = "sending"
+	ReminderSent    = "sent"
+	ReminderFailed  = "failed"
+
+	maxReminderAttempts = 5
+)
+
+const reminderColumns = `id, task_id, user_id, before_seconds, status, sent_at, created_at`
+
+func (s *SQLiteStore) CreateReminder(ctx context.Context, rem *Reminder) error {
+	before, err := time.ParseDuration(rem.Before)
+	if err != nil || before < 0 {
+		return fmt.Errorf("%w: before must be a non-negative duration", ErrInvalid)
+	}
+	if err := taskExists(ctx, s.db, rem.TaskID); err != nil {
+		return err
+	}
+	var active int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE id = ? AND deleted_at IS NULL`, rem.UserID).Scan(&active); err != nil {
+		return err
+	}
+	if active == 0 {
+		return fmt.Errorf("%w: unknown or deleted user", ErrInvalid)
+	}
+	now := time.Now()
+	res, err := s.db.ExecContext(ctx,
+		`INSERT INTO reminders (task_id, user_id, before_seconds, status, created_at) VALUES (?, ?, ?, ?, ?)`,
+		rem.TaskID, rem.UserID, int64(before/time.Second), ReminderPending, now,
+	)
+	if err != nil {
+		return err
+	}
+	id, _ := res.LastInsertId()
+	rem.ID = int(id)
+	rem.Before = (before / time.Second * time.Second).String()
+	rem.Status = ReminderPending
+	rem.SentAt = nil
+	rem.CreatedAt = now
+	return nil
+}
+
+func (s *SQLiteStore) ListReminders(ctx context.Context, taskID int) ([]Reminder, error) {
+	if err := taskExists(ctx, s.db, taskID); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT `+reminderColumns+` FROM reminders WHERE task_id = ? ORDER BY id`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Reminder{}
+	for rows.Next() {
+		rem, err := scanReminder(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, rem)
+	}
+	return list, rows.Err()
+}
+
+func (s *SQLiteStore) GetReminder(ctx context.Context, id int) (Reminder, error) {
+	rem, err := scanReminder(s.db.QueryRowContext(ctx, `SELECT `+reminderColumns+` FROM reminders WHERE id = ?`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Reminder{}, ErrNotFound
+		}
+		return Reminder{}, err
+	}
+	return rem, nil
+}
+
+func (s *SQLiteStore) DeleteReminder(ctx context.Context, id int) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM reminders WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DueReminders works out fire times in Go rather than SQL because due dates
+// are stored in the driver's time format, which SQLite's date functions do
+// not parse. Reminders on completed tasks or tasks without a due date wait,
+// as do ones backing off after a failed delivery.
+func (s *SQLiteStore) DueReminders(ctx context.Context, now time.Time) ([]DueReminder, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT r.id, r.user_id, r.before_seconds, r.status, r.created_at, r.attempts, t.*
+		FROM reminders r JOIN (SELECT `+taskColumns+` FROM tasks) t ON t.id = r.task_id
+		WHERE t.completed = 0 AND t.due_at IS NOT NULL AND r.status = ? AND r.next_attempt_unix <= ?
+		ORDER BY r.id`, ReminderPending, now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []DueReminder
+	for rows.Next() {
+		var rem Reminder
+		var beforeSecs int64
+		var attempts int
+		task, err := scanTask(prefixScanner{rows, []any{&rem.ID, &rem.UserID, &beforeSecs, &rem.Status, &rem.CreatedAt, &attempts}})
+		if err != nil {
+			return nil, err
+		}
+		before := time.Duration(beforeSecs) * time.Second
+		if task.DueAt.Add(-before).After(now) {
+			continue
+		}
+		rem.TaskID = task.ID
+		rem.Before = before.String()
+		due = append(due, DueReminder{Reminder: rem, Task: task, Attempts: attempts})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	tasks := make([]Task, len(due))
+	for i, d := range due {
+		tasks[i] = d.Task
+	}
+	if err := s.decorate(ctx, tasks); err != nil {
+		return nil, err
+	}
+	for i := range due {
+		due[i].Task = tasks[i]
+	}
+	return due, nil
+}
+
+// prefixScanner scans a row that has other columns in front of the ones
+// the wrapped scan function expects into pre.
+type prefixScanner struct {
+	rows *sql.Rows
+	pre  []any
+}
+
+func (p prefixScanner) Scan(dest ...any) error {
+	return p.rows.Scan(append(p.pre, dest...)...)
+}
+
+// ClaimReminder is a compare-and-set from pending to sending, so only one
+// scheduler (even across processes sharing the file) sends each reminder.
+// Claim times are unix seconds so SQLite can compare them.
+func (s *SQLiteStore) ClaimReminder(ctx context.Context, id int, now time.Time) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE reminders SET status = ?, claimed_unix = ?, attempts = attempts + 1
+		WHERE id = ? AND status = ?`,
+		ReminderSending, now.Unix(), id, ReminderPending,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+// AbandonReminders fails reminders claimed before the given time that were
+// never marked sent or failed. Their sender stopped partway, perhaps after
+// the notification went out, so they are not sent again.
+func (s *SQLiteStore) AbandonReminders(ctx context.Context, claimedBefore time.Time) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE reminders SET status = ?, claimed_unix = NULL, last_error = ?
+		WHERE status = ? AND claimed_unix < ?`,
+		ReminderFailed, "interrupted while sending", ReminderSending, claimedBefore.Unix(),
+	)
+	return err
+}
+
+func (s *SQLiteStore) MarkReminderSent(ctx context.Context, id int, now time.Time) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE reminders SET status = ?, sent_at = ?, last_error = '' WHERE id = ?`, ReminderSent, now, id)
+	return err
+}
+
+// MarkReminderFailed puts the reminder back in the queue until retryAt, or
+// gives up on it after maxReminderAttempts.
+func (s *SQLiteStore) MarkReminderFailed(ctx context.Context, id int, reason string, retryAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE reminders
+		SET status = CASE WHEN attempts >= ? THEN ? ELSE ? END, claimed_unix = NULL, next_attempt_unix = ?, last_error = ?
+		WHERE id = ?`,
+		maxReminderAttempts, ReminderFailed, ReminderPending, retryAt.Unix(), reason, id,
+	)
+	return err
+}
+
+func scanReminder(sc rowScanner) (Reminder, error) {
+	var rem Reminder
+	var beforeSecs int64
+	var sentAt sql.NullTime
+	if err := sc.Scan(&rem.ID, &rem.TaskID, &rem.UserID, &beforeSecs, &rem.Status, &sentAt, &rem.CreatedAt); err != nil {
+		return Reminder{}, err
+	}
+	rem.Before = (time.Duration(beforeSecs) * time.Second).String()
+	if sentAt.Valid {
+		t := sentAt.Time
+		rem.SentAt = &t
+	}
+	return rem, nil
+}
@@ -1,89 +1,370 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 )
 
 type SQLiteStore struct {
-	db *sql.DB
+	db       *DB
+	Subtasks SubtaskPolicy
+	// Metrics, when set, times the TaskStore methods.
+	Metrics *Metrics
+	// Blobs, when set, loses the attachment contents that deleting tasks
+	// leaves unreferenced.
+	Blobs BlobStore
 }
 
-func NewSQLiteStore(db *sql.DB) *SQLiteStore {
-	return &SQLiteStore{db: db}
+func NewSQLiteStore(db *DB) *SQLiteStore {
+	return &SQLiteStore{db: db, Subtasks: DefaultSubtaskPolicy()}
 }
 
-func (s *SQLiteStore) GetAllTasks(filterCompleted *bool) ([]Task, error) {
-	query := `SELECT id, title, description, completed, created_at, updated_at FROM tasks`
-	var args []any
+// queryer is satisfied by *DB, *sql.DB and *Tx so helpers can run
+// inside or outside a transaction.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+const taskColumns = `id, title, description, completed, parent_id, due_at, rrule, time_zone, recurrence_start, next_occurrence_id, created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
 
-	if filterCompleted != nil {
-		query += ` WHERE completed = ?`
-		args = append(args, *filterCompleted)
+func scanTask(sc rowScanner) (Task, error) {
+	var t Task
+	var parentID, nextID sql.NullInt64
+	var dueAt, recurrenceStart sql.NullTime
+	if err := sc.Scan(
+		&t.ID, &t.Title, &t.Description, &t.Completed, &parentID,
+		&dueAt, &t.RRule, &t.TimeZone, &recurrenceStart, &nextID,
+		&t.CreatedAt, &t.UpdatedAt,
+	); err != nil {
+		return Task{}, err
+	}
+	if parentID.Valid {
+		p := int(parentID.Int64)
+		t.ParentID = &p
+	}
+	if nextID.Valid {
+		n := int(nextID.Int64)
+		t.NextOccurrenceID = &n
 	}
+	if dueAt.Valid {
+		d := dueAt.Time
+		t.DueAt = &d
+	}
+	if recurrenceStart.Valid {
+		r := recurrenceStart.Time
+		t.RecurrenceStart = &r
+	}
+	return t, nil
+}
 
-	rows, err := s.db.Query(query, args...)
+func (s *SQLiteStore) GetAllTasks(ctx context.Context, filter TaskFilter) ([]Task, error) {
+	defer s.Metrics.observeStore("GetAllTasks", time.Now())
+	query, args := taskFilterQuery(filter)
+	tasks, err := s.queryTasks(ctx, s.db, query, args...)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	return tasks, s.decorate(ctx, tasks)
+}
 
-	var tasks []Task
-	for rows.Next() {
-		var t Task
-		if err := rows.Scan(&t.ID, &t.Title, &t.Description, &t.Completed, &t.CreatedAt, &t.UpdatedAt); err != nil {
-			return nil, err
-		}
-		tasks = append(tasks, t)
+// taskFilterQuery builds the SELECT for the tasks matching filter.
+func taskFilterQuery(filter TaskFilter) (string, []any) {
+	query := `SELECT ` + taskColumns + ` FROM tasks`
+	where, args := taskFilterWhere(filter)
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, ` AND `)
 	}
-	return tasks, rows.Err()
+	return query + ` ORDER BY id`, args
 }
 
-func (s *SQLiteStore) GetTaskByID(id int) (Task, error) {
-	var t Task
-	err := s.db.QueryRow(
-		`SELECT id, title, description, completed, created_at, updated_at FROM tasks WHERE id = ?`,
-		id,
-	).Scan(&t.ID, &t.Title, &t.Description, &t.Completed, &t.CreatedAt, &t.UpdatedAt)
+// taskFilterWhere returns the WHERE conditions for filter, to be joined
+// with AND.
+func taskFilterWhere(filter TaskFilter) ([]string, []any) {
+	var where []string
+	var args []any
 
+	if filter.Completed != nil {
+		where = append(where, `completed = ?`)
+		args = append(args, *filter.Completed)
+	}
+	if filter.Assignee != nil {
+		where = append(where, `id IN (SELECT task_id FROM task_assignees WHERE user_id = ?)`)
+		args = append(args, *filter.Assignee)
+	}
+	if filter.Unassigned {
+		where = append(where, `id NOT IN (SELECT task_id FROM task_assignees)`)
+	}
+	return where, args
+}
+
+func (s *SQLiteStore) GetTaskByID(ctx context.Context, id int) (Task, error) {
+	defer s.Metrics.observeStore("GetTaskByID", time.Now())
+	t, err := scanTask(s.db.QueryRowContext(ctx, `SELECT `+taskColumns+` FROM tasks WHERE id = ?`, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Task{}, ErrNotFound
 		}
 		return Task{}, err
 	}
-	return t, nil
+	tasks := []Task{t}
+	if err := s.decorate(ctx, tasks); err != nil {
+		return Task{}, err
+	}
+	return tasks[0], nil
+}
+
+// GetSubtasks returns every descendant of parentID, not only its direct
+// children, so callers can render either a flat list or a tree.
+func (s *SQLiteStore) GetSubtasks(ctx context.Context, parentID int) ([]Task, error) {
+	defer s.Metrics.observeStore("GetSubtasks", time.Now())
+	if err := s.db.QueryRowContext(ctx, `SELECT id FROM tasks WHERE id = ?`, parentID).Scan(&parentID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	tasks, err := s.queryTasks(ctx, s.db,
+		descendantsCTE+`SELECT `+taskColumns+` FROM tasks WHERE id IN (SELECT id FROM sub) ORDER BY id`,
+		parentID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return tasks, s.decorate(ctx, tasks)
 }
 
-func (s *SQLiteStore) CreateTask(task *Task) error {
+func (s *SQLiteStore) CreateTask(ctx context.Context, task *Task) error {
+	defer s.Metrics.observeStore("CreateTask", time.Now())
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.createTask(ctx, tx, task, time.Now()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// createTask inserts the task inside tx and fills in its ID and the fields
+// the database owns. Computed fields such as Progress are left at their
+// zero values; there is nothing to compute for a new task.
+func (s *SQLiteStore) createTask(ctx context.Context, tx *Tx, task *Task, now time.Time) error {
 	if task.Title == "" {
 		return ErrInvalid
 	}
-	now := time.Now()
-	res, err := s.db.Exec(
-		`INSERT INTO tasks (title, description, completed, created_at, updated_at) VALUES (?, ?, ?, ?, ?)`,
-		task.Title, task.Description, false, now, now,
-	)
+	if err := validateRecurrence(*task); err != nil {
+		return err
+	}
+
+	if task.ParentID != nil {
+		depth, err := s.depthOf(ctx, tx, *task.ParentID)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return ErrInvalid
+			}
+			return err
+		}
+		if depth+1 > s.Subtasks.MaxDepth {
+			return ErrTooDeep
+		}
+	}
+
+	task.RecurrenceStart = nil
+	if task.RRule != "" {
+		task.RecurrenceStart = task.DueAt
+	}
+
+	id, err := insertTask(ctx, tx, task, now)
 	if err != nil {
 		return err
 	}
-	id, _ := res.LastInsertId()
-	task.ID = int(id)
+
+	assignees := uniqueInts(task.Assignees)
+	for _, userID := range assignees {
+		if _, err := assignUser(ctx, tx, id, userID, now); err != nil {
+			return err
+		}
+	}
+
+	task.ID = id
+	task.Assignees = assignees
+	task.NextOccurrenceID = nil
 	task.Completed = false
+	task.Progress = 0
+	task.BlockedBy = []int{}
+	task.Blocks = []int{}
+	task.Blocked = false
 	task.CreatedAt = now
 	task.UpdatedAt = now
 	return nil
 }
 
-func (s *SQLiteStore) UpdateTask(task *Task) error {
+// UpdateTask saves the task. Completing a recurring task also creates its
+// next occurrence, once, with the same details and the next due date.
+func (s *SQLiteStore) UpdateTask(ctx context.Context, task *Task, opts UpdateOptions) error {
+	defer s.Metrics.observeStore("UpdateTask", time.Now())
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.updateTask(ctx, tx, task, opts, time.Now()); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	task.Subtasks = nil
+	tasks := []Task{*task}
+	if err := s.decorate(ctx, tasks); err != nil {
+		return err
+	}
+	*task = tasks[0]
+	return nil
+}
+
+// updateTask is UpdateTask inside tx, without filling in computed fields,
+// which need the committed state.
+func (s *SQLiteStore) updateTask(ctx context.Context, tx *Tx, task *Task, opts UpdateOptions, now time.Time) error {
 	if task.ID <= 0 || task.Title == "" {
 		return ErrInvalid
 	}
-	now := time.Now()
-	res, err := s.db.Exec(
-		`UPDATE tasks SET title = ?, description = ?, completed = ?, updated_at = ? WHERE id = ?`,
-		task.Title, task.Description, task.Completed, now, task.ID,
+
+	current, err := scanTask(tx.QueryRowContext(ctx, `SELECT `+taskColumns+` FROM tasks WHERE id = ?`, task.ID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+	wasCompleted := current.Completed
+	result := UpdateResult{WasCompleted: wasCompleted}
+	for _, name := range opts.Keep {
+		switch name {
+		case "parentId":
+			task.ParentID = current.ParentID
+		case "dueAt":
+			task.DueAt = current.DueAt
+		case "rrule":
+			task.RRule = current.RRule
+		case "timeZone":
+			task.TimeZone = current.TimeZone
+		}
+	}
+	if err := validateRecurrence(*task); err != nil {
+		return err
+	}
+
+	if opts.IfChangeSeq != 0 {
+		var seq int64
+		if err := tx.QueryRowContext(ctx, `SELECT change_seq FROM tasks WHERE id = ?`, task.ID).Scan(&seq); err != nil {
+			return err
+		}
+		if seq != opts.IfChangeSeq {
+			return ErrConflict
+		}
+	}
+
+	// The series keeps its original DTSTART unless the rule itself changes.
+	task.NextOccurrenceID = current.NextOccurrenceID
+	switch {
+	case task.RRule == "":
+		task.RecurrenceStart = nil
+	case task.RRule == current.RRule && current.RecurrenceStart != nil:
+		task.RecurrenceStart = current.RecurrenceStart
+	default:
+		task.RecurrenceStart = task.DueAt
+	}
+
+	if task.ParentID != nil {
+		if err := s.checkReparent(ctx, tx, task.ID, *task.ParentID); err != nil {
+			return err
+		}
+	}
+
+	completing := task.Completed && !wasCompleted
+	if completing && !opts.Force {
+		blocked, err := hasOpenBlockers(ctx, tx, task.ID, nil)
+		if err != nil {
+			return err
+		}
+		if blocked {
+			return ErrBlocked
+		}
+	}
+
+	if completing {
+		var open int
+		if err := tx.QueryRowContext(ctx, descendantsCTE+`SELECT COUNT(*) FROM tasks WHERE id IN (SELECT id FROM sub) AND completed = 0`, task.ID).Scan(&open); err != nil {
+			return err
+		}
+		if open > 0 {
+			switch s.Subtasks.ParentCompletion {
+			case ParentCompletionReject:
+				return ErrOpenSubtasks
+			case ParentCompletionCascade:
+				ids, err := queryIDs(ctx, tx, descendantsCTE+`SELECT id FROM tasks WHERE id IN (SELECT id FROM sub) AND completed = 0 ORDER BY id`, task.ID)
+				if err != nil {
+					return err
+				}
+				// Blockers completing in the same cascade do not count.
+				along := append([]int{task.ID}, ids...)
+				for _, id := range ids {
+					if !opts.Force {
+						blocked, err := hasOpenBlockers(ctx, tx, id, along)
+						if err != nil {
+							return err
+						}
+						if blocked {
+							return fmt.Errorf("%w: subtask %d", ErrBlocked, id)
+						}
+					}
+					nextID, err := s.completeTask(ctx, tx, id, now)
+					if err != nil {
+						return err
+					}
+					if nextID != nil {
+						result.Created = append(result.Created, *nextID)
+					}
+				}
+				result.Completed = append(result.Completed, ids...)
+			}
+		}
+	}
+
+	if completing && task.NextOccurrenceID == nil {
+		nextID, err := s.spawnNextOccurrence(ctx, tx, *task, now)
+		if err != nil {
+			return err
+		}
+		task.NextOccurrenceID = nextID
+		if nextID != nil {
+			result.Created = append(result.Created, *nextID)
+		}
+	}
+
+	res, err := tx.ExecContext(ctx,
+		`UPDATE tasks SET title = ?, description = ?, completed = ?, parent_id = ?,
+			due_at = ?, rrule = ?, time_zone = ?, recurrence_start = ?, next_occurrence_id = ?, updated_at = ?
+		WHERE id = ?`,
+		task.Title, task.Description, task.Completed, task.ParentID,
+		task.DueAt, task.RRule, task.TimeZone, task.RecurrenceStart, task.NextOccurrenceID, now,
+		task.ID,
 	)
 	if err != nil {
 		return err
@@ -91,17 +372,440 @@ func (s *SQLiteStore) UpdateTask(task *Task) error {
 	if n, _ := res.RowsAffected(); n == 0 {
 		return ErrNotFound
 	}
+	if err := setFieldClocks(ctx, tx, task.ID, opts.FieldClocks, opts.ClockMS); err != nil {
+		return err
+	}
+
+	if completing && s.Subtasks.AutoCompleteParents {
+		completed, created, err := s.autoCompleteAncestors(ctx, tx, task.ParentID, opts.Force, now)
+		if err != nil {
+			return err
+		}
+		result.Completed = append(result.Completed, completed...)
+		result.Created = append(result.Created, created...)
+	}
+
 	task.UpdatedAt = now
+	if opts.Result != nil {
+		*opts.Result = result
+	}
 	return nil
 }
 
-func (s *SQLiteStore) DeleteTask(id int) error {
-	res, err := s.db.Exec(`DELETE FROM tasks WHERE id = ?`, id)
+// DeleteTask removes the task together with all of its subtasks and any
+// dependency edges that pointed at them.
+func (s *SQLiteStore) DeleteTask(ctx context.Context, id int) error {
+	defer s.Metrics.observeStore("DeleteTask", time.Now())
+	tx, err := s.db.BeginTx(ctx)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
+
+	orphaned, err := deleteTask(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.removeBlobs(ctx, orphaned)
+	return nil
+}
+
+// deleteTask deletes the task, its subtasks and everything attached to
+// them, returning the attachment blobs no remaining attachment refers to.
+func deleteTask(ctx context.Context, tx *Tx, id int) ([]string, error) {
+	const tree = `
+		WITH RECURSIVE tree(id) AS (
+			SELECT id FROM tasks WHERE id = ?
+			UNION
+			SELECT t.id FROM tasks t JOIN tree ON t.parent_id = tree.id
+		)
+		`
+	// Attachment blobs are content addressed and may be shared, so only the
+	// ones nothing else refers to afterwards go. The rows may cascade with
+	// the tasks, so the sums are read first.
+	sums, err := querySums(ctx, tx, tree+`SELECT DISTINCT sha256 FROM attachments WHERE task_id IN (SELECT id FROM tree)`, id)
+	if err != nil {
+		return nil, err
+	}
+	res, err := tx.ExecContext(ctx, tree+`DELETE FROM tasks WHERE id IN (SELECT id FROM tree)`, id)
+	if err != nil {
+		return nil, err
+	}
 	if n, _ := res.RowsAffected(); n == 0 {
-		return ErrNotFound
+		return nil, ErrNotFound
+	}
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM task_dependencies
+		WHERE blocker_id NOT IN (SELECT id FROM tasks) OR blocked_id NOT IN (SELECT id FROM tasks)`); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM task_assignees WHERE task_id NOT IN (SELECT id FROM tasks)`); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM comment_mentions
+		WHERE comment_id IN (SELECT id FROM comments WHERE task_id NOT IN (SELECT id FROM tasks))`); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM comments WHERE task_id NOT IN (SELECT id FROM tasks)`); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM attachments WHERE task_id NOT IN (SELECT id FROM tasks)`); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM reminders WHERE task_id NOT IN (SELECT id FROM tasks)`); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM overdue_notices WHERE task_id NOT IN (SELECT id FROM tasks)`); err != nil {
+		return nil, err
+	}
+	return unreferencedBlobs(ctx, tx, sums)
+}
+
+func insertTask(ctx context.Context, q queryer, task *Task, now time.Time) (int, error) {
+	res, err := q.ExecContext(ctx,
+		`INSERT INTO tasks (title, description, completed, parent_id, due_at, rrule, time_zone, recurrence_start, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		task.Title, task.Description, false, task.ParentID,
+		task.DueAt, task.RRule, task.TimeZone, task.RecurrenceStart, now, now,
+	)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+// spawnNextOccurrence inserts the next open task of a recurring series and
+// copies its assignees and reminders. It returns nil when the task does not recur or the
+// series has ended.
+func (s *SQLiteStore) spawnNextOccurrence(ctx context.Context, tx *Tx, task Task, now time.Time) (*int, error) {
+	due, ok, err := nextDue(task)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	next := Task{
+		Title:           task.Title,
+		Description:     task.Description,
+		ParentID:        task.ParentID,
+		DueAt:           &due,
+		RRule:           task.RRule,
+		TimeZone:        task.TimeZone,
+		RecurrenceStart: task.RecurrenceStart,
+	}
+	id, err := insertTask(ctx, tx, &next, now)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO task_assignees (task_id, user_id, assigned_at)
+		SELECT ?, a.user_id, ? FROM task_assignees a JOIN users u ON u.id = a.user_id
+		WHERE a.task_id = ? AND u.deleted_at IS NULL`, id, now, task.ID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO reminders (task_id, user_id, before_seconds, status, created_at)
+		SELECT ?, user_id, before_seconds, ?, ? FROM reminders WHERE task_id = ?`,
+		id, ReminderPending, now, task.ID); err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+// queryIDs runs a query that selects one integer column.
+func queryIDs(ctx context.Context, q queryer, query string, args ...any) ([]int, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *SQLiteStore) queryTasks(ctx context.Context, q queryer, query string, args ...any) ([]Task, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []Task
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, rows.Err()
+}
+
+// descendantsCTE binds one argument, the root task id, and exposes its
+// descendants (excluding the root) as sub(id).
+const descendantsCTE = `
+	WITH RECURSIVE sub(id) AS (
+		SELECT id FROM tasks WHERE parent_id = ?
+		UNION
+		SELECT t.id FROM tasks t JOIN sub ON t.parent_id = sub.id
+	)
+	`
+
+// RootTaskID walks up parent links to the top of id's tree. The live
+// endpoint treats each root task and its subtree as a project.
+func (s *SQLiteStore) RootTaskID(ctx context.Context, id int) (int, error) {
+	var root int
+	err := s.db.QueryRowContext(ctx, `
+		WITH RECURSIVE up(id, parent_id, depth) AS (
+			SELECT id, parent_id, 0 FROM tasks WHERE id = ?
+			UNION ALL
+			SELECT t.id, t.parent_id, up.depth + 1 FROM tasks t JOIN up ON t.id = up.parent_id
+			WHERE up.depth < 1000
+		)
+		SELECT id FROM up WHERE parent_id IS NULL`, id).Scan(&root)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	return root, err
+}
+
+// depthOf returns how many ancestors the task has. A root task has depth 0.
+func (s *SQLiteStore) depthOf(ctx context.Context, q queryer, id int) (int, error) {
+	depth := -1
+	seen := make(map[int]bool)
+	cur := &id
+	for cur != nil {
+		if seen[*cur] {
+			return 0, ErrCycle
+		}
+		seen[*cur] = true
+
+		var parent sql.NullInt64
+		if err := q.QueryRowContext(ctx, `SELECT parent_id FROM tasks WHERE id = ?`, *cur).Scan(&parent); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return 0, ErrNotFound
+			}
+			return 0, err
+		}
+		depth++
+		if parent.Valid {
+			p := int(parent.Int64)
+			cur = &p
+		} else {
+			cur = nil
+		}
+	}
+	return depth, nil
+}
+
+// checkReparent rejects moving id under parentID when parentID is id itself
+// or one of its descendants, or when the move would exceed MaxDepth.
+func (s *SQLiteStore) checkReparent(ctx context.Context, q queryer, id, parentID int) error {
+	if id == parentID {
+		return ErrCycle
+	}
+	var isDescendant int
+	if err := q.QueryRowContext(ctx, descendantsCTE+`SELECT COUNT(*) FROM sub WHERE id = ?`, id, parentID).Scan(&isDescendant); err != nil {
+		return err
+	}
+	if isDescendant > 0 {
+		return ErrCycle
+	}
+
+	parentDepth, err := s.depthOf(ctx, q, parentID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return ErrInvalid
+		}
+		return err
+	}
+	var height int
+	if err := q.QueryRowContext(ctx, `
+		WITH RECURSIVE sub(id, lvl) AS (
+			SELECT id, 1 FROM tasks WHERE parent_id = ?
+			UNION
+			SELECT t.id, sub.lvl + 1 FROM tasks t JOIN sub ON t.parent_id = sub.id
+		)
+		SELECT COALESCE(MAX(lvl), 0) FROM sub`, id).Scan(&height); err != nil {
+		return err
+	}
+	if parentDepth+1+height > s.Subtasks.MaxDepth {
+		return ErrTooDeep
+	}
+	return nil
+}
+
+// autoCompleteAncestors walks up from parentID and completes each ancestor
+// once all of its children are completed, as completing it directly would.
+// An ancestor with open blockers stays open, and so do the ones above it,
+// unless force is set. It returns the ancestors it completed and the next
+// occurrences it spawned.
+func (s *SQLiteStore) autoCompleteAncestors(ctx context.Context, tx *Tx, parentID *int, force bool, now time.Time) (completed, created []int, err error) {
+	seen := make(map[int]bool)
+	for parentID != nil && !seen[*parentID] {
+		seen[*parentID] = true
+
+		var open int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM tasks WHERE parent_id = ? AND completed = 0`, *parentID).Scan(&open); err != nil {
+			return nil, nil, err
+		}
+		if open > 0 {
+			return completed, created, nil
+		}
+		if !force {
+			blocked, err := hasOpenBlockers(ctx, tx, *parentID, nil)
+			if err != nil {
+				return nil, nil, err
+			}
+			if blocked {
+				return completed, created, nil
+			}
+		}
+
+		var next sql.NullInt64
+		var done bool
+		if err := tx.QueryRowContext(ctx, `SELECT parent_id, completed FROM tasks WHERE id = ?`, *parentID).Scan(&next, &done); err != nil {
+			return nil, nil, err
+		}
+		if !done {
+			nextID, err := s.completeTask(ctx, tx, *parentID, now)
+			if err != nil {
+				return nil, nil, err
+			}
+			completed = append(completed, *parentID)
+			if nextID != nil {
+				created = append(created, *nextID)
+			}
+		}
+		if next.Valid {
+			p := int(next.Int64)
+			parentID = &p
+		} else {
+			parentID = nil
+		}
+	}
+	return completed, created, nil
+}
+
+// hasOpenBlockers reports whether an open task other than those in along
+// blocks id.
+func hasOpenBlockers(ctx context.Context, q queryer, id int, along []int) (bool, error) {
+	if along == nil {
+		along = []int{} // json_each reads null as one NULL row
+	}
+	var open int
+	err := q.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM task_dependencies d JOIN tasks t ON t.id = d.blocker_id
+		WHERE d.blocked_id = ? AND t.completed = 0
+			AND t.id NOT IN (SELECT value FROM json_each(?))`, id, idList(along)).Scan(&open)
+	return open > 0, err
+}
+
+// completeTask marks an open task completed on behalf of another one, its
+// parent or a subtask, spawning its next occurrence if it recurs. Callers
+// check blockers first. It returns the spawned task's ID.
+func (s *SQLiteStore) completeTask(ctx context.Context, tx *Tx, id int, now time.Time) (*int, error) {
+	t, err := scanTask(tx.QueryRowContext(ctx, `SELECT `+taskColumns+` FROM tasks WHERE id = ?`, id))
+	if err != nil {
+		return nil, err
+	}
+	nextID := t.NextOccurrenceID
+	var spawned *int
+	if nextID == nil {
+		if spawned, err = s.spawnNextOccurrence(ctx, tx, t, now); err != nil {
+			return nil, err
+		}
+		nextID = spawned
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE tasks SET completed = 1, next_occurrence_id = ?, updated_at = ? WHERE id = ?`, nextID, now, id); err != nil {
+		return nil, err
+	}
+	return spawned, nil
+}
+
+// decorate fills in the computed fields of each task: subtask progress,
+// dependency edges, assignees and comment counts.
+func (s *SQLiteStore) decorate(ctx context.Context, tasks []Task) error {
+	if err := s.applyProgress(ctx, tasks); err != nil {
+		return err
+	}
+	if err := s.applyDependencies(ctx, tasks); err != nil {
+		return err
+	}
+	if err := applyAssignees(ctx, s.db, tasks); err != nil {
+		return err
+	}
+	return s.applyCommentCounts(ctx, tasks)
+}
+
+// idList binds ids as a single argument that a query reads back with
+// IN (SELECT value FROM json_each(?)), so the statement is the same
+// however many there are.
+func idList(ids []int) string {
+	b, _ := json.Marshal(ids)
+	return string(b)
+}
+
+func taskIDs(tasks []Task) []int {
+	ids := make([]int, len(tasks))
+	for i, t := range tasks {
+		ids[i] = t.ID
+	}
+	return ids
+}
+
+// applyProgress fills in Progress for each task from its subtree, so a
+// parent reflects how far along its subtasks are.
+func (s *SQLiteStore) applyProgress(ctx context.Context, tasks []Task) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		WITH RECURSIVE tree(id, parent_id, completed) AS (
+			SELECT id, parent_id, completed FROM tasks WHERE id IN (SELECT value FROM json_each(?))
+			UNION
+			SELECT t.id, t.parent_id, t.completed FROM tasks t JOIN tree ON t.parent_id = tree.id
+		)
+		SELECT id, parent_id, completed FROM tree`, idList(taskIDs(tasks)))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	nodes := make(map[int]taskNode)
+	for rows.Next() {
+		var id int
+		var parent sql.NullInt64
+		var completed bool
+		if err := rows.Scan(&id, &parent, &completed); err != nil {
+			return err
+		}
+		n := taskNode{completed: completed}
+		if parent.Valid {
+			p := int(parent.Int64)
+			n.parentID = &p
+		}
+		nodes[id] = n
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	progress := rollupProgress(nodes)
+	for i := range tasks {
+		tasks[i].Progress = progress[tasks[i].ID]
 	}
 	return nil
 }
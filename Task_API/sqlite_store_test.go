@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// newTestStore opens a fresh SQLite file under the test's temp directory.
+func newTestStore(t *testing.T) *SQLiteStore {
+	t.Helper()
+	cfg := DefaultDBConfig()
+	cfg.Path = filepath.Join(t.TempDir(), "test.db")
+	db, err := OpenDB(cfg)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := migrate(db.writer); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return NewSQLiteStore(db)
+}
+
+func TestSQLiteStore_SubtaskCycleAndDepth(t *testing.T) {
+	s := newTestStore(t)
+	s.Subtasks.MaxDepth = 2
+
+	a := Task{Title: "a"}
+	if err := s.CreateTask(t.Context(), &a); err != nil {
+		t.Fatal(err)
+	}
+	b := Task{Title: "b", ParentID: &a.ID}
+	if err := s.CreateTask(t.Context(), &b); err != nil {
+		t.Fatal(err)
+	}
+	c := Task{Title: "c", ParentID: &b.ID}
+	if err := s.CreateTask(t.Context(), &c); err != nil {
+		t.Fatal(err)
+	}
+
+	d := Task{Title: "d", ParentID: &c.ID}
+	if err := s.CreateTask(t.Context(), &d); err != ErrTooDeep {
+		t.Fatalf("expected ErrTooDeep, got %v", err)
+	}
+	a.ParentID = &c.ID
+	if err := s.UpdateTask(t.Context(), &a, UpdateOptions{}); err != ErrCycle {
+		t.Fatalf("expected ErrCycle, got %v", err)
+	}
+}
+
+func TestSQLiteStore_DependencyCycle(t *testing.T) {
+	s := newTestStore(t)
+	var ids []int
+	for _, title := range []string{"a", "b", "c"} {
+		task := Task{Title: title}
+		if err := s.CreateTask(t.Context(), &task); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, task.ID)
+	}
+
+	if err := s.AddDependency(t.Context(), ids[0], ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddDependency(t.Context(), ids[1], ids[2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddDependency(t.Context(), ids[2], ids[0]); err != ErrDependencyCycle {
+		t.Fatalf("expected ErrDependencyCycle, got %v", err)
+	}
+}
+
+func TestSQLiteStore_CriticalPath(t *testing.T) {
+	s := newTestStore(t)
+	var tasks []Task
+	for _, title := range []string{"a", "b", "c", "side"} {
+		task := Task{Title: title}
+		if err := s.CreateTask(t.Context(), &task); err != nil {
+			t.Fatal(err)
+		}
+		tasks = append(tasks, task)
+	}
+	a, b, c, side := tasks[0], tasks[1], tasks[2], tasks[3]
+	for _, edge := range [][2]int{{a.ID, b.ID}, {b.ID, c.ID}, {side.ID, c.ID}} {
+		if err := s.AddDependency(t.Context(), edge[0], edge[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	titles := func() []string {
+		t.Helper()
+		path, err := s.GetCriticalPath(t.Context(), c.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, p := range path {
+			out = append(out, p.Title)
+		}
+		return out
+	}
+	if got := titles(); !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Errorf("path = %v", got)
+	}
+	a.Completed = true
+	if err := s.UpdateTask(t.Context(), &a, UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := titles(); len(got) != 2 || got[1] != "c" {
+		t.Errorf("path after completing a = %v", got)
+	}
+}
+
+func TestSQLiteStore_CascadeCompletesLikeDirectCompletion(t *testing.T) {
+	s := newTestStore(t)
+	s.Subtasks.ParentCompletion = ParentCompletionCascade
+	s.Subtasks.AutoCompleteParents = true
+	ctx := t.Context()
+	create := func(task Task) Task {
+		t.Helper()
+		if err := s.CreateTask(ctx, &task); err != nil {
+			t.Fatal(err)
+		}
+		return task
+	}
+
+	parent := create(Task{Title: "parent"})
+	due := time.Date(2030, 1, 1, 9, 0, 0, 0, time.UTC)
+	standup := create(Task{Title: "standup", ParentID: &parent.ID, DueAt: &due, RRule: "FREQ=DAILY"})
+	sub := create(Task{Title: "sub", ParentID: &parent.ID})
+	blocker := create(Task{Title: "blocker"})
+	if err := s.AddDependency(ctx, blocker.ID, sub.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	parent.Completed = true
+	if err := s.UpdateTask(ctx, &parent, UpdateOptions{}); !errors.Is(err, ErrBlocked) {
+		t.Fatalf("cascade over a blocked subtask: err %v, want ErrBlocked", err)
+	}
+	if got, _ := s.GetTaskByID(ctx, sub.ID); got.Completed {
+		t.Fatal("blocked subtask completed without force")
+	}
+
+	var res UpdateResult
+	if err := s.UpdateTask(ctx, &parent, UpdateOptions{Force: true, Result: &res}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.GetTaskByID(ctx, standup.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Completed || got.NextOccurrenceID == nil || !slices.Contains(res.Created, *got.NextOccurrenceID) {
+		t.Errorf("standup = %+v, created %v: want completed with its next occurrence", got, res.Created)
+	}
+
+	// Completing the last open subtask leaves a blocked parent open.
+	other := create(Task{Title: "other"})
+	last := create(Task{Title: "last", ParentID: &other.ID})
+	if err := s.AddDependency(ctx, blocker.ID, other.ID); err != nil {
+		t.Fatal(err)
+	}
+	last.Completed = true
+	if err := s.UpdateTask(ctx, &last, UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.GetTaskByID(ctx, other.ID); got.Completed {
+		t.Error("blocked parent auto-completed")
+	}
+}
+
+func TestSQLiteStore_DecorateSubtree(t *testing.T) {
+	s := newTestStore(t)
+	create := func(title string, parent *int) Task {
+		t.Helper()
+		task := Task{Title: title, ParentID: parent}
+		if err := s.CreateTask(t.Context(), &task); err != nil {
+			t.Fatal(err)
+		}
+		return task
+	}
+	root := create("root", nil)
+	mid := create("mid", &root.ID)
+	done := create("leaf done", &mid.ID)
+	create("leaf open", &mid.ID)
+	other := create("other", nil)
+	done.Completed = true
+	if err := s.UpdateTask(t.Context(), &done, UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddDependency(t.Context(), other.ID, root.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.GetTaskByID(t.Context(), root.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Progress != 50 {
+		t.Errorf("root progress = %d, want 50", got.Progress)
+	}
+	if len(got.BlockedBy) != 1 || got.BlockedBy[0] != other.ID || !got.Blocked {
+		t.Errorf("root dependencies = %v, blocked %v", got.BlockedBy, got.Blocked)
+	}
+	got, err = s.GetTaskByID(t.Context(), mid.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Progress != 50 || len(got.BlockedBy) != 0 {
+		t.Errorf("mid progress = %d, blocked by %v", got.Progress, got.BlockedBy)
+	}
+}
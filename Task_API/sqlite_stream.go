@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// taskEventRetention is how many stream events are kept for Last-Event-ID
+// resume. Clients further behind than this are told to reload.
+const taskEventRetention = 10000
+
+func (s *SQLiteStore) AppendTaskEvent(ctx context.Context, e Event) (int64, error) {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return 0, err
+	}
+	res, err := s.db.ExecContext(ctx,
+		`INSERT INTO task_events (type, task_id, payload, created_at) VALUES (?, ?, ?, ?)`,
+		e.Type, e.TaskID, string(payload), time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	id, _ := res.LastInsertId()
+	if id%100 == 0 {
+		if _, err := s.db.ExecContext(ctx, `DELETE FROM task_events WHERE id <= ?`, id-taskEventRetention); err != nil {
+			return id, err
+		}
+	}
+	return id, nil
+}
+
+func (s *SQLiteStore) TaskEventsSince(ctx context.Context, afterID int64, limit int) ([]LoggedEvent, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, payload FROM task_events WHERE id > ? ORDER BY id LIMIT ?`, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []LoggedEvent
+	for rows.Next() {
+		var le LoggedEvent
+		var payload string
+		if err := rows.Scan(&le.ID, &payload); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(payload), &le.Event); err != nil {
+			return nil, err
+		}
+		out = append(out, le)
+	}
+	return out, rows.Err()
+}
+
+func (s *SQLiteStore) TaskEventBounds(ctx context.Context) (int64, int64, error) {
+	var oldest, newest int64
+	err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MIN(id), 0), COALESCE(MAX(id), 0) FROM task_events`).Scan(&oldest, &newest)
+	return oldest, newest, err
+}
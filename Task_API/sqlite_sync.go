@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+)
+
+// syncFields are the task fields clients may change through POST /sync,
+// with the column each maps to. Every one has its own write clock.
+type syncField struct {
+	name   string
+	column string
+}
+
+var syncFields = []syncField{
+	{"title", "title"},
+	{"description", "description"},
+	{"completed", "completed"},
+	{"parentId", "parent_id"},
+	{"dueAt", "due_at"},
+	{"rrule", "rrule"},
+	{"timeZone", "time_zone"},
+}
+
+func (s *SQLiteStore) SyncChanges(ctx context.Context, since int64, limit int) (SyncPage, error) {
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return SyncPage{}, err
+	}
+	defer tx.Rollback()
+
+	page := SyncPage{}
+	if err := tx.QueryRowContext(ctx, `SELECT value FROM sync_seq WHERE id = 1`).Scan(&page.Seq); err != nil {
+		return SyncPage{}, err
+	}
+
+	// Stop at the limit-th change, counting deletions too, so the page ends
+	// on a sequence number the client can resume from. A first sync has
+	// nothing to delete, so only tasks count.
+	changes := `SELECT change_seq FROM tasks WHERE change_seq > ?1`
+	if since > 0 {
+		changes += ` UNION ALL SELECT change_seq FROM task_tombstones WHERE change_seq > ?1`
+	}
+	var cut int64
+	err = tx.QueryRowContext(ctx, `SELECT change_seq FROM (`+changes+`) ORDER BY change_seq LIMIT 1 OFFSET ?2`, since, limit).Scan(&cut)
+	switch {
+	case err == nil:
+		page.HasMore = true
+		page.Seq = cut - 1
+	case !errors.Is(err, sql.ErrNoRows):
+		return SyncPage{}, err
+	}
+
+	page.Tasks, err = s.queryTasks(ctx, tx, `SELECT `+taskColumns+` FROM tasks WHERE change_seq > ? AND change_seq <= ? ORDER BY change_seq`, since, page.Seq)
+	if err != nil {
+		return SyncPage{}, err
+	}
+
+	// A first sync has nothing to delete.
+	page.Deleted = []SyncTombstone{}
+	if since > 0 {
+		rows, err := tx.QueryContext(ctx, `SELECT task_id, deleted_at FROM task_tombstones WHERE change_seq > ? AND change_seq <= ? ORDER BY change_seq`, since, page.Seq)
+		if err != nil {
+			return SyncPage{}, err
+		}
+		for rows.Next() {
+			var t SyncTombstone
+			if err := rows.Scan(&t.ID, &t.DeletedAt); err != nil {
+				rows.Close()
+				return SyncPage{}, err
+			}
+			page.Deleted = append(page.Deleted, t)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return SyncPage{}, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return SyncPage{}, err
+	}
+	if page.Tasks == nil {
+		page.Tasks = []Task{}
+	}
+	return page, s.decorate(ctx, page.Tasks)
+}
+
+func (s *SQLiteStore) SyncState(ctx context.Context, taskID int) (TaskSyncState, error) {
+	state := TaskSyncState{Clocks: make(map[string]int64)}
+	err := s.db.QueryRowContext(ctx, `SELECT change_seq FROM tasks WHERE id = ?`, taskID).Scan(&state.ChangeSeq)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = s.db.QueryRowContext(ctx, `SELECT change_seq FROM task_tombstones WHERE task_id = ?`, taskID).Scan(&state.ChangeSeq)
+		if errors.Is(err, sql.ErrNoRows) {
+			return TaskSyncState{}, ErrNotFound
+		}
+		state.Deleted = true
+		return state, err
+	}
+	if err != nil {
+		return TaskSyncState{}, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT field, changed_ms FROM task_field_clocks WHERE task_id = ?`, taskID)
+	if err != nil {
+		return TaskSyncState{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var field string
+		var ms int64
+		if err := rows.Scan(&field, &ms); err != nil {
+			return TaskSyncState{}, err
+		}
+		state.Clocks[field] = ms
+	}
+	return state, rows.Err()
+}
+
+// setFieldClocks records when the given fields were last written, over
+// what the clock triggers stored for the update that wrote them.
+func setFieldClocks(ctx context.Context, tx *Tx, taskID int, fields []string, changedMS int64) error {
+	for _, f := range fields {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO task_field_clocks (task_id, field, changed_ms) VALUES (?, ?, ?)
+			ON CONFLICT(task_id, field) DO UPDATE SET changed_ms = excluded.changed_ms`,
+			taskID, f, changedMS,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isSyncField(name string) bool {
+	return slices.ContainsFunc(syncFields, func(f syncField) bool { return f.name == name })
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func (s *SQLiteStore) CreateUser(ctx context.Context, user *User) error {
+	user.Name = strings.TrimSpace(user.Name)
+	user.Email = strings.TrimSpace(user.Email)
+	if user.Name == "" {
+		return ErrInvalid
+	}
+	if user.Email != "" {
+		addr, err := parseMailbox(user.Email)
+		if err != nil {
+			return fmt.Errorf("%w: invalid email address", ErrInvalid)
+		}
+		user.Email = addr.Address
+	}
+	now := time.Now()
+	res, err := s.db.ExecContext(ctx,
+		`INSERT INTO users (name, email, is_admin, created_at) VALUES (?, ?, ?, ?)`,
+		user.Name, user.Email, user.IsAdmin, now,
+	)
+	if err != nil {
+		return err
+	}
+	id, _ := res.LastInsertId()
+	user.ID = int(id)
+	user.CreatedAt = now
+	user.DeletedAt = nil
+	return nil
+}
+
+func (s *SQLiteStore) GetUser(ctx context.Context, id int) (User, error) {
+	u, err := scanUser(s.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id = ?`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, ErrNotFound
+		}
+		return User{}, err
+	}
+	return u, nil
+}
+
+func (s *SQLiteStore) ListUsers(ctx context.Context) ([]User, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+userColumns+` FROM users WHERE deleted_at IS NULL ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+func (s *SQLiteStore) DeleteUser(ctx context.Context, id int) ([]int, error) {
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `UPDATE users SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL`, time.Now(), id)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, ErrNotFound
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT task_id FROM task_assignees WHERE user_id = ? ORDER BY task_id`, id)
+	if err != nil {
+		return nil, err
+	}
+	var taskIDs []int
+	for rows.Next() {
+		var taskID int
+		if err := rows.Scan(&taskID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		taskIDs = append(taskIDs, taskID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM task_assignees WHERE user_id = ?`, id); err != nil {
+		return nil, err
+	}
+	return taskIDs, tx.Commit()
+}
+
+func (s *SQLiteStore) AssignUser(ctx context.Context, taskID, userID int) (bool, error) {
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if err := taskExists(ctx, tx, taskID); err != nil {
+		return false, err
+	}
+	changed, err := assignUser(ctx, tx, taskID, userID, time.Now())
+	if err != nil {
+		return false, err
+	}
+	return changed, tx.Commit()
+}
+
+// UnassignUser succeeds even when the user has since been deleted or was
+// never assigned, so clients can retry it safely.
+func (s *SQLiteStore) UnassignUser(ctx context.Context, taskID, userID int) (bool, error) {
+	if err := taskExists(ctx, s.db, taskID); err != nil {
+		return false, err
+	}
+	res, err := s.db.ExecContext(ctx, `DELETE FROM task_assignees WHERE task_id = ? AND user_id = ?`, taskID, userID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// assignUser links an active user to a task, reporting false if they were
+// already assigned. Deleted or unknown users are rejected with ErrInvalid.
+func assignUser(ctx context.Context, q queryer, taskID, userID int, now time.Time) (bool, error) {
+	var active int
+	if err := q.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE id = ? AND deleted_at IS NULL`, userID).Scan(&active); err != nil {
+		return false, err
+	}
+	if active == 0 {
+		return false, ErrInvalid
+	}
+	res, err := q.ExecContext(ctx,
+		`INSERT OR IGNORE INTO task_assignees (task_id, user_id, assigned_at) VALUES (?, ?, ?)`,
+		taskID, userID, now,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// applyAssignees fills in Assignees for each task.
+func applyAssignees(ctx context.Context, q queryer, tasks []Task) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+	rows, err := q.QueryContext(ctx, `
+		SELECT task_id, user_id FROM task_assignees
+		WHERE task_id IN (SELECT value FROM json_each(?))
+		ORDER BY task_id, user_id`, idList(taskIDs(tasks)))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	assignees := make(map[int][]int)
+	for rows.Next() {
+		var taskID, userID int
+		if err := rows.Scan(&taskID, &userID); err != nil {
+			return err
+		}
+		assignees[taskID] = append(assignees[taskID], userID)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range tasks {
+		tasks[i].Assignees = append([]int{}, assignees[tasks[i].ID]...)
+	}
+	return nil
+}
+
+const userColumns = `id, name, email, is_admin, created_at, deleted_at`
+
+func scanUser(sc rowScanner) (User, error) {
+	var u User
+	var deletedAt sql.NullTime
+	if err := sc.Scan(&u.ID, &u.Name, &u.Email, &u.IsAdmin, &u.CreatedAt, &deletedAt); err != nil {
+		return User{}, err
+	}
+	if deletedAt.Valid {
+		t := deletedAt.Time
+		u.DeletedAt = &t
+	}
+	return u, nil
+}
+
+func uniqueInts(in []int) []int {
+	out := []int{}
+	seen := make(map[int]bool, len(in))
+	for _, v := range in {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// webhookEvents are the event types a webhook can subscribe to.
+var webhookEvents = []string{EventTaskCreated, EventTaskUpdated, EventTaskCompleted, EventTaskDeleted}
+
+const deliveryColumns = `id, webhook_id, event_type, payload, status, attempts, response_status, last_error, next_attempt_unix, delivered_at, created_at, traceparent`
+
+// CreateWebhook subscribes a URL to task events. An empty event list means
+// every event, and an empty secret gets a random one.
+func (s *SQLiteStore) CreateWebhook(ctx context.Context, h *Webhook) error {
+	u, err := url.Parse(strings.TrimSpace(h.URL))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalid)
+	}
+	h.URL = u.String()
+
+	if len(h.Events) == 0 {
+		h.Events = append([]string(nil), webhookEvents...)
+	}
+	for _, ev := range h.Events {
+		if !slices.Contains(webhookEvents, ev) {
+			return fmt.Errorf("%w: unknown event %q", ErrInvalid, ev)
+		}
+	}
+	h.Events = uniqueStrings(h.Events)
+
+	if h.Secret == "" {
+		var buf [24]byte
+		if _, err := rand.Read(buf[:]); err != nil {
+			return err
+		}
+		h.Secret = hex.EncodeToString(buf[:])
+	}
+
+	now := time.Now()
+	res, err := s.db.ExecContext(ctx,
+		`INSERT INTO webhooks (url, secret, events, created_by, created_at) VALUES (?, ?, ?, ?, ?)`,
+		h.URL, h.Secret, strings.Join(h.Events, ","), h.CreatedBy, now,
+	)
+	if err != nil {
+		return err
+	}
+	id, _ := res.LastInsertId()
+	h.ID = int(id)
+	h.CreatedAt = now
+	return nil
+}
+
+func (s *SQLiteStore) GetWebhook(ctx context.Context, id int) (Webhook, error) {
+	h, err := scanWebhook(s.db.QueryRowContext(ctx, `SELECT id, url, secret, events, created_by, created_at FROM webhooks WHERE id = ?`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Webhook{}, ErrNotFound
+		}
+		return Webhook{}, err
+	}
+	return h, nil
+}
+
+func (s *SQLiteStore) ListWebhooks(ctx context.Context) ([]Webhook, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, url, secret, events, created_by, created_at FROM webhooks ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []Webhook{}
+	for rows.Next() {
+		h, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, h)
+	}
+	return list, rows.Err()
+}
+
+func (s *SQLiteStore) DeleteWebhook(ctx context.Context, id int) error {
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM webhooks WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE webhook_id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) EnqueueDeliveries(ctx context.Context, eventType string, payload []byte, now time.Time, traceparent string) error {
+	hooks, err := s.ListWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, h := range hooks {
+		if !slices.Contains(h.Events, eventType) {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO webhook_deliveries (webhook_id, event_type, payload, status, next_attempt_unix, created_at, traceparent)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			h.ID, eventType, string(payload), DeliveryPending, now.Unix(), now, traceparent,
+		); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) ListDeliveries(ctx context.Context, webhookID int) ([]WebhookDelivery, error) {
+	if _, err := s.GetWebhook(ctx, webhookID); err != nil {
+		return nil, err
+	}
+	return s.queryDeliveries(ctx, `SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE webhook_id = ? ORDER BY id DESC`, webhookID)
+}
+
+func (s *SQLiteStore) GetDelivery(ctx context.Context, id int) (WebhookDelivery, error) {
+	d, err := scanDelivery(s.db.QueryRowContext(ctx, `SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE id = ?`, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return WebhookDelivery{}, ErrNotFound
+		}
+		return WebhookDelivery{}, err
+	}
+	return d, nil
+}
+
+func (s *SQLiteStore) DueDeliveries(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error) {
+	return s.queryDeliveries(ctx, `
+		SELECT `+deliveryColumns+` FROM webhook_deliveries
+		WHERE status = ? AND next_attempt_unix <= ?
+		ORDER BY next_attempt_unix, id
+		LIMIT ?`, DeliveryPending, now.Unix(), limit)
+}
+
+// ClaimDelivery leases a delivery the same way ClaimEmail does.
+func (s *SQLiteStore) ClaimDelivery(ctx context.Context, id int, now time.Time, lease time.Duration) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE webhook_deliveries SET next_attempt_unix = ?, attempts = attempts + 1
+		WHERE id = ? AND status = ? AND next_attempt_unix <= ?`,
+		now.Add(lease).Unix(), id, DeliveryPending, now.Unix(),
+	)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n == 1, nil
+}
+
+func (s *SQLiteStore) MarkDeliverySucceeded(ctx context.Context, id, responseStatus int, now time.Time) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE webhook_deliveries SET status = ?, response_status = ?, last_error = '', delivered_at = ? WHERE id = ?`,
+		DeliveryDelivered, responseStatus, now, id,
+	)
+	return err
+}
+
+func (s *SQLiteStore) MarkDeliveryFailed(ctx context.Context, id, responseStatus int, reason string, retryAt time.Time, dead bool) error {
+	status := DeliveryPending
+	if dead {
+		status = DeliveryDead
+	}
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE webhook_deliveries SET status = ?, response_status = ?, last_error = ?, next_attempt_unix = ? WHERE id = ?`,
+		status, responseStatus, reason, retryAt.Unix(), id,
+	)
+	return err
+}
+
+func (s *SQLiteStore) Redeliver(ctx context.Context, id int, now time.Time) error {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE webhook_deliveries SET status = ?, attempts = 0, next_attempt_unix = ?, delivered_at = NULL WHERE id = ?`,
+		DeliveryPending, now.Unix(), id,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) queryDeliveries(ctx context.Context, query string, args ...any) ([]WebhookDelivery, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, d)
+	}
+	return list, rows.Err()
+}
+
+func scanWebhook(sc rowScanner) (Webhook, error) {
+	var h Webhook
+	var events string
+	if err := sc.Scan(&h.ID, &h.URL, &h.Secret, &events, &h.CreatedBy, &h.CreatedAt); err != nil {
+		return Webhook{}, err
+	}
+	h.Events = strings.Split(events, ",")
+	return h, nil
+}
+
+func scanDelivery(sc rowScanner) (WebhookDelivery, error) {
+	var d WebhookDelivery
+	var payload string
+	var nextUnix int64
+	var deliveredAt sql.NullTime
+	if err := sc.Scan(&d.ID, &d.WebhookID, &d.EventType, &payload, &d.Status, &d.Attempts,
+		&d.ResponseStatus, &d.LastError, &nextUnix, &deliveredAt, &d.CreatedAt, &d.TraceParent); err != nil {
+		return WebhookDelivery{}, err
+	}
+	d.Payload = []byte(payload)
+	if d.Status == DeliveryPending {
+		next := time.Unix(nextUnix, 0).UTC()
+		d.NextAttemptAt = &next
+	}
+	if deliveredAt.Valid {
+		t := deliveredAt.Time
+		d.DeliveredAt = &t
+	}
+	return d, nil
+}
+
+func uniqueStrings(in []string) []string {
+	seen := make(map[string]bool, len(in))
+	out := make([]string, 0, len(in))
+	for _, s := range in {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
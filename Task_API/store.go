@@ -1,16 +1,326 @@
 package main
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+)
 
 type TaskStore interface {
-	GetAllTasks(filterCompleted *bool) ([]Task, error)
-	GetTaskByID(id int) (Task, error)
-	CreateTask(task *Task) error
-	UpdateTask(task *Task) error
-	DeleteTask(id int) error
+	GetAllTasks(ctx context.Context, filter TaskFilter) ([]Task, error)
+	GetTaskByID(ctx context.Context, id int) (Task, error)
+	GetSubtasks(ctx context.Context, parentID int) ([]Task, error)
+	CreateTask(ctx context.Context, task *Task) error
+	UpdateTask(ctx context.Context, task *Task, opts UpdateOptions) error
+	DeleteTask(ctx context.Context, id int) error
+}
+
+// TaskBatchStore runs many task writes in one transaction, for
+// POST /tasks:batch.
+type TaskBatchStore interface {
+	// TaskBatch calls fn with the store bound to a single transaction,
+	// which commits if fn returns nil and rolls back otherwise.
+	TaskBatch(ctx context.Context, fn func(tx TaskTx) error) error
+}
+
+// TaskTx is TaskStore inside a batch. Reads see the batch's own writes.
+// Returned tasks carry their assignees but no other computed fields, which
+// are only filled in once the batch has committed.
+type TaskTx interface {
+	GetAllTasks(ctx context.Context, filter TaskFilter) ([]Task, error)
+	GetTaskByID(ctx context.Context, id int) (Task, error)
+	GetSubtasks(ctx context.Context, parentID int) ([]Task, error)
+	CreateTask(ctx context.Context, task *Task) error
+	UpdateTask(ctx context.Context, task *Task, opts UpdateOptions) error
+	DeleteTask(ctx context.Context, id int) error
+	// Savepoint runs fn and, if it fails, undoes fn's writes while keeping
+	// the rest of the batch.
+	Savepoint(ctx context.Context, fn func() error) error
+}
+
+// TaskTransfer backs GET /export and POST /import.
+type TaskTransfer interface {
+	// ExportTasks returns up to limit tasks matching filter with IDs above
+	// afterID, in ID order, with their assignees filled in. Callers page
+	// through so an export never holds every task in memory.
+	ExportTasks(ctx context.Context, filter TaskFilter, afterID, limit int) ([]Task, error)
+	// ExportChildren is ExportTasks for the direct children of parentID
+	// that match filter. With a nil parentID it returns the tops of the
+	// exported trees: matching tasks whose parent is absent or does not
+	// match.
+	ExportChildren(ctx context.Context, filter TaskFilter, parentID *int, afterID, limit int) ([]Task, error)
+	// ImportTasks creates the rows in order in one transaction and reports
+	// an outcome for each. A row that fails is skipped without affecting
+	// the others; a row whose title and creation time match an existing
+	// task is a duplicate and is not created. A dry run rolls everything
+	// back but reports the same outcomes.
+	ImportTasks(ctx context.Context, rows []ImportRow, dryRun bool) ([]ImportOutcome, error)
+}
+
+// ImportRow is a task read from an import file. Ref is the row's ID in the
+// source, and ParentRef points at an earlier row's Ref.
+type ImportRow struct {
+	Ref       string
+	ParentRef string
+	Task      Task
+}
+
+// ImportOutcome is what happened to one ImportRow. ID is the new task, or
+// the existing one for a duplicate; Task is set for a new task.
+type ImportOutcome struct {
+	ID        int
+	Duplicate bool
+	Err       error
+	Task      *Task
+}
+
+// CalendarStore manages the secret tokens behind per-user iCal feeds. Only
+// a hash of each token is kept, so a token is shown once, when it is made.
+type CalendarStore interface {
+	// RotateCalendarToken replaces the user's feed token, creating one if
+	// there was none, and returns the new token.
+	RotateCalendarToken(ctx context.Context, userID int) (string, error)
+	RevokeCalendarToken(ctx context.Context, userID int) error
+	// CalendarUser returns the active user a token belongs to.
+	CalendarUser(ctx context.Context, token string) (int, error)
+}
+
+// TaskFilter narrows GetAllTasks. Nil fields are not filtered on.
+type TaskFilter struct {
+	Completed  *bool
+	Assignee   *int
+	Unassigned bool
+}
+
+// Matches reports whether t passes the filter, for callers that see tasks
+// one at a time rather than through GetAllTasks.
+func (f TaskFilter) Matches(t Task) bool {
+	if f.Completed != nil && t.Completed != *f.Completed {
+		return false
+	}
+	if f.Assignee != nil && !slices.Contains(t.Assignees, *f.Assignee) {
+		return false
+	}
+	if f.Unassigned && len(t.Assignees) > 0 {
+		return false
+	}
+	return true
+}
+
+// UpdateOptions carries per-call switches for UpdateTask.
+type UpdateOptions struct {
+	// Force completes a task even while its blockers are still open.
+	Force bool
+	// Keep names fields, as in FieldClocks, that stay as stored instead of
+	// being taken from task: "parentId", "dueAt", "rrule" or "timeZone".
+	Keep []string
+	// IfChangeSeq, when set, makes the update fail with ErrConflict if
+	// the task has changed since the caller read that sequence number.
+	IfChangeSeq int64
+	// FieldClocks records, in the same transaction, that these sync
+	// fields were written at ClockMS, in unix milliseconds of the writer's
+	// clock, rather than now.
+	FieldClocks []string
+	ClockMS     int64
+	// Result, when set, is filled in with what the update changed, so the
+	// caller can announce it.
+	Result *UpdateResult
+}
+
+// UpdateResult describes an update as the transaction saw it.
+type UpdateResult struct {
+	// WasCompleted is whether the task was completed before the update.
+	WasCompleted bool
+	// Created are the tasks the update added: the next occurrence of a
+	// recurring task.
+	Created []int
+	// Completed are the other tasks the update completed: subtasks
+	// completed with their parent, and parents whose last open subtask it
+	// was.
+	Completed []int
+}
+
+type DependencyStore interface {
+	AddDependency(ctx context.Context, blockerID, blockedID int) error
+	RemoveDependency(ctx context.Context, blockerID, blockedID int) error
+	GetCriticalPath(ctx context.Context, id int) ([]Task, error)
+}
+
+type UserStore interface {
+	CreateUser(ctx context.Context, user *User) error
+	GetUser(ctx context.Context, id int) (User, error)
+	ListUsers(ctx context.Context) ([]User, error)
+	// DeleteUser soft-deletes the user and removes their assignments,
+	// returning the IDs of the tasks they were unassigned from.
+	DeleteUser(ctx context.Context, id int) ([]int, error)
+}
+
+// AssignmentStore methods report whether the assignment changed, so a
+// repeated call does not announce it again.
+type AssignmentStore interface {
+	AssignUser(ctx context.Context, taskID, userID int) (changed bool, err error)
+	UnassignUser(ctx context.Context, taskID, userID int) (changed bool, err error)
+}
+
+type CommentStore interface {
+	CreateComment(ctx context.Context, c *Comment) error
+	GetComment(ctx context.Context, id int) (Comment, error)
+	UpdateComment(ctx context.Context, c *Comment) error
+	DeleteComment(ctx context.Context, id int) error
+	// ListComments returns up to limit comments on the task with an ID
+	// greater than afterID, oldest first.
+	ListComments(ctx context.Context, taskID, afterID, limit int) ([]Comment, error)
+}
+
+type AttachmentStore interface {
+	CreateAttachment(ctx context.Context, a *Attachment) error
+	GetAttachment(ctx context.Context, id int) (Attachment, error)
+	ListAttachments(ctx context.Context, taskID int) ([]Attachment, error)
+	// DeleteAttachment reports whether the blob is now unreferenced and can
+	// be removed from the BlobStore.
+	DeleteAttachment(ctx context.Context, id int) (orphaned bool, err error)
+}
+
+type ReminderStore interface {
+	CreateReminder(ctx context.Context, rem *Reminder) error
+	GetReminder(ctx context.Context, id int) (Reminder, error)
+	ListReminders(ctx context.Context, taskID int) ([]Reminder, error)
+	DeleteReminder(ctx context.Context, id int) error
+	// DueReminders returns pending reminders whose fire time has passed.
+	DueReminders(ctx context.Context, now time.Time) ([]DueReminder, error)
+	// ClaimReminder marks the reminder as being sent and reports whether
+	// this caller won the claim.
+	ClaimReminder(ctx context.Context, id int, now time.Time) (bool, error)
+	// AbandonReminders gives up on reminders still being sent since before
+	// claimedBefore.
+	AbandonReminders(ctx context.Context, claimedBefore time.Time) error
+	MarkReminderSent(ctx context.Context, id int, now time.Time) error
+	MarkReminderFailed(ctx context.Context, id int, reason string, retryAt time.Time) error
+	// OverdueTasks returns open tasks past their due date whose assignees
+	// have not yet been told about that due date.
+	OverdueTasks(ctx context.Context, now time.Time) ([]Task, error)
+	MarkOverdueNotified(ctx context.Context, taskID int, due time.Time) error
+}
+
+type NotificationPrefStore interface {
+	GetNotificationPrefs(ctx context.Context, userID int) (NotificationPrefs, error)
+	SetNotificationPrefs(ctx context.Context, userID int, prefs NotificationPrefs) error
+}
+
+// OutboxEmail is a rendered email waiting in the SQLite outbox.
+type OutboxEmail struct {
+	ID       int
+	UserID   int
+	Message  EmailMessage
+	Attempts int
+}
+
+type EmailOutbox interface {
+	EnqueueEmail(ctx context.Context, userID int, msg EmailMessage) error
+	DueEmails(ctx context.Context, now time.Time, limit int) ([]OutboxEmail, error)
+	ClaimEmail(ctx context.Context, id int, now time.Time, lease time.Duration) (bool, error)
+	MarkEmailSent(ctx context.Context, id int, now time.Time) error
+	// MarkEmailFailed schedules another attempt at retryAt, or parks the
+	// email as dead when dead is true.
+	MarkEmailFailed(ctx context.Context, id int, reason string, retryAt time.Time, dead bool) error
+}
+
+type WebhookStore interface {
+	CreateWebhook(ctx context.Context, h *Webhook) error
+	GetWebhook(ctx context.Context, id int) (Webhook, error)
+	ListWebhooks(ctx context.Context) ([]Webhook, error)
+	DeleteWebhook(ctx context.Context, id int) error
+	// EnqueueDeliveries queues payload for every webhook subscribed to
+	// eventType. traceparent, when set, continues the trace of the
+	// request that caused the event.
+	EnqueueDeliveries(ctx context.Context, eventType string, payload []byte, now time.Time, traceparent string) error
+	ListDeliveries(ctx context.Context, webhookID int) ([]WebhookDelivery, error)
+	GetDelivery(ctx context.Context, id int) (WebhookDelivery, error)
+	DueDeliveries(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error)
+	ClaimDelivery(ctx context.Context, id int, now time.Time, lease time.Duration) (bool, error)
+	MarkDeliverySucceeded(ctx context.Context, id, responseStatus int, now time.Time) error
+	// MarkDeliveryFailed schedules another attempt at retryAt, or moves the
+	// delivery to the dead-letter state when dead is true.
+	MarkDeliveryFailed(ctx context.Context, id, responseStatus int, reason string, retryAt time.Time, dead bool) error
+	// Redeliver puts a delivery back in the queue with a fresh attempt
+	// budget, whatever state it was in.
+	Redeliver(ctx context.Context, id int, now time.Time) error
+}
+
+// ProjectResolver maps a task to the root of its tree, which the live
+// endpoint uses as the project the task belongs to.
+type ProjectResolver interface {
+	RootTaskID(ctx context.Context, id int) (int, error)
+}
+
+// TaskEventStore is the persisted log behind GET /tasks/stream. IDs increase
+// monotonically and double as SSE event IDs.
+type TaskEventStore interface {
+	AppendTaskEvent(ctx context.Context, e Event) (int64, error)
+	TaskEventsSince(ctx context.Context, afterID int64, limit int) ([]LoggedEvent, error)
+	// TaskEventBounds returns the oldest and newest retained IDs, or zeros
+	// when the log is empty.
+	TaskEventBounds(ctx context.Context) (oldest, newest int64, err error)
+}
+
+type LoggedEvent struct {
+	ID    int64
+	Event Event
+}
+
+type SyncStore interface {
+	// SyncChanges returns tasks changed and deleted after since, up to
+	// limit changes in all, and the sequence number to resume from.
+	SyncChanges(ctx context.Context, since int64, limit int) (SyncPage, error)
+	SyncState(ctx context.Context, taskID int) (TaskSyncState, error)
+}
+
+// IdempotencyStore remembers Idempotency-Key requests per user.
+type IdempotencyStore interface {
+	// BeginIdempotent claims key for userID for idempotencyLease. When the
+	// key is already held, started is false and rec is the existing record.
+	// Keys older than idempotencyTTL are treated as unused, and an
+	// unfinished claim on the same request whose lease has run out is
+	// taken over.
+	BeginIdempotent(ctx context.Context, userID int, key, fingerprint string, now time.Time) (rec IdempotencyRecord, started bool, err error)
+	CompleteIdempotent(ctx context.Context, userID int, key string, status int, header http.Header, body []byte) error
+	// ReleaseIdempotent forgets an unfinished key so the request can be
+	// retried.
+	ReleaseIdempotent(ctx context.Context, userID int, key string) error
+}
+
+type SyncPage struct {
+	Tasks   []Task
+	Deleted []SyncTombstone
+	Seq     int64
+	HasMore bool
+}
+
+// TaskSyncState is what last-writer-wins needs to know about a task.
+// Clocks holds per-field write times in unix milliseconds; a field that was
+// never written since creation has no entry.
+type TaskSyncState struct {
+	ChangeSeq int64
+	Clocks    map[string]int64
+	Deleted   bool
+}
+
+// DueReminder pairs a reminder with the task it is about.
+type DueReminder struct {
+	Reminder Reminder
+	Task     Task
+	Attempts int
 }
 
 var (
-	ErrNotFound = errors.New("not found")
-	ErrInvalid  = errors.New("invalid input")
+	ErrNotFound        = errors.New("not found")
+	ErrInvalid         = errors.New("invalid input")
+	ErrCycle           = errors.New("parent would create a cycle")
+	ErrDependencyCycle = errors.New("dependency would create a cycle")
+	ErrTooDeep         = errors.New("subtask depth limit exceeded")
+	ErrOpenSubtasks    = errors.New("task has open subtasks")
+	ErrBlocked         = errors.New("task has open blockers")
+	ErrConflict        = errors.New("task changed concurrently")
 )
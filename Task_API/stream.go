@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// streamEvents are the events pushed to GET /tasks/stream. task.completed
+// is left out because the same change already arrives as task.updated.
+var streamEvents = []string{EventTaskCreated, EventTaskUpdated, EventTaskDeleted}
+
+const (
+	streamHeartbeat     = 15 * time.Second
+	streamWriteDeadline = 15 * time.Second
+	streamBatch         = 200
+)
+
+// StreamBroker wakes open streams when the event log grows. Wake-ups carry
+// no data; each stream reads what it has not seen from the log, so a slow
+// client just catches up on its next turn instead of being sent a backlog.
+type StreamBroker struct {
+	mu      sync.Mutex
+	clients map[chan struct{}]struct{}
+	closed  bool
+}
+
+func NewStreamBroker() *StreamBroker {
+	return &StreamBroker{clients: make(map[chan struct{}]struct{})}
+}
+
+// Subscribe returns a channel that receives a value after new events are
+// logged and is closed when the broker shuts down.
+func (b *StreamBroker) Subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	b.clients[ch] = struct{}{}
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.clients[ch]; ok {
+			delete(b.clients, ch)
+			close(ch)
+		}
+	}
+}
+
+func (b *StreamBroker) Notify() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.clients {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Close ends every open stream. It is registered with srv.RegisterOnShutdown.
+func (b *StreamBroker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for ch := range b.clients {
+		delete(b.clients, ch)
+		close(ch)
+	}
+}
+
+// SubscribeTaskStream records lifecycle events in the log and wakes the
+// open streams.
+func SubscribeTaskStream(events *EventBus, store TaskEventStore, broker *StreamBroker) {
+	events.Subscribe(func(ctx context.Context, e Event) {
+		if !slices.Contains(streamEvents, e.Type) {
+			return
+		}
+		if _, err := store.AppendTaskEvent(ctx, e); err != nil {
+			loggerFrom(ctx).Error("append task event failed", "event", e.Type, "task_id", e.TaskID, "error", err)
+			return
+		}
+		broker.Notify()
+	})
+}
+
+// taskStreamHandler serves GET /tasks/stream. It takes the same filters as
+// GET /tasks and resumes after Last-Event-ID (or ?lastEventId=) when given.
+// A client that has fallen out of the retained log gets a "reset" event and
+// should reload the list. Updates are filtered on the task's new state, so
+// a task that stops matching simply stops appearing.
+//
+// The server's WriteTimeout would cut the stream off, so the handler
+// replaces it with a deadline that is pushed forward before every write.
+func taskStreamHandler(store TaskEventStore, broker *StreamBroker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseTaskFilter(r)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		lastID := int64(-1)
+		if v := r.Header.Get("Last-Event-ID"); v != "" {
+			lastID, err = strconv.ParseInt(v, 10, 64)
+		} else if v := r.URL.Query().Get("lastEventId"); v != "" {
+			lastID, err = strconv.ParseInt(v, 10, 64)
+		}
+		if err != nil || lastID < -1 {
+			writeErr(w, http.StatusBadRequest, "invalid last event id")
+			return
+		}
+
+		// Subscribe before reading the log so nothing logged in between
+		// is missed.
+		wake, unsubscribe := broker.Subscribe()
+		defer unsubscribe()
+
+		oldest, newest, err := store.TaskEventBounds(r.Context())
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		rc := http.NewResponseController(w)
+		write := func(format string, args ...any) error {
+			_ = rc.SetWriteDeadline(time.Now().Add(streamWriteDeadline))
+			if _, err := fmt.Fprintf(w, format, args...); err != nil {
+				return err
+			}
+			return rc.Flush()
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		if err := write("retry: 3000\n\n"); err != nil {
+			return
+		}
+		// The request is answered; what follows is the stream, not part
+		// of the request's span.
+		endRequestSpan(r.Context())
+
+		switch {
+		case lastID < 0:
+			lastID = newest
+		case oldest > 0 && lastID < oldest-1:
+			if err := write("event: reset\ndata: {}\n\n"); err != nil {
+				return
+			}
+			lastID = newest
+		}
+
+		heartbeat := time.NewTicker(streamHeartbeat)
+		defer heartbeat.Stop()
+
+		for {
+			// A failed read (typically SQLITE_BUSY under write load) is
+			// retried shortly rather than dropping the client.
+			var retry <-chan time.Time
+			for {
+				batch, err := store.TaskEventsSince(r.Context(), lastID, streamBatch)
+				if err != nil {
+					loggerFrom(r.Context()).Warn("read task events failed", "after", lastID, "error", err)
+					retry = time.After(time.Second)
+					break
+				}
+				for _, le := range batch {
+					lastID = le.ID
+					if le.Event.Task != nil && !filter.Matches(*le.Event.Task) {
+						continue
+					}
+					data, err := json.Marshal(le.Event)
+					if err != nil {
+						continue
+					}
+					if err := write("id: %d\nevent: %s\ndata: %s\n\n", le.ID, le.Event.Type, data); err != nil {
+						return
+					}
+				}
+				if len(batch) < streamBatch {
+					break
+				}
+			}
+
+			select {
+			case <-r.Context().Done():
+				return
+			case _, ok := <-wake:
+				if !ok {
+					return
+				}
+			case <-retry:
+			case <-heartbeat.C:
+				if err := write(": ping\n\n"); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type sseEvent struct {
+	id, event, data string
+}
+
+// readSSE parses events off an open stream, skipping comments and the
+// retry hint, and sends them on the returned channel.
+func readSSE(t *testing.T, resp *http.Response) <-chan sseEvent {
+	t.Helper()
+	out := make(chan sseEvent, 16)
+	go func() {
+		defer close(out)
+		sc := bufio.NewScanner(resp.Body)
+		var ev sseEvent
+		for sc.Scan() {
+			line := sc.Text()
+			switch {
+			case line == "":
+				if ev.event != "" || ev.data != "" {
+					out <- ev
+				}
+				ev = sseEvent{}
+			case strings.HasPrefix(line, "id: "):
+				ev.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				ev.event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				ev.data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+	return out
+}
+
+func nextSSE(t *testing.T, ch <-chan sseEvent) sseEvent {
+	t.Helper()
+	select {
+	case ev, ok := <-ch:
+		if !ok {
+			t.Fatal("stream closed")
+		}
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+	return sseEvent{}
+}
+
+func newStreamServer(t *testing.T) (*SQLiteStore, *EventBus, *httptest.Server) {
+	t.Helper()
+	s := newTestStore(t)
+	events := NewEventBus()
+	broker := NewStreamBroker()
+	SubscribeTaskStream(events, s, broker)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /tasks/stream", taskStreamHandler(s, broker))
+	srv := httptest.NewUnstartedServer(Chain(mux, Logging))
+	// Much shorter than the stream lives, to prove the handler lifts it.
+	srv.Config.WriteTimeout = 200 * time.Millisecond
+	srv.Config.RegisterOnShutdown(broker.Close)
+	srv.Start()
+	t.Cleanup(func() {
+		broker.Close()
+		srv.Close()
+	})
+	return s, events, srv
+}
+
+func openStream(t *testing.T, url, lastEventID string) <-chan sseEvent {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	return readSSE(t, resp)
+}
+
+func TestTaskStream_FiltersAndOutlivesWriteTimeout(t *testing.T) {
+	_, events, srv := newStreamServer(t)
+	stream := openStream(t, srv.URL+"/tasks/stream?completed=true", "")
+
+	time.Sleep(400 * time.Millisecond)
+	events.Publish(Event{Type: EventTaskCreated, TaskID: 1, Task: &Task{ID: 1, Title: "open"}})
+	events.Publish(Event{Type: EventTaskUpdated, TaskID: 1, Task: &Task{ID: 1, Title: "open", Completed: true}})
+	events.Publish(Event{Type: EventTaskCompleted, TaskID: 1, Task: &Task{ID: 1, Title: "open", Completed: true}})
+	events.Publish(Event{Type: EventTaskDeleted, TaskID: 1})
+
+	first := nextSSE(t, stream)
+	if first.event != EventTaskUpdated || first.id != "2" {
+		t.Fatalf("expected the completing update as event 2, got %+v", first)
+	}
+	if second := nextSSE(t, stream); second.event != EventTaskDeleted {
+		t.Fatalf("expected the delete next, got %+v", second)
+	}
+}
+
+func TestTaskStream_ResumesFromLastEventID(t *testing.T) {
+	s, events, srv := newStreamServer(t)
+	for i := 1; i <= 3; i++ {
+		events.Publish(Event{Type: EventTaskCreated, TaskID: i, Task: &Task{ID: i}})
+	}
+
+	stream := openStream(t, srv.URL+"/tasks/stream", "1")
+	for _, want := range []string{"2", "3"} {
+		if ev := nextSSE(t, stream); ev.id != want {
+			t.Fatalf("expected replay of event %s, got %+v", want, ev)
+		}
+	}
+
+	// Fall out of the retained log and the client is told to reload.
+	if _, err := s.db.Exec(`DELETE FROM task_events WHERE id <= 2`); err != nil {
+		t.Fatal(err)
+	}
+	stale := openStream(t, srv.URL+"/tasks/stream", "1")
+	if ev := nextSSE(t, stale); ev.event != "reset" {
+		t.Fatalf("expected a reset event, got %+v", ev)
+	}
+}
+
+func TestTaskStream_EndsOnShutdown(t *testing.T) {
+	_, _, srv := newStreamServer(t)
+	stream := openStream(t, srv.URL+"/tasks/stream", "")
+
+	srv.Config.Shutdown(t.Context())
+	select {
+	case _, ok := <-stream:
+		if ok {
+			t.Fatal("expected no events, only the stream closing")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream still open after shutdown")
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+const (
+	ParentCompletionAllow   = "allow"
+	ParentCompletionReject  = "reject"
+	ParentCompletionCascade = "cascade"
+)
+
+// SubtaskPolicy controls how deep task trees may grow and how completion
+// flows between a parent and its subtasks.
+type SubtaskPolicy struct {
+	MaxDepth            int
+	ParentCompletion    string
+	AutoCompleteParents bool
+}
+
+func DefaultSubtaskPolicy() SubtaskPolicy {
+	return SubtaskPolicy{
+		MaxDepth:         5,
+		ParentCompletion: ParentCompletionAllow,
+	}
+}
+
+type taskNode struct {
+	parentID  *int
+	completed bool
+}
+
+// rollupProgress computes a completion percentage for every node. A leaf is
+// 0 or 100; a parent is the average of its children's progress.
+func rollupProgress(nodes map[int]taskNode) map[int]int {
+	children := make(map[int][]int)
+	for id, n := range nodes {
+		if n.parentID != nil {
+			children[*n.parentID] = append(children[*n.parentID], id)
+		}
+	}
+
+	progress := make(map[int]int, len(nodes))
+	visiting := make(map[int]bool)
+	var visit func(id int) int
+	visit = func(id int) int {
+		if p, ok := progress[id]; ok {
+			return p
+		}
+		if visiting[id] {
+			return 0
+		}
+		visiting[id] = true
+		defer delete(visiting, id)
+
+		if nodes[id].completed {
+			progress[id] = 100
+			return 100
+		}
+		kids := children[id]
+		if len(kids) == 0 {
+			progress[id] = 0
+			return 0
+		}
+		sum := 0
+		for _, k := range kids {
+			sum += visit(k)
+		}
+		progress[id] = sum / len(kids)
+		return progress[id]
+	}
+	for id := range nodes {
+		visit(id)
+	}
+	return progress
+}
+
+// buildTaskTree nests tasks under their parents. Tasks whose parent is not in
+// the list become roots, so a filtered list still renders.
+func buildTaskTree(tasks []Task) []Task {
+	present := make(map[int]bool, len(tasks))
+	for _, t := range tasks {
+		present[t.ID] = true
+	}
+
+	children := make(map[int][]Task)
+	var roots []Task
+	for _, t := range tasks {
+		if t.ParentID != nil && present[*t.ParentID] && *t.ParentID != t.ID {
+			children[*t.ParentID] = append(children[*t.ParentID], t)
+		} else {
+			roots = append(roots, t)
+		}
+	}
+
+	var attach func(t Task, depth int) Task
+	attach = func(t Task, depth int) Task {
+		if depth > len(tasks) {
+			return t
+		}
+		for _, c := range children[t.ID] {
+			t.Subtasks = append(t.Subtasks, attach(c, depth+1))
+		}
+		return t
+	}
+	out := make([]Task, 0, len(roots))
+	for _, r := range roots {
+		out = append(out, attach(r, 0))
+	}
+	return out
+}
+
+func parseTreeParam(r *http.Request) (bool, error) {
+	val := r.URL.Query().Get("tree")
+	if val == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(val)
+}
+
+func getSubtasksHandler(store TaskStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idStr := r.PathValue("ID")
+		id, err := strconv.Atoi(idStr)
+		if err != nil || id <= 0 {
+			writeErr(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		tree, err := parseTreeParam(r)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, "invalid tree value")
+			return
+		}
+
+		descendants, err := store.GetSubtasks(r.Context(), id)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				writeErr(w, http.StatusNotFound, "not found")
+			} else {
+				writeErr(w, http.StatusInternalServerError, "internal error")
+			}
+			return
+		}
+
+		if tree {
+			writeJSON(w, http.StatusOK, buildTaskTree(descendants))
+			return
+		}
+		direct := []Task{}
+		for _, t := range descendants {
+			if t.ParentID != nil && *t.ParentID == id {
+				direct = append(direct, t)
+			}
+		}
+		writeJSON(w, http.StatusOK, direct)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestRollupProgress(t *testing.T) {
+	root, mid := 1, 2
+	nodes := map[int]taskNode{
+		1: {},
+		2: {parentID: &root},
+		3: {parentID: &root, completed: true},
+		4: {parentID: &mid, completed: true},
+		5: {parentID: &mid},
+	}
+
+	progress := rollupProgress(nodes)
+
+	if progress[2] != 50 {
+		t.Fatalf("expected mid progress 50, got %d", progress[2])
+	}
+	if progress[1] != 75 {
+		t.Fatalf("expected root progress 75, got %d", progress[1])
+	}
+	if progress[3] != 100 || progress[5] != 0 {
+		t.Fatalf("unexpected leaf progress: %v", progress)
+	}
+}
+
+func TestBuildTaskTree_OrphansBecomeRoots(t *testing.T) {
+	missing := 42
+	tasks := []Task{{ID: 7, Title: "orphan", ParentID: &missing}}
+
+	tree := buildTaskTree(tasks)
+
+	if len(tree) != 1 || tree[0].ID != 7 {
+		t.Fatalf("expected orphan as root, got %+v", tree)
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	syncPageSize     = 500
+	maxSyncMutations = 500
+	syncRetries      = 3
+)
+
+// The sync token is opaque to clients. Today it is the change sequence
+// number the next GET /sync should resume after.
+func formatSyncToken(seq int64) string {
+	return strconv.FormatInt(seq, 10)
+}
+
+func parseSyncToken(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	seq, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || seq < 0 {
+		return 0, errors.New("invalid sync token")
+	}
+	return seq, nil
+}
+
+type syncResponse struct {
+	Tasks   []Task          `json:"tasks"`
+	Deleted []SyncTombstone `json:"deleted"`
+	Token   string          `json:"token"`
+	HasMore bool            `json:"hasMore"`
+}
+
+// getSyncHandler returns everything that changed after ?since=. Without a
+// token it returns every task. When hasMore is set the client should call
+// again straight away with the new token.
+func getSyncHandler(store SyncStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		since, err := parseSyncToken(r.URL.Query().Get("since"))
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		page, err := store.SyncChanges(r.Context(), since, syncPageSize)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		writeJSON(w, http.StatusOK, syncResponse{
+			Tasks:   page.Tasks,
+			Deleted: page.Deleted,
+			Token:   formatSyncToken(page.Seq),
+			HasMore: page.HasMore,
+		})
+	}
+}
+
+// syncMutation is one offline change. ChangedAt is when the user made it
+// on the device; it decides last-writer-wins per field and is capped at the
+// server's clock so a fast device clock cannot win forever.
+type syncMutation struct {
+	ClientID  string                     `json:"clientId"`
+	Op        string                     `json:"op"`
+	ID        int                        `json:"id,omitempty"`
+	Task      *Task                      `json:"task,omitempty"`
+	Fields    map[string]json.RawMessage `json:"fields,omitempty"`
+	ChangedAt time.Time                  `json:"changedAt"`
+}
+
+const (
+	SyncApplied  = "applied"
+	SyncPartial  = "partial"
+	SyncConflict = "conflict"
+	SyncRejected = "rejected"
+)
+
+type syncResult struct {
+	ClientID string `json:"clientId"`
+	Status   string `json:"status"`
+	ID       int    `json:"id,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Task     *Task  `json:"task,omitempty"`
+}
+
+// SyncFieldConflict reports a field the server kept because it was written
+// more recently than the client's change.
+type SyncFieldConflict struct {
+	ClientID        string          `json:"clientId"`
+	ID              int             `json:"id"`
+	Field           string          `json:"field"`
+	ClientValue     json.RawMessage `json:"clientValue"`
+	ServerValue     any             `json:"serverValue"`
+	ServerChangedAt time.Time       `json:"serverChangedAt"`
+}
+
+type syncUploadResponse struct {
+	Results   []syncResult        `json:"results"`
+	Conflicts []SyncFieldConflict `json:"conflicts"`
+}
+
+// postSyncHandler applies a batch of offline mutations in order. Each one
+// succeeds or fails on its own; the response reports every outcome and
+// every field conflict.
+func postSyncHandler(tasks TaskStore, sync SyncStore, users UserStore, events *EventBus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Mutations []syncMutation `json:"mutations"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeErr(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		if len(req.Mutations) > maxSyncMutations {
+			writeErr(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("at most %d mutations per request", maxSyncMutations))
+			return
+		}
+
+		actorID := getUserID(r)
+		a := syncApplier{
+			tasks:   tasks,
+			sync:    sync,
+			events:  events,
+			auth:    newTaskAuth(r.Context(), users, actorID),
+			actorID: actorID,
+			now:     time.Now(),
+		}
+		resp := syncUploadResponse{Results: []syncResult{}, Conflicts: []SyncFieldConflict{}}
+		for _, m := range req.Mutations {
+			res, conflicts := a.apply(r.Context(), m)
+			resp.Results = append(resp.Results, res)
+			resp.Conflicts = append(resp.Conflicts, conflicts...)
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+type syncApplier struct {
+	tasks   TaskStore
+	sync    SyncStore
+	events  *EventBus
+	auth    taskAuth
+	actorID int
+	now     time.Time
+}
+
+func (a syncApplier) apply(ctx context.Context, m syncMutation) (syncResult, []SyncFieldConflict) {
+	res := syncResult{ClientID: m.ClientID, ID: m.ID}
+	reject := func(err error) (syncResult, []SyncFieldConflict) {
+		res.Status = SyncRejected
+		res.Error = publicErrorMessage(ctx, err)
+		return res, nil
+	}
+
+	switch m.Op {
+	case "create":
+		if m.Task == nil {
+			return reject(fmt.Errorf("%w: task is required", ErrInvalid))
+		}
+		task := *m.Task
+		task.ID = 0
+		if err := a.auth.move(ctx, a.tasks, nil, task.ParentID); err != nil {
+			return reject(err)
+		}
+		if err := a.tasks.CreateTask(ctx, &task); err != nil {
+			return reject(err)
+		}
+		a.events.publishTaskCreated(ctx, &task, a.actorID)
+		res.Status, res.ID, res.Task = SyncApplied, task.ID, &task
+		return res, nil
+
+	case "delete":
+		state, err := a.sync.SyncState(ctx, m.ID)
+		if err != nil {
+			return reject(err)
+		}
+		if state.Deleted {
+			res.Status = SyncApplied
+			return res, nil
+		}
+		before, err := a.auth.task(ctx, a.tasks, m.ID)
+		var subtasks []Task
+		if err == nil {
+			subtasks, err = a.auth.subtree(ctx, a.tasks, m.ID)
+		}
+		if err == nil {
+			err = a.tasks.DeleteTask(ctx, m.ID)
+		}
+		if err != nil {
+			return reject(err)
+		}
+		a.events.publishSubtreeDeleted(ctx, before, subtasks, a.actorID)
+		res.Status = SyncApplied
+		return res, nil
+
+	case "update":
+		return a.update(ctx, m)
+	}
+	return reject(fmt.Errorf("%w: unknown op %q", ErrInvalid, m.Op))
+}
+
+// update merges the client's fields into the current task, keeping any
+// field the server wrote after the client's change. The write is
+// conditional on the task not having moved underneath, and retried if it
+// has.
+func (a syncApplier) update(ctx context.Context, m syncMutation) (syncResult, []SyncFieldConflict) {
+	res := syncResult{ClientID: m.ClientID, ID: m.ID}
+	changedAt := m.ChangedAt
+	if changedAt.IsZero() || changedAt.After(a.now) {
+		changedAt = a.now
+	}
+
+	names := make([]string, 0, len(m.Fields))
+	for name := range m.Fields {
+		if !isSyncField(name) {
+			res.Status, res.Error = SyncRejected, fmt.Sprintf("unknown field %q", name)
+			return res, nil
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for attempt := 0; attempt < syncRetries; attempt++ {
+		state, err := a.sync.SyncState(ctx, m.ID)
+		if err == nil && state.Deleted {
+			res.Status, res.Error = SyncConflict, "task was deleted"
+			return res, nil
+		}
+		var current Task
+		if err == nil {
+			current, err = a.auth.task(ctx, a.tasks, m.ID)
+		}
+		if err != nil {
+			res.Status, res.Error = SyncRejected, publicErrorMessage(ctx, err)
+			return res, nil
+		}
+
+		merged := current
+		var applied []string
+		var conflicts []SyncFieldConflict
+		for _, name := range names {
+			if serverMS, ok := state.Clocks[name]; ok && serverMS > changedAt.UnixMilli() {
+				conflicts = append(conflicts, SyncFieldConflict{
+					ClientID:        m.ClientID,
+					ID:              m.ID,
+					Field:           name,
+					ClientValue:     m.Fields[name],
+					ServerValue:     syncFieldValue(current, name),
+					ServerChangedAt: time.UnixMilli(serverMS).UTC(),
+				})
+				continue
+			}
+			if err := setSyncField(&merged, name, m.Fields[name]); err != nil {
+				res.Status, res.Error = SyncRejected, fmt.Sprintf("invalid value for %s", name)
+				return res, nil
+			}
+			applied = append(applied, name)
+		}
+
+		if len(applied) == 0 {
+			res.Status, res.Task = SyncConflict, &current
+			return res, conflicts
+		}
+		if err := a.auth.move(ctx, a.tasks, current.ParentID, merged.ParentID); err != nil {
+			res.Status, res.Error = SyncRejected, publicErrorMessage(ctx, err)
+			return res, nil
+		}
+
+		var updated UpdateResult
+		err = a.tasks.UpdateTask(ctx, &merged, UpdateOptions{
+			IfChangeSeq: state.ChangeSeq,
+			FieldClocks: applied,
+			ClockMS:     changedAt.UnixMilli(),
+			Result:      &updated,
+		})
+		if errors.Is(err, ErrConflict) {
+			continue
+		}
+		if err != nil {
+			res.Status, res.Error = SyncRejected, publicErrorMessage(ctx, err)
+			return res, nil
+		}
+		a.events.publishTaskUpdateResult(ctx, a.tasks, &merged, updated, a.actorID)
+
+		res.Status, res.Task = SyncApplied, &merged
+		if len(conflicts) > 0 {
+			res.Status = SyncPartial
+		}
+		return res, conflicts
+	}
+
+	res.Status, res.Error = SyncRejected, "task is changing too quickly, retry later"
+	return res, nil
+}
+
+func syncFieldValue(t Task, name string) any {
+	switch name {
+	case "title":
+		return t.Title
+	case "description":
+		return t.Description
+	case "completed":
+		return t.Completed
+	case "parentId":
+		return t.ParentID
+	case "dueAt":
+		return t.DueAt
+	case "rrule":
+		return t.RRule
+	case "timeZone":
+		return t.TimeZone
+	}
+	return nil
+}
+
+func setSyncField(t *Task, name string, raw json.RawMessage) error {
+	switch name {
+	case "title":
+		return json.Unmarshal(raw, &t.Title)
+	case "description":
+		return json.Unmarshal(raw, &t.Description)
+	case "completed":
+		return json.Unmarshal(raw, &t.Completed)
+	case "parentId":
+		t.ParentID = nil
+		return json.Unmarshal(raw, &t.ParentID)
+	case "dueAt":
+		t.DueAt = nil
+		return json.Unmarshal(raw, &t.DueAt)
+	case "rrule":
+		return json.Unmarshal(raw, &t.RRule)
+	case "timeZone":
+		return json.Unmarshal(raw, &t.TimeZone)
+	}
+	return fmt.Errorf("unknown field %q", name)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func getSync(t *testing.T, s *SQLiteStore, token string) syncResponse {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/sync?since="+token, nil)
+	rec := httptest.NewRecorder()
+	getSyncHandler(s).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /sync: %d %s", rec.Code, rec.Body)
+	}
+	var resp syncResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func postSync(t *testing.T, s *SQLiteStore, body string) syncUploadResponse {
+	t.Helper()
+	return postSyncAs(t, s, demoUserID, body)
+}
+
+func postSyncAs(t *testing.T, s *SQLiteStore, userID int, body string) syncUploadResponse {
+	t.Helper()
+	req := withUser(httptest.NewRequest(http.MethodPost, "/sync", bytes.NewBufferString(body)), userID)
+	rec := httptest.NewRecorder()
+	postSyncHandler(s, s, s, nil).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("POST /sync: %d %s", rec.Code, rec.Body)
+	}
+	var resp syncUploadResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestSync_ChangesAndTombstones(t *testing.T) {
+	s := newTestStore(t)
+	a, b := Task{Title: "a"}, Task{Title: "b"}
+	for _, task := range []*Task{&a, &b} {
+		if err := s.CreateTask(t.Context(), task); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first := getSync(t, s, "")
+	if len(first.Tasks) != 2 || len(first.Deleted) != 0 || first.HasMore {
+		t.Fatalf("expected a full first sync, got %+v", first)
+	}
+	if again := getSync(t, s, first.Token); len(again.Tasks) != 0 {
+		t.Fatalf("expected nothing new, got %+v", again)
+	}
+
+	a.Title = "a2"
+	if err := s.UpdateTask(t.Context(), &a, UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AssignUser(t.Context(), b.ID, demoUserID); err != nil {
+		t.Fatal(err)
+	}
+	c := Task{Title: "c"}
+	if err := s.CreateTask(t.Context(), &c); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteTask(t.Context(), c.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	delta := getSync(t, s, first.Token)
+	if len(delta.Tasks) != 2 || delta.Tasks[0].Title != "a2" || delta.Tasks[1].ID != b.ID {
+		t.Fatalf("expected the renamed and the reassigned task, got %+v", delta.Tasks)
+	}
+	if len(delta.Deleted) != 1 || delta.Deleted[0].ID != c.ID {
+		t.Fatalf("expected a tombstone for %d, got %+v", c.ID, delta.Deleted)
+	}
+
+	since, _ := parseSyncToken(first.Token)
+	page, err := s.SyncChanges(t.Context(), since, 1)
+	if err != nil || !page.HasMore || len(page.Tasks) != 1 {
+		t.Fatalf("expected a one-task page with more to come, got %+v (%v)", page, err)
+	}
+	rest, err := s.SyncChanges(t.Context(), page.Seq, 10)
+	if err != nil || rest.HasMore || len(rest.Tasks) != 1 || rest.Tasks[0].ID != b.ID {
+		t.Fatalf("expected the rest on the next page, got %+v (%v)", rest, err)
+	}
+}
+
+func TestSync_TombstonesArePaged(t *testing.T) {
+	s := newTestStore(t)
+	var ids []int
+	for _, title := range []string{"a", "b", "c"} {
+		task := Task{Title: title}
+		if err := s.CreateTask(t.Context(), &task); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, task.ID)
+	}
+	since, _ := parseSyncToken(getSync(t, s, "").Token)
+	for _, id := range ids {
+		if err := s.DeleteTask(t.Context(), id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	page, err := s.SyncChanges(t.Context(), since, 2)
+	if err != nil || !page.HasMore || len(page.Deleted) != 2 {
+		t.Fatalf("expected two tombstones with more to come, got %+v (%v)", page, err)
+	}
+	rest, err := s.SyncChanges(t.Context(), page.Seq, 2)
+	if err != nil || rest.HasMore || len(rest.Deleted) != 1 || rest.Deleted[0].ID != ids[2] {
+		t.Fatalf("expected the last tombstone on the next page, got %+v (%v)", rest, err)
+	}
+}
+
+func TestSync_PerFieldLastWriterWins(t *testing.T) {
+	s := newTestStore(t)
+	task := Task{Title: "Plan offsite", Description: "draft"}
+	if err := s.CreateTask(t.Context(), &task); err != nil {
+		t.Fatal(err)
+	}
+
+	// The phone went offline and edited both fields...
+	offlineEdit := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339Nano)
+	// ...while someone renamed the task on the web.
+	task.Title = "Plan team offsite"
+	if err := s.UpdateTask(t.Context(), &task, UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	id := strconv.Itoa(task.ID)
+	resp := postSync(t, s, `{"mutations":[
+		{"clientId":"m1","op":"update","id":`+id+`,"changedAt":"`+offlineEdit+`","fields":{"title":"Plan offsite v2","description":"agenda attached"}},
+		{"clientId":"m2","op":"create","task":{"title":"Book venue"}},
+		{"clientId":"m3","op":"update","id":`+id+`,"fields":{"colour":"red"}}
+	]}`)
+
+	if got := resp.Results[0]; got.Status != SyncPartial {
+		t.Fatalf("expected a partial apply, got %+v", got)
+	}
+	if len(resp.Conflicts) != 1 || resp.Conflicts[0].Field != "title" || resp.Conflicts[0].ServerValue != "Plan team offsite" {
+		t.Fatalf("expected a title conflict, got %+v", resp.Conflicts)
+	}
+	if got := resp.Results[1]; got.Status != SyncApplied || got.ID == 0 {
+		t.Fatalf("expected the create to be applied with a server id, got %+v", got)
+	}
+	if got := resp.Results[2]; got.Status != SyncRejected {
+		t.Fatalf("expected an unknown field to be rejected, got %+v", got)
+	}
+
+	stored, _ := s.GetTaskByID(t.Context(), task.ID)
+	if stored.Title != "Plan team offsite" || stored.Description != "agenda attached" {
+		t.Fatalf("expected server title and client description, got %+v", stored)
+	}
+
+	// A later edit wins.
+	resp = postSync(t, s, `{"mutations":[{"clientId":"m4","op":"update","id":`+id+`,"fields":{"title":"Offsite"}}]}`)
+	if resp.Results[0].Status != SyncApplied {
+		t.Fatalf("expected a fresh edit to win, got %+v", resp.Results[0])
+	}
+}
+
+func TestSync_DeletesAreIdempotentAndWinOverUpdates(t *testing.T) {
+	s := newTestStore(t)
+	task := Task{Title: "Old"}
+	if err := s.CreateTask(t.Context(), &task); err != nil {
+		t.Fatal(err)
+	}
+	id := strconv.Itoa(task.ID)
+
+	resp := postSync(t, s, `{"mutations":[
+		{"clientId":"d1","op":"delete","id":`+id+`},
+		{"clientId":"d2","op":"delete","id":`+id+`},
+		{"clientId":"u1","op":"update","id":`+id+`,"fields":{"title":"New"}}
+	]}`)
+	if resp.Results[0].Status != SyncApplied || resp.Results[1].Status != SyncApplied {
+		t.Fatalf("expected repeated deletes to succeed, got %+v", resp.Results)
+	}
+	if resp.Results[2].Status != SyncConflict {
+		t.Fatalf("expected an update to a deleted task to conflict, got %+v", resp.Results[2])
+	}
+}
+
+func TestSync_AuthorizesEachMutation(t *testing.T) {
+	s := newTestStore(t)
+	alice, bob := User{Name: "Alice"}, User{Name: "Bob"}
+	for _, u := range []*User{&alice, &bob} {
+		if err := s.CreateUser(t.Context(), u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mine := Task{Title: "mine", Assignees: []int{alice.ID}}
+	theirs := Task{Title: "theirs", Assignees: []int{bob.ID}}
+	for _, task := range []*Task{&mine, &theirs} {
+		if err := s.CreateTask(t.Context(), task); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp := postSyncAs(t, s, alice.ID, fmt.Sprintf(`{"mutations":[
+		{"clientId":"1","op":"update","id":%[1]d,"fields":{"title":"taken"}},
+		{"clientId":"2","op":"delete","id":%[1]d},
+		{"clientId":"3","op":"update","id":%[2]d,"fields":{"parentId":%[1]d}},
+		{"clientId":"4","op":"create","task":{"title":"x","parentId":%[1]d}},
+		{"clientId":"5","op":"update","id":%[2]d,"fields":{"title":"renamed"}}
+	]}`, theirs.ID, mine.ID))
+	for _, r := range resp.Results[:4] {
+		if r.Status != SyncRejected || !strings.Contains(r.Error, errNotAllowed.Error()) {
+			t.Errorf("mutation %s: %+v, want rejected as not allowed", r.ClientID, r)
+		}
+	}
+	if r := resp.Results[4]; r.Status != SyncApplied {
+		t.Errorf("update own task: %+v", r)
+	}
+	got, err := s.GetTaskByID(t.Context(), theirs.ID)
+	if err != nil || got.Title != "theirs" {
+		t.Errorf("their task = %+v, err %v", got, err)
+	}
+}
+
+func TestUpdateTask_IfChangeSeq(t *testing.T) {
+	s := newTestStore(t)
+	task := Task{Title: "x"}
+	if err := s.CreateTask(t.Context(), &task); err != nil {
+		t.Fatal(err)
+	}
+	state, err := s.SyncState(t.Context(), task.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	task.Title = "y"
+	if err := s.UpdateTask(t.Context(), &task, UpdateOptions{IfChangeSeq: state.ChangeSeq}); err != nil {
+		t.Fatalf("expected the first conditional update to pass: %v", err)
+	}
+	task.Title = "z"
+	if err := s.UpdateTask(t.Context(), &task, UpdateOptions{IfChangeSeq: state.ChangeSeq}); err != ErrConflict {
+		t.Fatalf("expected ErrConflict for a stale sequence, got %v", err)
+	}
+}
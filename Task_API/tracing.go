@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const traceparentHeader = "traceparent"
+
+// propagator reads and writes the W3C traceparent header.
+var propagator = propagation.TraceContext{}
+
+// TracingConfig selects the exporter: "otlp" or "stdout", or "" to leave
+// tracing off.
+type TracingConfig struct {
+	Exporter     string
+	OTLPEndpoint string
+	ServiceName  string
+}
+
+// NewTracerProvider returns the provider cfg selects, and a function that
+// exports the spans still buffered and stops it. Spans are exported in
+// batches off the request path. With tracing off the provider is a no-op.
+func NewTracerProvider(ctx context.Context, cfg TracingConfig) (trace.TracerProvider, func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(cfg.OTLPEndpoint+"/v1/traces"))
+	case "stdout":
+		exporter, err = stdouttrace.New()
+	default:
+		return noop.NewTracerProvider(), func(context.Context) error { return nil }, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", cfg.ServiceName))),
+	)
+	return tp, tp.Shutdown, nil
+}
+
+// noopTracer is what components trace with until they are given a tracer.
+func noopTracer() trace.Tracer {
+	return noop.NewTracerProvider().Tracer("")
+}
+
+// setSpanError marks span as failed with err, if there is one.
+func setSpanError(span trace.Span, err error) {
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// traceparentFrom returns the header value that continues the trace of the
+// span in ctx, or "" if there is none.
+func traceparentFrom(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	return carrier.Get(traceparentHeader)
+}
+
+// endRequestSpan ends the span Tracing started for the request in ctx now
+// rather than when the handler returns, for handlers such as event streams
+// that keep the connection long after answering. The span gets the status
+// written so far.
+func endRequestSpan(ctx context.Context) {
+	if end, ok := ctx.Value(endSpanKey).(func()); ok {
+		end()
+	}
+}
+
+// secretPathRoutes carry a credential in the path, so their spans record
+// the route but not the path.
+var secretPathRoutes = map[string]bool{
+	"GET /calendar/{file}": true,
+}
+
+// Tracing starts a server span for each request with tracer, continuing
+// the caller's trace when it sends a traceparent header. The span is named
+// after the matched route and carries the status, the user and, on
+// /tasks/{ID} routes, the task. It passes a new request down, so it has to
+// be the last middleware before the mux that replaces the request: it
+// records the route for the ones above it.
+func Tracing(tracer trace.Tracer) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			// The route is not known until the mux has run, and the raw path
+			// may hold a secret, so the span is renamed when it ends.
+			ctx, span := tracer.Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer))
+			if !span.IsRecording() {
+				next.ServeHTTP(w, r)
+				recordRoute(r)
+				return
+			}
+			traceID := span.SpanContext().TraceID().String()
+			ctx = withLogger(ctx, loggerFrom(ctx).With("trace_id", traceID))
+			if info := requestInfoFrom(ctx); info != nil {
+				info.TraceID = traceID
+			}
+
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			end := sync.OnceFunc(func() {
+				recordRoute(r)
+				span.SetName(routeLabel(r))
+				span.SetAttributes(
+					attribute.String("http.request.method", r.Method),
+					attribute.String("http.route", r.Pattern),
+					attribute.Int("http.response.status_code", rec.status),
+				)
+				if !secretPathRoutes[r.Pattern] {
+					span.SetAttributes(attribute.String("url.path", r.URL.Path))
+				}
+				if id := requestIDFrom(ctx); id != "" {
+					span.SetAttributes(attribute.String("request.id", id))
+				}
+				if info := requestInfoFrom(ctx); info != nil && info.UserID != 0 {
+					span.SetAttributes(attribute.Int("user.id", info.UserID))
+				}
+				if strings.Contains(r.Pattern, "/tasks/{ID}") {
+					if id, err := strconv.Atoi(r.PathValue("ID")); err == nil {
+						span.SetAttributes(attribute.Int("task.id", id))
+					}
+				}
+				if rec.status >= http.StatusInternalServerError {
+					span.SetStatus(codes.Error, http.StatusText(rec.status))
+				}
+				span.End()
+			})
+			r = r.WithContext(context.WithValue(ctx, endSpanKey, end))
+			defer func() {
+				if v := recover(); v != nil {
+					span.SetStatus(codes.Error, fmt.Sprint(v))
+					end()
+					panic(v)
+				}
+				end()
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// withTracer returns a tracer for the test and a function that returns
+// every span it has ended so far.
+func withTracer(t *testing.T) (trace.Tracer, func() []sdktrace.ReadOnlySpan) {
+	t.Helper()
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	t.Cleanup(func() { _ = tp.Shutdown(context.Background()) })
+	return tp.Tracer("test"), rec.Ended
+}
+
+func findSpan(spans []sdktrace.ReadOnlySpan, name string) sdktrace.ReadOnlySpan {
+	for _, s := range spans {
+		if s.Name() == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func spanAttrs(s sdktrace.ReadOnlySpan) map[string]any {
+	attrs := map[string]any{}
+	for _, kv := range s.Attributes() {
+		attrs[string(kv.Key)] = kv.Value.AsInterface()
+	}
+	return attrs
+}
+
+func TestTracing_RequestAndSQLSpans(t *testing.T) {
+	withJWTSecret(t)
+	tracer, spans := withTracer(t)
+	logs := captureLogs(t)
+	s := newTestStore(t)
+	task := Task{Title: "traced"}
+	if err := s.CreateTask(t.Context(), &task); err != nil {
+		t.Fatal(err)
+	}
+	s.db.Tracer = tracer
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /tasks/{ID}", AuthMiddleware(getTaskByIDHandler(s)))
+	token, _ := GenerateJWT(demoUserID)
+	req := httptest.NewRequest(http.MethodGet, "/tasks/"+strconv.Itoa(task.ID), nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(traceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+	Chain(mux, RequestID, Logging, Tracing(tracer), Recover).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rr.Code, rr.Body)
+	}
+
+	got := spans()
+	server := findSpan(got, "GET /tasks/{ID}")
+	if server == nil {
+		t.Fatalf("no server span in %v", got)
+	}
+	if server.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || server.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("server span did not continue the caller's trace: %v, parent %v", server.SpanContext(), server.Parent())
+	}
+	attrs := spanAttrs(server)
+	for k, want := range map[string]any{
+		"http.route":                "GET /tasks/{ID}",
+		"http.response.status_code": int64(http.StatusOK),
+		"user.id":                   int64(demoUserID),
+		"task.id":                   int64(task.ID),
+		"request.id":                rr.Header().Get(requestIDHeader),
+	} {
+		if attrs[k] != want {
+			t.Errorf("server span %s = %v, want %v", k, attrs[k], want)
+		}
+	}
+
+	var taskSelect bool
+	for _, sp := range got {
+		if sp.SpanKind() != trace.SpanKindClient {
+			continue
+		}
+		attrs := spanAttrs(sp)
+		if sp.Parent().SpanID() != server.SpanContext().SpanID() || sp.SpanContext().TraceID() != server.SpanContext().TraceID() {
+			t.Errorf("SQL span %q is not a child of the request", attrs["db.statement"])
+		}
+		if attrs["db.system"] != "sqlite" {
+			t.Errorf("SQL span attrs = %v", attrs)
+		}
+		stmt, _ := attrs["db.statement"].(string)
+		taskSelect = taskSelect || sp.Name() == "SELECT" && strings.Contains(stmt, "FROM tasks WHERE id = ?")
+	}
+	if !taskSelect {
+		t.Errorf("no span for loading the task in %v", got)
+	}
+	// The task created before the request had no span to belong to.
+	for _, sp := range got {
+		if sp.Name() == "INSERT" {
+			t.Errorf("untraced statement exported: %v", spanAttrs(sp)["db.statement"])
+		}
+	}
+
+	access := findLog(logs(), "request")
+	if access == nil || access["route"] != "GET /tasks/{ID}" || access["trace_id"] != server.SpanContext().TraceID().String() {
+		t.Errorf("access line = %v", access)
+	}
+}
+
+func TestTracing_ServerErrorMarksSpan(t *testing.T) {
+	tracer, spans := withTracer(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /broken", func(w http.ResponseWriter, r *http.Request) {
+		writeErr(w, http.StatusInternalServerError, "internal error")
+	})
+	Chain(mux, Tracing(tracer)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/broken", nil))
+
+	span := findSpan(spans(), "GET /broken")
+	if span == nil || span.Status().Code != codes.Error {
+		t.Fatalf("span = %v", span)
+	}
+}
+
+func TestTracing_KeepsCalendarTokenOutOfSpans(t *testing.T) {
+	tracer, spans := withTracer(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /calendar/{file}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	Chain(mux, Tracing(tracer)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/calendar/s3cret.ics", nil))
+
+	got := spans()
+	if len(got) != 1 || got[0].Name() != "GET /calendar/{file}" {
+		t.Fatalf("spans = %v", got)
+	}
+	for k, v := range spanAttrs(got[0]) {
+		if s, ok := v.(string); ok && strings.Contains(s, "s3cret") {
+			t.Errorf("span attribute %s = %q leaks the token", k, s)
+		}
+	}
+}
+
+func TestTracing_WebhookContinuesTrace(t *testing.T) {
+	tracer, spans := withTracer(t)
+	s := newTestStore(t)
+	rcv := &webhookReceiver{}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+	if err := s.CreateWebhook(t.Context(), &Webhook{URL: srv.URL, Secret: "shh", Events: []string{EventTaskCreated}}); err != nil {
+		t.Fatal(err)
+	}
+	events := NewEventBus()
+	SubscribeWebhooks(events, s)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /tasks", postTaskHandler(s, s, events))
+	req := withUser(httptest.NewRequest(http.MethodPost, "/tasks", strings.NewReader(`{"title":"hook me"}`)), demoUserID)
+	rr := httptest.NewRecorder()
+	Chain(mux, RequestID, Logging, Tracing(tracer)).ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create: status %d: %s", rr.Code, rr.Body)
+	}
+
+	d := NewWebhookDispatcher(s)
+	d.Tracer = tracer
+	d.now = func() time.Time { return time.Now().Add(time.Second) }
+	d.tick(context.Background())
+	if rcv.count() != 1 {
+		t.Fatalf("got %d deliveries", rcv.count())
+	}
+
+	got := spans()
+	server, client := findSpan(got, "POST /tasks"), findSpan(got, "webhook "+EventTaskCreated)
+	if server == nil || client == nil {
+		t.Fatalf("spans = %v", got)
+	}
+	if client.SpanKind() != trace.SpanKindClient || client.Parent().SpanID() != server.SpanContext().SpanID() ||
+		client.SpanContext().TraceID() != server.SpanContext().TraceID() {
+		t.Errorf("delivery span %v is not a child of the request span %v", client.SpanContext(), server.SpanContext())
+	}
+	want := "00-" + client.SpanContext().TraceID().String() + "-" + client.SpanContext().SpanID().String() + "-01"
+	if tp := rcv.headers[0].Get(traceparentHeader); tp != want {
+		t.Errorf("webhook traceparent = %q, want %q", tp, want)
+	}
+}
+
+func TestTracing_StreamSpanEndsAfterHandshake(t *testing.T) {
+	tracer, spans := withTracer(t)
+	s := newTestStore(t)
+	broker := NewStreamBroker()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /tasks/stream", taskStreamHandler(s, broker))
+	srv := httptest.NewServer(Chain(mux, Tracing(tracer)))
+	t.Cleanup(func() {
+		broker.Close()
+		srv.Close()
+	})
+
+	resp, err := http.Get(srv.URL + "/tasks/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if _, err := resp.Body.Read(make([]byte, 64)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The stream is still open here.
+	deadline := time.Now().Add(2 * time.Second)
+	for findSpan(spans(), "GET /tasks/stream") == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("stream span still open after the handshake")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	span := findSpan(spans(), "GET /tasks/stream")
+	if spanAttrs(span)["http.response.status_code"] != int64(http.StatusOK) {
+		t.Errorf("attrs = %v", spanAttrs(span))
+	}
+}
+
+func TestTracing_OffIsNoop(t *testing.T) {
+	var seen *http.Request
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(traceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	Tracing(noopTracer())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r
+	})).ServeHTTP(httptest.NewRecorder(), req)
+	if seen != req || traceparentFrom(seen.Context()) != "" {
+		t.Error("request changed with tracing off")
+	}
+}
+
+func TestNewTracerProvider_OTLP(t *testing.T) {
+	var mu sync.Mutex
+	var path, contentType string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		path, contentType = r.URL.Path, r.Header.Get("Content-Type")
+	}))
+	defer collector.Close()
+
+	tp, shutdown, err := NewTracerProvider(t.Context(), TracingConfig{Exporter: "otlp", OTLPEndpoint: collector.URL, ServiceName: "taskapi-test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := tp.Tracer("test").Start(t.Context(), "work")
+	span.End()
+	if err := shutdown(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if path != "/v1/traces" || contentType != "application/x-protobuf" {
+		t.Errorf("collector got %q with %q", path, contentType)
+	}
+}
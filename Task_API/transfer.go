@@ -0,0 +1,707 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	exportPageSize = 500
+	maxImportBody  = 10 << 20
+	maxImportRows  = 10000
+)
+
+const (
+	FormatCSV      = "csv"
+	FormatJSONL    = "jsonl"
+	FormatMarkdown = "md"
+)
+
+var errTooManyImportRows = fmt.Errorf("at most %d rows per import", maxImportRows)
+
+var transferContentTypes = map[string]string{
+	FormatCSV:      "text/csv; charset=utf-8",
+	FormatJSONL:    "application/x-ndjson",
+	FormatMarkdown: "text/markdown; charset=utf-8",
+}
+
+// transferTask is a task as it appears in an export file: the fields a
+// user wrote, without the computed ones. JSON Lines files use it as is and
+// CSV files use csvColumns, in the same order.
+type transferTask struct {
+	ID          int        `json:"id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description,omitempty"`
+	Completed   bool       `json:"completed"`
+	ParentID    *int       `json:"parentId,omitempty"`
+	DueAt       *time.Time `json:"dueAt,omitempty"`
+	RRule       string     `json:"rrule,omitempty"`
+	TimeZone    string     `json:"timeZone,omitempty"`
+	Assignees   []int      `json:"assignees"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt,omitempty"`
+}
+
+func toTransferTask(t Task) transferTask {
+	return transferTask{
+		ID:          t.ID,
+		Title:       t.Title,
+		Description: t.Description,
+		Completed:   t.Completed,
+		ParentID:    t.ParentID,
+		DueAt:       t.DueAt,
+		RRule:       t.RRule,
+		TimeZone:    t.TimeZone,
+		Assignees:   t.Assignees,
+		CreatedAt:   t.CreatedAt,
+		UpdatedAt:   t.UpdatedAt,
+	}
+}
+
+var csvColumns = []string{"id", "title", "description", "completed", "parent_id", "due_at", "rrule", "time_zone", "assignees", "created_at", "updated_at"}
+
+func (t transferTask) csvRecord() []string {
+	var parent, due string
+	if t.ParentID != nil {
+		parent = strconv.Itoa(*t.ParentID)
+	}
+	if t.DueAt != nil {
+		due = t.DueAt.UTC().Format(time.RFC3339)
+	}
+	assignees := make([]string, len(t.Assignees))
+	for i, id := range t.Assignees {
+		assignees[i] = strconv.Itoa(id)
+	}
+	return []string{
+		strconv.Itoa(t.ID), t.Title, t.Description, strconv.FormatBool(t.Completed), parent, due,
+		t.RRule, t.TimeZone, strings.Join(assignees, ";"),
+		t.CreatedAt.UTC().Format(time.RFC3339), t.UpdatedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// exportWriter writes one format. Depth is how far below the top of its
+// exported tree a task is, for formats that nest. Flush is called after
+// every page so the client sees progress on a large export.
+type exportWriter interface {
+	Write(t Task, depth int) error
+	Flush() error
+}
+
+// csvFormulaPrefixes start a cell that a spreadsheet would run as a
+// formula. A leading quote is escaped too, so that csvUnsafe can tell an
+// escaped cell from one that started with a quote.
+const csvFormulaPrefixes = "=+-@\t\r'"
+
+// csvSafe prefixes a cell a spreadsheet would evaluate with a quote so it
+// opens as text.
+func csvSafe(record []string) []string {
+	for i, v := range record {
+		if v != "" && strings.ContainsRune(csvFormulaPrefixes, rune(v[0])) {
+			record[i] = "'" + v
+		}
+	}
+	return record
+}
+
+// csvUnsafe undoes csvSafe so an exported file imports unchanged.
+func csvUnsafe(v string) string {
+	if len(v) > 1 && v[0] == '\'' && strings.ContainsRune(csvFormulaPrefixes, rune(v[1])) {
+		return v[1:]
+	}
+	return v
+}
+
+type csvExportWriter struct{ w *csv.Writer }
+
+func (e *csvExportWriter) Write(t Task, _ int) error {
+	return e.w.Write(csvSafe(toTransferTask(t).csvRecord()))
+}
+func (e *csvExportWriter) Flush() error { e.w.Flush(); return e.w.Error() }
+
+type jsonlExportWriter struct{ enc *json.Encoder }
+
+func (e *jsonlExportWriter) Write(t Task, _ int) error { return e.enc.Encode(toTransferTask(t)) }
+func (e *jsonlExportWriter) Flush() error              { return nil }
+
+// markdownExportWriter writes a checklist with subtasks nested under their
+// parent, two spaces a level. The description follows each item as lines
+// indented one level further.
+type markdownExportWriter struct{ w *bufio.Writer }
+
+func (e *markdownExportWriter) Write(t Task, depth int) error {
+	indent := strings.Repeat("  ", depth)
+	box := " "
+	if t.Completed {
+		box = "x"
+	}
+	fmt.Fprintf(e.w, "%s- [%s] %s\n", indent, box, strings.ReplaceAll(t.Title, "\n", " "))
+	if t.Description != "" {
+		for _, line := range strings.Split(t.Description, "\n") {
+			fmt.Fprintf(e.w, "%s  %s\n", indent, line)
+		}
+	}
+	return nil
+}
+
+func (e *markdownExportWriter) Flush() error { return e.w.Flush() }
+
+// exportPages reads one list of tasks a page at a time.
+type exportPages struct {
+	fetch func(afterID int) ([]Task, error)
+	page  []Task
+	next  int
+	done  bool
+}
+
+func (p *exportPages) read() (Task, bool, error) {
+	if p.next == len(p.page) {
+		if p.done {
+			return Task{}, false, nil
+		}
+		afterID := 0
+		if len(p.page) > 0 {
+			afterID = p.page[len(p.page)-1].ID
+		}
+		page, err := p.fetch(afterID)
+		if err != nil {
+			return Task{}, false, err
+		}
+		p.page, p.next, p.done = page, 0, len(page) < exportPageSize
+		if len(page) == 0 {
+			return Task{}, false, nil
+		}
+	}
+	t := p.page[p.next]
+	p.next++
+	return t, true, nil
+}
+
+// exportCursor yields the tasks of an export one at a time with their
+// depth. Nested exports walk each tree depth first, keeping one page per
+// level, so memory follows the depth of the trees rather than their size.
+type exportCursor struct {
+	store  TaskTransfer
+	filter TaskFilter
+	nested bool
+	levels []*exportPages
+}
+
+func newExportCursor(ctx context.Context, store TaskTransfer, filter TaskFilter, nested bool) *exportCursor {
+	c := &exportCursor{store: store, filter: filter, nested: nested}
+	if nested {
+		c.levels = []*exportPages{c.children(ctx, nil)}
+	} else {
+		c.levels = []*exportPages{{fetch: func(afterID int) ([]Task, error) {
+			return store.ExportTasks(ctx, filter, afterID, exportPageSize)
+		}}}
+	}
+	return c
+}
+
+func (c *exportCursor) children(ctx context.Context, parentID *int) *exportPages {
+	return &exportPages{fetch: func(afterID int) ([]Task, error) {
+		return c.store.ExportChildren(ctx, c.filter, parentID, afterID, exportPageSize)
+	}}
+}
+
+func (c *exportCursor) next(ctx context.Context) (Task, int, bool, error) {
+	for len(c.levels) > 0 {
+		level := c.levels[len(c.levels)-1]
+		t, ok, err := level.read()
+		if err != nil {
+			return Task{}, 0, false, err
+		}
+		if !ok {
+			c.levels = c.levels[:len(c.levels)-1]
+			continue
+		}
+		depth := len(c.levels) - 1
+		if c.nested {
+			id := t.ID
+			c.levels = append(c.levels, c.children(ctx, &id))
+		}
+		return t, depth, true, nil
+	}
+	return Task{}, 0, false, nil
+}
+
+// exportHandler streams every task matching the list filters in the
+// requested format, a page at a time. Markdown nests subtasks under their
+// parent; a task whose parent is filtered out starts at the top level.
+func exportHandler(store TaskTransfer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		contentType, ok := transferContentTypes[format]
+		if !ok {
+			writeErr(w, http.StatusBadRequest, "format must be csv, jsonl or md")
+			return
+		}
+		filter, err := parseTaskFilter(r)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		ctx := r.Context()
+		cur := newExportCursor(ctx, store, filter, format == FormatMarkdown)
+		t, depth, ok, err := cur.next(ctx)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": "tasks." + format}))
+		w.WriteHeader(http.StatusOK)
+
+		var ew exportWriter
+		switch format {
+		case FormatCSV:
+			cw := csv.NewWriter(w)
+			_ = cw.Write(csvColumns)
+			ew = &csvExportWriter{w: cw}
+		case FormatJSONL:
+			ew = &jsonlExportWriter{enc: json.NewEncoder(w)}
+		case FormatMarkdown:
+			ew = &markdownExportWriter{w: bufio.NewWriter(w)}
+		}
+
+		rc := http.NewResponseController(w)
+		flush := func() bool {
+			if err := ew.Flush(); err != nil {
+				return false
+			}
+			_ = rc.Flush()
+			return true
+		}
+		for n := 1; ok; n++ {
+			if err := ew.Write(t, depth); err != nil {
+				return
+			}
+			if n%exportPageSize == 0 && !flush() {
+				return
+			}
+			// The status line is gone, so a failure now can only cut the
+			// file short.
+			if t, depth, ok, err = cur.next(ctx); err != nil {
+				loggerFrom(ctx).Error("export failed", "error", err)
+				return
+			}
+		}
+		flush()
+	}
+}
+
+// importLine is a parsed row, or the reason it could not be parsed.
+type importLine struct {
+	Line int
+	Row  ImportRow
+	Err  error
+}
+
+type importRowReport struct {
+	Line  int    `json:"line"`
+	Title string `json:"title,omitempty"`
+	ID    int    `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// importReport summarizes an import. Duplicates carry the ID of the task
+// they matched; Rejected carries why each row was skipped.
+type importReport struct {
+	DryRun     bool              `json:"dryRun"`
+	Format     string            `json:"format"`
+	Rows       int               `json:"rows"`
+	Imported   int               `json:"imported"`
+	Created    []int             `json:"created"`
+	Duplicates []importRowReport `json:"duplicates"`
+	Rejected   []importRowReport `json:"rejected"`
+}
+
+// importHandler creates tasks from a CSV, JSON Lines or Markdown checklist
+// body. Rows that fail are reported and skipped; the rest are imported
+// together. ?dry_run=true reports the same outcome without saving, and
+// ?col.<field>=<header> maps a CSV column to a task field.
+func importHandler(store TaskTransfer, events *EventBus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		format := q.Get("format")
+		if format == "" {
+			format = formatFromContentType(r.Header.Get("Content-Type"))
+		}
+		if _, ok := transferContentTypes[format]; !ok {
+			writeErr(w, http.StatusBadRequest, "format must be csv, jsonl or md")
+			return
+		}
+		var dryRun bool
+		if val := q.Get("dry_run"); val != "" {
+			var err error
+			if dryRun, err = strconv.ParseBool(val); err != nil {
+				writeErr(w, http.StatusBadRequest, "invalid dry_run value")
+				return
+			}
+		}
+
+		body := http.MaxBytesReader(w, r.Body, maxImportBody)
+		var lines []importLine
+		var err error
+		switch format {
+		case FormatCSV:
+			var mapping map[string]string
+			if mapping, err = parseColumnMapping(q); err == nil {
+				lines, err = parseCSVImport(body, mapping)
+			}
+		case FormatJSONL:
+			lines, err = parseJSONLImport(body)
+		case FormatMarkdown:
+			lines, err = parseMarkdownImport(body)
+		}
+		if err != nil {
+			var tooBig *http.MaxBytesError
+			switch {
+			case errors.As(err, &tooBig):
+				writeErr(w, http.StatusRequestEntityTooLarge, "import body too large")
+				return
+			case errors.Is(err, errTooManyImportRows):
+				writeErr(w, http.StatusRequestEntityTooLarge, err.Error())
+				return
+			}
+			writeErr(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		report := importReport{
+			DryRun: dryRun, Format: format, Rows: len(lines),
+			Created: []int{}, Duplicates: []importRowReport{}, Rejected: []importRowReport{},
+		}
+		var rows []ImportRow
+		var rowLines []importLine
+		for _, l := range lines {
+			if l.Err != nil {
+				report.Rejected = append(report.Rejected, importRowReport{Line: l.Line, Title: l.Row.Task.Title, Error: l.Err.Error()})
+				continue
+			}
+			rows = append(rows, l.Row)
+			rowLines = append(rowLines, l)
+		}
+
+		outcomes, err := store.ImportTasks(r.Context(), rows, dryRun)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		for i, o := range outcomes {
+			l := rowLines[i]
+			switch {
+			case o.Err != nil:
+				report.Rejected = append(report.Rejected, importRowReport{Line: l.Line, Title: l.Row.Task.Title, Error: publicErrorMessage(r.Context(), o.Err)})
+			case o.Duplicate:
+				report.Duplicates = append(report.Duplicates, importRowReport{Line: l.Line, Title: l.Row.Task.Title, ID: o.ID})
+			default:
+				report.Imported++
+				if !dryRun {
+					report.Created = append(report.Created, o.ID)
+					events.publishTaskCreated(r.Context(), o.Task, getUserID(r))
+				}
+			}
+		}
+		sort.Slice(report.Rejected, func(i, j int) bool { return report.Rejected[i].Line < report.Rejected[j].Line })
+		writeJSON(w, http.StatusOK, report)
+	}
+}
+
+func formatFromContentType(ct string) string {
+	mediaType, _, _ := mime.ParseMediaType(ct)
+	switch mediaType {
+	case "text/csv":
+		return FormatCSV
+	case "application/x-ndjson", "application/jsonl":
+		return FormatJSONL
+	case "text/markdown":
+		return FormatMarkdown
+	}
+	return ""
+}
+
+// csvFieldAliases lists the header names recognized for each field without
+// a mapping, compared case-insensitively.
+var csvFieldAliases = map[string][]string{
+	"id":          {"id"},
+	"title":       {"title"},
+	"description": {"description"},
+	"completed":   {"completed"},
+	"parent_id":   {"parent_id", "parentid"},
+	"due_at":      {"due_at", "dueat", "due"},
+	"rrule":       {"rrule"},
+	"time_zone":   {"time_zone", "timezone"},
+	"assignees":   {"assignees"},
+	"created_at":  {"created_at", "createdat", "created"},
+}
+
+// parseColumnMapping reads ?col.<field>=<header> parameters.
+func parseColumnMapping(q map[string][]string) (map[string]string, error) {
+	mapping := make(map[string]string)
+	for key, vals := range q {
+		field, ok := strings.CutPrefix(key, "col.")
+		if !ok {
+			continue
+		}
+		if _, known := csvFieldAliases[field]; !known {
+			return nil, fmt.Errorf("unknown field %q in column mapping", field)
+		}
+		mapping[field] = vals[0]
+	}
+	return mapping, nil
+}
+
+func parseCSVImport(body io.Reader, mapping map[string]string) ([]importLine, error) {
+	cr := csv.NewReader(body)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("csv has no header row")
+		}
+		return nil, csvError(err)
+	}
+
+	index := make(map[string]int)
+	for field, aliases := range csvFieldAliases {
+		for i, h := range header {
+			name := strings.ToLower(strings.TrimSpace(h))
+			if want, ok := mapping[field]; ok {
+				if strings.EqualFold(strings.TrimSpace(want), name) {
+					index[field] = i
+				}
+				continue
+			}
+			for _, a := range aliases {
+				if name == a {
+					index[field] = i
+				}
+			}
+		}
+		if want, ok := mapping[field]; ok {
+			if _, found := index[field]; !found {
+				return nil, fmt.Errorf("column %q mapped to %s is not in the header", want, field)
+			}
+		}
+	}
+	if _, ok := index["title"]; !ok {
+		return nil, errors.New("csv has no title column")
+	}
+
+	var lines []importLine
+	for {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return lines, nil
+		}
+		if err != nil {
+			return nil, csvError(err)
+		}
+		line, _ := cr.FieldPos(0)
+		if len(lines) >= maxImportRows {
+			return nil, errTooManyImportRows
+		}
+		get := func(field string) string {
+			if i, ok := index[field]; ok && i < len(rec) {
+				return csvUnsafe(strings.TrimSpace(rec[i]))
+			}
+			return ""
+		}
+		l := importLine{Line: line}
+		l.Row, l.Err = csvImportRow(get)
+		lines = append(lines, l)
+	}
+}
+
+func csvError(err error) error {
+	var pe *csv.ParseError
+	if errors.As(err, &pe) {
+		return fmt.Errorf("csv line %d: %v", pe.Line, pe.Err)
+	}
+	return err
+}
+
+func csvImportRow(get func(field string) string) (ImportRow, error) {
+	row := ImportRow{Ref: get("id"), ParentRef: get("parent_id")}
+	t := &row.Task
+	t.Title = get("title")
+	t.Description = get("description")
+	t.RRule = get("rrule")
+	t.TimeZone = get("time_zone")
+
+	var err error
+	if t.Completed, err = parseImportBool(get("completed")); err != nil {
+		return row, err
+	}
+	if v := get("due_at"); v != "" {
+		due, err := parseImportTime(v)
+		if err != nil {
+			return row, fmt.Errorf("due_at: %w", err)
+		}
+		t.DueAt = &due
+	}
+	if v := get("created_at"); v != "" {
+		if t.CreatedAt, err = parseImportTime(v); err != nil {
+			return row, fmt.Errorf("created_at: %w", err)
+		}
+	}
+	if v := get("assignees"); v != "" {
+		for _, f := range strings.FieldsFunc(v, func(r rune) bool { return r == ';' || r == ',' || r == ' ' }) {
+			id, err := strconv.Atoi(f)
+			if err != nil || id <= 0 {
+				return row, fmt.Errorf("assignees: invalid user id %q", f)
+			}
+			t.Assignees = append(t.Assignees, id)
+		}
+	}
+	return row, nil
+}
+
+func parseImportBool(v string) (bool, error) {
+	switch strings.ToLower(v) {
+	case "", "false", "0", "no", "n", "open", "todo":
+		return false, nil
+	case "true", "1", "yes", "y", "x", "done", "completed":
+		return true, nil
+	}
+	return false, fmt.Errorf("completed: cannot read %q as true or false", v)
+}
+
+var importTimeLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"}
+
+func parseImportTime(v string) (time.Time, error) {
+	for _, layout := range importTimeLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot read %q as a time", v)
+}
+
+func parseJSONLImport(body io.Reader) ([]importLine, error) {
+	sc := bufio.NewScanner(body)
+	sc.Buffer(make([]byte, 64<<10), maxImportBody)
+	var lines []importLine
+	for n := 1; sc.Scan(); n++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" {
+			continue
+		}
+		if len(lines) >= maxImportRows {
+			return nil, errTooManyImportRows
+		}
+		l := importLine{Line: n}
+		var tt transferTask
+		if err := json.Unmarshal([]byte(text), &tt); err != nil {
+			l.Err = fmt.Errorf("invalid json: %v", err)
+			lines = append(lines, l)
+			continue
+		}
+		if tt.ID != 0 {
+			l.Row.Ref = strconv.Itoa(tt.ID)
+		}
+		if tt.ParentID != nil {
+			l.Row.ParentRef = strconv.Itoa(*tt.ParentID)
+		}
+		l.Row.Task = Task{
+			Title:       tt.Title,
+			Description: tt.Description,
+			Completed:   tt.Completed,
+			DueAt:       tt.DueAt,
+			RRule:       tt.RRule,
+			TimeZone:    tt.TimeZone,
+			Assignees:   tt.Assignees,
+			CreatedAt:   tt.CreatedAt,
+		}
+		lines = append(lines, l)
+	}
+	return lines, sc.Err()
+}
+
+var checklistItem = regexp.MustCompile(`^([ \t]*)[-*+] (?:\[([ xX])\] )?(.*)$`)
+
+// parseMarkdownImport reads a checklist. Each list item is a task, nested
+// items become subtasks, and indented lines under an item that are not
+// items themselves become its description. Headings and blank lines are
+// skipped.
+func parseMarkdownImport(body io.Reader) ([]importLine, error) {
+	type open struct {
+		indent int
+		ref    string
+		index  int
+	}
+	var stack []open
+	var lines []importLine
+
+	sc := bufio.NewScanner(body)
+	sc.Buffer(make([]byte, 64<<10), maxImportBody)
+	for n := 1; sc.Scan(); n++ {
+		text := strings.TrimRight(sc.Text(), " \t\r")
+		trimmed := strings.TrimLeft(text, " \t")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := markdownIndent(text)
+
+		m := checklistItem.FindStringSubmatch(text)
+		if m == nil {
+			if len(stack) > 0 && indent > stack[len(stack)-1].indent {
+				last := &lines[stack[len(stack)-1].index].Row.Task
+				if last.Description != "" {
+					last.Description += "\n"
+				}
+				last.Description += trimmed
+				continue
+			}
+			lines = append(lines, importLine{Line: n, Err: errors.New("not a checklist item")})
+			continue
+		}
+
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		if len(lines) >= maxImportRows {
+			return nil, errTooManyImportRows
+		}
+		l := importLine{Line: n}
+		l.Row.Ref = strconv.Itoa(n)
+		if len(stack) > 0 {
+			l.Row.ParentRef = stack[len(stack)-1].ref
+		}
+		l.Row.Task.Title = strings.TrimSpace(m[3])
+		l.Row.Task.Completed = m[2] == "x" || m[2] == "X"
+		lines = append(lines, l)
+		stack = append(stack, open{indent: indent, ref: l.Row.Ref, index: len(lines) - 1})
+	}
+	return lines, sc.Err()
+}
+
+func markdownIndent(s string) int {
+	n := 0
+	for _, r := range s {
+		switch r {
+		case ' ':
+			n++
+		case '\t':
+			n += 4
+		default:
+			return n
+		}
+	}
+	return n
+}
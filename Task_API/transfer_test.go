@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func doExport(t *testing.T, s *SQLiteStore, query string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/export?"+query, nil)
+	rr := httptest.NewRecorder()
+	exportHandler(s).ServeHTTP(rr, withUser(req, 1))
+	return rr
+}
+
+func doImport(t *testing.T, s *SQLiteStore, query, body string) importReport {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/import?"+query, strings.NewReader(body))
+	rr := httptest.NewRecorder()
+	importHandler(s, nil).ServeHTTP(rr, withUser(req, 1))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("import: status %d: %s", rr.Code, rr.Body)
+	}
+	var report importReport
+	if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestExport_CSVPagesThroughEveryTask(t *testing.T) {
+	s := newTestStore(t)
+	n := exportPageSize + 3
+	for i := 0; i < n; i++ {
+		task := Task{Title: "t"}
+		if err := s.CreateTask(t.Context(), &task); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rr := doExport(t, s, "format=csv")
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("status %d, content type %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	records, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != n+1 || strings.Join(records[0], ",") != strings.Join(csvColumns, ",") {
+		t.Fatalf("got %d records, header %v", len(records), records[0])
+	}
+}
+
+func TestExport_Markdown(t *testing.T) {
+	s := newTestStore(t)
+	a := Task{Title: "Buy milk", Description: "semi-skimmed"}
+	if err := s.CreateTask(t.Context(), &a); err != nil {
+		t.Fatal(err)
+	}
+	a.Completed = true
+	if err := s.UpdateTask(t.Context(), &a, UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := doExport(t, s, "format=md")
+	if got, want := rr.Body.String(), "- [x] Buy milk\n  semi-skimmed\n"; got != want {
+		t.Errorf("markdown = %q, want %q", got, want)
+	}
+	if doExport(t, s, "format=xml").Code != http.StatusBadRequest {
+		t.Error("unknown format accepted")
+	}
+}
+
+func TestExport_MarkdownNestsSubtasks(t *testing.T) {
+	s := newTestStore(t)
+	ctx := t.Context()
+	shop := Task{Title: "Shop"}
+	cook := Task{Title: "Cook"}
+	for _, task := range []*Task{&shop, &cook} {
+		if err := s.CreateTask(ctx, task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Created after Cook, so ID order alone would put it in the wrong place.
+	milk := Task{Title: "Milk", Description: "two litres", ParentID: &shop.ID}
+	if err := s.CreateTask(ctx, &milk); err != nil {
+		t.Fatal(err)
+	}
+	oats := Task{Title: "Oats", ParentID: &milk.ID}
+	if err := s.CreateTask(ctx, &oats); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := doExport(t, s, "format=md")
+	want := "- [ ] Shop\n" +
+		"  - [ ] Milk\n" +
+		"    two litres\n" +
+		"    - [ ] Oats\n" +
+		"- [ ] Cook\n"
+	if got := rr.Body.String(); got != want {
+		t.Fatalf("markdown = %q, want %q", got, want)
+	}
+
+	dst := newTestStore(t)
+	report := doImport(t, dst, "format=md", want)
+	if report.Imported != 4 || len(report.Rejected) != 0 {
+		t.Fatalf("report = %+v", report)
+	}
+	got, err := dst.GetTaskByID(ctx, report.Created[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "Oats" || got.ParentID == nil || *got.ParentID != report.Created[1] {
+		t.Errorf("oats = %+v", got)
+	}
+}
+
+func TestExport_CSVNeutralizesFormulas(t *testing.T) {
+	s := newTestStore(t)
+	tasks := []Task{
+		{Title: "=HYPERLINK(\"http://evil\")", Description: "@SUM(A1)"},
+		{Title: "'=x", Description: "\tcmd"},
+		{Title: "'quoted", Description: "-1"},
+	}
+	for i := range tasks {
+		if err := s.CreateTask(t.Context(), &tasks[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rr := doExport(t, s, "format=csv")
+	records, err := csv.NewReader(strings.NewReader(rr.Body.String())).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, task := range tasks {
+		if got := records[i+1][1:3]; got[0] != "'"+task.Title || got[1] != "'"+task.Description {
+			t.Errorf("row %d cells = %q", i+1, got)
+		}
+	}
+
+	dst := newTestStore(t)
+	report := doImport(t, dst, "format=csv", rr.Body.String())
+	if report.Imported != len(tasks) {
+		t.Fatalf("report = %+v", report)
+	}
+	for i, task := range tasks {
+		got, err := dst.GetTaskByID(t.Context(), report.Created[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Title != task.Title || got.Description != task.Description {
+			t.Errorf("imported %q / %q, want %q / %q", got.Title, got.Description, task.Title, task.Description)
+		}
+	}
+}
+
+func TestExport_MarkdownFilteredParentStartsATree(t *testing.T) {
+	s := newTestStore(t)
+	ctx := t.Context()
+	done := Task{Title: "done"}
+	if err := s.CreateTask(ctx, &done); err != nil {
+		t.Fatal(err)
+	}
+	child := Task{Title: "child", ParentID: &done.ID}
+	if err := s.CreateTask(ctx, &child); err != nil {
+		t.Fatal(err)
+	}
+	grandchild := Task{Title: "grandchild", ParentID: &child.ID}
+	if err := s.CreateTask(ctx, &grandchild); err != nil {
+		t.Fatal(err)
+	}
+	// Completing a task with an open subtask is refused, so the child is
+	// moved out of the way and back.
+	child.ParentID = nil
+	if err := s.UpdateTask(ctx, &child, UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	done.Completed = true
+	if err := s.UpdateTask(ctx, &done, UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	child.ParentID = &done.ID
+	if err := s.UpdateTask(ctx, &child, UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := doExport(t, s, "format=md&completed=false")
+	if got, want := rr.Body.String(), "- [ ] child\n  - [ ] grandchild\n"; got != want {
+		t.Errorf("markdown = %q, want %q", got, want)
+	}
+}
+
+func TestImport_JSONLRoundTripDetectsDuplicates(t *testing.T) {
+	src := newTestStore(t)
+	parent := Task{Title: "Parent"}
+	if err := src.CreateTask(t.Context(), &parent); err != nil {
+		t.Fatal(err)
+	}
+	child := Task{Title: "Child", ParentID: &parent.ID}
+	if err := src.CreateTask(t.Context(), &child); err != nil {
+		t.Fatal(err)
+	}
+	exported := doExport(t, src, "format=jsonl").Body.String()
+
+	dst := newTestStore(t)
+	report := doImport(t, dst, "format=jsonl", exported)
+	if report.Imported != 2 || len(report.Rejected) != 0 {
+		t.Fatalf("report = %+v", report)
+	}
+	tasks, err := dst.GetAllTasks(t.Context(), TaskFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 2 || tasks[1].ParentID == nil || *tasks[1].ParentID != tasks[0].ID {
+		t.Fatalf("imported tasks = %+v", tasks)
+	}
+	if !tasks[0].CreatedAt.Truncate(time.Second).Equal(parent.CreatedAt.Truncate(time.Second)) {
+		t.Errorf("created at %v, want %v", tasks[0].CreatedAt, parent.CreatedAt)
+	}
+
+	again := doImport(t, dst, "format=jsonl", exported)
+	if again.Imported != 0 || len(again.Duplicates) != 2 || again.Duplicates[0].ID != tasks[0].ID {
+		t.Errorf("second import = %+v", again)
+	}
+}
+
+func TestImport_CSVMappingDryRunAndRejectedRows(t *testing.T) {
+	s := newTestStore(t)
+	body := "Task Name,Done,Deadline\n" +
+		"Write report,yes,2026-03-01\n" +
+		",no,\n" +
+		"Call Bob,maybe,\n" +
+		"Plan trip,no,not a date\n"
+	query := "format=csv&dry_run=true&col.title=Task+Name&col.completed=Done&col.due_at=Deadline"
+
+	report := doImport(t, s, query, body)
+	if !report.DryRun || report.Imported != 1 || len(report.Rejected) != 3 {
+		t.Fatalf("report = %+v", report)
+	}
+	lines := []int{report.Rejected[0].Line, report.Rejected[1].Line, report.Rejected[2].Line}
+	if lines[0] != 3 || lines[1] != 4 || lines[2] != 5 {
+		t.Errorf("rejected lines = %v, want [3 4 5]", lines)
+	}
+	if tasks, _ := s.GetAllTasks(t.Context(), TaskFilter{}); len(tasks) != 0 {
+		t.Fatalf("dry run created %d tasks", len(tasks))
+	}
+
+	report = doImport(t, s, strings.Replace(query, "dry_run=true", "dry_run=false", 1), body)
+	if report.Imported != 1 || len(report.Created) != 1 {
+		t.Fatalf("report = %+v", report)
+	}
+	task, err := s.GetTaskByID(t.Context(), report.Created[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Title != "Write report" || !task.Completed || task.DueAt == nil {
+		t.Errorf("task = %+v", task)
+	}
+}
+
+func TestImport_MarkdownChecklist(t *testing.T) {
+	s := newTestStore(t)
+	body := "# Groceries\n\n" +
+		"- [ ] Shop\n" +
+		"  - [x] Milk\n" +
+		"    two litres\n" +
+		"  - [ ] Bread\n" +
+		"- [x] Cook\n"
+
+	report := doImport(t, s, "format=md", body)
+	if report.Imported != 4 || len(report.Rejected) != 0 {
+		t.Fatalf("report = %+v", report)
+	}
+	shop, milk := report.Created[0], report.Created[1]
+	task, err := s.GetTaskByID(t.Context(), milk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.ParentID == nil || *task.ParentID != shop || !task.Completed || task.Description != "two litres" {
+		t.Errorf("milk = %+v", task)
+	}
+}
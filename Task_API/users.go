@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// AdminOnly rejects requests from users that are not workspace admins. It
+// must run after AuthMiddleware so the user ID is in the context.
+func AdminOnly(users UserStore) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !isAdmin(r.Context(), users, getUserID(r)) {
+				writeErr(w, http.StatusForbidden, "admin only")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func isAdmin(ctx context.Context, users UserStore, userID int) bool {
+	if userID <= 0 {
+		return false
+	}
+	u, err := users.GetUser(ctx, userID)
+	return err == nil && u.IsAdmin && u.DeletedAt == nil
+}
+
+// canModifyTask is the per-task authorization rule: admins may modify any
+// task, other users only tasks that are unassigned or assigned to them.
+func canModifyTask(t Task, userID int, admin bool) bool {
+	return admin || len(t.Assignees) == 0 || slices.Contains(t.Assignees, userID)
+}
+
+var errNotAllowed = errors.New("not allowed to modify this task")
+
+// taskReader is the part of TaskStore, or of TaskTx inside a batch, that
+// authorization reads.
+type taskReader interface {
+	GetTaskByID(ctx context.Context, id int) (Task, error)
+	GetSubtasks(ctx context.Context, parentID int) ([]Task, error)
+}
+
+// taskAuth applies canModifyTask for one user. Every way of changing tasks
+// (REST, batches, offline sync and live connections) checks through it, so
+// they agree on who may change what.
+type taskAuth struct {
+	userID int
+	admin  bool
+}
+
+func newTaskAuth(ctx context.Context, users UserStore, userID int) taskAuth {
+	return taskAuth{userID: userID, admin: isAdmin(ctx, users, userID)}
+}
+
+func (a taskAuth) allows(t Task) bool {
+	return canModifyTask(t, a.userID, a.admin)
+}
+
+// task loads the task with id for changing it.
+func (a taskAuth) task(ctx context.Context, tasks taskReader, id int) (Task, error) {
+	t, err := tasks.GetTaskByID(ctx, id)
+	if err != nil {
+		return Task{}, err
+	}
+	if !a.allows(t) {
+		return Task{}, fmt.Errorf("%w: task %d", errNotAllowed, id)
+	}
+	return t, nil
+}
+
+// move checks that a task may go from under one parent to another: putting
+// a task under a parent changes the parent too. A parent that does not
+// exist is left for the store to reject.
+func (a taskAuth) move(ctx context.Context, tasks taskReader, from, to *int) error {
+	if to == nil || (from != nil && *from == *to) {
+		return nil
+	}
+	if _, err := a.task(ctx, tasks, *to); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// writeTaskAuthError answers a request that failed a taskAuth check.
+func writeTaskAuthError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errNotAllowed):
+		writeErr(w, http.StatusForbidden, errNotAllowed.Error())
+	case errors.Is(err, ErrNotFound):
+		writeErr(w, http.StatusNotFound, "not found")
+	default:
+		writeErr(w, http.StatusInternalServerError, "internal error")
+	}
+}
+
+// subtree checks every subtask a delete would take with it, and returns
+// them so the delete can be announced for each.
+func (a taskAuth) subtree(ctx context.Context, tasks taskReader, id int) ([]Task, error) {
+	subtasks, err := tasks.GetSubtasks(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range subtasks {
+		if !a.allows(t) {
+			return nil, fmt.Errorf("%w: subtask %d", errNotAllowed, t.ID)
+		}
+	}
+	return subtasks, nil
+}
+
+func listUsersHandler(users UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		list, err := users.ListUsers(r.Context())
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		writeJSON(w, http.StatusOK, list)
+	}
+}
+
+func postUserHandler(users UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var u User
+		if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+			writeErr(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		if err := users.CreateUser(r.Context(), &u); err != nil {
+			if errors.Is(err, ErrInvalid) {
+				writeErr(w, http.StatusBadRequest, err.Error())
+			} else {
+				writeErr(w, http.StatusInternalServerError, "internal error")
+			}
+			return
+		}
+		writeJSON(w, http.StatusCreated, u)
+	}
+}
+
+func deleteUserHandler(users UserStore, events *EventBus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("ID"))
+		if err != nil || id <= 0 {
+			writeErr(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		taskIDs, err := users.DeleteUser(r.Context(), id)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				writeErr(w, http.StatusNotFound, "not found")
+			} else {
+				writeErr(w, http.StatusInternalServerError, "internal error")
+			}
+			return
+		}
+		for _, taskID := range taskIDs {
+			events.PublishFrom(r.Context(), Event{Type: EventTaskUnassigned, TaskID: taskID, UserID: id, ActorID: getUserID(r)})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	webhookSignatureHeader = "X-Webhook-Signature-256"
+	webhookEventHeader     = "X-Webhook-Event"
+	webhookDeliveryHeader  = "X-Webhook-Delivery"
+	webhookTimestampHeader = "X-Webhook-Timestamp"
+)
+
+// signWebhook returns the value of the signature header: the hex HMAC-SHA256
+// of the timestamp header, a dot and the raw body, keyed with the webhook
+// secret and prefixed with "sha256=". Receivers should recompute it,
+// compare with hmac.Equal and reject old timestamps, so a captured delivery
+// cannot be replayed later.
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SubscribeWebhooks queues a delivery for every task lifecycle event. The
+// payload is the event itself, so receivers see the task snapshot.
+func SubscribeWebhooks(events *EventBus, store WebhookStore) {
+	events.Subscribe(func(ctx context.Context, e Event) {
+		if !slices.Contains(webhookEvents, e.Type) {
+			return
+		}
+		payload, err := json.Marshal(e)
+		if err != nil {
+			loggerFrom(ctx).Error("encode webhook payload failed", "event", e.Type, "error", err)
+			return
+		}
+		if err := store.EnqueueDeliveries(ctx, e.Type, payload, e.At, e.TraceParent); err != nil {
+			loggerFrom(ctx).Error("enqueue webhook deliveries failed", "event", e.Type, "task_id", e.TaskID, "error", err)
+		}
+	})
+}
+
+// WebhookDispatcher posts queued deliveries. Any non-2xx response or
+// transport error is retried with exponential backoff; after maxAttempts
+// the delivery is dead-lettered until someone redelivers it.
+type WebhookDispatcher struct {
+	// Tracer traces each delivery in the trace of the request behind it.
+	Tracer trace.Tracer
+
+	store       WebhookStore
+	client      *http.Client
+	interval    time.Duration
+	lease       time.Duration
+	baseBackoff time.Duration
+	maxBackoff  time.Duration
+	maxAttempts int
+	now         func() time.Time
+}
+
+func NewWebhookDispatcher(store WebhookStore) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		Tracer:      noopTracer(),
+		store:       store,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		interval:    5 * time.Second,
+		lease:       time.Minute,
+		baseBackoff: 30 * time.Second,
+		maxBackoff:  6 * time.Hour,
+		maxAttempts: 10,
+		now:         time.Now,
+	}
+}
+
+func (d *WebhookDispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		d.tick(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *WebhookDispatcher) tick(ctx context.Context) {
+	now := d.now()
+	due, err := d.store.DueDeliveries(ctx, now, 50)
+	if err != nil {
+		loggerFrom(ctx).Error("load webhook deliveries failed", "error", err)
+		return
+	}
+
+	hooks := make(map[int]Webhook)
+	for _, del := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		hook, ok := hooks[del.WebhookID]
+		if !ok {
+			hook, err = d.store.GetWebhook(ctx, del.WebhookID)
+			if err != nil {
+				continue
+			}
+			hooks[del.WebhookID] = hook
+		}
+		ok, err := d.store.ClaimDelivery(ctx, del.ID, now, d.lease)
+		if err != nil || !ok {
+			continue
+		}
+		attempts := del.Attempts + 1
+
+		status, err := d.post(ctx, hook, del)
+		if err != nil {
+			dead := attempts >= d.maxAttempts
+			retryAt := now.Add(backoffDelay(d.baseBackoff, d.maxBackoff, attempts))
+			loggerFrom(ctx).Warn("webhook delivery failed", "delivery_id", del.ID, "url", hook.URL, "attempt", attempts, "error", err)
+			if err := d.store.MarkDeliveryFailed(ctx, del.ID, status, err.Error(), retryAt, dead); err != nil {
+				loggerFrom(ctx).Error("mark delivery failed", "delivery_id", del.ID, "error", err)
+			}
+			continue
+		}
+		if err := d.store.MarkDeliverySucceeded(ctx, del.ID, status, d.now()); err != nil {
+			loggerFrom(ctx).Error("mark delivery succeeded failed", "delivery_id", del.ID, "error", err)
+		}
+	}
+}
+
+// post sends one delivery as a client span in the trace of the request
+// that caused the event, and passes the trace on to the receiver.
+func (d *WebhookDispatcher) post(ctx context.Context, hook Webhook, del WebhookDelivery) (status int, err error) {
+	ctx = propagator.Extract(ctx, propagation.MapCarrier{traceparentHeader: del.TraceParent})
+	ctx, span := d.Tracer.Start(ctx, "webhook "+del.EventType,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.Int("webhook.id", hook.ID), attribute.Int("webhook.delivery.id", del.ID)),
+	)
+	defer func() {
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		setSpanError(span, err)
+		span.End()
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(del.Payload))
+	if err != nil {
+		return 0, err
+	}
+	propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "taskapi-webhooks")
+	req.Header.Set(webhookEventHeader, del.EventType)
+	req.Header.Set(webhookDeliveryHeader, strconv.Itoa(del.ID))
+	timestamp := strconv.FormatInt(d.now().Unix(), 10)
+	req.Header.Set(webhookTimestampHeader, timestamp)
+	req.Header.Set(webhookSignatureHeader, signWebhook(hook.Secret, timestamp, del.Payload))
+	// The payload is the event, which carries the request that caused it.
+	var origin struct {
+		RequestID string `json:"requestId"`
+	}
+	if json.Unmarshal(del.Payload, &origin) == nil && origin.RequestID != "" {
+		req.Header.Set(requestIDHeader, origin.RequestID)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Secrets are only shown once, in the response to POST /webhooks.
+func redactWebhook(h Webhook) Webhook {
+	h.Secret = ""
+	return h
+}
+
+func listWebhooksHandler(store WebhookStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hooks, err := store.ListWebhooks(r.Context())
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		for i := range hooks {
+			hooks[i] = redactWebhook(hooks[i])
+		}
+		writeJSON(w, http.StatusOK, hooks)
+	}
+}
+
+func postWebhookHandler(store WebhookStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var hook Webhook
+		if err := json.NewDecoder(r.Body).Decode(&hook); err != nil {
+			writeErr(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		hook.CreatedBy = getUserID(r)
+		if err := store.CreateWebhook(r.Context(), &hook); err != nil {
+			if errors.Is(err, ErrInvalid) {
+				writeErr(w, http.StatusBadRequest, err.Error())
+			} else {
+				writeErr(w, http.StatusInternalServerError, "internal error")
+			}
+			return
+		}
+		writeJSON(w, http.StatusCreated, hook)
+	}
+}
+
+func getWebhookHandler(store WebhookStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("ID"))
+		if err != nil || id <= 0 {
+			writeErr(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		hook, err := store.GetWebhook(r.Context(), id)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				writeErr(w, http.StatusNotFound, "not found")
+			} else {
+				writeErr(w, http.StatusInternalServerError, "internal error")
+			}
+			return
+		}
+		writeJSON(w, http.StatusOK, redactWebhook(hook))
+	}
+}
+
+func deleteWebhookHandler(store WebhookStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("ID"))
+		if err != nil || id <= 0 {
+			writeErr(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		if err := store.DeleteWebhook(r.Context(), id); err != nil {
+			if errors.Is(err, ErrNotFound) {
+				writeErr(w, http.StatusNotFound, "not found")
+			} else {
+				writeErr(w, http.StatusInternalServerError, "internal error")
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func listDeliveriesHandler(store WebhookStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("ID"))
+		if err != nil || id <= 0 {
+			writeErr(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		list, err := store.ListDeliveries(r.Context(), id)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				writeErr(w, http.StatusNotFound, "not found")
+			} else {
+				writeErr(w, http.StatusInternalServerError, "internal error")
+			}
+			return
+		}
+		writeJSON(w, http.StatusOK, list)
+	}
+}
+
+// redeliverHandler queues a delivery again, typically one that was
+// dead-lettered. It responds 202 because sending happens in the background.
+func redeliverHandler(store WebhookStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("ID"))
+		if err != nil || id <= 0 {
+			writeErr(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		deliveryID, err := strconv.Atoi(r.PathValue("DeliveryID"))
+		if err != nil || deliveryID <= 0 {
+			writeErr(w, http.StatusBadRequest, "invalid delivery id")
+			return
+		}
+
+		del, err := store.GetDelivery(r.Context(), deliveryID)
+		if err == nil && del.WebhookID != id {
+			err = ErrNotFound
+		}
+		if err == nil {
+			err = store.Redeliver(r.Context(), deliveryID, time.Now())
+		}
+		if err == nil {
+			del, err = store.GetDelivery(r.Context(), deliveryID)
+		}
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				writeErr(w, http.StatusNotFound, "not found")
+			} else {
+				writeErr(w, http.StatusInternalServerError, "internal error")
+			}
+			return
+		}
+		writeJSON(w, http.StatusAccepted, del)
+	}
+}
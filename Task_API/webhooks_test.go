@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type webhookReceiver struct {
+	mu       sync.Mutex
+	failures int
+	bodies   [][]byte
+	headers  []http.Header
+}
+
+func (rcv *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	if rcv.failures > 0 {
+		rcv.failures--
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rcv.bodies = append(rcv.bodies, body)
+	rcv.headers = append(rcv.headers, r.Header.Clone())
+	w.WriteHeader(http.StatusOK)
+}
+
+func (rcv *webhookReceiver) count() int {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return len(rcv.bodies)
+}
+
+func TestWebhookDispatcher_SignsAndRetries(t *testing.T) {
+	s := newTestStore(t)
+	rcv := &webhookReceiver{failures: 1}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+
+	hook := Webhook{URL: srv.URL, Secret: "shh", Events: []string{EventTaskCreated}}
+	if err := s.CreateWebhook(t.Context(), &hook); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	payload := []byte(`{"type":"task.created","taskId":1}`)
+	if err := s.EnqueueDeliveries(t.Context(), EventTaskCreated, payload, now, ""); err != nil {
+		t.Fatal(err)
+	}
+	// Not subscribed, so nothing is queued.
+	if err := s.EnqueueDeliveries(t.Context(), EventTaskDeleted, payload, now, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewWebhookDispatcher(s)
+	d.now = func() time.Time { return now }
+	d.tick(context.Background())
+
+	list, err := s.ListDeliveries(t.Context(), hook.ID)
+	if err != nil || len(list) != 1 {
+		t.Fatalf("expected one delivery, got %d (%v)", len(list), err)
+	}
+	if list[0].Status != DeliveryPending || list[0].ResponseStatus != http.StatusInternalServerError || list[0].NextAttemptAt == nil {
+		t.Fatalf("expected a scheduled retry after the 500, got %+v", list[0])
+	}
+
+	d.tick(context.Background())
+	if rcv.count() != 0 {
+		t.Fatal("retried before the backoff elapsed")
+	}
+
+	now = now.Add(time.Minute)
+	d.tick(context.Background())
+	if rcv.count() != 1 {
+		t.Fatalf("expected delivery after backoff, got %d", rcv.count())
+	}
+
+	h := rcv.headers[0]
+	if h.Get(webhookEventHeader) != EventTaskCreated || h.Get(webhookDeliveryHeader) != strconv.Itoa(list[0].ID) {
+		t.Fatalf("unexpected headers %v", h)
+	}
+	if ts := h.Get(webhookTimestampHeader); ts != strconv.FormatInt(now.Unix(), 10) {
+		t.Fatalf("timestamp header = %q", ts)
+	}
+	want := signWebhook("shh", h.Get(webhookTimestampHeader), rcv.bodies[0])
+	if !hmac.Equal([]byte(h.Get(webhookSignatureHeader)), []byte(want)) || !bytes.Equal(rcv.bodies[0], payload) {
+		t.Fatalf("signature mismatch: got %q want %q", h.Get(webhookSignatureHeader), want)
+	}
+
+	got, _ := s.GetDelivery(t.Context(), list[0].ID)
+	if got.Status != DeliveryDelivered || got.Attempts != 2 || got.DeliveredAt == nil {
+		t.Fatalf("expected delivered after 2 attempts, got %+v", got)
+	}
+}
+
+func TestWebhookDispatcher_DeadLetterAndRedeliver(t *testing.T) {
+	s := newTestStore(t)
+	rcv := &webhookReceiver{failures: 3}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+
+	hook := Webhook{URL: srv.URL}
+	if err := s.CreateWebhook(t.Context(), &hook); err != nil {
+		t.Fatal(err)
+	}
+	if hook.Secret == "" || len(hook.Events) != len(webhookEvents) {
+		t.Fatalf("expected a generated secret and all events, got %+v", hook)
+	}
+	now := time.Now()
+	if err := s.EnqueueDeliveries(t.Context(), EventTaskUpdated, []byte(`{}`), now, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewWebhookDispatcher(s)
+	d.maxAttempts = 3
+	d.now = func() time.Time { return now }
+	for i := 0; i < 5; i++ {
+		d.tick(context.Background())
+		now = now.Add(24 * time.Hour)
+	}
+
+	list, _ := s.ListDeliveries(t.Context(), hook.ID)
+	if len(list) != 1 || list[0].Status != DeliveryDead || list[0].Attempts != 3 {
+		t.Fatalf("expected dead-lettered after 3 attempts, got %+v", list)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.SetPathValue("ID", strconv.Itoa(hook.ID))
+	req.SetPathValue("DeliveryID", strconv.Itoa(list[0].ID))
+	rec := httptest.NewRecorder()
+	redeliverHandler(s).ServeHTTP(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", rec.Code, rec.Body)
+	}
+
+	d.now = time.Now
+	d.tick(context.Background())
+	got, _ := s.GetDelivery(t.Context(), list[0].ID)
+	if got.Status != DeliveryDelivered || rcv.count() != 1 {
+		t.Fatalf("expected redelivery to succeed, got %+v", got)
+	}
+}
+
+func TestWebhookEvents_EmittedByTaskHandlers(t *testing.T) {
+	s := newTestStore(t)
+	hook := Webhook{URL: "http://example.invalid/hook"}
+	if err := s.CreateWebhook(t.Context(), &hook); err != nil {
+		t.Fatal(err)
+	}
+	events := NewEventBus()
+	SubscribeWebhooks(events, s)
+
+	rec := httptest.NewRecorder()
+	postTaskHandler(s, s, events).ServeHTTP(rec, withUser(httptest.NewRequest(http.MethodPost, "/tasks", bytes.NewBufferString(`{"title":"Ship it"}`)), demoUserID))
+	var task Task
+	if err := json.NewDecoder(rec.Body).Decode(&task); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, body := range []string{`{"title":"Ship it now"}`, `{"title":"Ship it now","completed":true}`} {
+		req := withUser(httptest.NewRequest(http.MethodPut, "/tasks/1", bytes.NewBufferString(body)), demoUserID)
+		req.SetPathValue("ID", strconv.Itoa(task.ID))
+		updateTaskByIDHandler(s, s, events).ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	req := withUser(httptest.NewRequest(http.MethodDelete, "/tasks/1", nil), demoUserID)
+	req.SetPathValue("ID", strconv.Itoa(task.ID))
+	deleteTaskByIDHandler(s, s, events).ServeHTTP(httptest.NewRecorder(), req)
+
+	list, err := s.ListDeliveries(t.Context(), hook.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for i := len(list) - 1; i >= 0; i-- {
+		types = append(types, list[i].EventType)
+	}
+	want := []string{EventTaskCreated, EventTaskUpdated, EventTaskUpdated, EventTaskCompleted, EventTaskDeleted}
+	if len(types) != len(want) {
+		t.Fatalf("expected %v, got %v", want, types)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, types)
+		}
+	}
+
+	var deleted Event
+	if err := json.Unmarshal(list[0].Payload, &deleted); err != nil {
+		t.Fatal(err)
+	}
+	if deleted.Task == nil || deleted.Task.Title != "Ship it now" || deleted.ActorID != demoUserID {
+		t.Fatalf("expected the deleted task snapshot in the payload, got %+v", deleted)
+	}
+}
+
+func TestTaskEvents_CoverTasksChangedAlongTheWay(t *testing.T) {
+	s := newTestStore(t)
+	s.Subtasks.ParentCompletion = ParentCompletionCascade
+	s.Subtasks.AutoCompleteParents = true
+	events := NewEventBus()
+	var got []string
+	events.Subscribe(func(_ context.Context, e Event) { got = append(got, fmt.Sprintf("%s %d", e.Type, e.TaskID)) })
+
+	create := func(task Task) Task {
+		t.Helper()
+		if err := s.CreateTask(t.Context(), &task); err != nil {
+			t.Fatal(err)
+		}
+		return task
+	}
+	complete := func(id int) {
+		t.Helper()
+		req := withUser(httptest.NewRequest(http.MethodPut, "/tasks/x", strings.NewReader(`{"title":"done","completed":true}`)), demoUserID)
+		req.SetPathValue("ID", strconv.Itoa(id))
+		rec := httptest.NewRecorder()
+		updateTaskByIDHandler(s, s, events).ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("complete %d: status %d: %s", id, rec.Code, rec.Body)
+		}
+	}
+	due := time.Date(2026, 6, 1, 9, 0, 0, 0, time.UTC)
+	recurring := create(Task{Title: "standup", DueAt: &due, RRule: "FREQ=DAILY"})
+	parent := create(Task{Title: "parent"})
+	child := create(Task{Title: "child", ParentID: &parent.ID})
+	cascading := create(Task{Title: "cascading"})
+	sub := create(Task{Title: "sub", ParentID: &cascading.ID})
+
+	complete(recurring.ID)
+	next, err := s.GetTaskByID(t.Context(), recurring.ID)
+	if err != nil || next.NextOccurrenceID == nil {
+		t.Fatalf("no next occurrence: %+v (%v)", next, err)
+	}
+	complete(child.ID)
+	complete(cascading.ID)
+
+	req := withUser(httptest.NewRequest(http.MethodDelete, "/tasks/x", nil), demoUserID)
+	req.SetPathValue("ID", strconv.Itoa(cascading.ID))
+	rec := httptest.NewRecorder()
+	deleteTaskByIDHandler(s, s, events).ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: status %d: %s", rec.Code, rec.Body)
+	}
+	if code, _ := postBatch(t, s, events, demoUserID, fmt.Sprintf(`{"operations":[{"op":"delete","id":%d}]}`, parent.ID)); code != http.StatusOK {
+		t.Fatalf("batch delete: status %d", code)
+	}
+
+	want := []string{
+		fmt.Sprintf("task.updated %d", recurring.ID), fmt.Sprintf("task.completed %d", recurring.ID),
+		fmt.Sprintf("task.created %d", *next.NextOccurrenceID),
+		fmt.Sprintf("task.updated %d", child.ID), fmt.Sprintf("task.completed %d", child.ID),
+		fmt.Sprintf("task.updated %d", parent.ID), fmt.Sprintf("task.completed %d", parent.ID),
+		fmt.Sprintf("task.updated %d", cascading.ID), fmt.Sprintf("task.completed %d", cascading.ID),
+		fmt.Sprintf("task.updated %d", sub.ID), fmt.Sprintf("task.completed %d", sub.ID),
+		fmt.Sprintf("task.deleted %d", cascading.ID), fmt.Sprintf("task.deleted %d", sub.ID),
+		fmt.Sprintf("task.deleted %d", parent.ID), fmt.Sprintf("task.deleted %d", child.ID),
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("events = %v\nwant %v", got, want)
+	}
+}
+
+func TestPostWebhookHandler_Validation(t *testing.T) {
+	s := newTestStore(t)
+	for _, body := range []string{`{"url":"ftp://example.com"}`, `{"url":"https://example.com","events":["task.exploded"]}`} {
+		rec := httptest.NewRecorder()
+		postWebhookHandler(s).ServeHTTP(rec, withUser(httptest.NewRequest(http.MethodPost, "/webhooks", bytes.NewBufferString(body)), demoUserID))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", body, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This is the small part of RFC 6455 the live endpoint needs: the upgrade
+// handshake, masked client frames, fragmentation and the control frames.
+// Extensions are not negotiated, and the only subprotocol is the one that
+// carries the access token (see AuthMiddleware).
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+
+	wsCloseNormal     = 1000
+	wsCloseGoingAway  = 1001
+	wsCloseProtocol   = 1002
+	wsCloseTooBig     = 1009
+	wsCloseTryAgain   = 1013
+	wsMaxMessageBytes = 1 << 20
+	wsWriteTimeout    = 10 * time.Second
+	websocketGUID     = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	// wsTokenProtocol is offered as a subprotocol right before the access
+	// token.
+	wsTokenProtocol = "bearer"
+)
+
+var errWSClosed = errors.New("websocket: closed")
+
+type wsConn struct {
+	conn     net.Conn
+	br       *bufio.Reader
+	isClient bool // clients mask what they send, servers must not
+	onPong   func()
+
+	wmu       sync.Mutex
+	closeOnce sync.Once
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, part := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// webSocketProtocols lists the subprotocols the client offered, in order.
+func webSocketProtocols(h http.Header) []string {
+	var protocols []string
+	for _, v := range h.Values("Sec-WebSocket-Protocol") {
+		for _, part := range strings.Split(v, ",") {
+			if p := strings.TrimSpace(part); p != "" {
+				protocols = append(protocols, p)
+			}
+		}
+	}
+	return protocols
+}
+
+func websocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// upgradeWebSocket completes the handshake and takes over the connection.
+// On failure it has already written an error response.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || !headerHasToken(r.Header, "Connection", "upgrade") ||
+		!headerHasToken(r.Header, "Upgrade", "websocket") || key == "" {
+		writeErr(w, http.StatusBadRequest, "websocket upgrade required")
+		return nil, errors.New("websocket: not an upgrade request")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		writeErr(w, http.StatusUpgradeRequired, "unsupported websocket version")
+		return nil, errors.New("websocket: unsupported version")
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "internal error")
+		return nil, err
+	}
+	// The server's read and write timeouts no longer apply; the live
+	// connection manages its own deadlines.
+	_ = conn.SetDeadline(time.Time{})
+
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + websocketAccept(key) + "\r\n"
+	// Browsers drop the connection unless one of the offered subprotocols
+	// is selected. The token itself is never echoed.
+	if slices.Contains(webSocketProtocols(r.Header), wsTokenProtocol) {
+		resp += "Sec-WebSocket-Protocol: " + wsTokenProtocol + "\r\n"
+	}
+	resp += "\r\n"
+	if _, err := brw.WriteString(resp); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, br: brw.Reader}, nil
+}
+
+// ReadMessage returns the next text or binary message. Pings are answered
+// and pongs reported through onPong along the way. A close frame from the
+// peer is echoed and reported as errWSClosed.
+func (c *wsConn) ReadMessage() (int, []byte, error) {
+	var msgOp int
+	var msg []byte
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch op {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case wsOpPong:
+			if c.onPong != nil {
+				c.onPong()
+			}
+			continue
+		case wsOpClose:
+			code := wsCloseNormal
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			c.Close(code, "")
+			return 0, nil, errWSClosed
+		case wsOpText, wsOpBinary:
+			if msg != nil {
+				c.Close(wsCloseProtocol, "expected continuation")
+				return 0, nil, errWSClosed
+			}
+			msgOp, msg = op, payload
+		case wsOpContinuation:
+			if msg == nil {
+				c.Close(wsCloseProtocol, "unexpected continuation")
+				return 0, nil, errWSClosed
+			}
+			msg = append(msg, payload...)
+		default:
+			c.Close(wsCloseProtocol, "unknown opcode")
+			return 0, nil, errWSClosed
+		}
+
+		if len(msg) > wsMaxMessageBytes {
+			c.Close(wsCloseTooBig, "message too big")
+			return 0, nil, errWSClosed
+		}
+		if fin {
+			if msg == nil {
+				msg = []byte{}
+			}
+			return msgOp, msg, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (fin bool, op int, payload []byte, err error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = head[0]&0x80 != 0
+	op = int(head[0] & 0x0F)
+	masked := head[1]&0x80 != 0
+	length := uint64(head[1] & 0x7F)
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if op >= wsOpClose && (!fin || length > 125) {
+		c.Close(wsCloseProtocol, "bad control frame")
+		return false, 0, nil, errWSClosed
+	}
+	if masked == c.isClient {
+		c.Close(wsCloseProtocol, "bad masking")
+		return false, 0, nil, errWSClosed
+	}
+	if length > wsMaxMessageBytes {
+		c.Close(wsCloseTooBig, "message too big")
+		return false, 0, nil, errWSClosed
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, op, payload, nil
+}
+
+// WriteMessage sends one unfragmented frame. It is safe to call from
+// several goroutines.
+func (c *wsConn) WriteMessage(op int, data []byte) error {
+	return c.writeFrame(op, data)
+}
+
+func (c *wsConn) writeFrame(op int, data []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	buf := make([]byte, 0, len(data)+14)
+	buf = append(buf, 0x80|byte(op))
+
+	maskBit := byte(0)
+	if c.isClient {
+		maskBit = 0x80
+	}
+	switch n := len(data); {
+	case n <= 125:
+		buf = append(buf, maskBit|byte(n))
+	case n <= 0xFFFF:
+		buf = append(buf, maskBit|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(n))
+	default:
+		buf = append(buf, maskBit|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(n))
+	}
+
+	if c.isClient {
+		var mask [4]byte
+		_, _ = rand.Read(mask[:])
+		buf = append(buf, mask[:]...)
+		start := len(buf)
+		buf = append(buf, data...)
+		for i := range data {
+			buf[start+i] ^= mask[i%4]
+		}
+	} else {
+		buf = append(buf, data...)
+	}
+
+	_ = c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// Close sends a close frame (best effort) and closes the connection. Only
+// the first call has any effect.
+func (c *wsConn) Close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+		if len(reason) > 123 {
+			reason = reason[:123]
+		}
+		payload = append(payload, reason...)
+		_ = c.writeFrame(wsOpClose, payload)
+		c.conn.Close()
+	})
+}